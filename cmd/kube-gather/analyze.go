@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"kube-query/pkg/analyze"
+	"kube-query/pkg/export"
+	"kube-query/pkg/gather"
+	"kube-query/pkg/store"
+)
+
+// runAnalyze implements `analyze --db file.db`, reporting the problems found
+// in a run's gathered objects, worst first.
+func runAnalyze(args []string) error {
+	flags := flag.NewFlagSet("analyze", flag.ExitOnError)
+	dbFile := flags.String("db", "kube_data.db", "Path to the SQLite database file")
+	run := flags.Int64("run", 0, "Run to analyze (defaults to the latest run)")
+	format := flags.String("output", "table", "Output format: table, json, csv or sarif")
+	flags.StringVar(format, "o", "table", "Shorthand for --output")
+	minSeverity := flags.String("severity", "info", "Only report findings at least this severe: info, warning or critical")
+	checks := flags.String("check", "", "Comma-separated analyzers to run (defaults to all), e.g. certs")
+	certDays := flags.Int("cert-days", 30, "Report certificates expiring within this many days")
+	targetVersion := flags.String("target-version", "", "Kubernetes version to check API deprecations against, e.g. 1.29 (defaults to the gathered server version)")
+	list := flags.Bool("list", false, "List the analyzers and exit")
+	if _, err := parseInterspersed(flags, args); err != nil {
+		return err
+	}
+
+	if *list {
+		for _, a := range analyze.Analyzers {
+			fmt.Printf("%-15s %s\n", a.Name, a.Description)
+		}
+		return nil
+	}
+	threshold, err := analyze.ParseSeverity(*minSeverity)
+	if err != nil {
+		return err
+	}
+
+	db, err := store.OpenReadOnly(*dbFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	runID := *run
+	if runID == 0 {
+		if runID, err = store.LatestRunID(db); err != nil {
+			return err
+		}
+	}
+
+	findings, err := analyze.Run(db, runID, gather.SplitList(*checks), time.Duration(*certDays)*24*time.Hour, *targetVersion)
+	if err != nil {
+		return err
+	}
+	var reported []analyze.Finding
+	var records [][]interface{}
+	for _, f := range findings {
+		if f.Severity >= threshold {
+			reported = append(reported, f)
+			records = append(records, []interface{}{f.Severity.String(), f.Rule, f.Kind, f.Namespace, f.Name, f.Message})
+		}
+	}
+	// A SARIF log without results is still written, so that uploading it
+	// closes findings that have been fixed.
+	if *format == "sarif" {
+		return analyze.WriteSARIF(os.Stdout, reported)
+	}
+	if len(records) == 0 {
+		fmt.Fprintf(os.Stderr, "No problems found in run %d.\n", runID)
+		return nil
+	}
+	return export.WriteRecords(os.Stdout, *format, []string{"severity", "rule", "kind", "namespace", "name", "message"}, records)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+	"sigs.k8s.io/yaml"
+
+	"kube-query/pkg/store"
+)
+
+// runBrowse implements `browse --db file.db`, a terminal UI for navigating
+// runs, resources and logs when a browser is not available.
+func runBrowse(args []string) error {
+	flags := flag.NewFlagSet("browse", flag.ExitOnError)
+	dbFile := flags.String("db", "kube_data.db", "Path to the SQLite database file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return fmt.Errorf("browse requires an interactive terminal")
+	}
+
+	db, err := store.OpenReadOnly(*dbFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	root, err := runsScreen(db)
+	if err != nil {
+		return err
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("Error configuring terminal: %v", err)
+	}
+	defer term.Restore(fd, state)
+
+	b := &browser{out: bufio.NewWriter(os.Stdout), stack: []*browseScreen{root}}
+	fmt.Fprint(b.out, "\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Fprint(b.out, "\x1b[?25h\x1b[?1049l")
+		b.out.Flush()
+	}()
+	return b.loop(os.Stdin)
+}
+
+// browseScreen is one level of the navigation stack: either a selectable
+// list or a scrollable text view.
+type browseScreen struct {
+	title      string
+	lines      []string
+	selectable bool
+	cursor     int
+	offset     int
+	search     string
+	// open returns the screen for the selected line of a list.
+	open func(index int) (*browseScreen, error)
+	// logs returns the log screen for the selected line, if it has logs.
+	logs func(index int) (*browseScreen, error)
+}
+
+type browser struct {
+	out     *bufio.Writer
+	stack   []*browseScreen
+	status  string
+	prompt  bool
+	pending string
+}
+
+func (b *browser) top() *browseScreen {
+	return b.stack[len(b.stack)-1]
+}
+
+func (b *browser) loop(in *os.File) error {
+	buf := make([]byte, 16)
+	for {
+		b.render()
+		n, err := in.Read(buf)
+		if err != nil {
+			return err
+		}
+		if quit := b.handleKey(string(buf[:n])); quit {
+			return nil
+		}
+	}
+}
+
+func (b *browser) handleKey(key string) bool {
+	s := b.top()
+	b.status = ""
+
+	if b.prompt {
+		switch key {
+		case "\r":
+			b.prompt = false
+			s.search = b.pending
+			b.findNext(s, s.cursorLine())
+		case "\x1b":
+			b.prompt = false
+		case "\x7f":
+			if len(b.pending) > 0 {
+				b.pending = b.pending[:len(b.pending)-1]
+			}
+		default:
+			if key >= " " {
+				b.pending += key
+			}
+		}
+		return false
+	}
+
+	_, height := b.size()
+	page := height - 2
+	switch key {
+	case "q", "\x1b", "\x7f", "h", "\x1b[D":
+		if len(b.stack) == 1 {
+			return key == "q" || key == "\x1b"
+		}
+		b.stack = b.stack[:len(b.stack)-1]
+	case "\x03":
+		return true
+	case "j", "\x1b[B":
+		s.move(1, page)
+	case "k", "\x1b[A":
+		s.move(-1, page)
+	case " ", "\x1b[6~":
+		s.move(page, page)
+	case "b", "\x1b[5~":
+		s.move(-page, page)
+	case "g":
+		s.move(-len(s.lines), page)
+	case "G":
+		s.move(len(s.lines), page)
+	case "/":
+		b.prompt = true
+		b.pending = ""
+	case "n":
+		b.findNext(s, s.cursorLine()+1)
+	case "\r", "l", "\x1b[C":
+		if s.open != nil && len(s.lines) > 0 {
+			b.push(s.open(s.cursor))
+		}
+	case "L":
+		if s.logs != nil && len(s.lines) > 0 {
+			b.push(s.logs(s.cursor))
+		}
+	}
+	return false
+}
+
+func (b *browser) push(next *browseScreen, err error) {
+	if err != nil {
+		b.status = err.Error()
+		return
+	}
+	if next != nil {
+		b.stack = append(b.stack, next)
+	}
+}
+
+func (b *browser) findNext(s *browseScreen, from int) {
+	if s.search == "" {
+		return
+	}
+	_, height := b.size()
+	needle := strings.ToLower(s.search)
+	for i := 0; i < len(s.lines); i++ {
+		line := (from + i) % len(s.lines)
+		if strings.Contains(strings.ToLower(s.lines[line]), needle) {
+			s.move(line-s.cursorLine(), height-2)
+			return
+		}
+	}
+	b.status = fmt.Sprintf("%q not found", s.search)
+}
+
+// cursorLine is the line searches and movement are relative to: the selected
+// line in lists, or the first visible line in text views.
+func (s *browseScreen) cursorLine() int {
+	if s.selectable {
+		return s.cursor
+	}
+	return s.offset
+}
+
+func (s *browseScreen) move(delta, page int) {
+	if s.selectable {
+		s.cursor = clamp(s.cursor+delta, 0, len(s.lines)-1)
+		if s.cursor < s.offset {
+			s.offset = s.cursor
+		} else if s.cursor >= s.offset+page {
+			s.offset = s.cursor - page + 1
+		}
+		return
+	}
+	s.offset = clamp(s.offset+delta, 0, len(s.lines)-page)
+}
+
+func clamp(v, lo, hi int) int {
+	if v > hi {
+		v = hi
+	}
+	if v < lo {
+		v = lo
+	}
+	return v
+}
+
+func (b *browser) size() (int, int) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || height < 3 {
+		return 80, 24
+	}
+	return width, height
+}
+
+func (b *browser) render() {
+	width, height := b.size()
+	s := b.top()
+	fit := func(line string) string {
+		line = strings.ReplaceAll(line, "\t", "    ")
+		if len(line) > width {
+			line = line[:width]
+		}
+		return line
+	}
+
+	fmt.Fprint(b.out, "\x1b[H\x1b[2J")
+	fmt.Fprintf(b.out, "\x1b[7m%-*s\x1b[0m\r\n", width, fit(s.title))
+	for i := s.offset; i < s.offset+height-2 && i < len(s.lines); i++ {
+		line := fit(s.lines[i])
+		if s.selectable && i == s.cursor {
+			fmt.Fprintf(b.out, "\x1b[7m%-*s\x1b[0m\r\n", width, line)
+		} else if s.search != "" && strings.Contains(strings.ToLower(line), strings.ToLower(s.search)) {
+			fmt.Fprintf(b.out, "\x1b[33m%s\x1b[0m\r\n", line)
+		} else {
+			fmt.Fprintf(b.out, "%s\r\n", line)
+		}
+	}
+
+	fmt.Fprintf(b.out, "\x1b[%d;1H", height)
+	switch {
+	case b.prompt:
+		fmt.Fprintf(b.out, "/%s", b.pending)
+	case b.status != "":
+		fmt.Fprint(b.out, fit(b.status))
+	default:
+		help := "j/k move  enter open  L logs  / search  n next  q back"
+		fmt.Fprintf(b.out, "\x1b[2m%s\x1b[0m", fit(help))
+	}
+	b.out.Flush()
+}
+
+func runsScreen(db *sql.DB) (*browseScreen, error) {
+	runs, err := store.ListRuns(db)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &browseScreen{title: "Runs", selectable: true}
+	for _, run := range runs {
+		started, finished := "-", "-"
+		if run.StartedAt != nil {
+			started = run.StartedAt.Format("2006-01-02 15:04:05")
+		}
+		if run.FinishedAt != nil {
+			finished = run.FinishedAt.Format("2006-01-02 15:04:05")
+		}
+		if run.Interrupted {
+			finished += " (interrupted)"
+		}
+		s.lines = append(s.lines, fmt.Sprintf("#%-5d %s  ->  %s", run.ID, started, finished))
+	}
+	s.open = func(i int) (*browseScreen, error) {
+		return resourcesScreen(db, runs[i].ID)
+	}
+	return s, nil
+}
+
+func resourcesScreen(db *sql.DB, runID int64) (*browseScreen, error) {
+	resources, err := store.ListResources(db, runID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &browseScreen{title: fmt.Sprintf("Run #%d resources", runID), selectable: true}
+	for _, r := range resources {
+		s.lines = append(s.lines, fmt.Sprintf("%-12s %s/%s", r.Kind, r.Namespace, r.Name))
+	}
+	s.open = func(i int) (*browseScreen, error) {
+		r := resources[i]
+		resource, err := store.GetResource(db, runID, r.Kind, r.Namespace, r.Name)
+		if err != nil {
+			return nil, err
+		}
+		yamlBytes, err := yaml.Marshal(resource)
+		if err != nil {
+			return nil, err
+		}
+		return textScreen(fmt.Sprintf("%s %s/%s", r.Kind, r.Namespace, r.Name), string(yamlBytes)), nil
+	}
+	s.logs = func(i int) (*browseScreen, error) {
+		r := resources[i]
+		if r.Kind != "deployment" {
+			return nil, fmt.Errorf("logs are only captured for deployments")
+		}
+		logs, err := store.GetDeploymentLogs(db, runID, r.Namespace, r.Name)
+		if err != nil {
+			return nil, err
+		}
+		return textScreen(fmt.Sprintf("Logs %s/%s", r.Namespace, r.Name), string(logs)), nil
+	}
+	return s, nil
+}
+
+func textScreen(title, text string) *browseScreen {
+	return &browseScreen{title: title, lines: strings.Split(strings.TrimRight(text, "\n"), "\n")}
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"kube-query/pkg/export"
+	"kube-query/pkg/gather"
+	"kube-query/pkg/schedule"
+	"kube-query/pkg/store"
+)
+
+// runDaemon implements `daemon --schedule "0 */6 * * *"`, gathering into the
+// database each time the schedule fires and pruning all but the newest
+// --keep-runs runs after each gather. What is gathered is chosen with the
+// default command's flags. With --listen it also serves the read-only API of
+// `serve` and accepts POST /gather to trigger gathers within that selection
+// and Alertmanager's webhooks at POST /alertmanager, from clients holding the
+// --token-env token or, without one, from localhost alone. With
+// --watch-namespaces it gathers workloads as they fail and jobs as they
+// finish. It runs until interrupted.
+func runDaemon(args []string) error {
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	options := gatherFlags(flags)
+	dbFile := flags.String("db", "kube_data.db", "Path to the SQLite database file")
+	scheduleSpec := flags.String("schedule", "", "Cron schedule to gather on, such as \"0 */6 * * *\" or @hourly, in local time")
+	keepRuns := flags.Int("keep-runs", 28, "Delete all but this many of the newest runs after each gather (0 keeps every run)")
+	listen := flags.String("listen", "", "Address to serve the read API and POST /gather on, e.g. 127.0.0.1:8080 (default: none)")
+	watchNamespaces := flags.String("watch-namespaces", "", "Comma-separated namespaces, or * for all, to watch for pods entering CrashLoopBackOff, deployments becoming unavailable and jobs with a TTL finishing, gathering each such workload with its ConfigMaps and Secrets")
+	triggerCooldown := flags.Duration("trigger-cooldown", 15*time.Minute, "Minimum time between gathers triggered by the same workload")
+	notifyURL := flags.String("notify-url", "", "Post a summary of each gather to this Slack or Teams incoming webhook, or as JSON to any other URL, when it finishes")
+	leaderElect := flags.Bool("leader-elect", false, "Gather only while holding a Lease, so that of several replicas one gathers while the others stand by")
+	leaderElectNamespace := flags.String("leader-elect-namespace", "", "Namespace of the --leader-elect Lease (default: the pod's own namespace)")
+	leaderElectID := flags.String("leader-elect-id", "kube-gather", "Name of the --leader-elect Lease")
+	tokenEnv := flags.String("token-env", "", "Environment variable holding a bearer token required of POST /gather and POST /alertmanager (default: accept them only from localhost)")
+	alertPresets := flags.String("alert-presets", "", "Comma-separated label=value:preset rules choosing presets to gather for alerts received at POST /alertmanager, e.g. alertname=KubeDNSDown:dns")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	watched := gather.SplitList(*watchNamespaces)
+	if *scheduleSpec == "" && *listen == "" && len(watched) == 0 {
+		return fmt.Errorf("Nothing to do. Use the --schedule, --listen or --watch-namespaces flag, e.g. --schedule \"0 */6 * * *\".")
+	}
+	var sched *schedule.Schedule
+	if *scheduleSpec != "" {
+		parsed, err := schedule.Parse(*scheduleSpec)
+		if err != nil {
+			return err
+		}
+		if parsed.Next(time.Now()).IsZero() {
+			return fmt.Errorf("Schedule %q never fires", *scheduleSpec)
+		}
+		sched = &parsed
+	}
+	if *keepRuns < 0 {
+		return fmt.Errorf("Invalid --keep-runs %d", *keepRuns)
+	}
+	alertRules, err := gather.ParseAlertRules(gather.SplitList(*alertPresets))
+	if err != nil {
+		return err
+	}
+	var token string
+	if *tokenEnv != "" {
+		if token = os.Getenv(*tokenEnv); token == "" {
+			return fmt.Errorf("$%s, named by --token-env, is not set", *tokenEnv)
+		}
+	}
+
+	clientConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return fmt.Errorf("Error loading kube client config: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		return fmt.Errorf("Error creating Kubernetes client: %v", err)
+	}
+	var g *gather.Gatherer
+	if sched != nil {
+		scheduled := options()
+		scheduled.Trigger = "schedule " + *scheduleSpec
+		g, err = gather.New(clientConfig, scheduled)
+		if err != nil {
+			return err
+		}
+	}
+
+	s, err := store.Open(*dbFile)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// Ctrl-C or SIGTERM stops the daemon, finishing the run in progress if
+	// there is one; a second one exits at once.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		fmt.Fprintln(os.Stderr, "Interrupted, stopping the daemon (interrupt again to quit now)")
+		cancel()
+	}()
+
+	d := &daemon{ctx: ctx, config: clientConfig, options: options(), store: s, keepRuns: *keepRuns, notifyURL: *notifyURL, alertRules: alertRules, token: token}
+	if *listen != "" {
+		// A daemon started without a selection of its own, only to watch or
+		// receive alerts, has none for POST /gather to choose from.
+		if own, err := gather.New(clientConfig, d.options); err == nil {
+			d.own = own
+		}
+		listener, err := net.Listen("tcp", *listen)
+		if err != nil {
+			return fmt.Errorf("Error listening on %s: %v", *listen, err)
+		}
+		log.Printf("Serving %s on http://%s\n", *dbFile, *listen)
+		go func() {
+			if err := http.Serve(listener, d.routes(*dbFile)); err != nil {
+				log.Printf("Error serving: %v\n", err)
+			}
+		}()
+	}
+	run := func(ctx context.Context) error {
+		if len(watched) > 0 {
+			log.Printf("Watching %s for failing workloads\n", strings.Join(watched, ", "))
+			go func() {
+				if err := gather.WatchTriggers(ctx, clientset, watched, *triggerCooldown, d.triggered, func(err error) {
+					log.Printf("%v\n", err)
+				}); err != nil {
+					log.Printf("%v\n", err)
+				}
+			}()
+		}
+		return d.run(ctx, g, sched)
+	}
+	if !*leaderElect {
+		d.leading.Store(true)
+		return run(ctx)
+	}
+	return d.elect(ctx, cancel, clientset, *leaderElectNamespace, *leaderElectID, run)
+}
+
+// daemon runs the daemon's gathers, scheduled and triggered, one at a time.
+type daemon struct {
+	ctx      context.Context
+	config   *rest.Config
+	options  gather.Options
+	store    *store.Store
+	keepRuns int
+	// notifyURL is posted a summary of each gather.
+	notifyURL string
+	// attachTicket, if set, is the ticket each finished database is
+	// attached to with attachBackend.
+	attachBackend export.TicketBackend
+	attachTicket  string
+	// finished, if set, is called after each gather with its summary, or
+	// nil if it failed to start, and the error failing it, if any.
+	finished func(summary *store.Run, err error)
+	// alertRules choose the presets gathered for Alertmanager's alerts.
+	alertRules gather.AlertRules
+	// token, if set, is the bearer token POST requests must carry; without
+	// it they are accepted from localhost alone.
+	token string
+	// own gathers the daemon's selection, which POST /gather may narrow but
+	// not go beyond, or is nil if it has none.
+	own *gather.Gatherer
+	// leading is set while this replica holds the --leader-elect Lease, or
+	// always without it. Only the leader gathers; the others serve reads.
+	leading atomic.Bool
+	// running is held while a gather runs. POST /gather is refused rather
+	// than queued behind it; gathers of failing workloads wait their turn.
+	running sync.Mutex
+}
+
+// run gathers each time sched fires until ctx is done, then waits for the
+// gather in progress, if any, to finish. Without a schedule it only waits.
+func (d *daemon) run(ctx context.Context, g *gather.Gatherer, sched *schedule.Schedule) error {
+	if sched == nil {
+		<-ctx.Done()
+		d.running.Lock()
+		return nil
+	}
+	for {
+		next := sched.Next(time.Now())
+		if next.IsZero() {
+			return fmt.Errorf("Schedule no longer fires")
+		}
+		log.Printf("Next gather at %s\n", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			// Let a triggered gather finish its run.
+			d.running.Lock()
+			return nil
+		case <-timer.C:
+		}
+		d.running.Lock()
+		d.gather(g)
+		d.running.Unlock()
+	}
+}
+
+// elect runs the daemon's gathers only while it holds a Lease, standing by
+// while another replica does. Losing the Lease stops the daemon, through
+// cancel, so that it restarts as a candidate rather than gathering alongside
+// the new leader.
+func (d *daemon) elect(ctx context.Context, cancel context.CancelFunc, clientset kubernetes.Interface, namespace, name string, run func(ctx context.Context) error) error {
+	identity, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("Error finding the leader election identity: %v", err)
+	}
+	if namespace == "" {
+		namespace = "default"
+		if ns, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace"); err == nil {
+			namespace = strings.TrimSpace(string(ns))
+		}
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Client:     clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+
+	// The callbacks run before RunOrDie returns, except for
+	// OnStartedLeading, whose result is waited for in done.
+	done := make(chan error, 1)
+	var led, lost bool
+	log.Printf("Waiting to lead as %s, on Lease %s/%s\n", identity, namespace, name)
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Printf("Leading as %s\n", identity)
+				d.leading.Store(true)
+				done <- run(ctx)
+			},
+			OnStoppedLeading: func() {
+				led = d.leading.Swap(false)
+				if led && ctx.Err() == nil {
+					lost = true
+					cancel()
+				}
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					log.Printf("Standing by while %s leads\n", leader)
+				}
+			},
+		},
+	})
+	if !led {
+		return nil
+	}
+	err = <-done
+	if lost {
+		return fmt.Errorf("Lost the Lease %s/%s to another replica", namespace, name)
+	}
+	return err
+}
+
+// gather performs one of the daemon's gathers, then prunes old runs.
+// Failures are logged rather than returned so later gathers still happen.
+func (d *daemon) gather(g *gather.Gatherer) {
+	// Gathers queued behind the one running when the daemon was stopped
+	// are dropped.
+	if d.ctx.Err() != nil {
+		return
+	}
+	summary, err := g.Gather(d.ctx, d.store)
+	if err != nil {
+		log.Printf("Error gathering: %v\n", err)
+		notify(d.notifyURL, export.Notification{Err: err})
+		if d.finished != nil {
+			d.finished(nil, err)
+		}
+		return
+	}
+	summary.Print(os.Stdout)
+	n := export.Notification{Run: summary}
+	if d.attachTicket != "" && !summary.Interrupted {
+		fmt.Printf("Attaching %s to %s ticket %s\n", d.store.Path, d.attachBackend.Name, d.attachTicket)
+		n.Err = export.AttachBundle(d.attachBackend, d.attachTicket, d.store.Path)
+		if n.Err != nil {
+			log.Printf("%v\n", n.Err)
+		} else if d.attachBackend.URL != nil {
+			n.Link = d.attachBackend.URL(d.attachTicket)
+		}
+	}
+	notify(d.notifyURL, n)
+	if d.finished != nil {
+		d.finished(summary, n.Err)
+	}
+	if d.keepRuns == 0 {
+		return
+	}
+
+	pruned, err := store.PruneRuns(d.store.DB, d.keepRuns)
+	if err != nil {
+		log.Printf("%v\n", err)
+		return
+	}
+	if pruned > 0 {
+		log.Printf("Pruned %d old runs, keeping the newest %d\n", pruned, d.keepRuns)
+	}
+}
+
+func (d *daemon) routes(dbFile string) *http.ServeMux {
+	mux := (&server{db: d.store.DB, dbFile: dbFile}).routes()
+	mux.HandleFunc("POST /gather", d.handleGather)
+	mux.HandleFunc("POST /alertmanager", d.handleAlerts)
+	return mux
+}
+
+// selection returns the daemon's options gathering only the given preset,
+// --app selector and --resources entries, rather than the daemon's own
+// selection.
+func (d *daemon) selection(preset, app string, resources []string) gather.Options {
+	options := d.options
+	options.Preset, options.App, options.Resources = preset, app, resources
+	options.NamespaceDump, options.CRDs = nil, nil
+	options.Operators, options.Inventory, options.Plugins = false, false, false
+	return options
+}
+
+// triggered gathers a failing workload WatchTriggers found, after any gather
+// already running.
+func (d *daemon) triggered(t gather.Trigger) {
+	log.Printf("Gathering %s/%s/%s: %s\n", t.Kind, t.Namespace, t.Name, t.Reason)
+	options := d.selection("", "", t.Resources())
+	options.Trigger = t.Reason
+	g, err := gather.New(d.config, options)
+	if err != nil {
+		log.Printf("Error gathering %s/%s/%s: %v\n", t.Kind, t.Namespace, t.Name, err)
+		return
+	}
+	d.queue(g)
+}
+
+// queue runs a gather after any gather already running.
+func (d *daemon) queue(g *gather.Gatherer) {
+	go func() {
+		d.running.Lock()
+		defer d.running.Unlock()
+		d.gather(g)
+	}()
+}
+
+// alertWebhook is the body of Alertmanager's webhook notifications, less the
+// fields of the group the alerts were sent for.
+type alertWebhook struct {
+	Receiver string        `json:"receiver"`
+	Alerts   []store.Alert `json:"alerts"`
+}
+
+// handleAlerts gathers what the firing alerts of an Alertmanager webhook
+// are about, chosen by their labels and the --alert-presets rules, and
+// records the alerts against the run. Resolved alerts and alerts about
+// nothing the daemon can gather, or may gather only outside its selection,
+// are acknowledged and otherwise ignored.
+// Alertmanager doesn't wait for the gather, which is queued behind any
+// running one rather than refused.
+func (d *daemon) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	if !d.authorized(w, r) {
+		return
+	}
+	if !d.leading.Load() {
+		refuse(w, http.StatusServiceUnavailable, "this replica is standing by, gathers run on the leader")
+		return
+	}
+	var webhook alertWebhook
+	if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil {
+		writeError(w, badRequest(fmt.Errorf("invalid Alertmanager webhook: %v", err)))
+		return
+	}
+	var firing []store.Alert
+	var names []string
+	for _, alert := range webhook.Alerts {
+		if alert.Status == "firing" {
+			firing = append(firing, alert)
+			names = append(names, alert.Labels["alertname"])
+		}
+	}
+
+	preset, resources := d.alertRules.Selection(firing)
+	// The objects alerts are labelled with are gathered only within the
+	// daemon's selection, as POST /gather's are; the --alert-presets rules
+	// are the daemon's own choice.
+	outside := resources
+	if d.own != nil {
+		resources, outside = d.own.Covering(resources)
+	} else {
+		resources = nil
+	}
+	if len(outside) > 0 {
+		log.Printf("Not gathering %s for alerts: outside the daemon's selection\n", strings.Join(outside, ", "))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if preset == "" && len(resources) == 0 {
+		json.NewEncoder(w).Encode(map[string]bool{"gathering": false})
+		return
+	}
+
+	options := d.selection(preset, "", resources)
+	options.Trigger = "alert " + strings.Join(names, ", ")
+	options.Hooks.OnRunStart = func(runID int64) {
+		for _, alert := range firing {
+			if err := store.StoreAlert(d.store.DB, runID, alert); err != nil {
+				log.Printf("%v\n", err)
+			}
+		}
+	}
+	g, err := gather.New(d.config, options)
+	if err != nil {
+		writeError(w, badRequest(err))
+		return
+	}
+	log.Printf("Gathering for %s from %s\n", options.Trigger, webhook.Receiver)
+	d.queue(g)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]bool{"gathering": true})
+}
+
+// gatherRequest is the body of POST /gather: a preset, --app selector or
+// --resources entries to gather, in place of those the daemon was started
+// with but within them, or nothing to gather the daemon's own selection.
+type gatherRequest struct {
+	Preset    string   `json:"preset"`
+	App       string   `json:"app"`
+	Resources []string `json:"resources"`
+}
+
+// handleGather starts a gather of what the request asks for, with the
+// daemon's other options, and responds with its run ID once it has been
+// recorded. The run can be polled at /api/runs/{run}, and is finished once
+// it has a finished_at. Requests reaching beyond the daemon's selection are
+// forbidden.
+func (d *daemon) handleGather(w http.ResponseWriter, r *http.Request) {
+	if !d.authorized(w, r) {
+		return
+	}
+	if !d.leading.Load() {
+		refuse(w, http.StatusServiceUnavailable, "this replica is standing by, gathers run on the leader")
+		return
+	}
+	var req gatherRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, badRequest(fmt.Errorf("invalid gather request: %v", err)))
+		return
+	}
+
+	if d.own == nil {
+		refuse(w, http.StatusForbidden, "the daemon has no selection of its own to gather within")
+		return
+	}
+	options := d.options
+	if req.Preset != "" || req.App != "" || len(req.Resources) > 0 {
+		options = d.selection(req.Preset, req.App, req.Resources)
+	}
+	options.Trigger = "POST /gather from " + r.RemoteAddr
+	started := make(chan int64, 1)
+	options.Hooks.OnRunStart = func(runID int64) { started <- runID }
+
+	g, err := gather.New(d.config, options)
+	if err != nil {
+		writeError(w, badRequest(err))
+		return
+	}
+	if err := g.Within(d.own); err != nil {
+		refuse(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if !d.running.TryLock() {
+		refuse(w, http.StatusConflict, "a gather is already running")
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer d.running.Unlock()
+		defer close(done)
+		d.gather(g)
+	}()
+
+	var runID int64
+	select {
+	case runID = <-started:
+	case <-done:
+		// A gather may finish as soon as it starts.
+		select {
+		case runID = <-started:
+		default:
+			writeError(w, fmt.Errorf("gather failed to start, see the daemon's log"))
+			return
+		}
+	}
+	log.Printf("Gathering run %d for %s\n", runID, r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"run_id": runID,
+		"status": fmt.Sprintf("/api/runs/%d", runID),
+	})
+}
+
+// authorized reports whether a request may trigger a gather, refusing it if
+// not: it must carry the daemon's token, or come from localhost when the
+// daemon has none.
+func (d *daemon) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if d.token != "" {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+d.token)) == 1 {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		refuse(w, http.StatusUnauthorized, "a bearer token is required")
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if ip := net.ParseIP(host); err == nil && ip != nil && ip.IsLoopback() {
+		return true
+	}
+	refuse(w, http.StatusForbidden, "gathers are triggered from localhost only, unless the daemon has --token-env")
+	return false
+}
+
+// refuse responds with an error status and message for a gather the daemon
+// won't run, at least not now.
+func refuse(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/gather"
+)
+
+// newTestDaemon returns a leading daemon selecting the prod namespace, of a
+// cluster whose API server answers every request with 404.
+func newTestDaemon(t *testing.T) *daemon {
+	t.Helper()
+	api := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(api.Close)
+	config := &rest.Config{Host: api.URL}
+	d := &daemon{ctx: context.Background(), config: config, options: gather.Options{NamespaceDump: []string{"prod"}}, store: kubetest.NewStore(t)}
+	own, err := gather.New(config, d.options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.own = own
+	d.leading.Store(true)
+	return d
+}
+
+// postGather posts a gather request to the daemon from remoteAddr, with an
+// Authorization header if auth isn't empty.
+func postGather(d *daemon, remoteAddr, auth, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/gather", strings.NewReader(body))
+	r.RemoteAddr = remoteAddr
+	if auth != "" {
+		r.Header.Set("Authorization", auth)
+	}
+	w := httptest.NewRecorder()
+	d.routes("kube_data.db").ServeHTTP(w, r)
+	return w
+}
+
+func TestGatherAuthorization(t *testing.T) {
+	d := newTestDaemon(t)
+	// The daemon is busy, so authorized requests get as far as 409.
+	d.running.Lock()
+	for _, tt := range []struct {
+		token, remoteAddr, auth string
+		want                    int
+	}{
+		{"s3cret", "10.0.0.5:4000", "", http.StatusUnauthorized},
+		{"s3cret", "10.0.0.5:4000", "Bearer wrong", http.StatusUnauthorized},
+		{"s3cret", "127.0.0.1:4000", "s3cret", http.StatusUnauthorized},
+		{"s3cret", "10.0.0.5:4000", "Bearer s3cret", http.StatusConflict},
+		{"", "10.0.0.5:4000", "", http.StatusForbidden},
+		{"", "10.0.0.5:4000", "Bearer s3cret", http.StatusForbidden},
+		{"", "127.0.0.1:4000", "", http.StatusConflict},
+		{"", "[::1]:4000", "", http.StatusConflict},
+	} {
+		d.token = tt.token
+		w := postGather(d, tt.remoteAddr, tt.auth, `{}`)
+		if w.Code != tt.want {
+			t.Errorf("token %q, from %s with %q: got %d %s, want %d", tt.token, tt.remoteAddr, tt.auth, w.Code, w.Body, tt.want)
+		}
+		if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("token %q, from %s with %q: got no WWW-Authenticate challenge", tt.token, tt.remoteAddr, tt.auth)
+		}
+	}
+}
+
+func TestGatherWithinSelection(t *testing.T) {
+	d := newTestDaemon(t)
+	for _, body := range []string{
+		`{"preset": "dns"}`,
+		`{"app": "web"}`,
+		`{"resources": ["prod:deployment:web", "kube-system:secret:bootstrap-token"]}`,
+	} {
+		w := postGather(d, "127.0.0.1:4000", "", body)
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "outside the selection prod:*:*") {
+			t.Errorf("%s: got %d %s, want 403 for reaching beyond prod", body, w.Code, w.Body)
+		}
+	}
+
+	// Without a selection of its own, the daemon has nothing to gather within.
+	d.own = nil
+	if w := postGather(d, "127.0.0.1:4000", "", `{"resources": ["prod:deployment:web"]}`); w.Code != http.StatusForbidden {
+		t.Errorf("got %d %s from a daemon without a selection, want 403", w.Code, w.Body)
+	}
+}
+
+func TestGather(t *testing.T) {
+	d := newTestDaemon(t)
+	d.running.Lock()
+	if w := postGather(d, "127.0.0.1:4000", "", `{"resources": ["prod:deployment:web"]}`); w.Code != http.StatusConflict {
+		t.Errorf("got %d %s while a gather runs, want 409", w.Code, w.Body)
+	}
+	d.running.Unlock()
+
+	w := postGather(d, "127.0.0.1:4000", "", `{"resources": ["prod:deployment:web"]}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("got %d %s, want 202", w.Code, w.Body)
+	}
+	var got struct {
+		RunID  int64  `json:"run_id"`
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.RunID == 0 || got.Status != "/api/runs/1" {
+		t.Errorf("got %+v, want run 1 and its status URL", got)
+	}
+
+	// Waiting for the gather to finish, its run was recorded as triggered.
+	d.running.Lock()
+	defer d.running.Unlock()
+	if n := kubetest.Count(t, d.store.DB, "runs", "id = ? AND trigger = ?", got.RunID, "POST /gather from 127.0.0.1:4000"); n != 1 {
+		t.Errorf("got %d runs triggered by the request, want 1", n)
+	}
+}
+
+func TestElectStandby(t *testing.T) {
+	d := newTestDaemon(t)
+	d.leading.Store(false)
+	clientset := kubetest.NewClientset(t)
+	holder, duration, renewed := "kube-gather-0", int32(3600), metav1.NewMicroTime(time.Now())
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "kube-gather"},
+		Spec:       coordinationv1.LeaseSpec{HolderIdentity: &holder, LeaseDurationSeconds: &duration, AcquireTime: &renewed, RenewTime: &renewed},
+	}
+	if _, err := clientset.CoordinationV1().Leases("monitoring").Create(context.Background(), lease, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ran := make(chan struct{}, 1)
+	result := make(chan error, 1)
+	go func() {
+		result <- d.elect(ctx, cancel, clientset, "monitoring", "kube-gather", func(ctx context.Context) error {
+			ran <- struct{}{}
+			return nil
+		})
+	}()
+	// Once it has seen the Lease held, the replica stands by.
+	waitFor(t, "the Lease to be read", func() bool {
+		for _, a := range clientset.Actions() {
+			if a.GetResource().Resource == "leases" && a.GetVerb() == "get" {
+				return true
+			}
+		}
+		return false
+	})
+	if w := postGather(d, "127.0.0.1:4000", "", `{"resources": ["prod:deployment:web"]}`); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got %d %s from a standby, want 503", w.Code, w.Body)
+	}
+
+	cancel()
+	if err := <-result; err != nil {
+		t.Errorf("standby returned %v, want nil once stopped", err)
+	}
+	select {
+	case <-ran:
+		t.Error("standby gathered while another replica held the Lease")
+	default:
+	}
+	if n := kubetest.Count(t, d.store.DB, "runs", ""); n != 0 {
+		t.Errorf("got %d runs gathered by a standby, want 0", n)
+	}
+}
+
+func TestElectLeader(t *testing.T) {
+	d := newTestDaemon(t)
+	d.leading.Store(false)
+	g, err := gather.New(d.config, gather.Options{Resources: []string{"prod:deployment:web"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gathered := make(chan bool, 1)
+	result := make(chan error, 1)
+	go func() {
+		result <- d.elect(ctx, cancel, kubetest.NewClientset(t), "monitoring", "kube-gather", func(ctx context.Context) error {
+			d.gather(g)
+			gathered <- d.leading.Load()
+			<-ctx.Done()
+			return nil
+		})
+	}()
+	select {
+	case leading := <-gathered:
+		if !leading {
+			t.Error("gathered without leading")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the sole replica didn't take the Lease and gather")
+	}
+	if n := kubetest.Count(t, d.store.DB, "runs", ""); n != 1 {
+		t.Errorf("got %d runs gathered by the leader, want 1", n)
+	}
+
+	cancel()
+	if err := <-result; err != nil {
+		t.Errorf("leader returned %v, want nil once stopped", err)
+	}
+	if d.leading.Load() {
+		t.Error("still leading once stopped")
+	}
+}
+
+// waitFor polls cond until it holds, failing the test if it doesn't within
+// 10 seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
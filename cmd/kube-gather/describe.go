@@ -0,0 +1,531 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/yaml"
+
+	"kube-query/pkg/gather"
+	"kube-query/pkg/store"
+)
+
+// runDescribe implements `describe kind/namespace/name --db file.db`,
+// rendering a kubectl-describe-like view of a stored resource together with
+// its pods and events as they were at collection time.
+func runDescribe(args []string) error {
+	flags := flag.NewFlagSet("describe", flag.ExitOnError)
+	dbFile := flags.String("db", "kube_data.db", "Path to the SQLite database file")
+	namespace := flags.String("namespace", "default", "Namespace, if not given as kind/namespace/name")
+	flags.StringVar(namespace, "n", "default", "Shorthand for --namespace")
+	run := flags.Int64("run", 0, "Run to describe from (defaults to the latest run)")
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+
+	var kind, name string
+	ns := *namespace
+	switch {
+	case len(positional) == 1 && strings.Count(positional[0], "/") == 2:
+		parts := strings.Split(positional[0], "/")
+		kind, ns, name = parts[0], parts[1], parts[2]
+	case len(positional) == 1 && strings.Count(positional[0], "/") == 1:
+		kind, name, _ = strings.Cut(positional[0], "/")
+	case len(positional) == 2:
+		kind, name = positional[0], positional[1]
+	default:
+		return fmt.Errorf("usage: describe kind/namespace/name, or describe kind name --namespace ns")
+	}
+	// Kinds without an alias, such as those from a namespace dump, are
+	// looked up by their stored name.
+	stored, ok := kindAliases[strings.ToLower(kind)]
+	if !ok {
+		stored = strings.ToLower(kind)
+	}
+
+	db, err := store.OpenReadOnly(*dbFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	runID := *run
+	if runID == 0 {
+		if runID, err = store.LatestRunID(db); err != nil {
+			return err
+		}
+	}
+	info, err := store.GetRun(db, runID)
+	if err != nil {
+		return err
+	}
+	asOf := time.Now()
+	if info.StartedAt != nil {
+		asOf = *info.StartedAt
+	}
+
+	resource, err := store.GetResource(db, runID, stored, ns, name)
+	if err == store.ErrNotFound {
+		return fmt.Errorf("%s %s/%s not found in run %d", stored, ns, name, runID)
+	}
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+	switch stored {
+	case "deployment":
+		return describeDeployment(w, db, resource, asOf)
+	case "pod":
+		return describePod(w, db, resource, asOf)
+	case "daemonset":
+		return describeDaemonSet(w, db, resource, asOf)
+	case "configmap", "secret":
+		return describeData(w, resource)
+	default:
+		return describeObject(w, resource)
+	}
+}
+
+func describeDeployment(w io.Writer, db *sql.DB, r *store.Resource, asOf time.Time) error {
+	var meta metav1.ObjectMeta
+	var spec appsv1.DeploymentSpec
+	var status appsv1.DeploymentStatus
+	for column, v := range map[string]interface{}{"metadata": &meta, "spec": &spec, "status": &status} {
+		if err := decodeContent(r, column, v); err != nil {
+			return err
+		}
+	}
+
+	describeMeta(w, r, &meta)
+	if spec.Selector != nil {
+		fmt.Fprintf(w, "Selector:\t%s\n", metav1.FormatLabelSelector(spec.Selector))
+	}
+	desired := int32(1)
+	if spec.Replicas != nil {
+		desired = *spec.Replicas
+	}
+	fmt.Fprintf(w, "Replicas:\t%d desired | %d updated | %d total | %d available | %d unavailable\n",
+		desired, status.UpdatedReplicas, status.Replicas, status.AvailableReplicas, status.UnavailableReplicas)
+	fmt.Fprintf(w, "StrategyType:\t%s\n", spec.Strategy.Type)
+	fmt.Fprintf(w, "MinReadySeconds:\t%d\n", spec.MinReadySeconds)
+	if ru := spec.Strategy.RollingUpdate; ru != nil && ru.MaxUnavailable != nil && ru.MaxSurge != nil {
+		fmt.Fprintf(w, "RollingUpdateStrategy:\t%s max unavailable, %s max surge\n", ru.MaxUnavailable.String(), ru.MaxSurge.String())
+	}
+
+	fmt.Fprintf(w, "Pod Template:\n")
+	fmt.Fprintf(w, "  Labels:\t%s\n", formatMap(spec.Template.Labels))
+	describeContainers(w, "  ", spec.Template.Spec.Containers, nil)
+	describeVolumes(w, "  ", spec.Template.Spec.Volumes)
+
+	if len(status.Conditions) > 0 {
+		fmt.Fprintf(w, "Conditions:\n  Type\tStatus\tReason\n  ----\t------\t------\n")
+		for _, c := range status.Conditions {
+			fmt.Fprintf(w, "  %s\t%s\t%s\n", c.Type, c.Status, c.Reason)
+		}
+	}
+
+	pods, err := store.ListDeploymentPods(db, r.ID)
+	if err != nil {
+		return err
+	}
+	if err := describePods(w, db, r.RunID, pods, asOf); err != nil {
+		return err
+	}
+
+	events, err := store.ListEvents(db, r.RunID, "deployment_id = ?", r.ID)
+	if err != nil {
+		return err
+	}
+	describeEvents(w, events, asOf, true)
+	return nil
+}
+
+// describeDaemonSet renders a DaemonSet. Events are only gathered for
+// deployments, so none are shown.
+func describeDaemonSet(w io.Writer, db *sql.DB, r *store.Resource, asOf time.Time) error {
+	var meta metav1.ObjectMeta
+	var spec appsv1.DaemonSetSpec
+	var status appsv1.DaemonSetStatus
+	for column, v := range map[string]interface{}{"metadata": &meta, "spec": &spec, "status": &status} {
+		if err := decodeContent(r, column, v); err != nil {
+			return err
+		}
+	}
+
+	describeMeta(w, r, &meta)
+	if spec.Selector != nil {
+		fmt.Fprintf(w, "Selector:\t%s\n", metav1.FormatLabelSelector(spec.Selector))
+	}
+	fmt.Fprintf(w, "Node-Selector:\t%s\n", formatMap(spec.Template.Spec.NodeSelector))
+	fmt.Fprintf(w, "Desired Number of Nodes Scheduled:\t%d\n", status.DesiredNumberScheduled)
+	fmt.Fprintf(w, "Current Number of Nodes Scheduled:\t%d\n", status.CurrentNumberScheduled)
+	fmt.Fprintf(w, "Number of Nodes Scheduled with Up-to-date Pods:\t%d\n", status.UpdatedNumberScheduled)
+	fmt.Fprintf(w, "Number of Nodes Scheduled with Available Pods:\t%d\n", status.NumberAvailable)
+	fmt.Fprintf(w, "Number of Nodes Misscheduled:\t%d\n", status.NumberMisscheduled)
+	fmt.Fprintf(w, "Pods Status:\t%d Ready / %d Unavailable\n", status.NumberReady, status.NumberUnavailable)
+
+	fmt.Fprintf(w, "Pod Template:\n")
+	fmt.Fprintf(w, "  Labels:\t%s\n", formatMap(spec.Template.Labels))
+	describeContainers(w, "  ", spec.Template.Spec.Containers, nil)
+	describeVolumes(w, "  ", spec.Template.Spec.Volumes)
+
+	pods, err := store.ListObjectPods(db, r.ID)
+	if err != nil {
+		return err
+	}
+	return describePods(w, db, r.RunID, pods, asOf)
+}
+
+// describePods renders the pods table of a workload.
+func describePods(w io.Writer, db *sql.DB, runID int64, pods []store.Resource, asOf time.Time) error {
+	if len(pods) == 0 {
+		fmt.Fprintf(w, "Pods:\t<none>\n")
+		return nil
+	}
+	fmt.Fprintf(w, "Pods:\n  Name\tReady\tStatus\tRestarts\tAge\n  ----\t-----\t------\t--------\t---\n")
+	for _, p := range pods {
+		pod, err := store.GetResource(db, runID, "pod", p.Namespace, p.Name)
+		if err != nil {
+			return err
+		}
+		var podMeta metav1.ObjectMeta
+		var podStatus corev1.PodStatus
+		if err := decodeContent(pod, "metadata", &podMeta); err != nil {
+			return err
+		}
+		if err := decodeContent(pod, "status", &podStatus); err != nil {
+			return err
+		}
+		ready, restarts := gather.PodReadiness(&podStatus)
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%d\t%s\n", p.Name, ready, gather.PodStatusReason(&podMeta, &podStatus), restarts, ageAt(podMeta.CreationTimestamp, asOf))
+	}
+	return nil
+}
+
+func describePod(w io.Writer, db *sql.DB, r *store.Resource, asOf time.Time) error {
+	var meta metav1.ObjectMeta
+	var spec corev1.PodSpec
+	var status corev1.PodStatus
+	for column, v := range map[string]interface{}{"metadata": &meta, "spec": &spec, "status": &status} {
+		if err := decodeContent(r, column, v); err != nil {
+			return err
+		}
+	}
+
+	describeMeta(w, r, &meta)
+	fmt.Fprintf(w, "Node:\t%s/%s\n", valueOrNone(spec.NodeName), valueOrNone(status.HostIP))
+	if status.StartTime != nil {
+		fmt.Fprintf(w, "Start Time:\t%s\n", status.StartTime.Format(time.RFC1123Z))
+	}
+	fmt.Fprintf(w, "Status:\t%s\n", gather.PodStatusReason(&meta, &status))
+	if status.Reason != "" {
+		fmt.Fprintf(w, "Reason:\t%s\n", status.Reason)
+	}
+	if status.Message != "" {
+		fmt.Fprintf(w, "Message:\t%s\n", status.Message)
+	}
+	fmt.Fprintf(w, "IP:\t%s\n", status.PodIP)
+	if len(meta.OwnerReferences) > 0 {
+		fmt.Fprintf(w, "Controlled By:\t%s/%s\n", meta.OwnerReferences[0].Kind, meta.OwnerReferences[0].Name)
+	}
+
+	statuses := map[string]corev1.ContainerStatus{}
+	for _, cs := range status.ContainerStatuses {
+		statuses[cs.Name] = cs
+	}
+	describeContainers(w, "", spec.Containers, statuses)
+
+	if len(status.Conditions) > 0 {
+		fmt.Fprintf(w, "Conditions:\n  Type\tStatus\n  ----\t------\n")
+		for _, c := range status.Conditions {
+			fmt.Fprintf(w, "  %s\t%s\n", c.Type, c.Status)
+		}
+	}
+	describeVolumes(w, "", spec.Volumes)
+	fmt.Fprintf(w, "QoS Class:\t%s\n", valueOrNone(string(status.QOSClass)))
+
+	events, err := store.ListEvents(db, r.RunID, "involved_kind = 'Pod' AND namespace = ? AND involved_name = ?", r.Namespace, r.Name)
+	if err != nil {
+		return err
+	}
+	describeEvents(w, events, asOf, false)
+	return nil
+}
+
+// describeData renders ConfigMaps and Secrets. Secret values and ConfigMap
+// binaryData are summarised by size, as kubectl does, rather than printed.
+func describeData(w io.Writer, r *store.Resource) error {
+	var meta metav1.ObjectMeta
+	if err := decodeContent(r, "metadata", &meta); err != nil {
+		return err
+	}
+	describeMeta(w, r, &meta)
+
+	fmt.Fprintf(w, "\nData\n====\n")
+	if r.Kind == "secret" {
+		var data map[string][]byte
+		if err := decodeContent(r, "data", &data); err != nil {
+			return err
+		}
+		for _, key := range store.SortedMapKeys(data) {
+			fmt.Fprintf(w, "%s:\t%d bytes\n", key, len(data[key]))
+		}
+		return nil
+	}
+
+	var data map[string]string
+	if err := decodeContent(r, "data", &data); err != nil {
+		return err
+	}
+	for _, key := range store.SortedMapKeys(data) {
+		fmt.Fprintf(w, "%s:\n----\n%s\n\n", key, data[key])
+	}
+
+	var binaryData map[string][]byte
+	if err := decodeContent(r, "binaryData", &binaryData); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\nBinaryData\n====\n")
+	for _, key := range store.SortedMapKeys(binaryData) {
+		fmt.Fprintf(w, "%s:\t%d bytes\n", key, len(binaryData[key]))
+	}
+	return nil
+}
+
+// describeObject renders kinds without a dedicated view as their metadata
+// followed by the spec and status as YAML.
+func describeObject(w io.Writer, r *store.Resource) error {
+	var meta metav1.ObjectMeta
+	if err := decodeContent(r, "metadata", &meta); err != nil {
+		return err
+	}
+	describeMeta(w, r, &meta)
+	for _, column := range []string{"spec", "status"} {
+		raw, ok := r.Content[column]
+		if !ok || string(raw) == "null" || string(raw) == "{}" {
+			continue
+		}
+		out, err := yaml.JSONToYAML(raw)
+		if err != nil {
+			return fmt.Errorf("Error converting %s to YAML: %v", column, err)
+		}
+		fmt.Fprintf(w, "%s%s:\n", strings.ToUpper(column[:1]), column[1:])
+		for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	return nil
+}
+
+func describeMeta(w io.Writer, r *store.Resource, meta *metav1.ObjectMeta) {
+	fmt.Fprintf(w, "Name:\t%s\n", r.Name)
+	fmt.Fprintf(w, "Namespace:\t%s\n", r.Namespace)
+	if !meta.CreationTimestamp.IsZero() {
+		fmt.Fprintf(w, "CreationTimestamp:\t%s\n", meta.CreationTimestamp.Format(time.RFC1123Z))
+	}
+	fmt.Fprintf(w, "Labels:\t%s\n", formatMap(meta.Labels))
+	annotations := map[string]string{}
+	for key, value := range meta.Annotations {
+		if key != corev1.LastAppliedConfigAnnotation {
+			annotations[key] = value
+		}
+	}
+	fmt.Fprintf(w, "Annotations:\t%s\n", formatMap(annotations))
+}
+
+func describeContainers(w io.Writer, indent string, containers []corev1.Container, statuses map[string]corev1.ContainerStatus) {
+	fmt.Fprintf(w, "%sContainers:\n", indent)
+	for _, c := range containers {
+		fmt.Fprintf(w, "%s   %s:\n", indent, c.Name)
+		fmt.Fprintf(w, "%s    Image:\t%s\n", indent, c.Image)
+		for _, p := range c.Ports {
+			fmt.Fprintf(w, "%s    Port:\t%d/%s\n", indent, p.ContainerPort, p.Protocol)
+		}
+		if len(c.Command) > 0 {
+			fmt.Fprintf(w, "%s    Command:\t%s\n", indent, strings.Join(c.Command, " "))
+		}
+		if len(c.Args) > 0 {
+			fmt.Fprintf(w, "%s    Args:\t%s\n", indent, strings.Join(c.Args, " "))
+		}
+		if cs, ok := statuses[c.Name]; ok {
+			fmt.Fprintf(w, "%s    State:\t%s\n", indent, formatContainerState(cs.State))
+			if cs.LastTerminationState.Terminated != nil {
+				fmt.Fprintf(w, "%s    Last State:\t%s\n", indent, formatContainerState(cs.LastTerminationState))
+			}
+			fmt.Fprintf(w, "%s    Ready:\t%t\n", indent, cs.Ready)
+			fmt.Fprintf(w, "%s    Restart Count:\t%d\n", indent, cs.RestartCount)
+		}
+		if len(c.Resources.Limits) > 0 {
+			fmt.Fprintf(w, "%s    Limits:\t%s\n", indent, formatResourceList(c.Resources.Limits))
+		}
+		if len(c.Resources.Requests) > 0 {
+			fmt.Fprintf(w, "%s    Requests:\t%s\n", indent, formatResourceList(c.Resources.Requests))
+		}
+		if c.LivenessProbe != nil {
+			fmt.Fprintf(w, "%s    Liveness:\t%s\n", indent, formatProbe(c.LivenessProbe))
+		}
+		if c.ReadinessProbe != nil {
+			fmt.Fprintf(w, "%s    Readiness:\t%s\n", indent, formatProbe(c.ReadinessProbe))
+		}
+		for _, from := range c.EnvFrom {
+			switch {
+			case from.ConfigMapRef != nil:
+				fmt.Fprintf(w, "%s    Environment From:\t%s\tConfigMap\n", indent, from.ConfigMapRef.Name)
+			case from.SecretRef != nil:
+				fmt.Fprintf(w, "%s    Environment From:\t%s\tSecret\n", indent, from.SecretRef.Name)
+			}
+		}
+		if len(c.Env) > 0 {
+			fmt.Fprintf(w, "%s    Environment:\n", indent)
+			for _, env := range c.Env {
+				fmt.Fprintf(w, "%s      %s:\t%s\n", indent, env.Name, formatEnvValue(env))
+			}
+		}
+		for _, m := range c.VolumeMounts {
+			fmt.Fprintf(w, "%s    Mount:\t%s from %s (ro=%t)\n", indent, m.MountPath, m.Name, m.ReadOnly)
+		}
+	}
+}
+
+func describeVolumes(w io.Writer, indent string, volumes []corev1.Volume) {
+	if len(volumes) == 0 {
+		fmt.Fprintf(w, "%sVolumes:\t<none>\n", indent)
+		return
+	}
+	fmt.Fprintf(w, "%sVolumes:\n", indent)
+	for _, v := range volumes {
+		source := "Other"
+		switch {
+		case v.ConfigMap != nil:
+			source = "ConfigMap " + v.ConfigMap.Name
+		case v.Secret != nil:
+			source = "Secret " + v.Secret.SecretName
+		case v.PersistentVolumeClaim != nil:
+			source = "PersistentVolumeClaim " + v.PersistentVolumeClaim.ClaimName
+		case v.EmptyDir != nil:
+			source = "EmptyDir"
+		case v.HostPath != nil:
+			source = "HostPath " + v.HostPath.Path
+		case v.Projected != nil:
+			source = "Projected"
+		}
+		fmt.Fprintf(w, "%s   %s:\t%s\n", indent, v.Name, source)
+	}
+}
+
+// describeEvents renders events the way kubectl does, with ages relative to
+// the collection time. Deployment views include the involved object since
+// they aggregate events from ReplicaSets and pods.
+func describeEvents(w io.Writer, events []store.Event, asOf time.Time, showObject bool) {
+	if len(events) == 0 {
+		fmt.Fprintf(w, "Events:\t<none>\n")
+		return
+	}
+	if showObject {
+		fmt.Fprintf(w, "Events:\n  Type\tReason\tAge\tObject\tFrom\tMessage\n  ----\t------\t---\t------\t----\t-------\n")
+	} else {
+		fmt.Fprintf(w, "Events:\n  Type\tReason\tAge\tFrom\tMessage\n  ----\t------\t---\t----\t-------\n")
+	}
+	for _, e := range events {
+		age := "<unknown>"
+		if e.LastSeen != nil {
+			age = duration.HumanDuration(asOf.Sub(*e.LastSeen))
+			if e.Count > 1 && e.FirstSeen != nil {
+				age = fmt.Sprintf("%s (x%d over %s)", age, e.Count, duration.HumanDuration(asOf.Sub(*e.FirstSeen)))
+			}
+		}
+		if showObject {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s/%s\t%s\t%s\n", e.Type, e.Reason, age, e.InvolvedKind, e.InvolvedName, e.SourceComponent, strings.TrimSpace(e.Message))
+		} else {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", e.Type, e.Reason, age, e.SourceComponent, strings.TrimSpace(e.Message))
+		}
+	}
+}
+
+func formatContainerState(state corev1.ContainerState) string {
+	switch {
+	case state.Running != nil:
+		return "Running (started " + state.Running.StartedAt.Format(time.RFC1123Z) + ")"
+	case state.Waiting != nil:
+		return "Waiting (" + state.Waiting.Reason + ")"
+	case state.Terminated != nil:
+		return fmt.Sprintf("Terminated (%s, exit code %d)", state.Terminated.Reason, state.Terminated.ExitCode)
+	}
+	return "Unknown"
+}
+
+func formatProbe(p *corev1.Probe) string {
+	var action string
+	switch {
+	case p.HTTPGet != nil:
+		action = fmt.Sprintf("http-get %s:%s", p.HTTPGet.Path, p.HTTPGet.Port.String())
+	case p.TCPSocket != nil:
+		action = "tcp-socket :" + p.TCPSocket.Port.String()
+	case p.Exec != nil:
+		action = "exec " + strings.Join(p.Exec.Command, " ")
+	case p.GRPC != nil:
+		action = fmt.Sprintf("grpc :%d", p.GRPC.Port)
+	default:
+		action = "unknown"
+	}
+	return fmt.Sprintf("%s delay=%ds timeout=%ds period=%ds #success=%d #failure=%d",
+		action, p.InitialDelaySeconds, p.TimeoutSeconds, p.PeriodSeconds, p.SuccessThreshold, p.FailureThreshold)
+}
+
+func formatEnvValue(env corev1.EnvVar) string {
+	if env.ValueFrom == nil {
+		return env.Value
+	}
+	switch from := env.ValueFrom; {
+	case from.ConfigMapKeyRef != nil:
+		return fmt.Sprintf("<set to the key '%s' of config map '%s'>", from.ConfigMapKeyRef.Key, from.ConfigMapKeyRef.Name)
+	case from.SecretKeyRef != nil:
+		return fmt.Sprintf("<set to the key '%s' in secret '%s'>", from.SecretKeyRef.Key, from.SecretKeyRef.Name)
+	case from.FieldRef != nil:
+		return fmt.Sprintf("(%s:%s)", from.FieldRef.APIVersion, from.FieldRef.FieldPath)
+	case from.ResourceFieldRef != nil:
+		return fmt.Sprintf("%s (%s)", from.ResourceFieldRef.Resource, from.ResourceFieldRef.ContainerName)
+	}
+	return ""
+}
+
+func formatResourceList(list corev1.ResourceList) string {
+	parts := make([]string, 0, len(list))
+	for name, quantity := range list {
+		parts = append(parts, fmt.Sprintf("%s=%s", name, quantity.String()))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+func formatMap(m map[string]string) string {
+	if len(m) == 0 {
+		return "<none>"
+	}
+	parts := make([]string, 0, len(m))
+	for _, key := range store.SortedMapKeys(m) {
+		parts = append(parts, key+"="+m[key])
+	}
+	return strings.Join(parts, "\n\t")
+}
+
+func valueOrNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
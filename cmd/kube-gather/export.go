@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"kube-query/pkg/export"
+	"kube-query/pkg/store"
+)
+
+// runExport implements `export --format must-gather --out dir`, writing a
+// run out in the directory layout vendors' must-gather tooling reads:
+//
+//	cluster-scoped-resources/<group>/<resource>/<name>.yaml
+//	namespaces/<ns>/<group>/<resource>/<name>.yaml
+//	namespaces/<ns>/core/events.yaml
+//	namespaces/<ns>/pods/<pod>/<container>/<container>/logs/current.log
+//
+// The core API group is written as "core". With --format csv it writes a
+// table's rows in the run, or a query's results, as CSV instead, and with
+// --format otlp or loki it sends the run's pod logs to an OpenTelemetry
+// collector or to Loki. --format elasticsearch indexes its objects and logs
+// into Elasticsearch or OpenSearch, and --format kustomize writes the objects
+// matching --app as a kustomize base.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	dbFile := flags.String("db", "kube_data.db", "Path to the SQLite database file")
+	run := flags.Int64("run", 0, "Run to export (defaults to the latest run)")
+	format := flags.String("format", "must-gather", "Export format: must-gather, csv, otlp, loki, elasticsearch or kustomize")
+	flags.StringVar(format, "to", "must-gather", "Alias for --format")
+	out := flags.String("out", "", "Directory (must-gather and kustomize, default the format's name) or file (csv, default stdout) to export to")
+	table := flags.String("table", "", "Table to export as CSV, limited to the run if it has a run_id column")
+	statement := flags.String("query", "", "SQL query whose results to export as CSV")
+	name := flags.String("name", "", "Named query whose results to export as CSV")
+	endpoint := flags.String("endpoint", "", "OTLP/HTTP collector endpoint to send logs to, e.g. http://localhost:4318 (defaults to $OTEL_EXPORTER_OTLP_ENDPOINT)")
+	url := flags.String("url", "", "Loki or Elasticsearch URL to export to, e.g. http://loki:3100")
+	app := flags.String("app", "", "Label selector of the objects to write as a kustomize base, e.g. app.kubernetes.io/name=shop")
+	indexPrefix := flags.String("index-prefix", "kube-gather", "Prefix of the Elasticsearch indices, <prefix>-objects and <prefix>-logs")
+	headers := flags.String("headers", "", "Comma-separated key=value headers to send with logs, e.g. X-Scope-OrgID=ops (OTLP defaults to $OTEL_EXPORTER_OTLP_HEADERS)")
+	cluster := flags.String("cluster", "", "Cluster name to label exported logs with")
+	passphraseEnv := flags.String("passphrase-env", "", "Environment variable holding the passphrase of an encrypted database")
+	if _, err := parseInterspersed(flags, args); err != nil {
+		return err
+	}
+	switch *format {
+	case "must-gather", "csv":
+	case "otlp":
+		if *endpoint == "" {
+			*endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		}
+		if *headers == "" {
+			*headers = os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")
+		}
+		if *endpoint == "" {
+			return fmt.Errorf("--format otlp needs --endpoint")
+		}
+	case "kustomize":
+		if *app == "" {
+			return fmt.Errorf("--format kustomize needs --app")
+		}
+	case "loki", "elasticsearch", "opensearch":
+		if *url == "" {
+			return fmt.Errorf("--format %s needs --url", *format)
+		}
+	default:
+		return fmt.Errorf("unknown export format %q, expected must-gather, csv, otlp, loki, elasticsearch or kustomize", *format)
+	}
+
+	db, err := openReadOnly(*dbFile, *passphraseEnv)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	runID := *run
+	if runID == 0 {
+		if runID, err = store.LatestRunID(db); err != nil {
+			return err
+		}
+	}
+	if *format == "csv" {
+		return export.CSV(db, runID, *out, *table, *statement, *name)
+	}
+	if *format == "otlp" {
+		sent, err := export.OTLP(db, runID, *endpoint, *headers, *cluster)
+		if sent > 0 {
+			fmt.Printf("Exported %d log lines from run %d to %s\n", sent, runID, *endpoint)
+		}
+		return err
+	}
+	if *format == "loki" {
+		pushed, err := export.Loki(db, runID, *url, *headers, *cluster)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Pushed %d log lines from run %d to %s\n", pushed, runID, *url)
+		return nil
+	}
+	if *format == "elasticsearch" || *format == "opensearch" {
+		indexed, err := export.Elasticsearch(db, runID, *url, *headers, *indexPrefix, *cluster)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Indexed %d documents from run %d into %s-objects and %s-logs at %s\n", indexed, runID, *indexPrefix, *indexPrefix, *url)
+		return nil
+	}
+	if *out == "" {
+		*out = *format
+	}
+	if *format == "kustomize" {
+		return export.Kustomize(db, runID, *out, *app)
+	}
+	return export.MustGather(db, runID, *out)
+}
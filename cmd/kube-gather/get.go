@@ -0,0 +1,235 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+
+	"kube-query/pkg/export"
+	"kube-query/pkg/gather"
+	"kube-query/pkg/store"
+)
+
+// kindAliases maps the kind names kubectl accepts to stored kinds.
+var kindAliases = map[string]string{
+	"deployment": "deployment", "deployments": "deployment", "deploy": "deployment",
+	"pod": "pod", "pods": "pod", "po": "pod",
+	"configmap": "configmap", "configmaps": "configmap", "cm": "configmap",
+	"secret": "secret", "secrets": "secret",
+	"daemonset": "daemonset", "daemonsets": "daemonset", "ds": "daemonset",
+	"statefulset": "statefulset", "statefulsets": "statefulset", "sts": "statefulset",
+	"controllerrevision": "controllerrevision", "controllerrevisions": "controllerrevision",
+	"job": "job", "jobs": "job",
+	"cronjob": "cronjob", "cronjobs": "cronjob", "cj": "cronjob",
+	"horizontalpodautoscaler": "horizontalpodautoscaler", "horizontalpodautoscalers": "horizontalpodautoscaler", "hpa": "horizontalpodautoscaler",
+	"verticalpodautoscaler": "verticalpodautoscaler", "verticalpodautoscalers": "verticalpodautoscaler", "vpa": "verticalpodautoscaler",
+	"poddisruptionbudget": "poddisruptionbudget", "poddisruptionbudgets": "poddisruptionbudget", "pdb": "poddisruptionbudget",
+	"service": "service", "services": "service", "svc": "service",
+	"endpoints": "endpoints", "ep": "endpoints",
+	"endpointslice": "endpointslice", "endpointslices": "endpointslice",
+	"ingress": "ingress", "ingresses": "ingress", "ing": "ingress",
+	"ingressclass": "ingressclass", "ingressclasses": "ingressclass",
+	"networkpolicy": "networkpolicy", "networkpolicies": "networkpolicy", "netpol": "networkpolicy",
+	"persistentvolumeclaim": "persistentvolumeclaim", "persistentvolumeclaims": "persistentvolumeclaim", "pvc": "persistentvolumeclaim",
+	"persistentvolume": "persistentvolume", "persistentvolumes": "persistentvolume", "pv": "persistentvolume",
+	"storageclass": "storageclass", "storageclasses": "storageclass", "sc": "storageclass",
+	"csidriver": "csidriver", "csidrivers": "csidriver",
+	"csinode": "csinode", "csinodes": "csinode",
+	"volumeattachment": "volumeattachment", "volumeattachments": "volumeattachment",
+	"gatewayclass": "gatewayclass", "gatewayclasses": "gatewayclass",
+	"gateway": "gateway", "gateways": "gateway", "gtw": "gateway",
+	"httproute": "httproute", "httproutes": "httproute",
+	"route": "route", "routes": "route",
+	"deploymentconfig": "deploymentconfig", "deploymentconfigs": "deploymentconfig", "dc": "deploymentconfig",
+	"clusteroperator": "clusteroperator", "clusteroperators": "clusteroperator", "co": "clusteroperator",
+	"clusterversion": "clusterversion", "clusterversions": "clusterversion",
+	"customresourcedefinition": "customresourcedefinition", "customresourcedefinitions": "customresourcedefinition", "crd": "customresourcedefinition", "crds": "customresourcedefinition",
+	"validatingwebhookconfiguration": "validatingwebhookconfiguration", "validatingwebhookconfigurations": "validatingwebhookconfiguration",
+	"mutatingwebhookconfiguration": "mutatingwebhookconfiguration", "mutatingwebhookconfigurations": "mutatingwebhookconfiguration",
+	"role": "role", "roles": "role",
+	"rolebinding": "rolebinding", "rolebindings": "rolebinding",
+	"clusterrole": "clusterrole", "clusterroles": "clusterrole",
+	"clusterrolebinding": "clusterrolebinding", "clusterrolebindings": "clusterrolebinding",
+	"certificatesigningrequest": "certificatesigningrequest", "certificatesigningrequests": "certificatesigningrequest", "csr": "certificatesigningrequest", "csrs": "certificatesigningrequest",
+	"certificate": "certificate", "certificates": "certificate", "cert": "certificate", "certs": "certificate",
+	"certificaterequest": "certificaterequest", "certificaterequests": "certificaterequest", "cr": "certificaterequest",
+	"issuer": "issuer", "issuers": "issuer",
+	"clusterissuer": "clusterissuer", "clusterissuers": "clusterissuer",
+	"application": "application", "applications": "application",
+	"applicationset": "applicationset", "applicationsets": "applicationset",
+	"appproject": "appproject", "appprojects": "appproject",
+}
+
+// runGet implements `get <kind> [name] --db file.db`, listing stored
+// resources with kubectl-like columns. Ages are computed as of the time the
+// run was collected rather than now.
+func runGet(args []string) error {
+	flags := flag.NewFlagSet("get", flag.ExitOnError)
+	dbFile := flags.String("db", "kube_data.db", "Path to the SQLite database file")
+	namespace := flags.String("namespace", "", "Only list resources in this namespace (defaults to all)")
+	flags.StringVar(namespace, "n", "", "Shorthand for --namespace")
+	run := flags.Int64("run", 0, "Run to list (defaults to the latest run)")
+	format := flags.String("output", "table", "Output format: table, json or csv")
+	flags.StringVar(format, "o", "table", "Shorthand for --output")
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) < 1 || len(positional) > 2 {
+		return fmt.Errorf("usage: get <kind> [name] [--namespace ns]")
+	}
+	kind, ok := kindAliases[strings.ToLower(positional[0])]
+	if !ok {
+		kind = strings.ToLower(positional[0])
+	}
+	var name string
+	if len(positional) == 2 {
+		name = positional[1]
+	}
+
+	db, err := store.OpenReadOnly(*dbFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	runID := *run
+	if runID == 0 {
+		if runID, err = store.LatestRunID(db); err != nil {
+			return err
+		}
+	}
+	info, err := store.GetRun(db, runID)
+	if err != nil {
+		return err
+	}
+	asOf := time.Now()
+	if info.StartedAt != nil {
+		asOf = *info.StartedAt
+	}
+
+	columns, records, err := getRows(db, runID, kind, *namespace, name, asOf)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		fmt.Fprintf(os.Stderr, "No resources found in run %d.\n", runID)
+		return nil
+	}
+	return export.WriteRecords(os.Stdout, *format, columns, records)
+}
+
+func getRows(db *sql.DB, runID int64, kind, namespace, name string, asOf time.Time) ([]string, [][]interface{}, error) {
+	resources, err := store.ListResources(db, runID, kind)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var columns []string
+	switch kind {
+	case "deployment":
+		columns = []string{"namespace", "name", "ready", "up-to-date", "available", "age"}
+	case "pod":
+		columns = []string{"namespace", "name", "ready", "status", "restarts", "age"}
+	case "daemonset":
+		columns = []string{"namespace", "name", "desired", "current", "ready", "up-to-date", "available", "age"}
+	case "configmap", "secret":
+		columns = []string{"namespace", "name", "data", "age"}
+	default:
+		columns = []string{"namespace", "name", "age"}
+	}
+
+	var records [][]interface{}
+	for _, r := range resources {
+		if (namespace != "" && r.Namespace != namespace) || (name != "" && r.Name != name) {
+			continue
+		}
+		resource, err := store.GetResource(db, runID, r.Kind, r.Namespace, r.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var meta metav1.ObjectMeta
+		if err := decodeContent(resource, "metadata", &meta); err != nil {
+			return nil, nil, err
+		}
+		age := ageAt(meta.CreationTimestamp, asOf)
+
+		var record []interface{}
+		switch kind {
+		case "deployment":
+			var spec appsv1.DeploymentSpec
+			var status appsv1.DeploymentStatus
+			if err := decodeContent(resource, "spec", &spec); err != nil {
+				return nil, nil, err
+			}
+			if err := decodeContent(resource, "status", &status); err != nil {
+				return nil, nil, err
+			}
+			desired := int32(1)
+			if spec.Replicas != nil {
+				desired = *spec.Replicas
+			}
+			record = []interface{}{r.Namespace, r.Name,
+				fmt.Sprintf("%d/%d", status.ReadyReplicas, desired),
+				status.UpdatedReplicas, status.AvailableReplicas, age}
+		case "pod":
+			var status corev1.PodStatus
+			if err := decodeContent(resource, "status", &status); err != nil {
+				return nil, nil, err
+			}
+			ready, restarts := gather.PodReadiness(&status)
+			record = []interface{}{r.Namespace, r.Name, ready, gather.PodStatusReason(&meta, &status), restarts, age}
+		case "daemonset":
+			var status appsv1.DaemonSetStatus
+			if err := decodeContent(resource, "status", &status); err != nil {
+				return nil, nil, err
+			}
+			record = []interface{}{r.Namespace, r.Name, status.DesiredNumberScheduled, status.CurrentNumberScheduled,
+				status.NumberReady, status.UpdatedNumberScheduled, status.NumberAvailable, age}
+		case "configmap", "secret":
+			var data, binaryData map[string]interface{}
+			if err := decodeContent(resource, "data", &data); err != nil {
+				return nil, nil, err
+			}
+			if err := decodeContent(resource, "binaryData", &binaryData); err != nil {
+				return nil, nil, err
+			}
+			record = []interface{}{r.Namespace, r.Name, len(data) + len(binaryData), age}
+		default:
+			record = []interface{}{r.Namespace, r.Name, age}
+		}
+		records = append(records, record)
+	}
+	return columns, records, nil
+}
+
+// ageAt formats the age of an object as of the collection time.
+func ageAt(created metav1.Time, asOf time.Time) string {
+	if created.IsZero() {
+		return "<unknown>"
+	}
+	return duration.HumanDuration(asOf.Sub(created.Time))
+}
+
+// decodeContent decodes one of a stored resource's JSON columns, leaving the
+// target untouched if the column is empty.
+func decodeContent(r *store.Resource, column string, v interface{}) error {
+	raw, ok := r.Content[column]
+	if !ok || len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("Error decoding %s of %s %s/%s: %v", column, r.Kind, r.Namespace, r.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file implements the subset of GraphQL needed to traverse the gather
+// graph in one round trip: queries with nested selections, aliases, arguments
+// and variables. Mutations, fragments and directives are not supported.
+
+// gqlType describes an object type as a set of field resolvers.
+type gqlType struct {
+	name   string
+	fields map[string]gqlResolver
+}
+
+// gqlResolver resolves a field against its parent's source value. It returns
+// a scalar, a gqlObject, or a []gqlObject.
+type gqlResolver func(source interface{}, args map[string]interface{}) (interface{}, error)
+
+// gqlObject pairs a resolved source value with the type used to resolve its
+// sub-selections.
+type gqlObject struct {
+	typ    *gqlType
+	source interface{}
+}
+
+type gqlSelection struct {
+	alias      string
+	name       string
+	args       map[string]interface{}
+	selections []gqlSelection
+}
+
+type gqlRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type gqlError struct {
+	Message string `json:"message"`
+}
+
+type gqlResponse struct {
+	Data   map[string]interface{} `json:"data,omitempty"`
+	Errors []gqlError             `json:"errors,omitempty"`
+}
+
+// handleGraphQL serves GraphQL requests as JSON POST bodies or GET query
+// parameters.
+func handleGraphQL(root *gqlType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req gqlRequest
+		if r.Method == http.MethodPost {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSONResponse(w, gqlResponse{Errors: []gqlError{{Message: "invalid request body: " + err.Error()}}})
+				return
+			}
+		} else {
+			req.Query = r.URL.Query().Get("query")
+			if variables := r.URL.Query().Get("variables"); variables != "" {
+				if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+					writeJSONResponse(w, gqlResponse{Errors: []gqlError{{Message: "invalid variables: " + err.Error()}}})
+					return
+				}
+			}
+		}
+
+		data, err := executeGraphQL(root, req.Query, req.Variables)
+		if err != nil {
+			writeJSONResponse(w, gqlResponse{Errors: []gqlError{{Message: err.Error()}}})
+			return
+		}
+		writeJSONResponse(w, gqlResponse{Data: data})
+	}
+}
+
+func executeGraphQL(root *gqlType, query string, variables map[string]interface{}) (map[string]interface{}, error) {
+	p := &gqlParser{src: query, variables: variables}
+	selections, err := p.parseDocument()
+	if err != nil {
+		return nil, err
+	}
+	return resolveSelections(root, nil, selections)
+}
+
+func resolveSelections(t *gqlType, source interface{}, selections []gqlSelection) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(selections))
+	for _, sel := range selections {
+		if sel.name == "__typename" {
+			out[sel.alias] = t.name
+			continue
+		}
+		resolver, ok := t.fields[sel.name]
+		if !ok {
+			return nil, fmt.Errorf("cannot query field %q on type %q", sel.name, t.name)
+		}
+		value, err := resolver(source, sel.args)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", sel.name, err)
+		}
+		out[sel.alias], err = completeValue(sel, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func completeValue(sel gqlSelection, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case gqlObject:
+		if len(sel.selections) == 0 {
+			return nil, fmt.Errorf("field %q of type %q must have a selection of subfields", sel.name, v.typ.name)
+		}
+		return resolveSelections(v.typ, v.source, sel.selections)
+	case *gqlObject:
+		if v == nil {
+			return nil, nil
+		}
+		return completeValue(sel, *v)
+	case []gqlObject:
+		list := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			completed, err := completeValue(sel, item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, completed)
+		}
+		return list, nil
+	default:
+		if len(sel.selections) != 0 {
+			return nil, fmt.Errorf("field %q is a scalar and cannot have subfields", sel.name)
+		}
+		return value, nil
+	}
+}
+
+// gqlParser is a small recursive-descent parser for GraphQL query documents.
+type gqlParser struct {
+	src       string
+	pos       int
+	variables map[string]interface{}
+}
+
+func (p *gqlParser) parseDocument() ([]gqlSelection, error) {
+	p.skipIgnored()
+	if name := p.peekName(); name != "" {
+		if name != "query" {
+			return nil, fmt.Errorf("unsupported operation %q", name)
+		}
+		p.readName()
+		p.skipIgnored()
+		p.readName()
+		p.skipIgnored()
+		if p.peek() == '(' {
+			if err := p.parseVariableDefinitions(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	selections, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	p.skipIgnored()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q after query; only a single operation is supported", p.src[p.pos:p.pos+1])
+	}
+	return selections, nil
+}
+
+// parseVariableDefinitions applies default values for variables that were
+// not supplied; types are not checked.
+func (p *gqlParser) parseVariableDefinitions() error {
+	p.pos++
+	for {
+		p.skipIgnored()
+		if p.peek() == ')' {
+			p.pos++
+			return nil
+		}
+		if err := p.expect('$'); err != nil {
+			return err
+		}
+		name := p.readName()
+		p.skipIgnored()
+		if err := p.expect(':'); err != nil {
+			return err
+		}
+		p.skipIgnored()
+		for p.peek() != 0 && (strings.ContainsRune("[]!", rune(p.peek())) || isNameChar(p.peek())) {
+			p.pos++
+			p.skipIgnored()
+		}
+		if p.peek() == '=' {
+			p.pos++
+			value, err := p.parseValue()
+			if err != nil {
+				return err
+			}
+			if _, ok := p.variables[name]; !ok {
+				if p.variables == nil {
+					p.variables = map[string]interface{}{}
+				}
+				p.variables[name] = value
+			}
+		}
+	}
+}
+
+func (p *gqlParser) parseSelectionSet() ([]gqlSelection, error) {
+	p.skipIgnored()
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+
+	var selections []gqlSelection
+	for {
+		p.skipIgnored()
+		if p.peek() == '}' {
+			p.pos++
+			if len(selections) == 0 {
+				return nil, p.errorf("empty selection set")
+			}
+			return selections, nil
+		}
+		if strings.HasPrefix(p.src[p.pos:], "...") {
+			return nil, p.errorf("fragments are not supported")
+		}
+
+		sel, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, sel)
+	}
+}
+
+func (p *gqlParser) parseField() (gqlSelection, error) {
+	name := p.readName()
+	if name == "" {
+		return gqlSelection{}, p.errorf("expected field name")
+	}
+	sel := gqlSelection{alias: name, name: name}
+
+	p.skipIgnored()
+	if p.peek() == ':' {
+		p.pos++
+		p.skipIgnored()
+		sel.name = p.readName()
+		if sel.name == "" {
+			return gqlSelection{}, p.errorf("expected field name after alias %q", name)
+		}
+		p.skipIgnored()
+	}
+
+	if p.peek() == '(' {
+		p.pos++
+		sel.args = map[string]interface{}{}
+		for {
+			p.skipIgnored()
+			if p.peek() == ')' {
+				p.pos++
+				break
+			}
+			argName := p.readName()
+			if argName == "" {
+				return gqlSelection{}, p.errorf("expected argument name")
+			}
+			p.skipIgnored()
+			if err := p.expect(':'); err != nil {
+				return gqlSelection{}, err
+			}
+			value, err := p.parseValue()
+			if err != nil {
+				return gqlSelection{}, err
+			}
+			sel.args[argName] = value
+		}
+		p.skipIgnored()
+	}
+
+	if p.peek() == '{' {
+		selections, err := p.parseSelectionSet()
+		if err != nil {
+			return gqlSelection{}, err
+		}
+		sel.selections = selections
+	}
+	return sel, nil
+}
+
+func (p *gqlParser) parseValue() (interface{}, error) {
+	p.skipIgnored()
+	switch c := p.peek(); {
+	case c == '$':
+		p.pos++
+		name := p.readName()
+		return p.variables[name], nil
+	case c == '"':
+		return p.parseString()
+	case c == '[':
+		p.pos++
+		var list []interface{}
+		for {
+			p.skipIgnored()
+			if p.peek() == ']' {
+				p.pos++
+				return list, nil
+			}
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && strings.ContainsRune("0123456789.eE+-", rune(p.src[p.pos])) {
+			p.pos++
+		}
+		literal := p.src[start:p.pos]
+		if i, err := strconv.ParseInt(literal, 10, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(literal, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", literal)
+		}
+		return f, nil
+	case isNameChar(c):
+		switch name := p.readName(); name {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		default:
+			return name, nil
+		}
+	default:
+		return nil, p.errorf("unexpected character %q", string(c))
+	}
+}
+
+func (p *gqlParser) parseString() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.pos >= len(p.src) {
+				return "", p.errorf("unterminated string")
+			}
+			escaped := p.src[p.pos]
+			p.pos++
+			switch escaped {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if p.pos+4 > len(p.src) {
+					return "", p.errorf("invalid unicode escape")
+				}
+				r, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 32)
+				if err != nil {
+					return "", p.errorf("invalid unicode escape")
+				}
+				b.WriteRune(rune(r))
+				p.pos += 4
+			default:
+				b.WriteByte(escaped)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// skipIgnored skips whitespace, commas and comments, which GraphQL treats as
+// insignificant.
+func (p *gqlParser) skipIgnored() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == ',' || unicode.IsSpace(rune(c)):
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *gqlParser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *gqlParser) peekName() string {
+	start := p.pos
+	name := p.readName()
+	p.pos = start
+	return name
+}
+
+func (p *gqlParser) readName() string {
+	start := p.pos
+	for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *gqlParser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorf("expected %q", string(c))
+	}
+	p.pos++
+	return nil
+}
+
+func (p *gqlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("syntax error at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"kube-query/pkg/store"
+)
+
+// newGraphQLSchema builds the query root for serve mode's /graphql endpoint.
+// It lets callers walk from a run to its deployments and on to the pods,
+// ConfigMaps, Secrets and logs they depend on in a single request, e.g.
+//
+//	{ run { deployment(namespace: "prod", name: "web") {
+//	    name configmaps { name data } pods { name logs } } } }
+func newGraphQLSchema(db *sql.DB) *gqlType {
+	runType := &gqlType{name: "Run"}
+	deploymentType := &gqlType{name: "Deployment"}
+	podType := &gqlType{name: "Pod"}
+	configMapType := &gqlType{name: "ConfigMap"}
+	secretType := &gqlType{name: "Secret"}
+	typesByKind := map[string]*gqlType{
+		"deployment": deploymentType,
+		"pod":        podType,
+		"configmap":  configMapType,
+		"secret":     secretType,
+	}
+
+	// resources lists resources of a kind in a run, applying the optional
+	// namespace and name arguments.
+	resources := func(runID int64, kind string, args map[string]interface{}) ([]gqlObject, error) {
+		list, err := store.ListResources(db, runID, kind)
+		if err != nil {
+			return nil, err
+		}
+		namespace, name := argString(args, "namespace"), argString(args, "name")
+		objects := []gqlObject{}
+		for i := range list {
+			r := list[i]
+			if (namespace != "" && r.Namespace != namespace) || (name != "" && r.Name != name) {
+				continue
+			}
+			objects = append(objects, gqlObject{typ: typesByKind[kind], source: &r})
+		}
+		return objects, nil
+	}
+
+	// resource fetches a single named resource, resolving to null if absent.
+	resource := func(runID int64, kind, namespace, name string) (*gqlObject, error) {
+		r, err := store.GetResource(db, runID, kind, namespace, name)
+		if err == store.ErrNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &gqlObject{typ: typesByKind[kind], source: r}, nil
+	}
+
+	// content decodes one of a resource's stored JSON columns.
+	content := func(column string) gqlResolver {
+		return func(source interface{}, args map[string]interface{}) (interface{}, error) {
+			r := source.(*store.Resource)
+			if r.Content == nil {
+				loaded, err := store.GetResource(db, r.RunID, r.Kind, r.Namespace, r.Name)
+				if err != nil {
+					return nil, err
+				}
+				r.Content = loaded.Content
+			}
+			raw, ok := r.Content[column]
+			if !ok {
+				return nil, nil
+			}
+			var value interface{}
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return nil, err
+			}
+			return value, nil
+		}
+	}
+
+	// references resolves the ConfigMaps or Secrets a deployment refers to
+	// that were also gathered in the same run.
+	references := func(kind string) gqlResolver {
+		return func(source interface{}, args map[string]interface{}) (interface{}, error) {
+			d := source.(*store.Resource)
+			spec, err := content("spec")(d, nil)
+			if err != nil || spec == nil {
+				return []gqlObject{}, err
+			}
+			specBytes, err := json.Marshal(spec)
+			if err != nil {
+				return nil, err
+			}
+			refs, err := store.FindConfigReferences(specBytes)
+			if err != nil {
+				return nil, err
+			}
+			names := refs.ConfigMaps
+			if kind == "secret" {
+				names = refs.Secrets
+			}
+
+			objects := []gqlObject{}
+			for _, name := range names {
+				object, err := resource(d.RunID, kind, d.Namespace, name)
+				if err != nil {
+					return nil, err
+				}
+				if object != nil {
+					objects = append(objects, *object)
+				}
+			}
+			return objects, nil
+		}
+	}
+
+	// consumers resolves the deployments in the same run that reference a
+	// ConfigMap or Secret.
+	consumers := func(source interface{}, args map[string]interface{}) (interface{}, error) {
+		r := source.(*store.Resource)
+		deployments, err := resources(r.RunID, "deployment", map[string]interface{}{"namespace": r.Namespace})
+		if err != nil {
+			return nil, err
+		}
+		kind := "configmaps"
+		if r.Kind == "secret" {
+			kind = "secrets"
+		}
+
+		objects := []gqlObject{}
+		for _, d := range deployments {
+			referenced, err := deploymentType.fields[kind](d.source, nil)
+			if err != nil {
+				return nil, err
+			}
+			for _, ref := range referenced.([]gqlObject) {
+				if ref.source.(*store.Resource).Name == r.Name {
+					objects = append(objects, d)
+					break
+				}
+			}
+		}
+		return objects, nil
+	}
+
+	metadataFields := func(fields map[string]gqlResolver) map[string]gqlResolver {
+		fields["id"] = func(source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(*store.Resource).ID, nil
+		}
+		fields["runId"] = func(source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(*store.Resource).RunID, nil
+		}
+		fields["kind"] = func(source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(*store.Resource).Kind, nil
+		}
+		fields["namespace"] = func(source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(*store.Resource).Namespace, nil
+		}
+		fields["name"] = func(source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(*store.Resource).Name, nil
+		}
+		return fields
+	}
+
+	runType.fields = map[string]gqlResolver{
+		"id": func(source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(store.RunInfo).ID, nil
+		},
+		"startedAt": func(source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(store.RunInfo).StartedAt, nil
+		},
+		"finishedAt": func(source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(store.RunInfo).FinishedAt, nil
+		},
+		"interrupted": func(source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(store.RunInfo).Interrupted, nil
+		},
+		"summary": func(source interface{}, args map[string]interface{}) (interface{}, error) {
+			var summary interface{}
+			if raw := source.(store.RunInfo).Summary; raw != nil {
+				if err := json.Unmarshal(raw, &summary); err != nil {
+					return nil, err
+				}
+			}
+			return summary, nil
+		},
+	}
+	for kind, field := range map[string]string{"deployment": "deployments", "pod": "pods", "configmap": "configmaps", "secret": "secrets"} {
+		kind := kind
+		runType.fields[field] = func(source interface{}, args map[string]interface{}) (interface{}, error) {
+			return resources(source.(store.RunInfo).ID, kind, args)
+		}
+		runType.fields[kind] = func(source interface{}, args map[string]interface{}) (interface{}, error) {
+			namespace, name := argString(args, "namespace"), argString(args, "name")
+			if namespace == "" || name == "" {
+				return nil, fmt.Errorf("namespace and name arguments are required")
+			}
+			return resource(source.(store.RunInfo).ID, kind, namespace, name)
+		}
+	}
+
+	deploymentType.fields = metadataFields(map[string]gqlResolver{
+		"metadata":   content("metadata"),
+		"spec":       content("spec"),
+		"status":     content("status"),
+		"configmaps": references("configmap"),
+		"secrets":    references("secret"),
+		"pods": func(source interface{}, args map[string]interface{}) (interface{}, error) {
+			pods, err := store.ListDeploymentPods(db, source.(*store.Resource).ID)
+			if err != nil {
+				return nil, err
+			}
+			objects := make([]gqlObject, 0, len(pods))
+			for i := range pods {
+				objects = append(objects, gqlObject{typ: podType, source: &pods[i]})
+			}
+			return objects, nil
+		},
+		"logs": func(source interface{}, args map[string]interface{}) (interface{}, error) {
+			d := source.(*store.Resource)
+			logs, err := store.GetDeploymentLogs(db, d.RunID, d.Namespace, d.Name)
+			if err == store.ErrNotFound {
+				return nil, nil
+			}
+			return string(logs), err
+		},
+	})
+
+	podType.fields = metadataFields(map[string]gqlResolver{
+		"metadata": content("metadata"),
+		"spec":     content("spec"),
+		"status":   content("status"),
+		"logs": func(source interface{}, args map[string]interface{}) (interface{}, error) {
+			p := source.(*store.Resource)
+			return store.GetPodLogs(db, p.RunID, p.Namespace, p.Name)
+		},
+	})
+
+	configMapType.fields = metadataFields(map[string]gqlResolver{
+		"metadata":   content("metadata"),
+		"data":       content("data"),
+		"binaryData": content("binaryData"),
+		"consumers":  consumers,
+	})
+
+	secretType.fields = metadataFields(map[string]gqlResolver{
+		"metadata":  content("metadata"),
+		"data":      content("data"),
+		"consumers": consumers,
+	})
+
+	return &gqlType{name: "Query", fields: map[string]gqlResolver{
+		"runs": func(source interface{}, args map[string]interface{}) (interface{}, error) {
+			runs, err := store.ListRuns(db)
+			if err != nil {
+				return nil, err
+			}
+			objects := make([]gqlObject, 0, len(runs))
+			for _, run := range runs {
+				objects = append(objects, gqlObject{typ: runType, source: run})
+			}
+			return objects, nil
+		},
+		"run": func(source interface{}, args map[string]interface{}) (interface{}, error) {
+			runID := argInt(args, "id")
+			if runID == 0 {
+				var err error
+				if runID, err = store.LatestRunID(db); err != nil {
+					return nil, err
+				}
+			}
+			run, err := store.GetRun(db, runID)
+			if err == store.ErrNotFound {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return &gqlObject{typ: runType, source: *run}, nil
+		},
+	}}
+}
+
+func argString(args map[string]interface{}, name string) string {
+	s, _ := args[name].(string)
+	return s
+}
+
+// argInt reads an integer argument, which arrives as int64 from literals and
+// float64 from JSON variables.
+func argInt(args map[string]interface{}, name string) int64 {
+	switch v := args[name].(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"kube-query/pkg/export"
+	"kube-query/pkg/store"
+)
+
+// runImport implements `import <dir|tar.gz> --db file.db`, loading a
+// must-gather or support bundle of YAML or JSON objects and pod logs as a new
+// run, so it can be queried like a gather.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dbFile := flags.String("db", "kube_data.db", "Path to the SQLite database file")
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: import <dir|bundle.tar.gz> [--db file.db]")
+	}
+
+	s, err := store.Open(*dbFile)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	summary, err := export.ImportBundle(s, positional[0])
+	if err != nil {
+		return err
+	}
+	summary.Print(os.Stdout)
+	return nil
+}
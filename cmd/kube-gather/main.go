@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+
+	"kube-query/pkg/export"
+	"kube-query/pkg/gather"
+	"kube-query/pkg/store"
+)
+
+// subcommands maps the first command-line argument to an alternative entry
+// point. Without a recognised subcommand the tool performs a gather.
+var subcommands = map[string]func(args []string) error{
+	"query":    runQuery,
+	"serve":    runServe,
+	"browse":   runBrowse,
+	"search":   runSearch,
+	"get":      runGet,
+	"describe": runDescribe,
+	"analyze":  runAnalyze,
+	"report":   runReport,
+	"export":   runExport,
+	"import":   runImport,
+	"daemon":   runDaemon,
+	"operator": runOperator,
+	"tail":     runTail,
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				log.Fatalf("Error running %s: %v", os.Args[1], err)
+			}
+			return
+		}
+	}
+
+	err := runGather(os.Args[1:])
+	if err == errInterrupted {
+		os.Exit(130)
+	}
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+// errInterrupted is returned by runGather when the gather was interrupted,
+// after the partial run has been completed and summarised.
+var errInterrupted = errors.New("interrupted")
+
+// runGather implements the default command, gathering into the database and
+// optionally attaching it to a ticket. Only failures that stop the gather are
+// returned; those of individual resources are in the printed summary.
+func runGather(args []string) error {
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	options := gatherFlags(flags)
+	dbFile := flags.String("db", "kube_data.db", "Path to the SQLite database file")
+	attachTo := flags.String("attach-to", "", "Attach the finished database to a ticket, as backend:ticket, e.g. jira:OPS-123 or servicenow:INC0012345")
+	encrypt := flags.Bool("db-encrypt", false, "Encrypt the database with SQLCipher under the passphrase in the environment variable named by --passphrase-env")
+	passphraseEnv := flags.String("passphrase-env", "", "Environment variable holding the passphrase of an encrypted database")
+	notifyURL := flags.String("notify-url", "", "Post a summary of the gather to this Slack or Teams incoming webhook, or as JSON to any other URL, when it finishes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var attachBackend export.TicketBackend
+	var attachTicket string
+	if *attachTo != "" {
+		var err error
+		attachBackend, attachTicket, err = export.ParseAttachTo(*attachTo)
+		if err != nil {
+			return fmt.Errorf("Error checking --attach-to: %v", err)
+		}
+	}
+	var passphrase string
+	if *encrypt {
+		var err error
+		if passphrase, err = envPassphrase(*passphraseEnv); err != nil {
+			return err
+		}
+	} else if *passphraseEnv != "" {
+		return fmt.Errorf("--passphrase-env needs --db-encrypt")
+	}
+
+	clientConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return fmt.Errorf("Error loading kube client config: %v", err)
+	}
+
+	g, err := gather.New(clientConfig, options())
+	if err != nil {
+		return err
+	}
+
+	var s *store.Store
+	if *encrypt {
+		s, err = store.OpenEncrypted(*dbFile, passphrase)
+	} else {
+		s, err = store.Open(*dbFile)
+	}
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// Ctrl-C or SIGTERM stops the gather between resources, keeping what was
+	// gathered; a second one exits at once.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		fmt.Fprintln(os.Stderr, "Interrupted, finishing the run (interrupt again to quit now)")
+		cancel()
+	}()
+
+	summary, err := g.Gather(ctx, s)
+	if err != nil {
+		notify(*notifyURL, export.Notification{Err: err})
+		return err
+	}
+	summary.Print(os.Stdout)
+	if summary.Interrupted {
+		notify(*notifyURL, export.Notification{Run: summary})
+		return errInterrupted
+	}
+	n := export.Notification{Run: summary}
+	if *attachTo != "" {
+		fmt.Printf("Attaching %s to %s ticket %s\n", *dbFile, attachBackend.Name, attachTicket)
+		n.Err = export.AttachBundle(attachBackend, attachTicket, *dbFile)
+		if n.Err == nil && attachBackend.URL != nil {
+			n.Link = attachBackend.URL(attachTicket)
+		}
+	}
+	notify(*notifyURL, n)
+	return n.Err
+}
+
+// notify posts a notification to notifyURL, if set. Failing to deliver it
+// is logged rather than failing the gather.
+func notify(notifyURL string, n export.Notification) {
+	if notifyURL == "" {
+		return
+	}
+	if err := export.Notify(notifyURL, n); err != nil {
+		log.Printf("%v\n", err)
+	}
+}
+
+// gatherFlags defines the flags choosing what a gather collects, shared by the
+// default command and daemon, and returns a function building the gather's
+// options once they have been parsed.
+func gatherFlags(flags *flag.FlagSet) func() gather.Options {
+	resourcesArg := flags.String("resources", "", "List (one per line) of namespace:resourceType:resourceName")
+	presetName := flags.String("preset", "", "Also gather a built-in set of resources (see README), e.g. kube-system")
+	appSelector := flags.String("app", "", "Also gather every workload, Service, Ingress, ConfigMap, Secret, PVC, HPA and PDB matching this label selector, e.g. app.kubernetes.io/name=shop")
+	namespaceDump := flags.String("namespace-dump", "", "Comma-separated namespaces to gather every namespaced resource of")
+	dumpInclude := flags.String("dump-include", "", "Comma-separated kinds or resources to limit --namespace-dump to")
+	dumpExclude := flags.String("dump-exclude", "", "Comma-separated kinds or resources to leave out of --namespace-dump")
+	operators := flags.Bool("operators", false, "Also gather cert-manager, ingress-nginx and Argo CD resources, controller logs and webhooks where installed")
+	runPlugins := flags.Bool("plugins", false, "Run the kube-gather-collector-* plugins found on PATH after gathering")
+	pluginTimeout := flags.Duration("plugin-timeout", 5*time.Minute, "Maximum time each plugin may run")
+	crds := flags.String("crds", "", "Comma-separated CustomResourceDefinition names or API groups to gather with every instance, or * for all")
+	finishedJobs := flags.Bool("finished-jobs", false, "Gather finished Jobs with ttlSecondsAfterFinished set, and any finished since --since, with their pods and logs before they are deleted")
+	inventory := flags.Bool("inventory", false, "Record a catalog of every object in the cluster (kind, namespace, name, labels, creation time, owner)")
+	resourceVersionMatch := flags.String("resource-version-match", "", "Pin every list to the resource version of the run's first: NotOlderThan, or Exact for a point-in-time snapshot (default: each list at the latest)")
+	logTail := flags.Int64("log-tail", 0, "Only keep this many of the most recent log lines per container (0 keeps all)")
+	logSampleEvery := flags.Int("log-sample-every", 0, "Keep only every Nth log line of each container, recording the sampling in log_sampling (0 keeps all)")
+	logRate := flags.Int("log-rate", 0, "Keep at most this many log lines of each second per container, recording the sampling in log_sampling (0 for no limit)")
+	logRecordStart := flags.String("log-record-start", "", "List (one per line) of regular expressions matching the first line of each log record; other lines, such as a stack trace's, are stored with the record before them")
+	logMinLevel := flags.String("log-min-level", "", "Only gather log lines of this severity or worse (debug, info, warn, error or fatal), with the lines following them without one, such as a stack trace")
+	logInclude := flags.String("log-include-regex", "", "Only gather log lines matching this regular expression")
+	logExclude := flags.String("log-exclude-regex", "", "Leave out log lines matching this regular expression, such as health check access logs")
+	since := flags.String("since", "", "Only gather log lines logged and events seen after this time, as RFC 3339 or a duration before the gather such as 2h")
+	until := flags.String("until", "", "Only gather log lines logged and events seen before this time, as RFC 3339 or a duration before the gather")
+	storeSummary := flags.Bool("store-summary", false, "Store the end-of-run summary in the runs table")
+	denyNamespaces := flags.String("deny-namespaces", strings.Join(gather.DefaultDenyNamespaces, ","), "Comma-separated namespaces, or namespace:kind such as kube-system:secret, that gathers across all namespaces leave alone unless named explicitly")
+	maxObjectBytes := flags.Int64("max-object-bytes", 0, "Store objects whose spec and status (or data) exceed this many bytes with their metadata alone, recording them in oversized_objects (0 for no limit)")
+	subresources := flags.Bool("subresources", false, "Also store the /scale subresource of gathered objects that have one, such as Deployments and scalable custom resources, in scales")
+	keepManagedFields := flags.Bool("keep-managed-fields", false, "Keep objects' managedFields and kubectl last-applied-configuration annotations, which are stripped by default")
+	collectMetrics := flags.Bool("metrics", false, "Collect pod and node CPU/memory usage from metrics-server")
+	scrapeMetrics := flags.Bool("scrape", false, "Snapshot each gathered pod's Prometheus /metrics endpoint")
+	scrapePort := flags.String("scrape-port", "", "Port to scrape on pods without a prometheus.io/port annotation")
+	execEnabled := flags.Bool("exec", false, "Run diagnostic commands in each container of gathered pods")
+	execCommands := flags.String("exec-commands", "", "List (one per line) of commands for --exec, replacing the defaults")
+	debugImage := flags.String("debug-image", "", "Run the --exec commands in an ephemeral debug container with this image instead of exec, for shell-less images")
+	copyPaths := flags.String("copy", "", "List (one per line) of file or directory paths to copy out of each container of gathered pods")
+	copyLimit := flags.Int("copy-limit", 1<<20, "Maximum bytes copied per path for --copy")
+	collectNodeStats := flags.Bool("node-stats", false, "Collect kubelet summary stats and PLEG metrics from every node")
+	collectNodeLogs := flags.Bool("node-logs", false, "Collect excerpts of every node's kubelet and containerd journals through the node proxy")
+	nodeLogsImage := flags.String("node-logs-image", "", "Read node journals in a privileged pod of this image (which needs sh) where the node proxy can't serve them")
+	nodeLogsNamespace := flags.String("node-logs-namespace", "kube-system", "Namespace of the --node-logs-image pods")
+	return func() gather.Options {
+		var resources []string
+		if *resourcesArg != "" {
+			resources = strings.Split(*resourcesArg, "\n")
+		}
+		// An empty --deny-namespaces denies nothing, rather than the default.
+		denied := append([]string{}, gather.SplitList(*denyNamespaces)...)
+		return gather.Options{
+			Resources:            resources,
+			Preset:               *presetName,
+			App:                  *appSelector,
+			NamespaceDump:        gather.SplitList(*namespaceDump),
+			DumpInclude:          gather.SplitList(*dumpInclude),
+			DumpExclude:          gather.SplitList(*dumpExclude),
+			Operators:            *operators,
+			CRDs:                 gather.SplitList(*crds),
+			Inventory:            *inventory,
+			FinishedJobs:         *finishedJobs,
+			Plugins:              *runPlugins,
+			PluginTimeout:        *pluginTimeout,
+			LogTail:              *logTail,
+			LogSampleEvery:       *logSampleEvery,
+			LogRate:              *logRate,
+			LogRecordStart:       gather.NonEmptyLines(*logRecordStart),
+			LogMinLevel:          *logMinLevel,
+			LogInclude:           *logInclude,
+			LogExclude:           *logExclude,
+			Since:                *since,
+			Until:                *until,
+			Metrics:              *collectMetrics,
+			Scrape:               *scrapeMetrics,
+			ScrapePort:           *scrapePort,
+			Exec:                 *execEnabled,
+			ExecCommands:         gather.ParseExecCommands(*execCommands),
+			DebugImage:           *debugImage,
+			CopyPaths:            gather.NonEmptyLines(*copyPaths),
+			CopyLimit:            *copyLimit,
+			NodeStats:            *collectNodeStats,
+			NodeLogs:             *collectNodeLogs,
+			NodeLogsImage:        *nodeLogsImage,
+			NodeLogsNamespace:    *nodeLogsNamespace,
+			StoreSummary:         *storeSummary,
+			KeepManagedFields:    *keepManagedFields,
+			ResourceVersionMatch: *resourceVersionMatch,
+			DenyNamespaces:       denied,
+			MaxObjectBytes:       *maxObjectBytes,
+			Subresources:         *subresources,
+			Hooks:                progressHooks(),
+		}
+	}
+}
+
+// progressHooks prints each resource and step as it is gathered, and logs
+// errors as they happen.
+func progressHooks() gather.Hooks {
+	return gather.Hooks{
+		OnProgress: func(message string) {
+			fmt.Println(message)
+		},
+		OnResourceStart: func(kind, namespace, name string) {
+			if namespace == "" {
+				fmt.Printf("Processing %s: %s\n", kind, name)
+			} else {
+				fmt.Printf("Processing %s: %s/%s\n", kind, namespace, name)
+			}
+		},
+		OnError: func(err error) {
+			log.Printf("%v\n", err)
+		},
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"kube-query/pkg/export"
+	"kube-query/pkg/gather"
+	"kube-query/pkg/schedule"
+	"kube-query/pkg/store"
+)
+
+// gatherPolicies is the GatherPolicy custom resource reconciled by
+// `operator`, defined in deploy/gatherpolicy-crd.yaml.
+var gatherPolicies = schema.GroupVersionResource{Group: "kube-gather.io", Version: "v1alpha1", Resource: "gatherpolicies"}
+
+// gatherPolicySpec is the spec of a GatherPolicy: when to gather, what, how
+// many runs to keep and where to send them.
+type gatherPolicySpec struct {
+	Schedule string `json:"schedule"`
+	// Preset, App and Resources choose what is gathered, as the --preset,
+	// --app and --resources flags do.
+	Preset    string   `json:"preset"`
+	App       string   `json:"app"`
+	Resources []string `json:"resources"`
+	// KeepRuns is --keep-runs, 28 if unset.
+	KeepRuns *int `json:"keepRuns"`
+	// AttachTo and NotifyURL are --attach-to and --notify-url.
+	AttachTo  string `json:"attachTo"`
+	NotifyURL string `json:"notifyURL"`
+}
+
+// runOperator implements `operator`, gathering as every GatherPolicy in the
+// cluster (or --namespace) says, each into its own database under
+// --data-dir, and reporting each policy's last run in its status. Policies
+// are reconciled as they are created, changed and deleted. The gather flags
+// apply to every policy, which chooses what is gathered within its own
+// namespace; policies reaching beyond it, or run with flags gathering across
+// the cluster, are refused. It runs until interrupted.
+func runOperator(args []string) error {
+	flags := flag.NewFlagSet("operator", flag.ExitOnError)
+	options := gatherFlags(flags)
+	dataDir := flags.String("data-dir", ".", "Directory holding each GatherPolicy's database, named <namespace>_<name>.db")
+	namespace := flags.String("namespace", "", "Only reconcile the GatherPolicies of this namespace (default: all)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	clientConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return fmt.Errorf("Error loading kube client config: %v", err)
+	}
+	dyn, err := dynamic.NewForConfig(clientConfig)
+	if err != nil {
+		return fmt.Errorf("Error creating dynamic client: %v", err)
+	}
+
+	// Ctrl-C or SIGTERM stops the operator, finishing the runs in progress;
+	// a second one exits at once.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		fmt.Fprintln(os.Stderr, "Interrupted, stopping the operator (interrupt again to quit now)")
+		cancel()
+	}()
+
+	o := &operator{ctx: ctx, config: clientConfig, options: options(), dyn: dyn, dataDir: *dataDir, policies: map[string]*policyRunner{}}
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dyn, 0, *namespace, nil)
+	_, err = factory.ForResource(gatherPolicies).Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if policy, ok := obj.(*unstructured.Unstructured); ok {
+				o.reconcile(policy)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldPolicy, _ := oldObj.(*unstructured.Unstructured)
+			newPolicy, _ := newObj.(*unstructured.Unstructured)
+			// Status updates, including the operator's own, leave the
+			// generation alone.
+			if oldPolicy != nil && newPolicy != nil && oldPolicy.GetGeneration() != newPolicy.GetGeneration() {
+				o.reconcile(newPolicy)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if policy, ok := obj.(*unstructured.Unstructured); ok {
+				o.stop(policy.GetNamespace() + "/" + policy.GetName())
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Error watching GatherPolicies: %v", err)
+	}
+	factory.Start(ctx.Done())
+	log.Printf("Reconciling GatherPolicies, keeping their databases in %s\n", *dataDir)
+
+	<-ctx.Done()
+	o.running.Wait()
+	return nil
+}
+
+// operator runs the gathers of each GatherPolicy, one daemon per policy.
+type operator struct {
+	ctx     context.Context
+	config  *rest.Config
+	options gather.Options
+	dyn     dynamic.Interface
+	dataDir string
+
+	mu       sync.Mutex
+	policies map[string]*policyRunner
+	// running counts the policies' daemons still running.
+	running sync.WaitGroup
+}
+
+// policyRunner is the daemon of one GatherPolicy's current spec, stopped by
+// cancel and finished once done is closed.
+type policyRunner struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// reconcile restarts a policy's gathers with its current spec, once those of
+// its previous spec have stopped; they share a database.
+func (o *operator) reconcile(policy *unstructured.Unstructured) {
+	key := policy.GetNamespace() + "/" + policy.GetName()
+	ctx, cancel := context.WithCancel(o.ctx)
+	runner := &policyRunner{cancel: cancel, done: make(chan struct{})}
+
+	o.mu.Lock()
+	prev := o.policies[key]
+	o.policies[key] = runner
+	o.mu.Unlock()
+
+	o.running.Add(1)
+	go func() {
+		defer o.running.Done()
+		defer close(runner.done)
+		defer func() {
+			o.mu.Lock()
+			if o.policies[key] == runner {
+				delete(o.policies, key)
+			}
+			o.mu.Unlock()
+		}()
+		if prev != nil {
+			prev.cancel()
+			<-prev.done
+		}
+		if err := o.runPolicy(ctx, policy); err != nil {
+			log.Printf("Error running GatherPolicy %s: %v\n", key, err)
+			o.setStatus(policy.GetNamespace(), policy.GetName(), map[string]interface{}{
+				"observedGeneration": policy.GetGeneration(),
+				"lastError":          err.Error(),
+			})
+		}
+	}()
+}
+
+// stop stops a deleted policy's gathers, interrupting one in progress.
+func (o *operator) stop(key string) {
+	o.mu.Lock()
+	runner := o.policies[key]
+	o.mu.Unlock()
+	if runner != nil {
+		runner.cancel()
+	}
+}
+
+// runPolicy gathers as a policy says until ctx is done, recording each run in
+// the policy's status. It returns errors in the spec at once.
+func (o *operator) runPolicy(ctx context.Context, policy *unstructured.Unstructured) error {
+	namespace, name := policy.GetNamespace(), policy.GetName()
+	spec, err := readPolicySpec(policy)
+	if err != nil {
+		return err
+	}
+	sched, err := schedule.Parse(spec.Schedule)
+	if err != nil {
+		return err
+	}
+	d := &daemon{ctx: ctx, config: o.config, options: o.options, keepRuns: *spec.KeepRuns, notifyURL: spec.NotifyURL}
+	if spec.AttachTo != "" {
+		d.attachBackend, d.attachTicket, err = export.ParseAttachTo(spec.AttachTo)
+		if err != nil {
+			return err
+		}
+	}
+	// A policy gathers only its own namespace, whatever the operator's
+	// credentials reach, since its results go wherever the policy says.
+	options := d.selection(spec.Preset, spec.App, spec.Resources)
+	options.Namespace = namespace
+	options.Trigger = "GatherPolicy " + namespace + "/" + name
+	g, err := gather.New(o.config, options)
+	if err != nil {
+		return err
+	}
+
+	dbFile := filepath.Join(o.dataDir, namespace+"_"+name+".db")
+	d.store, err = store.Open(dbFile)
+	if err != nil {
+		return err
+	}
+	defer d.store.Close()
+
+	d.finished = func(summary *store.Run, err error) {
+		status := map[string]interface{}{"lastError": nil}
+		if summary != nil {
+			status["lastRunID"] = summary.RunID
+			status["lastRunTime"] = summary.FinishedAt.UTC().Format(time.RFC3339)
+			status["lastRunErrors"] = summary.Errors
+			if len(summary.Failures) > 0 {
+				status["lastError"] = summary.Failures[0].Error()
+			}
+		}
+		if err != nil {
+			status["lastError"] = err.Error()
+		}
+		o.setStatus(namespace, name, status)
+	}
+	o.setStatus(namespace, name, map[string]interface{}{
+		"observedGeneration": policy.GetGeneration(),
+		"database":           dbFile,
+		"lastError":          nil,
+	})
+	log.Printf("Gathering GatherPolicy %s/%s on %q into %s\n", namespace, name, spec.Schedule, dbFile)
+	return d.run(ctx, g, &sched)
+}
+
+// readPolicySpec returns a policy's spec, with keepRuns defaulted to 28.
+func readPolicySpec(policy *unstructured.Unstructured) (gatherPolicySpec, error) {
+	var spec gatherPolicySpec
+	fields, _, err := unstructured.NestedMap(policy.Object, "spec")
+	if err != nil {
+		return spec, fmt.Errorf("Error reading spec: %v", err)
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(fields, &spec); err != nil {
+		return spec, fmt.Errorf("Error reading spec: %v", err)
+	}
+	if spec.KeepRuns == nil {
+		keepRuns := 28
+		spec.KeepRuns = &keepRuns
+	}
+	if *spec.KeepRuns < 0 {
+		return spec, fmt.Errorf("Invalid keepRuns %d", *spec.KeepRuns)
+	}
+	return spec, nil
+}
+
+// setStatus merges fields into a policy's status, where a nil value removes
+// the field. Failures are logged; the next run sets the status again.
+func (o *operator) setStatus(namespace, name string, fields map[string]interface{}) {
+	patch, err := json.Marshal(map[string]interface{}{"status": fields})
+	if err != nil {
+		log.Printf("Error encoding GatherPolicy status: %v\n", err)
+		return
+	}
+	_, err = o.dyn.Resource(gatherPolicies).Namespace(namespace).Patch(context.Background(), name, types.MergePatchType, patch, metav1.PatchOptions{}, "status")
+	if err != nil {
+		log.Printf("Error updating GatherPolicy %s/%s status: %v\n", namespace, name, err)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/rest"
+
+	"kube-query/internal/kubetest"
+)
+
+// newTestOperator returns an operator of the GatherPolicies in
+// testdata/gatherpolicies.yaml, keeping their databases in a temporary
+// directory.
+func newTestOperator(t *testing.T) (*operator, *dynamicfake.FakeDynamicClient) {
+	t.Helper()
+	dyn := kubetest.NewDynamicClient(t, "testdata/gatherpolicies.yaml")
+	o := &operator{ctx: context.Background(), config: &rest.Config{Host: "https://127.0.0.1:1"}, dyn: dyn, dataDir: t.TempDir(), policies: map[string]*policyRunner{}}
+	return o, dyn
+}
+
+// getPolicy returns a GatherPolicy of the prod namespace.
+func getPolicy(t *testing.T, o *operator, name string) *unstructured.Unstructured {
+	t.Helper()
+	policy, err := o.dyn.Resource(gatherPolicies).Namespace("prod").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+// policyStatus returns a GatherPolicy's status fields, formatted.
+func policyStatus(t *testing.T, o *operator, name string) map[string]string {
+	t.Helper()
+	fields, _, _ := unstructured.NestedMap(getPolicy(t, o, name).Object, "status")
+	status := map[string]string{}
+	for k, v := range fields {
+		status[k] = fmt.Sprint(v)
+	}
+	return status
+}
+
+func TestReadPolicySpec(t *testing.T) {
+	o, _ := newTestOperator(t)
+	spec, err := readPolicySpec(getPolicy(t, o, "nightly"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fmt.Sprintf("%s %s %v %d %s %s", spec.Schedule, spec.Preset, spec.Resources, *spec.KeepRuns, spec.AttachTo, spec.NotifyURL)
+	if want := "0 2 * * * dns [prod:deployment:web] 7 jira:OPS-123 https://hooks.slack.com/services/T0/B0/x"; got != want {
+		t.Errorf("got spec %s, want %s", got, want)
+	}
+
+	if spec, err := readPolicySpec(getPolicy(t, o, "hourly")); err != nil || *spec.KeepRuns != 28 {
+		t.Errorf("got keepRuns %v, %v without one, want 28", spec.KeepRuns, err)
+	}
+	if _, err := readPolicySpec(getPolicy(t, o, "negative-keep-runs")); err == nil || err.Error() != "Invalid keepRuns -1" {
+		t.Errorf("got %v for keepRuns -1, want it refused", err)
+	}
+}
+
+func TestReconcileRejected(t *testing.T) {
+	o, _ := newTestOperator(t)
+	for _, tt := range []struct {
+		name, generation, lastError string
+	}{
+		{"negative-keep-runs", "3", "Invalid keepRuns -1"},
+		{"bad-schedule", "4", "every night"},
+		{"bad-attach-to", "5", `invalid --attach-to "OPS-123"`},
+		// A policy gathers within its own namespace only.
+		{"other-namespace", "6", `Resource "kube-system:secret:bootstrap-token" is outside namespace prod`},
+	} {
+		o.reconcile(getPolicy(t, o, tt.name))
+		o.running.Wait()
+		status := policyStatus(t, o, tt.name)
+		if !strings.Contains(status["lastError"], tt.lastError) || status["observedGeneration"] != tt.generation {
+			t.Errorf("%s: got status %v, want lastError %q at generation %s", tt.name, status, tt.lastError, tt.generation)
+		}
+		if _, err := os.Stat(filepath.Join(o.dataDir, "prod_"+tt.name+".db")); err == nil {
+			t.Errorf("%s: created a database for a rejected policy", tt.name)
+		}
+	}
+}
+
+func TestRunPolicy(t *testing.T) {
+	o, _ := newTestOperator(t)
+	// Once stopped, the policy returns without gathering.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := o.runPolicy(ctx, getPolicy(t, o, "hourly")); err != nil {
+		t.Fatal(err)
+	}
+	dbFile := filepath.Join(o.dataDir, "prod_hourly.db")
+	status := policyStatus(t, o, "hourly")
+	if status["observedGeneration"] != "1" || status["database"] != dbFile || status["lastError"] != "" {
+		t.Errorf("got status %v, want generation 1 observed into %s", status, dbFile)
+	}
+	if _, err := os.Stat(dbFile); err != nil {
+		t.Errorf("policy's database: %v", err)
+	}
+}
+
+func TestSetStatus(t *testing.T) {
+	o, _ := newTestOperator(t)
+	o.setStatus("prod", "hourly", map[string]interface{}{"lastRunID": 4, "lastError": "Error listing pods"})
+	o.setStatus("prod", "hourly", map[string]interface{}{"lastRunErrors": 0, "lastError": nil})
+	status := policyStatus(t, o, "hourly")
+	if got := fmt.Sprint(status); got != "map[lastRunErrors:0 lastRunID:4]" {
+		t.Errorf("got status %s, want the fields merged and lastError removed", got)
+	}
+}
+
+func TestReconcileWaitsForPrevious(t *testing.T) {
+	o, dyn := newTestOperator(t)
+	prevCtx, prevCancel := context.WithCancel(context.Background())
+	prev := &policyRunner{cancel: prevCancel, done: make(chan struct{})}
+	o.policies["prod/bad-schedule"] = prev
+
+	// The previous spec's gathers are stopped, and the policy restarts only
+	// once they have.
+	o.reconcile(getPolicy(t, o, "bad-schedule"))
+	<-prevCtx.Done()
+	time.Sleep(50 * time.Millisecond)
+	for _, a := range dyn.Actions() {
+		if a.GetVerb() == "patch" {
+			t.Fatal("restarted the policy before its previous gathers stopped")
+		}
+	}
+
+	close(prev.done)
+	o.running.Wait()
+	if status := policyStatus(t, o, "bad-schedule"); status["observedGeneration"] != "4" {
+		t.Errorf("got status %v once the previous gathers stopped, want generation 4 observed", status)
+	}
+	if len(o.policies) != 0 {
+		t.Errorf("got runners %v once the policy stopped, want none", o.policies)
+	}
+}
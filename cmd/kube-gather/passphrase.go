@@ -0,0 +1,36 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	"kube-query/pkg/store"
+)
+
+// envPassphrase returns the database passphrase held by the environment
+// variable named by --passphrase-env. It is never taken as a flag's value,
+// which other users of the machine could read.
+func envPassphrase(env string) (string, error) {
+	if env == "" {
+		return "", fmt.Errorf("--db-encrypt needs --passphrase-env")
+	}
+	passphrase := os.Getenv(env)
+	if passphrase == "" {
+		return "", fmt.Errorf("$%s, named by --passphrase-env, is not set", env)
+	}
+	return passphrase, nil
+}
+
+// openReadOnly opens a gather database without allowing writes, encrypted
+// with the passphrase in $passphraseEnv if that is set.
+func openReadOnly(dbFile, passphraseEnv string) (*sql.DB, error) {
+	if passphraseEnv == "" {
+		return store.OpenReadOnly(dbFile)
+	}
+	passphrase, err := envPassphrase(passphraseEnv)
+	if err != nil {
+		return nil, err
+	}
+	return store.OpenReadOnlyEncrypted(dbFile, passphrase)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"kube-query/pkg/export"
+	"kube-query/pkg/store"
+)
+
+// runQuery implements `query "SELECT ..." --db file.db`, executing an
+// arbitrary read-only statement against a gather database, or several
+// attached as one. Canned queries can be run against a single database
+// instead with --name and listed with --list.
+func runQuery(args []string) error {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	var dbFiles stringList
+	fs.Var(&dbFiles, "db", "Path to the SQLite database file; repeat to query several as one, each table a view of all of them with a db column naming each row's database (default kube_data.db)")
+	format := fs.String("output", "table", "Output format: table, json or csv")
+	fs.StringVar(format, "o", "table", "Shorthand for --output")
+	name := fs.String("name", "", "Run a named query instead of a SQL statement")
+	list := fs.Bool("list", false, "List the available named queries")
+	jsonPath := fs.String("jsonpath", "", "Evaluate a JSONPath template over stored objects instead of SQL")
+	kind := fs.String("kind", "", "Limit --jsonpath to one resource kind")
+	namespace := fs.String("namespace", "", "Limit --jsonpath to one namespace")
+	fs.StringVar(namespace, "n", "", "Shorthand for --namespace")
+	run := fs.Int64("run", 0, "Run to evaluate --jsonpath over (defaults to the latest run)")
+	passphraseEnv := fs.String("passphrase-env", "", "Environment variable holding the passphrase of an encrypted database")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if *list {
+		return store.ListNamedQueries(os.Stdout)
+	}
+	if len(dbFiles) == 0 {
+		dbFiles = stringList{"kube_data.db"}
+	}
+	if *passphraseEnv != "" && len(dbFiles) > 1 {
+		return fmt.Errorf("--passphrase-env opens a single database")
+	}
+
+	if *jsonPath != "" {
+		if len(positional) != 0 || *name != "" {
+			return fmt.Errorf("--jsonpath cannot be combined with --name or a SQL statement")
+		}
+		if len(dbFiles) > 1 {
+			return fmt.Errorf("--jsonpath reads a single database")
+		}
+		db, err := openReadOnly(dbFiles[0], *passphraseEnv)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return queryJSONPath(os.Stdout, db, *format, *jsonPath, *run, *kind, *namespace)
+	}
+
+	var statement string
+	if *name != "" {
+		if len(positional) != 0 {
+			return fmt.Errorf("--name cannot be combined with a SQL statement")
+		}
+		// Named queries join on row and run IDs, which repeat across
+		// databases, and would silently mix their rows.
+		if len(dbFiles) > 1 {
+			return fmt.Errorf("--name queries a single database")
+		}
+		q, err := store.FindNamedQuery(*name)
+		if err != nil {
+			return err
+		}
+		statement = q.SQL
+	} else {
+		if len(positional) != 1 {
+			return fmt.Errorf("expected exactly one SQL statement, got %d arguments", len(positional))
+		}
+		statement = positional[0]
+	}
+
+	var db *sql.DB
+	if len(dbFiles) > 1 {
+		db, err = store.OpenAttached(dbFiles)
+	} else {
+		db, err = openReadOnly(dbFiles[0], *passphraseEnv)
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(statement)
+	if err != nil {
+		return fmt.Errorf("Error executing query: %v", err)
+	}
+	defer rows.Close()
+
+	return export.WriteRows(os.Stdout, *format, rows)
+}
+
+// parseInterspersed parses flags that may appear before or after positional
+// arguments, returning the positional arguments in order.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// stringList is a flag that may be given several times, collecting each
+// value.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"k8s.io/client-go/util/jsonpath"
+
+	"kube-query/pkg/export"
+	"kube-query/pkg/store"
+)
+
+// queryJSONPath evaluates a kubectl-style JSONPath template against every
+// stored object in a run, optionally filtered by kind and namespace. Objects
+// are presented as {metadata: {namespace, name}, <content columns>...} so that
+// paths such as {.spec.replicas} work as they would against the live object.
+func queryJSONPath(w io.Writer, db *sql.DB, format, template string, runID int64, kind, namespace string) error {
+	jp := jsonpath.New("query")
+	jp.AllowMissingKeys(true)
+	if err := jp.Parse(template); err != nil {
+		return fmt.Errorf("Error parsing JSONPath: %v", err)
+	}
+
+	if kind != "" {
+		if _, err := store.FindResourceTable(kind); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if runID == 0 {
+		if runID, err = store.LatestRunID(db); err != nil {
+			return err
+		}
+	}
+
+	resources, err := store.ListResources(db, runID, kind)
+	if err != nil {
+		return err
+	}
+
+	columns := []string{"kind", "namespace", "name", "result"}
+	var records [][]interface{}
+	for _, r := range resources {
+		if namespace != "" && r.Namespace != namespace {
+			continue
+		}
+
+		object, err := loadObject(db, runID, r)
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err := jp.Execute(&buf, object); err != nil {
+			return fmt.Errorf("Error evaluating JSONPath on %s %s/%s: %v", r.Kind, r.Namespace, r.Name, err)
+		}
+		records = append(records, []interface{}{r.Kind, r.Namespace, r.Name, strings.TrimSpace(buf.String())})
+	}
+
+	return export.WriteRecords(w, format, columns, records)
+}
+
+// loadObject reassembles a stored resource into a generic object tree.
+func loadObject(db *sql.DB, runID int64, r store.Resource) (map[string]interface{}, error) {
+	resource, err := store.GetResource(db, runID, r.Kind, r.Namespace, r.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	object := map[string]interface{}{
+		"kind": r.Kind,
+		"metadata": map[string]interface{}{
+			"namespace": r.Namespace,
+			"name":      r.Name,
+		},
+	}
+	// Stored metadata, when present, replaces the placeholder above.
+	for column, raw := range resource.Content {
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("Error decoding %s of %s %s/%s: %v", column, r.Kind, r.Namespace, r.Name, err)
+		}
+		object[column] = value
+	}
+	return object, nil
+}
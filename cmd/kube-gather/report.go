@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"kube-query/pkg/analyze"
+	"kube-query/pkg/export"
+	"kube-query/pkg/store"
+)
+
+// runReport implements `report <name> --db file.db`, and `report --out
+// report.html`, which writes the HTML report of a run.
+func runReport(args []string) error {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	dbFile := flags.String("db", "kube_data.db", "Path to the SQLite database file")
+	run := flags.Int64("run", 0, "Run to report on (defaults to the latest run)")
+	format := flags.String("output", "table", "Output format: table, json or csv")
+	flags.StringVar(format, "o", "table", "Shorthand for --output")
+	list := flags.Bool("list", false, "List the available reports and exit")
+	out := flags.String("out", "", "Write a self-contained HTML report of the run to this file")
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if *list {
+		for _, r := range analyze.Reports {
+			fmt.Printf("%-10s %s\n", r.Name, r.Description)
+		}
+		return nil
+	}
+	if len(positional) != 1 && (*out == "" || len(positional) != 0) {
+		return fmt.Errorf("usage: report <name> [--db file.db], report --out report.html [--db file.db], or report --list")
+	}
+
+	db, err := store.OpenReadOnly(*dbFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	runID := *run
+	if runID == 0 {
+		if runID, err = store.LatestRunID(db); err != nil {
+			return err
+		}
+	}
+	if *out != "" {
+		return analyze.WriteHTMLReport(db, runID, *out)
+	}
+
+	r, err := analyze.FindReport(positional[0])
+	if err != nil {
+		return err
+	}
+	columns, records, err := r.Run(db, runID)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		fmt.Fprintf(os.Stderr, "Nothing to report in run %d.\n", runID)
+		return nil
+	}
+	return export.WriteRecords(os.Stdout, *format, columns, records)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"kube-query/pkg/export"
+	"kube-query/pkg/store"
+)
+
+// runSearch implements `search "phrase" --db file.db`, finding log lines that
+// contain a phrase across every pod gathered in a run.
+func runSearch(args []string) error {
+	flags := flag.NewFlagSet("search", flag.ExitOnError)
+	dbFile := flags.String("db", "kube_data.db", "Path to the SQLite database file")
+	run := flags.Int64("run", 0, "Run to search (defaults to the latest run)")
+	since := flags.Duration("since", 0, "Only match lines logged within this long before the run started")
+	limit := flags.Int("limit", 200, "Maximum number of lines to return")
+	format := flags.String("output", "table", "Output format: table, json or csv")
+	flags.StringVar(format, "o", "table", "Shorthand for --output")
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("expected exactly one search phrase, got %d arguments", len(positional))
+	}
+
+	db, err := store.OpenReadOnly(*dbFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	runID := *run
+	if runID == 0 {
+		if runID, err = store.LatestRunID(db); err != nil {
+			return err
+		}
+	}
+
+	condition, arg := store.LogSearchCondition(positional[0])
+	query := `
+		SELECT l.namespace, l.pod, l.timestamp, l.line
+		FROM log_lines l
+		WHERE l.run_id = ? AND ` + condition
+	queryArgs := []interface{}{runID, arg}
+
+	if *since > 0 {
+		info, err := store.GetRun(db, runID)
+		if err != nil {
+			return err
+		}
+		if info.StartedAt == nil {
+			return fmt.Errorf("run %d has no start time", runID)
+		}
+		query += " AND l.timestamp >= ?"
+		queryArgs = append(queryArgs, info.StartedAt.Add(-*since).UTC())
+	}
+
+	query += " ORDER BY l.namespace, l.pod, l.line_number LIMIT ?"
+	queryArgs = append(queryArgs, *limit)
+
+	rows, err := db.Query(query, queryArgs...)
+	if err != nil {
+		return fmt.Errorf("Error searching logs: %v", err)
+	}
+	defer rows.Close()
+
+	return export.WriteRows(os.Stdout, *format, rows)
+}
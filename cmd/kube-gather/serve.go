@@ -0,0 +1,230 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"sigs.k8s.io/yaml"
+
+	"kube-query/pkg/metrics"
+	"kube-query/pkg/store"
+)
+
+// uiFiles holds the single-page browser UI served at the root path.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// runServe implements `serve --db file.db`, exposing a gather database over
+// read-only HTTP endpoints with JSON responses and a browser UI.
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	dbFile := flags.String("db", "kube_data.db", "Path to the SQLite database file")
+	listen := flags.String("listen", "127.0.0.1:8080", "Address to listen on")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := store.OpenReadOnly(*dbFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	s := &server{db: db, dbFile: *dbFile}
+	log.Printf("Serving %s on http://%s\n", *dbFile, *listen)
+	return http.ListenAndServe(*listen, s.routes())
+}
+
+type server struct {
+	db     *sql.DB
+	dbFile string
+}
+
+func (s *server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/runs", s.handleRuns)
+	mux.HandleFunc("GET /api/runs/{run}", s.handleRun)
+	mux.HandleFunc("GET /api/runs/{run}/resources", s.handleResources)
+	mux.HandleFunc("GET /api/runs/{run}/resources/{kind}/{namespace}/{name}", s.handleResource)
+	mux.HandleFunc("GET /api/runs/{run}/logs/{namespace}/{name}", s.handleLogs)
+	mux.HandleFunc("GET /api/diff", s.handleDiff)
+
+	mux.Handle("GET /metrics", metrics.Handler(func() {
+		if info, err := os.Stat(s.dbFile); err == nil {
+			metrics.BundleSize.Set(float64(info.Size()))
+		}
+	}))
+
+	graphql := handleGraphQL(newGraphQLSchema(s.db))
+	mux.Handle("GET /graphql", graphql)
+	mux.Handle("POST /graphql", graphql)
+
+	ui, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	mux.Handle("GET /", http.FileServer(http.FS(ui)))
+	return mux
+}
+
+func (s *server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	runs, err := store.ListRuns(s.db)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSONResponse(w, runs)
+}
+
+func (s *server) handleRun(w http.ResponseWriter, r *http.Request) {
+	runID, err := parseRunID(r.PathValue("run"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	run, err := store.GetRun(s.db, runID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSONResponse(w, run)
+}
+
+func (s *server) handleResources(w http.ResponseWriter, r *http.Request) {
+	runID, err := parseRunID(r.PathValue("run"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	kind := r.URL.Query().Get("kind")
+	if kind != "" {
+		if _, err := store.FindResourceTable(kind); err != nil {
+			writeError(w, badRequest(err))
+			return
+		}
+	}
+
+	resources, err := store.ListResources(s.db, runID, kind)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSONResponse(w, resources)
+}
+
+func (s *server) handleResource(w http.ResponseWriter, r *http.Request) {
+	runID, err := parseRunID(r.PathValue("run"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if _, err := store.FindResourceTable(r.PathValue("kind")); err != nil {
+		writeError(w, badRequest(err))
+		return
+	}
+
+	resource, err := store.GetResource(s.db, runID, r.PathValue("kind"), r.PathValue("namespace"), r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "yaml" {
+		yamlBytes, err := yaml.Marshal(resource)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(yamlBytes)
+		return
+	}
+	writeJSONResponse(w, resource)
+}
+
+func (s *server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	runID, err := parseRunID(r.PathValue("run"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	logs, err := store.GetDeploymentLogs(s.db, runID, r.PathValue("namespace"), r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(logs)
+}
+
+func (s *server) handleDiff(w http.ResponseWriter, r *http.Request) {
+	from, err := parseRunID(r.URL.Query().Get("from"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	to, err := parseRunID(r.URL.Query().Get("to"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	diff, err := store.DiffRuns(s.db, from, to)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSONResponse(w, diff)
+}
+
+// requestError marks errors caused by the client rather than the server.
+type requestError struct {
+	err error
+}
+
+func (e requestError) Error() string { return e.err.Error() }
+
+func badRequest(err error) error {
+	return requestError{err: err}
+}
+
+func parseRunID(value string) (int64, error) {
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, badRequest(fmt.Errorf("invalid run ID %q", value))
+	}
+	return id, nil
+}
+
+func writeJSONResponse(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing response: %v\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var reqErr requestError
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.As(err, &reqErr):
+		status = http.StatusBadRequest
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"kube-query/pkg/gather"
+	"kube-query/pkg/store"
+)
+
+// runTail implements `tail -n prod --selector app=web`, following the logs
+// of the matching pods into a run of the database until interrupted, for
+// namespaces whose logs aren't collected anywhere else.
+func runTail(args []string) error {
+	flags := flag.NewFlagSet("tail", flag.ExitOnError)
+	dbFile := flags.String("db", "kube_data.db", "Path to the SQLite database file")
+	namespace := flags.String("namespace", "", "Namespace of the pods to follow, or * for all")
+	flags.StringVar(namespace, "n", "", "Shorthand for --namespace")
+	selector := flags.String("selector", "", "Label selector of the pods to follow, e.g. app=web (default: every pod in the namespace)")
+	flush := flags.Duration("flush", 5*time.Second, "How often followed lines are written to the database")
+	retain := flags.Duration("retain", 0, "Delete lines logged longer ago than this, e.g. 168h, keeping a rolling window (default: keep every line)")
+	include := flags.String("log-include-regex", "", "Only store lines matching this regular expression")
+	exclude := flags.String("log-exclude-regex", "", "Leave out lines matching this regular expression, such as health check access logs")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *namespace == "" {
+		return fmt.Errorf("No namespace to follow. Use the --namespace flag, e.g. -n prod, or -n '*' for all.")
+	}
+
+	clientConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return fmt.Errorf("Error loading kube client config: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		return fmt.Errorf("Error creating Kubernetes client: %v", err)
+	}
+
+	s, err := store.Open(*dbFile)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// Ctrl-C or SIGTERM stops following, storing the lines already read; a
+	// second one exits at once.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		fmt.Fprintln(os.Stderr, "Interrupted, storing the lines read (interrupt again to quit now)")
+		cancel()
+	}()
+
+	log.Printf("Following the logs of pods in %s matching %q into %s\n", *namespace, *selector, *dbFile)
+	summary, err := gather.TailLogs(ctx, clientset, s, gather.TailOptions{
+		Namespace: *namespace,
+		Selector:  *selector,
+		Flush:     *flush,
+		Retain:    *retain,
+		Include:   *include,
+		Exclude:   *exclude,
+	})
+	if err != nil {
+		return err
+	}
+	summary.Print(os.Stdout)
+	return nil
+}
@@ -0,0 +1,149 @@
+// Package kubetest provides fixtures for testing the gatherers: fake
+// Kubernetes clients seeded from YAML manifests, and temporary stores to
+// gather into.
+package kubetest
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/json"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	"sigs.k8s.io/yaml"
+
+	"kube-query/pkg/store"
+)
+
+// Load reads the objects in YAML manifests, which may hold several
+// documents separated by "---" lines. Paths are relative to the test's
+// package directory, conventionally under testdata. Whole numbers are read
+// as int64, as the API server's objects have them.
+func Load(t testing.TB, paths ...string) []*unstructured.Unstructured {
+	t.Helper()
+	var objects []*unstructured.Unstructured
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Error reading fixture: %v", err)
+		}
+		for _, doc := range bytes.Split(data, []byte("\n---")) {
+			if len(bytes.TrimSpace(doc)) == 0 {
+				continue
+			}
+			obj := &unstructured.Unstructured{}
+			data, err := yaml.YAMLToJSON(doc)
+			if err == nil {
+				err = json.Unmarshal(data, &obj.Object)
+			}
+			if err != nil {
+				t.Fatalf("Error decoding fixture %s: %v", path, err)
+			}
+			if obj.Object == nil {
+				continue
+			}
+			objects = append(objects, obj)
+		}
+	}
+	return objects
+}
+
+// NewClientset returns a fake clientset serving the objects in the manifests
+// whose kinds it knows; others, such as custom resources, are left to
+// NewDynamicClient.
+func NewClientset(t testing.TB, paths ...string) *fake.Clientset {
+	t.Helper()
+	var typed []runtime.Object
+	for _, obj := range Load(t, paths...) {
+		typedObj, err := scheme.Scheme.New(obj.GroupVersionKind())
+		if err != nil {
+			continue
+		}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, typedObj); err != nil {
+			t.Fatalf("Error converting fixture %s %s: %v", obj.GetKind(), obj.GetName(), err)
+		}
+		typed = append(typed, typedObj)
+	}
+	return fake.NewSimpleClientset(typed...)
+}
+
+// NewDynamicClient returns a fake dynamic client serving every object in the
+// manifests. Only kinds with an object in them can be listed.
+func NewDynamicClient(t testing.TB, paths ...string) *dynamicfake.FakeDynamicClient {
+	t.Helper()
+	var objects []runtime.Object
+	for _, obj := range Load(t, paths...) {
+		objects = append(objects, obj)
+	}
+	return dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), objects...)
+}
+
+// NewMetadataClient returns a fake metadata client serving the metadata of
+// every object in the manifests.
+func NewMetadataClient(t testing.TB, paths ...string) *metadatafake.FakeMetadataClient {
+	t.Helper()
+	s := runtime.NewScheme()
+	if err := metav1.AddMetaToScheme(s); err != nil {
+		t.Fatalf("Error building metadata scheme: %v", err)
+	}
+	var objects []runtime.Object
+	for _, obj := range Load(t, paths...) {
+		partial := &metav1.PartialObjectMetadata{}
+		partial.APIVersion, partial.Kind = obj.GetAPIVersion(), obj.GetKind()
+		partial.ObjectMeta = metav1.ObjectMeta{
+			Namespace:         obj.GetNamespace(),
+			Name:              obj.GetName(),
+			Labels:            obj.GetLabels(),
+			CreationTimestamp: obj.GetCreationTimestamp(),
+			OwnerReferences:   obj.GetOwnerReferences(),
+		}
+		objects = append(objects, partial)
+	}
+	return metadatafake.NewSimpleMetadataClient(s, objects...)
+}
+
+// NewStore opens a new database in a temporary directory, closed and
+// removed when the test ends.
+func NewStore(t testing.TB) *store.Store {
+	t.Helper()
+	s, err := store.Open(filepath.Join(t.TempDir(), "kube_data.db"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// NewRun begins a run in s for a test to gather into.
+func NewRun(t testing.TB, s *store.Store) *store.Run {
+	t.Helper()
+	run := store.NewRun(time.Now())
+	if err := run.Begin(s.DB); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return run
+}
+
+// Count returns the number of rows a query's WHERE clause matches in a
+// table, such as Count(t, db, "pods", "run_id = ?", runID).
+func Count(t testing.TB, db *sql.DB, table, where string, args ...interface{}) int {
+	t.Helper()
+	query := "SELECT COUNT(*) FROM " + table
+	if where != "" {
+		query += " WHERE " + where
+	}
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("Error counting %s: %v", table, err)
+	}
+	return n
+}
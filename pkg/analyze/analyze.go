@@ -0,0 +1,345 @@
+// Package analyze checks a gathered run for problems and renders reports on
+// it.
+package analyze
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+
+	"kube-query/pkg/store"
+)
+
+// Severity ranks analyzer findings; higher is worse.
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityCritical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityCritical:
+		return "critical"
+	case SeverityWarning:
+		return "warning"
+	}
+	return "info"
+}
+
+// Finding is a problem an analyzer spotted in one object.
+type Finding struct {
+	Severity  Severity
+	Rule      string
+	Kind      string
+	Namespace string
+	Name      string
+	Message   string
+}
+
+// Analyzer is a named rule run over a run's gathered objects.
+type Analyzer struct {
+	Name        string
+	Description string
+	Run         func(in *Input) ([]Finding, error)
+}
+
+var Analyzers = []Analyzer{
+	{Name: "crashloop", Description: "Containers in CrashLoopBackOff or restarting repeatedly", Run: analyzeCrashLoops},
+	{Name: "pending", Description: "Pending pods, with the scheduler's or kubelet's reason", Run: analyzePendingPods},
+	{Name: "unavailable", Description: "Deployments whose Available or Progressing condition is failing", Run: analyzeUnavailableDeployments},
+	{Name: "probes", Description: "Failing liveness, readiness and startup probes", Run: analyzeProbes},
+	{Name: "replicas", Description: "Workloads with fewer ready replicas than desired", Run: analyzeReplicas},
+	{Name: "resources", Description: "Containers without CPU and memory requests or a memory limit", Run: lintResources},
+	{Name: "missing-probes", Description: "Containers without readiness or liveness probes", Run: lintProbes},
+	{Name: "image-tag", Description: "Images that are untagged or use latest instead of a pinned tag", Run: lintImageTags},
+	{Name: "single-replica", Description: "Single-replica workloads without a PodDisruptionBudget", Run: lintSingleReplicas},
+	{Name: "deprecated", Description: "Deprecated pod template fields, annotations and node labels", Run: lintDeprecatedFields},
+	{Name: "certs", Description: "Certificates in secrets, and the ingresses serving them, expired or expiring within --cert-days", Run: analyzeCerts},
+	{Name: "unused", Description: "ConfigMaps, Secrets and PVCs no gathered workload, pod or ingress refers to", Run: analyzeUnused},
+	{Name: "log-patterns", Description: "The most frequent error and warning messages in gathered logs, per workload", Run: analyzeLogPatterns},
+	{Name: "security", Description: "Privileged containers, host namespaces and paths, root users, missing seccomp profiles and wildcard RBAC rules", Run: analyzeSecurity},
+	{Name: "deprecated-api", Description: "Objects using API versions deprecated or removed in --target-version (defaults to the gathered server version)", Run: analyzeDeprecatedAPIs},
+	{Name: "empty-service", Description: "Services whose selector matches none of the gathered pods", Run: analyzeEmptyServices},
+}
+
+// restartWarningThreshold is the restart count from which a container that is
+// currently running is still reported as unstable.
+const restartWarningThreshold = 5
+
+// Input holds a run's objects decoded for the analyzers, which run
+// over the same data.
+type Input struct {
+	db    *sql.DB
+	runID int64
+	// asOf is when the run was gathered, which certificate expiry is
+	// measured from.
+	asOf          time.Time
+	certWindow    time.Duration
+	targetVersion string
+
+	pods         []corev1.Pod
+	deployments  []appsv1.Deployment
+	statefulsets []appsv1.StatefulSet
+	daemonsets   []appsv1.DaemonSet
+	pdbs         []policyv1.PodDisruptionBudget
+	jobs         []batchv1.Job
+	cronjobs     []batchv1.CronJob
+	configMaps   []corev1.ConfigMap
+	secrets      []corev1.Secret
+	pvcs         []corev1.PersistentVolumeClaim
+	services     []corev1.Service
+	ingresses    []networkingv1.Ingress
+}
+
+func ParseSeverity(s string) (Severity, error) {
+	for _, sev := range []Severity{SeverityInfo, SeverityWarning, SeverityCritical} {
+		if sev.String() == s {
+			return sev, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown severity %q, expected info, warning or critical", s)
+}
+
+// Run runs the named analyzers, or all of them, over a run, returning
+// the findings ordered by severity and then by object.
+func Run(db *sql.DB, runID int64, checks []string, certWindow time.Duration, targetVersion string) ([]Finding, error) {
+	selected := Analyzers
+	if len(checks) > 0 {
+		selected = nil
+		for _, name := range checks {
+			a, err := findAnalyzer(name)
+			if err != nil {
+				return nil, err
+			}
+			selected = append(selected, a)
+		}
+	}
+
+	in, err := loadAnalysisInput(db, runID)
+	if err != nil {
+		return nil, err
+	}
+	in.certWindow = certWindow
+	in.targetVersion = targetVersion
+
+	var findings []Finding
+	for _, a := range selected {
+		found, err := a.Run(in)
+		if err != nil {
+			return nil, fmt.Errorf("Error running %s analyzer: %v", a.Name, err)
+		}
+		findings = append(findings, found...)
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.Severity != b.Severity {
+			return a.Severity > b.Severity
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return findings, nil
+}
+
+func findAnalyzer(name string) (Analyzer, error) {
+	names := make([]string, 0, len(Analyzers))
+	for _, a := range Analyzers {
+		if a.Name == name {
+			return a, nil
+		}
+		names = append(names, a.Name)
+	}
+	return Analyzer{}, fmt.Errorf("unknown analyzer %q, available: %s", name, strings.Join(names, ", "))
+}
+
+func loadAnalysisInput(db *sql.DB, runID int64) (*Input, error) {
+	in := &Input{db: db, runID: runID, asOf: time.Now()}
+	info, err := store.GetRun(db, runID)
+	if err != nil {
+		return nil, err
+	}
+	if info.StartedAt != nil {
+		in.asOf = *info.StartedAt
+	}
+	for _, load := range []func() error{
+		func() error { return store.LoadObjects(db, runID, "pod", &in.pods) },
+		func() error { return store.LoadObjects(db, runID, "deployment", &in.deployments) },
+		func() error { return store.LoadObjects(db, runID, "statefulset", &in.statefulsets) },
+		func() error { return store.LoadObjects(db, runID, "daemonset", &in.daemonsets) },
+		func() error { return store.LoadObjects(db, runID, "poddisruptionbudget", &in.pdbs) },
+		func() error { return store.LoadObjects(db, runID, "job", &in.jobs) },
+		func() error { return store.LoadObjects(db, runID, "cronjob", &in.cronjobs) },
+		func() error { return store.LoadObjects(db, runID, "configmap", &in.configMaps) },
+		func() error { return store.LoadObjects(db, runID, "secret", &in.secrets) },
+		func() error { return store.LoadObjects(db, runID, "persistentvolumeclaim", &in.pvcs) },
+		func() error { return store.LoadObjects(db, runID, "service", &in.services) },
+		func() error { return store.LoadObjects(db, runID, "ingress", &in.ingresses) },
+	} {
+		if err := load(); err != nil {
+			return nil, err
+		}
+	}
+	return in, nil
+}
+
+func analyzeCrashLoops(in *Input) ([]Finding, error) {
+	var findings []Finding
+	for _, pod := range in.pods {
+		for _, cs := range pod.Status.ContainerStatuses {
+			last := ""
+			if t := cs.LastTerminationState.Terminated; t != nil {
+				last = fmt.Sprintf(" (last exit %d, %s)", t.ExitCode, t.Reason)
+			}
+			f := Finding{Rule: "crashloop", Kind: "pod", Namespace: pod.Namespace, Name: pod.Name}
+			switch {
+			case cs.State.Waiting != nil && cs.State.Waiting.Reason == "CrashLoopBackOff":
+				f.Severity = SeverityCritical
+				f.Message = fmt.Sprintf("container %s is in CrashLoopBackOff after %d restarts%s", cs.Name, cs.RestartCount, last)
+			case cs.RestartCount >= restartWarningThreshold:
+				f.Severity = SeverityWarning
+				f.Message = fmt.Sprintf("container %s has restarted %d times%s", cs.Name, cs.RestartCount, last)
+			default:
+				continue
+			}
+			findings = append(findings, f)
+		}
+	}
+	return findings, nil
+}
+
+func analyzePendingPods(in *Input) ([]Finding, error) {
+	var findings []Finding
+	for _, pod := range in.pods {
+		if pod.Status.Phase != corev1.PodPending {
+			continue
+		}
+		f := Finding{Severity: SeverityWarning, Rule: "pending", Kind: "pod", Namespace: pod.Namespace, Name: pod.Name, Message: "pod is Pending"}
+		for _, c := range pod.Status.Conditions {
+			if c.Type != corev1.PodScheduled || c.Status != corev1.ConditionFalse {
+				continue
+			}
+			// The latest FailedScheduling event explains more than the
+			// condition when it was gathered.
+			message := c.Message
+			events, err := store.ListEvents(in.db, in.runID, "involved_kind = 'Pod' AND namespace = ? AND involved_name = ? AND reason = 'FailedScheduling'", pod.Namespace, pod.Name)
+			if err != nil {
+				return nil, err
+			}
+			if len(events) > 0 {
+				message = events[len(events)-1].Message
+			}
+			f.Severity = SeverityCritical
+			f.Message = "cannot be scheduled: " + message
+		}
+		// Once scheduled, a pod stays Pending while its containers can't
+		// start, such as on image pull or configuration errors.
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, cs := range statuses {
+			if w := cs.State.Waiting; w != nil && w.Reason != "" && w.Reason != "ContainerCreating" && w.Reason != "PodInitializing" {
+				f.Severity = SeverityCritical
+				f.Message = fmt.Sprintf("container %s is waiting: %s %s", cs.Name, w.Reason, w.Message)
+			}
+		}
+		f.Message = strings.TrimSpace(f.Message)
+		findings = append(findings, f)
+	}
+	return findings, nil
+}
+
+func analyzeUnavailableDeployments(in *Input) ([]Finding, error) {
+	var findings []Finding
+	for _, d := range in.deployments {
+		for _, c := range d.Status.Conditions {
+			f := Finding{Severity: SeverityCritical, Rule: "unavailable", Kind: "deployment", Namespace: d.Namespace, Name: d.Name}
+			switch {
+			case c.Type == appsv1.DeploymentAvailable && c.Status == corev1.ConditionFalse:
+				f.Message = "unavailable: " + c.Message
+			case c.Type == appsv1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded":
+				f.Message = "rollout stalled: " + c.Message
+			default:
+				continue
+			}
+			findings = append(findings, f)
+		}
+	}
+	return findings, nil
+}
+
+// analyzeProbes reports probe failures recorded in events, and running
+// containers that aren't ready, which usually means a failing readiness
+// probe.
+func analyzeProbes(in *Input) ([]Finding, error) {
+	var findings []Finding
+	for _, pod := range in.pods {
+		events, err := store.ListEvents(in.db, in.runID, "involved_kind = 'Pod' AND namespace = ? AND involved_name = ? AND reason = 'Unhealthy'", pod.Namespace, pod.Name)
+		if err != nil {
+			return nil, err
+		}
+		if len(events) > 0 {
+			e := events[len(events)-1]
+			findings = append(findings, Finding{Severity: SeverityWarning, Rule: "probes", Kind: "pod", Namespace: pod.Namespace, Name: pod.Name,
+				Message: fmt.Sprintf("%s (%d times)", e.Message, e.Count)})
+			continue
+		}
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.State.Running != nil && !cs.Ready {
+				findings = append(findings, Finding{Severity: SeverityWarning, Rule: "probes", Kind: "pod", Namespace: pod.Namespace, Name: pod.Name,
+					Message: fmt.Sprintf("container %s is running but not ready", cs.Name)})
+			}
+		}
+	}
+	return findings, nil
+}
+
+func analyzeReplicas(in *Input) ([]Finding, error) {
+	var findings []Finding
+	check := func(kind, namespace, name string, desired, ready int32) {
+		if ready >= desired {
+			return
+		}
+		f := Finding{Severity: SeverityWarning, Rule: "replicas", Kind: kind, Namespace: namespace, Name: name,
+			Message: fmt.Sprintf("%d of %d replicas ready", ready, desired)}
+		if ready == 0 {
+			f.Severity = SeverityCritical
+		}
+		findings = append(findings, f)
+	}
+
+	for _, d := range in.deployments {
+		desired := int32(1)
+		if d.Spec.Replicas != nil {
+			desired = *d.Spec.Replicas
+		}
+		check("deployment", d.Namespace, d.Name, desired, d.Status.ReadyReplicas)
+	}
+	for _, s := range in.statefulsets {
+		desired := int32(1)
+		if s.Spec.Replicas != nil {
+			desired = *s.Spec.Replicas
+		}
+		check("statefulset", s.Namespace, s.Name, desired, s.Status.ReadyReplicas)
+	}
+	for _, ds := range in.daemonsets {
+		check("daemonset", ds.Namespace, ds.Name, ds.Status.DesiredNumberScheduled, ds.Status.NumberReady)
+	}
+	return findings, nil
+}
@@ -0,0 +1,95 @@
+package analyze
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// certKeys are the secret keys certificates are read from: kubernetes.io/tls
+// secrets keep the chain in tls.crt, and cert-manager adds the issuing CA in
+// ca.crt.
+var certKeys = []string{"tls.crt", "ca.crt"}
+
+// parseCertificates decodes every PEM certificate in data, skipping blocks
+// that aren't certificates or don't parse.
+func parseCertificates(data []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+}
+
+// analyzeCerts reports certificates in gathered secrets that have expired or
+// expire within the window, as of when the run was gathered, and the
+// ingresses serving them.
+func analyzeCerts(in *Input) ([]Finding, error) {
+	// The first finding for each secret, repeated for the ingresses using it.
+	expiring := map[[2]string]Finding{}
+
+	var findings []Finding
+	for _, secret := range in.secrets {
+		for _, key := range certKeys {
+			for _, cert := range parseCertificates(secret.Data[key]) {
+				f, ok := certFinding(cert, in.asOf, in.certWindow)
+				if !ok {
+					continue
+				}
+				f.Kind, f.Namespace, f.Name = "secret", secret.Namespace, secret.Name
+				f.Message = fmt.Sprintf("%s: %s", key, f.Message)
+				findings = append(findings, f)
+				if _, seen := expiring[[2]string{secret.Namespace, secret.Name}]; !seen {
+					expiring[[2]string{secret.Namespace, secret.Name}] = f
+				}
+			}
+		}
+	}
+
+	for _, ing := range in.ingresses {
+		for _, tls := range ing.Spec.TLS {
+			f, ok := expiring[[2]string{ing.Namespace, tls.SecretName}]
+			if !ok {
+				continue
+			}
+			hosts := append([]string{}, tls.Hosts...)
+			sort.Strings(hosts)
+			f.Kind, f.Name = "ingress", ing.Name
+			f.Message = fmt.Sprintf("TLS for %s uses secret %s, %s", strings.Join(hosts, ","), tls.SecretName, f.Message)
+			findings = append(findings, f)
+		}
+	}
+	return findings, nil
+}
+
+func certFinding(cert *x509.Certificate, asOf time.Time, window time.Duration) (Finding, bool) {
+	subject := cert.Subject.CommonName
+	if subject == "" && len(cert.DNSNames) > 0 {
+		subject = cert.DNSNames[0]
+	}
+	remaining := cert.NotAfter.Sub(asOf)
+	expires := cert.NotAfter.UTC().Format("2006-01-02")
+	switch {
+	case remaining <= 0:
+		return Finding{Severity: SeverityCritical, Rule: "certs",
+			Message: fmt.Sprintf("certificate %q expired %s (%s ago)", subject, expires, duration.HumanDuration(-remaining))}, true
+	case remaining <= window:
+		return Finding{Severity: SeverityWarning, Rule: "certs",
+			Message: fmt.Sprintf("certificate %q expires %s (in %s)", subject, expires, duration.HumanDuration(remaining))}, true
+	}
+	return Finding{}, false
+}
@@ -0,0 +1,260 @@
+package analyze
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"kube-query/pkg/store"
+)
+
+// apiDeprecation is an API version of a kind the Kubernetes project has
+// deprecated, and the minor release that stops serving it.
+type apiDeprecation struct {
+	APIVersion   string
+	Kind         string
+	DeprecatedIn string
+	RemovedIn    string
+	Replacement  string
+}
+
+// apiDeprecations follows the Kubernetes deprecated API migration guide.
+var apiDeprecations = []apiDeprecation{
+	{"extensions/v1beta1", "Deployment", "1.9", "1.16", "apps/v1"},
+	{"extensions/v1beta1", "DaemonSet", "1.9", "1.16", "apps/v1"},
+	{"extensions/v1beta1", "ReplicaSet", "1.9", "1.16", "apps/v1"},
+	{"extensions/v1beta1", "NetworkPolicy", "1.9", "1.16", "networking.k8s.io/v1"},
+	{"extensions/v1beta1", "PodSecurityPolicy", "1.10", "1.16", "policy/v1beta1"},
+	{"apps/v1beta1", "Deployment", "1.9", "1.16", "apps/v1"},
+	{"apps/v1beta1", "StatefulSet", "1.9", "1.16", "apps/v1"},
+	{"apps/v1beta2", "Deployment", "1.9", "1.16", "apps/v1"},
+	{"apps/v1beta2", "StatefulSet", "1.9", "1.16", "apps/v1"},
+	{"apps/v1beta2", "DaemonSet", "1.9", "1.16", "apps/v1"},
+	{"apps/v1beta2", "ReplicaSet", "1.9", "1.16", "apps/v1"},
+
+	{"extensions/v1beta1", "Ingress", "1.14", "1.22", "networking.k8s.io/v1"},
+	{"networking.k8s.io/v1beta1", "Ingress", "1.19", "1.22", "networking.k8s.io/v1"},
+	{"networking.k8s.io/v1beta1", "IngressClass", "1.19", "1.22", "networking.k8s.io/v1"},
+	{"admissionregistration.k8s.io/v1beta1", "MutatingWebhookConfiguration", "1.16", "1.22", "admissionregistration.k8s.io/v1"},
+	{"admissionregistration.k8s.io/v1beta1", "ValidatingWebhookConfiguration", "1.16", "1.22", "admissionregistration.k8s.io/v1"},
+	{"apiextensions.k8s.io/v1beta1", "CustomResourceDefinition", "1.16", "1.22", "apiextensions.k8s.io/v1"},
+	{"apiregistration.k8s.io/v1beta1", "APIService", "1.19", "1.22", "apiregistration.k8s.io/v1"},
+	{"certificates.k8s.io/v1beta1", "CertificateSigningRequest", "1.19", "1.22", "certificates.k8s.io/v1"},
+	{"coordination.k8s.io/v1beta1", "Lease", "1.19", "1.22", "coordination.k8s.io/v1"},
+	{"rbac.authorization.k8s.io/v1beta1", "ClusterRole", "1.17", "1.22", "rbac.authorization.k8s.io/v1"},
+	{"rbac.authorization.k8s.io/v1beta1", "ClusterRoleBinding", "1.17", "1.22", "rbac.authorization.k8s.io/v1"},
+	{"rbac.authorization.k8s.io/v1beta1", "Role", "1.17", "1.22", "rbac.authorization.k8s.io/v1"},
+	{"rbac.authorization.k8s.io/v1beta1", "RoleBinding", "1.17", "1.22", "rbac.authorization.k8s.io/v1"},
+	{"scheduling.k8s.io/v1beta1", "PriorityClass", "1.14", "1.22", "scheduling.k8s.io/v1"},
+	{"storage.k8s.io/v1beta1", "CSIDriver", "1.19", "1.22", "storage.k8s.io/v1"},
+	{"storage.k8s.io/v1beta1", "CSINode", "1.17", "1.22", "storage.k8s.io/v1"},
+	{"storage.k8s.io/v1beta1", "StorageClass", "1.19", "1.22", "storage.k8s.io/v1"},
+	{"storage.k8s.io/v1beta1", "VolumeAttachment", "1.19", "1.22", "storage.k8s.io/v1"},
+
+	{"batch/v1beta1", "CronJob", "1.21", "1.25", "batch/v1"},
+	{"discovery.k8s.io/v1beta1", "EndpointSlice", "1.21", "1.25", "discovery.k8s.io/v1"},
+	{"events.k8s.io/v1beta1", "Event", "1.22", "1.25", "events.k8s.io/v1"},
+	{"autoscaling/v2beta1", "HorizontalPodAutoscaler", "1.22", "1.25", "autoscaling/v2"},
+	{"policy/v1beta1", "PodDisruptionBudget", "1.21", "1.25", "policy/v1"},
+	{"policy/v1beta1", "PodSecurityPolicy", "1.21", "1.25", "Pod Security Admission"},
+	{"node.k8s.io/v1beta1", "RuntimeClass", "1.20", "1.25", "node.k8s.io/v1"},
+
+	{"autoscaling/v2beta2", "HorizontalPodAutoscaler", "1.23", "1.26", "autoscaling/v2"},
+	{"flowcontrol.apiserver.k8s.io/v1beta1", "FlowSchema", "1.23", "1.26", "flowcontrol.apiserver.k8s.io/v1"},
+	{"flowcontrol.apiserver.k8s.io/v1beta1", "PriorityLevelConfiguration", "1.23", "1.26", "flowcontrol.apiserver.k8s.io/v1"},
+	{"storage.k8s.io/v1beta1", "CSIStorageCapacity", "1.24", "1.27", "storage.k8s.io/v1"},
+	{"flowcontrol.apiserver.k8s.io/v1beta2", "FlowSchema", "1.26", "1.29", "flowcontrol.apiserver.k8s.io/v1"},
+	{"flowcontrol.apiserver.k8s.io/v1beta2", "PriorityLevelConfiguration", "1.26", "1.29", "flowcontrol.apiserver.k8s.io/v1"},
+	{"flowcontrol.apiserver.k8s.io/v1beta3", "FlowSchema", "1.29", "1.32", "flowcontrol.apiserver.k8s.io/v1"},
+	{"flowcontrol.apiserver.k8s.io/v1beta3", "PriorityLevelConfiguration", "1.29", "1.32", "flowcontrol.apiserver.k8s.io/v1"},
+}
+
+func findDeprecation(apiVersion, kind string) (apiDeprecation, bool) {
+	for _, d := range apiDeprecations {
+		if d.APIVersion == apiVersion && d.Kind == kind {
+			return d, true
+		}
+	}
+	return apiDeprecation{}, false
+}
+
+// parseMinorVersion extracts the major and minor release from a version such
+// as 1.29, v1.29.3 or a provider's v1.29.3-gke.1000.
+func parseMinorVersion(version string) ([2]int, error) {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return [2]int{}, fmt.Errorf("invalid Kubernetes version %q, expected e.g. 1.29", version)
+	}
+	var v [2]int
+	for i := range v {
+		n, err := strconv.Atoi(strings.TrimRight(parts[i], "+"))
+		if err != nil {
+			return [2]int{}, fmt.Errorf("invalid Kubernetes version %q, expected e.g. 1.29", version)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+func versionAtLeast(v [2]int, release string) bool {
+	r, err := parseMinorVersion(release)
+	if err != nil {
+		return false
+	}
+	return v[0] > r[0] || v[0] == r[0] && v[1] >= r[1]
+}
+
+// gatheredServerVersion returns the API server version recorded with
+// --control-plane, or "" if it wasn't gathered.
+func gatheredServerVersion(db *sql.DB, runID int64) (string, error) {
+	var version string
+	err := db.QueryRow(`
+		SELECT status FROM control_plane WHERE run_id = ? AND component = 'apiserver' AND name = 'version'
+	`, runID).Scan(&version)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error querying server version: %v", err)
+	}
+	return version, nil
+}
+
+// versionedObject is an object's kind and name along with an API version it
+// is known by, and where that version was seen.
+type versionedObject struct {
+	APIVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+	Source     string
+}
+
+// analyzeDeprecatedAPIs reports objects known by an API version that is
+// deprecated in, or removed by, the target version: --target-version, or the
+// gathered API server version if none was given. Versions are taken from the
+// inventory, which records the version the API server serves, and from
+// kubectl's last-applied configuration, which records the version the object
+// was applied with and so shows which manifests need updating.
+func analyzeDeprecatedAPIs(in *Input) ([]Finding, error) {
+	target := in.targetVersion
+	if target == "" {
+		var err error
+		if target, err = gatheredServerVersion(in.db, in.runID); err != nil {
+			return nil, err
+		}
+	}
+	var version [2]int
+	if target != "" {
+		var err error
+		if version, err = parseMinorVersion(target); err != nil {
+			return nil, err
+		}
+	}
+
+	objects, err := inventoryVersions(in.db, in.runID)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := lastAppliedVersions(in.db, in.runID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[versionedObject]bool{}
+	var findings []Finding
+	for _, obj := range append(objects, applied...) {
+		d, ok := findDeprecation(obj.APIVersion, obj.Kind)
+		if !ok {
+			continue
+		}
+		key := obj
+		key.Source = ""
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		f := Finding{Rule: "deprecated-api", Kind: strings.ToLower(obj.Kind), Namespace: obj.Namespace, Name: obj.Name}
+		switch {
+		case target == "":
+			f.Severity = SeverityWarning
+			f.Message = fmt.Sprintf("%s is deprecated since %s and removed in %s", obj.APIVersion, d.DeprecatedIn, d.RemovedIn)
+		case versionAtLeast(version, d.RemovedIn):
+			f.Severity = SeverityCritical
+			f.Message = fmt.Sprintf("%s is removed in %s and will not be served by %s", obj.APIVersion, d.RemovedIn, target)
+		case versionAtLeast(version, d.DeprecatedIn):
+			f.Severity = SeverityWarning
+			f.Message = fmt.Sprintf("%s is deprecated since %s and removed in %s", obj.APIVersion, d.DeprecatedIn, d.RemovedIn)
+		default:
+			continue
+		}
+		f.Message = fmt.Sprintf("%s (%s); use %s", f.Message, obj.Source, d.Replacement)
+		findings = append(findings, f)
+	}
+	return findings, nil
+}
+
+func inventoryVersions(db *sql.DB, runID int64) ([]versionedObject, error) {
+	rows, err := db.Query(`
+		SELECT api_version, kind, namespace, name FROM inventory WHERE run_id = ? ORDER BY id
+	`, runID)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying inventory: %v", err)
+	}
+	defer rows.Close()
+
+	var objects []versionedObject
+	for rows.Next() {
+		obj := versionedObject{Source: "as served"}
+		if err := rows.Scan(&obj.APIVersion, &obj.Kind, &obj.Namespace, &obj.Name); err != nil {
+			return nil, fmt.Errorf("Error scanning inventory: %v", err)
+		}
+		objects = append(objects, obj)
+	}
+	return objects, rows.Err()
+}
+
+// lastAppliedVersions reads the API version and kind from the
+// kubectl.kubernetes.io/last-applied-configuration annotation of every
+// gathered object that has one.
+func lastAppliedVersions(db *sql.DB, runID int64) ([]versionedObject, error) {
+	tables, err := store.RunResourceTables(db, runID)
+	if err != nil {
+		return nil, err
+	}
+	var objects []versionedObject
+	for _, t := range tables {
+		rows, err := db.Query(fmt.Sprintf(`
+			SELECT namespace, name, json_extract(metadata, '$.annotations."kubectl.kubernetes.io/last-applied-configuration"')
+			FROM %s WHERE %s AND json_valid(metadata)
+		`, t.Table, t.Where()), t.Args(runID)...)
+		if err != nil {
+			return nil, fmt.Errorf("Error querying %s: %v", t.Table, err)
+		}
+		for rows.Next() {
+			var namespace, name string
+			var applied sql.NullString
+			if err := rows.Scan(&namespace, &name, &applied); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("Error scanning %s: %v", t.Table, err)
+			}
+			var typeMeta struct {
+				APIVersion string `json:"apiVersion"`
+				Kind       string `json:"kind"`
+			}
+			if !applied.Valid || json.Unmarshal([]byte(applied.String), &typeMeta) != nil {
+				continue
+			}
+			objects = append(objects, versionedObject{typeMeta.APIVersion, typeMeta.Kind, namespace, name, "in last-applied configuration"})
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Error querying %s: %v", t.Table, err)
+		}
+	}
+	return objects, nil
+}
@@ -0,0 +1,155 @@
+package analyze
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"kube-query/pkg/store"
+)
+
+// imageRef is a container image reference split into its parts, with Docker
+// Hub defaults filled in as the container runtime would.
+type imageRef struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+func parseImageRef(image string) imageRef {
+	var ref imageRef
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.Digest = name[:i], name[i+1:]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+	}
+
+	// The first component is a registry only if it looks like a host.
+	first, rest, found := strings.Cut(name, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.Registry, ref.Repository = first, rest
+	} else {
+		ref.Registry, ref.Repository = "docker.io", name
+		if !found {
+			ref.Repository = "library/" + name
+		}
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	return ref
+}
+
+// imageDigest extracts the digest from a container status imageID, such as
+// docker-pullable://nginx@sha256:... or sha256:....
+func imageDigest(imageID string) string {
+	if i := strings.LastIndex(imageID, "@"); i >= 0 {
+		return imageID[i+1:]
+	}
+	if strings.HasPrefix(imageID, "sha256:") {
+		return imageID
+	}
+	return ""
+}
+
+// imagesReport lists every image used by gathered workloads and pods, one
+// row per namespace it is used in, with the workloads and pods using it and
+// the digests pods are running.
+func imagesReport(db *sql.DB, runID int64) ([]string, [][]interface{}, error) {
+	type usage struct {
+		workloads map[string]bool
+		pods      int
+		digests   map[string]bool
+	}
+	usages := map[[2]string]*usage{}
+	use := func(image, namespace string) *usage {
+		key := [2]string{image, namespace}
+		if usages[key] == nil {
+			usages[key] = &usage{workloads: map[string]bool{}, digests: map[string]bool{}}
+		}
+		return usages[key]
+	}
+	addTemplate := func(kind, namespace, name string, spec corev1.PodSpec) {
+		for _, c := range append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...) {
+			use(c.Image, namespace).workloads[kind+"/"+name] = true
+		}
+	}
+
+	var deployments []appsv1.Deployment
+	var statefulsets []appsv1.StatefulSet
+	var daemonsets []appsv1.DaemonSet
+	var jobs []batchv1.Job
+	var cronjobs []batchv1.CronJob
+	var pods []corev1.Pod
+	for _, load := range []func() error{
+		func() error { return store.LoadObjects(db, runID, "deployment", &deployments) },
+		func() error { return store.LoadObjects(db, runID, "statefulset", &statefulsets) },
+		func() error { return store.LoadObjects(db, runID, "daemonset", &daemonsets) },
+		func() error { return store.LoadObjects(db, runID, "job", &jobs) },
+		func() error { return store.LoadObjects(db, runID, "cronjob", &cronjobs) },
+		func() error { return store.LoadObjects(db, runID, "pod", &pods) },
+	} {
+		if err := load(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, d := range deployments {
+		addTemplate("deployment", d.Namespace, d.Name, d.Spec.Template.Spec)
+	}
+	for _, s := range statefulsets {
+		addTemplate("statefulset", s.Namespace, s.Name, s.Spec.Template.Spec)
+	}
+	for _, ds := range daemonsets {
+		addTemplate("daemonset", ds.Namespace, ds.Name, ds.Spec.Template.Spec)
+	}
+	for _, j := range jobs {
+		addTemplate("job", j.Namespace, j.Name, j.Spec.Template.Spec)
+	}
+	for _, cj := range cronjobs {
+		addTemplate("cronjob", cj.Namespace, cj.Name, cj.Spec.JobTemplate.Spec.Template.Spec)
+	}
+	for _, pod := range pods {
+		images := map[string]string{}
+		for _, c := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+			images[c.Name] = c.Image
+			use(c.Image, pod.Namespace).pods++
+		}
+		for _, cs := range append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...) {
+			if digest := imageDigest(cs.ImageID); digest != "" && images[cs.Name] != "" {
+				use(images[cs.Name], pod.Namespace).digests[digest] = true
+			}
+		}
+	}
+
+	keys := make([][2]string, 0, len(usages))
+	for key := range usages {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+
+	columns := []string{"image", "registry", "repository", "tag", "digest", "namespace", "workloads", "pods"}
+	var records [][]interface{}
+	for _, key := range keys {
+		u := usages[key]
+		ref := parseImageRef(key[0])
+		digests := ref.Digest
+		if digests == "" {
+			digests = strings.Join(store.SortedKeys(u.digests), ",")
+		}
+		records = append(records, []interface{}{key[0], ref.Registry, ref.Repository, ref.Tag, digests, key[1], len(u.workloads), u.pods})
+	}
+	return columns, records, nil
+}
@@ -0,0 +1,189 @@
+package analyze
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"kube-query/pkg/store"
+)
+
+// Configuration lint rules check workloads' pod templates for hygiene
+// problems rather than live failures, so they can run over any gather.
+
+// workloadTemplate is a workload's pod template with what the lint rules need
+// to know about its owner. Replicas is nil for DaemonSets.
+type workloadTemplate struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Replicas  *int32
+	Template  corev1.PodTemplateSpec
+}
+
+func (in *Input) workloads() []workloadTemplate {
+	var workloads []workloadTemplate
+	for _, d := range in.deployments {
+		workloads = append(workloads, workloadTemplate{"deployment", d.Namespace, d.Name, d.Spec.Replicas, d.Spec.Template})
+	}
+	for _, s := range in.statefulsets {
+		workloads = append(workloads, workloadTemplate{"statefulset", s.Namespace, s.Name, s.Spec.Replicas, s.Spec.Template})
+	}
+	for _, ds := range in.daemonsets {
+		workloads = append(workloads, workloadTemplate{"daemonset", ds.Namespace, ds.Name, nil, ds.Spec.Template})
+	}
+	return workloads
+}
+
+func (w workloadTemplate) finding(sev Severity, rule, format string, args ...interface{}) Finding {
+	return Finding{Severity: sev, Rule: rule, Kind: w.Kind, Namespace: w.Namespace, Name: w.Name, Message: fmt.Sprintf(format, args...)}
+}
+
+// lintResources reports containers without CPU or memory requests, which the
+// scheduler then can't place sensibly, and without a memory limit.
+func lintResources(in *Input) ([]Finding, error) {
+	var findings []Finding
+	for _, w := range in.workloads() {
+		for _, c := range w.Template.Spec.Containers {
+			var missing []string
+			for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+				if _, ok := c.Resources.Requests[name]; !ok {
+					missing = append(missing, string(name))
+				}
+			}
+			if len(missing) > 0 {
+				findings = append(findings, w.finding(SeverityWarning, "resources", "container %s has no %s request", c.Name, strings.Join(missing, " or ")))
+			}
+			if _, ok := c.Resources.Limits[corev1.ResourceMemory]; !ok {
+				findings = append(findings, w.finding(SeverityInfo, "resources", "container %s has no memory limit", c.Name))
+			}
+		}
+	}
+	return findings, nil
+}
+
+func lintProbes(in *Input) ([]Finding, error) {
+	var findings []Finding
+	for _, w := range in.workloads() {
+		for _, c := range w.Template.Spec.Containers {
+			if c.ReadinessProbe == nil {
+				findings = append(findings, w.finding(SeverityWarning, "missing-probes", "container %s has no readiness probe", c.Name))
+			}
+			if c.LivenessProbe == nil {
+				findings = append(findings, w.finding(SeverityInfo, "missing-probes", "container %s has no liveness probe", c.Name))
+			}
+		}
+	}
+	return findings, nil
+}
+
+// lintImageTags reports images that float: untagged or tagged latest, and not
+// pinned by digest.
+func lintImageTags(in *Input) ([]Finding, error) {
+	var findings []Finding
+	for _, w := range in.workloads() {
+		containers := append(append([]corev1.Container{}, w.Template.Spec.InitContainers...), w.Template.Spec.Containers...)
+		for _, c := range containers {
+			if strings.Contains(c.Image, "@") {
+				continue
+			}
+			// The tag follows the last colon after the last slash, which
+			// keeps registry ports out of it.
+			tag := ""
+			if i := strings.LastIndex(c.Image, ":"); i > strings.LastIndex(c.Image, "/") {
+				tag = c.Image[i+1:]
+			}
+			if tag == "" || tag == "latest" {
+				findings = append(findings, w.finding(SeverityWarning, "image-tag", "container %s uses floating image %s", c.Name, c.Image))
+			}
+		}
+	}
+	return findings, nil
+}
+
+// lintSingleReplicas reports workloads running one replica with no
+// PodDisruptionBudget, which a node drain takes down without warning.
+func lintSingleReplicas(in *Input) ([]Finding, error) {
+	var findings []Finding
+	for _, w := range in.workloads() {
+		if w.Replicas == nil || *w.Replicas != 1 {
+			continue
+		}
+		covered := false
+		for _, pdb := range in.pdbs {
+			if pdb.Namespace != w.Namespace || pdb.Spec.Selector == nil {
+				continue
+			}
+			selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			if err == nil && !selector.Empty() && selector.Matches(labels.Set(w.Template.Labels)) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			findings = append(findings, w.finding(SeverityWarning, "single-replica", "runs a single replica with no PodDisruptionBudget"))
+		}
+	}
+	return findings, nil
+}
+
+// deprecatedLabels are label and annotation keys the Kubernetes project has
+// deprecated, with their replacements.
+var deprecatedLabels = map[string]string{
+	"beta.kubernetes.io/os":                          "kubernetes.io/os",
+	"beta.kubernetes.io/arch":                        "kubernetes.io/arch",
+	"beta.kubernetes.io/instance-type":               "node.kubernetes.io/instance-type",
+	"failure-domain.beta.kubernetes.io/zone":         "topology.kubernetes.io/zone",
+	"failure-domain.beta.kubernetes.io/region":       "topology.kubernetes.io/region",
+	"scheduler.alpha.kubernetes.io/critical-pod":     "spec.priorityClassName",
+	"seccomp.security.alpha.kubernetes.io/pod":       "spec.securityContext.seccompProfile",
+	"container.seccomp.security.alpha.kubernetes.io": "the container's securityContext.seccompProfile",
+	"container.apparmor.security.beta.kubernetes.io": "the container's securityContext.appArmorProfile",
+}
+
+// lintDeprecatedFields reports deprecated fields, annotations and node
+// labels used in pod templates.
+func lintDeprecatedFields(in *Input) ([]Finding, error) {
+	var findings []Finding
+	for _, w := range in.workloads() {
+		spec := w.Template.Spec
+		if spec.DeprecatedServiceAccount != "" && spec.ServiceAccountName == "" {
+			findings = append(findings, w.finding(SeverityInfo, "deprecated", "uses spec.serviceAccount; use spec.serviceAccountName"))
+		}
+
+		keys := map[string]string{}
+		for key := range w.Template.Annotations {
+			keys[key] = "annotation"
+		}
+		for key := range spec.NodeSelector {
+			keys[key] = "node selector"
+		}
+		if spec.Affinity != nil && spec.Affinity.NodeAffinity != nil && spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+			for _, term := range spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+				for _, expr := range term.MatchExpressions {
+					keys[expr.Key] = "node affinity"
+				}
+			}
+		}
+		for _, c := range spec.TopologySpreadConstraints {
+			keys[c.TopologyKey] = "topology spread constraint"
+		}
+
+		for _, key := range store.SortedMapKeys(keys) {
+			// Per-container annotations carry the container name after a
+			// slash.
+			prefix, _, _ := strings.Cut(key, "/")
+			replacement, ok := deprecatedLabels[key]
+			if !ok {
+				if replacement, ok = deprecatedLabels[prefix]; !ok {
+					continue
+				}
+			}
+			findings = append(findings, w.finding(SeverityWarning, "deprecated", "%s %s is deprecated; use %s", keys[key], key, replacement))
+		}
+	}
+	return findings, nil
+}
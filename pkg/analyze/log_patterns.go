@@ -0,0 +1,192 @@
+package analyze
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// logPatternLimit is how many of the most frequent error and warning
+// patterns are reported for each workload.
+const logPatternLimit = 10
+
+var (
+	// klog prefixes lines with the severity letter, date, time, thread and
+	// source location, as in "E0102 15:04:05.000000    1 main.go:42] ".
+	klogHeader = regexp.MustCompile(`^([IWEF])\d{4} \d{2}:\d{2}:\d{2}\.\d+\s+\d+ [^\]]+\] `)
+	logError   = regexp.MustCompile(`(?i)\b(error|err|fatal|panic|exception|critical)\b`)
+	logWarning = regexp.MustCompile(`(?i)\b(warn|warning)\b`)
+
+	// Variable parts of a message, replaced in order so that the same message
+	// logged with different values clusters together.
+	logVariables = []struct {
+		re          *regexp.Regexp
+		replacement string
+	}{
+		{regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`), "<time>"},
+		{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<uuid>"},
+		{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`), "<ip>"},
+		{regexp.MustCompile(`(?i)\b(0x)?[0-9a-f]*\d[0-9a-f]*[a-f][0-9a-f]*\b`), "<hex>"},
+		{regexp.MustCompile(`\d+`), "<n>"},
+	}
+)
+
+// LogLevel classifies a log line as an error or a warning, trusting klog's
+// severity letter where there is one.
+func LogLevel(line string) (Severity, bool) {
+	if m := klogHeader.FindStringSubmatch(line); m != nil {
+		switch m[1] {
+		case "E", "F":
+			return SeverityWarning, true
+		case "W":
+			return SeverityInfo, true
+		}
+		return 0, false
+	}
+	switch {
+	case logError.MatchString(line):
+		return SeverityWarning, true
+	case logWarning.MatchString(line):
+		return SeverityInfo, true
+	}
+	return 0, false
+}
+
+// normalizeLogLine reduces a log line to its pattern, stripping klog headers
+// and replacing timestamps, IDs, addresses and numbers with placeholders.
+func normalizeLogLine(line string) string {
+	line = klogHeader.ReplaceAllString(line, "")
+	for _, v := range logVariables {
+		line = v.re.ReplaceAllString(line, v.replacement)
+	}
+	line = strings.Join(strings.Fields(line), " ")
+	if len(line) > 200 {
+		line = line[:200] + "..."
+	}
+	return line
+}
+
+// podWorkload names the workload a pod belongs to, following a ReplicaSet
+// owner to its Deployment by the pod-template-hash suffix. Pods without an
+// owner are their own workload.
+func podWorkload(pod corev1.Pod) (kind, name string) {
+	for _, ref := range pod.OwnerReferences {
+		if ref.Controller == nil || !*ref.Controller {
+			continue
+		}
+		if hash := pod.Labels["pod-template-hash"]; ref.Kind == "ReplicaSet" && strings.HasSuffix(ref.Name, "-"+hash) {
+			return "deployment", strings.TrimSuffix(ref.Name, "-"+hash)
+		}
+		return strings.ToLower(ref.Kind), ref.Name
+	}
+	return "pod", pod.Name
+}
+
+// analyzeLogPatterns clusters the error and warning lines in a run's gathered
+// logs by their normalized message and reports the most frequent patterns
+// for each workload, with how often and over what period each was logged.
+func analyzeLogPatterns(in *Input) ([]Finding, error) {
+	workloads := map[[2]string][2]string{}
+	for _, pod := range in.pods {
+		kind, name := podWorkload(pod)
+		workloads[[2]string{pod.Namespace, pod.Name}] = [2]string{kind, name}
+	}
+
+	rows, err := in.db.Query(`
+		SELECT namespace, pod, timestamp, line FROM log_lines WHERE run_id = ? ORDER BY id
+	`, in.runID)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying log lines: %v", err)
+	}
+	defer rows.Close()
+
+	type pattern struct {
+		severity    Severity
+		text        string
+		count       int
+		pods        map[string]bool
+		first, last time.Time
+	}
+	// patterns by workload (kind, namespace, name) and then by text.
+	patterns := map[[3]string]map[string]*pattern{}
+	for rows.Next() {
+		var namespace, pod, line string
+		var timestamp sql.NullTime
+		if err := rows.Scan(&namespace, &pod, &timestamp, &line); err != nil {
+			return nil, fmt.Errorf("Error scanning log line: %v", err)
+		}
+		sev, ok := LogLevel(line)
+		if !ok {
+			continue
+		}
+		workload, ok := workloads[[2]string{namespace, pod}]
+		if !ok {
+			workload = [2]string{"pod", pod}
+		}
+		key := [3]string{workload[0], namespace, workload[1]}
+		if patterns[key] == nil {
+			patterns[key] = map[string]*pattern{}
+		}
+		text := normalizeLogLine(line)
+		p := patterns[key][text]
+		if p == nil {
+			p = &pattern{severity: sev, text: text, pods: map[string]bool{}}
+			patterns[key][text] = p
+		}
+		p.count++
+		p.pods[pod] = true
+		if timestamp.Valid {
+			if p.first.IsZero() || timestamp.Time.Before(p.first) {
+				p.first = timestamp.Time
+			}
+			if timestamp.Time.After(p.last) {
+				p.last = timestamp.Time
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading log lines: %v", err)
+	}
+
+	keys := make([][3]string, 0, len(patterns))
+	for key := range patterns {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return strings.Join(keys[i][:], "/") < strings.Join(keys[j][:], "/")
+	})
+
+	// Most frequent first; Run's stable sort keeps this order within
+	// each workload.
+	var findings []Finding
+	for _, key := range keys {
+		byText := patterns[key]
+		top := make([]*pattern, 0, len(byText))
+		for _, p := range byText {
+			top = append(top, p)
+		}
+		sort.Slice(top, func(i, j int) bool {
+			if top[i].count != top[j].count {
+				return top[i].count > top[j].count
+			}
+			return top[i].text < top[j].text
+		})
+		if len(top) > logPatternLimit {
+			top = top[:logPatternLimit]
+		}
+		for _, p := range top {
+			when := ""
+			if !p.first.IsZero() {
+				when = fmt.Sprintf(", %s to %s", p.first.Format(time.RFC3339), p.last.Format(time.RFC3339))
+			}
+			findings = append(findings, Finding{Severity: p.severity, Rule: "log-patterns", Kind: key[0], Namespace: key[1], Name: key[2],
+				Message: fmt.Sprintf("%d lines (%d pods%s): %s", p.count, len(p.pods), when, p.text)})
+		}
+	}
+	return findings, nil
+}
@@ -0,0 +1,154 @@
+package analyze
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"kube-query/pkg/store"
+)
+
+// Objects created and managed by Kubernetes itself are never reported as
+// unused.
+var (
+	systemConfigMaps  = map[string]bool{"kube-root-ca.crt": true, "openshift-service-ca.crt": true}
+	systemSecretTypes = map[corev1.SecretType]bool{
+		corev1.SecretTypeServiceAccountToken: true,
+		"helm.sh/release.v1":                 true,
+		"bootstrap.kubernetes.io/token":      true,
+	}
+)
+
+// namespacedPodSpec is a pod spec found in a gathered pod or workload
+// template.
+type namespacedPodSpec struct {
+	Namespace string
+	Spec      corev1.PodSpec
+}
+
+// podSpecs returns every pod spec in the run: those of pods and of workload
+// templates, which may have no running pods.
+func (in *Input) podSpecs() []namespacedPodSpec {
+	var specs []namespacedPodSpec
+	for _, w := range in.workloads() {
+		specs = append(specs, namespacedPodSpec{w.Namespace, w.Template.Spec})
+	}
+	for _, j := range in.jobs {
+		specs = append(specs, namespacedPodSpec{j.Namespace, j.Spec.Template.Spec})
+	}
+	for _, cj := range in.cronjobs {
+		specs = append(specs, namespacedPodSpec{cj.Namespace, cj.Spec.JobTemplate.Spec.Template.Spec})
+	}
+	for _, pod := range in.pods {
+		specs = append(specs, namespacedPodSpec{pod.Namespace, pod.Spec})
+	}
+	return specs
+}
+
+// analyzeUnused reports ConfigMaps, Secrets and PersistentVolumeClaims no
+// gathered workload, pod or ingress refers to. Only references within the
+// gather are seen, so this is most useful over a namespace dump.
+func analyzeUnused(in *Input) ([]Finding, error) {
+	configMaps, secrets, claims := map[[2]string]bool{}, map[[2]string]bool{}, map[[2]string]bool{}
+	for _, s := range in.podSpecs() {
+		specBytes, err := json.Marshal(s.Spec)
+		if err != nil {
+			return nil, err
+		}
+		refs, err := store.FindConfigReferences(specBytes)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range refs.ConfigMaps {
+			configMaps[[2]string{s.Namespace, name}] = true
+		}
+		for _, name := range refs.Secrets {
+			secrets[[2]string{s.Namespace, name}] = true
+		}
+		for _, ref := range s.Spec.ImagePullSecrets {
+			secrets[[2]string{s.Namespace, ref.Name}] = true
+		}
+		for _, v := range s.Spec.Volumes {
+			if v.PersistentVolumeClaim != nil {
+				claims[[2]string{s.Namespace, v.PersistentVolumeClaim.ClaimName}] = true
+			}
+		}
+	}
+	for _, ing := range in.ingresses {
+		for _, tls := range ing.Spec.TLS {
+			secrets[[2]string{ing.Namespace, tls.SecretName}] = true
+		}
+	}
+
+	var findings []Finding
+	unused := func(kind, namespace, name string) {
+		findings = append(findings, Finding{Severity: SeverityInfo, Rule: "unused", Kind: kind, Namespace: namespace, Name: name,
+			Message: fmt.Sprintf("no gathered workload, pod or ingress refers to this %s", kind)})
+	}
+	for _, cm := range in.configMaps {
+		if !configMaps[[2]string{cm.Namespace, cm.Name}] && !systemConfigMaps[cm.Name] {
+			unused("configmap", cm.Namespace, cm.Name)
+		}
+	}
+	for _, secret := range in.secrets {
+		if !secrets[[2]string{secret.Namespace, secret.Name}] && !systemSecretTypes[secret.Type] {
+			unused("secret", secret.Namespace, secret.Name)
+		}
+	}
+	for _, pvc := range in.pvcs {
+		if !claims[[2]string{pvc.Namespace, pvc.Name}] && !claimedByStatefulSet(in, pvc.Namespace, pvc.Name) {
+			unused("persistentvolumeclaim", pvc.Namespace, pvc.Name)
+		}
+	}
+	return findings, nil
+}
+
+// claimedByStatefulSet reports whether a claim was created from a
+// StatefulSet's volumeClaimTemplates, named <template>-<statefulset>-<ordinal>.
+func claimedByStatefulSet(in *Input, namespace, name string) bool {
+	for _, s := range in.statefulsets {
+		if s.Namespace != namespace {
+			continue
+		}
+		for _, t := range s.Spec.VolumeClaimTemplates {
+			if strings.HasPrefix(name, t.Name+"-"+s.Name+"-") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// analyzeEmptyServices reports Services whose selector matches none of the
+// gathered pods in their namespace. Namespaces without any gathered pods are
+// skipped, since there is nothing to match against.
+func analyzeEmptyServices(in *Input) ([]Finding, error) {
+	podLabels := map[string][]labels.Set{}
+	for _, pod := range in.pods {
+		podLabels[pod.Namespace] = append(podLabels[pod.Namespace], labels.Set(pod.Labels))
+	}
+
+	var findings []Finding
+	for _, svc := range in.services {
+		pods, ok := podLabels[svc.Namespace]
+		if len(svc.Spec.Selector) == 0 || !ok {
+			continue
+		}
+		selector := labels.SelectorFromSet(svc.Spec.Selector)
+		matched := false
+		for _, set := range pods {
+			if selector.Matches(set) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			findings = append(findings, Finding{Severity: SeverityWarning, Rule: "empty-service", Kind: "service", Namespace: svc.Namespace, Name: svc.Name,
+				Message: fmt.Sprintf("selector %s matches no gathered pods", selector)})
+		}
+	}
+	return findings, nil
+}
@@ -0,0 +1,137 @@
+package analyze
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"kube-query/pkg/store"
+)
+
+// Report is a derived table computed from a run, printed by
+// `report <name>`.
+type Report struct {
+	Name        string
+	Description string
+	Run         func(db *sql.DB, runID int64) ([]string, [][]interface{}, error)
+}
+
+var Reports = []Report{
+	{Name: "usage", Description: "Container CPU and memory usage (from --metrics) against requests and limits, flagging over- and under-provisioning", Run: usageReport},
+	{Name: "images", Description: "Every image used by gathered workloads and pods, by namespace, with the digests running", Run: imagesReport},
+	{Name: "restarts", Description: "Pods that restarted, were OOM killed or evicted, with their last termination and related events", Run: restartsReport},
+}
+
+// Thresholds for the usage report. A container using less than
+// overProvisionedRatio of its request is over-provisioned; one using more
+// than nearLimitRatio of its memory limit risks being OOM killed.
+const (
+	overProvisionedRatio = 0.25
+	nearLimitRatio       = 0.9
+)
+
+func FindReport(name string) (Report, error) {
+	names := make([]string, 0, len(Reports))
+	for _, r := range Reports {
+		if r.Name == name {
+			return r, nil
+		}
+		names = append(names, r.Name)
+	}
+	return Report{}, fmt.Errorf("unknown report %q, available: %s", name, strings.Join(names, ", "))
+}
+
+// usageReport lines each container's sampled usage up against the requests
+// and limits in its pod spec.
+func usageReport(db *sql.DB, runID int64) ([]string, [][]interface{}, error) {
+	var pods []corev1.Pod
+	if err := store.LoadObjects(db, runID, "pod", &pods); err != nil {
+		return nil, nil, err
+	}
+	containers := map[[3]string]corev1.Container{}
+	for _, pod := range pods {
+		for _, c := range pod.Spec.Containers {
+			containers[[3]string{pod.Namespace, pod.Name, c.Name}] = c
+		}
+	}
+
+	rows, err := db.Query(`
+		SELECT namespace, pod, container, cpu_millicores, memory_bytes
+		FROM pod_metrics WHERE run_id = ? ORDER BY namespace, pod, container
+	`, runID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error querying pod metrics: %v", err)
+	}
+	defer rows.Close()
+
+	columns := []string{"namespace", "pod", "container", "cpu", "cpu_request", "cpu_limit", "cpu_of_request",
+		"memory", "memory_request", "memory_limit", "memory_of_request", "assessment"}
+	var records [][]interface{}
+	for rows.Next() {
+		var namespace, pod, container string
+		var cpu, memory int64
+		if err := rows.Scan(&namespace, &pod, &container, &cpu, &memory); err != nil {
+			return nil, nil, fmt.Errorf("Error scanning pod metrics: %v", err)
+		}
+		c, ok := containers[[3]string{namespace, pod, container}]
+		if !ok {
+			continue
+		}
+
+		cpuUsage := *resource.NewMilliQuantity(cpu, resource.DecimalSI)
+		memoryUsage := *resource.NewQuantity(memory, resource.BinarySI)
+		cpuRequest, cpuLimit := c.Resources.Requests[corev1.ResourceCPU], c.Resources.Limits[corev1.ResourceCPU]
+		memoryRequest, memoryLimit := c.Resources.Requests[corev1.ResourceMemory], c.Resources.Limits[corev1.ResourceMemory]
+		records = append(records, []interface{}{namespace, pod, container,
+			cpuUsage.String(), quantityOrNone(cpuRequest), quantityOrNone(cpuLimit), percentOf(cpuUsage, cpuRequest),
+			memoryUsage.String(), quantityOrNone(memoryRequest), quantityOrNone(memoryLimit), percentOf(memoryUsage, memoryRequest),
+			assessUsage(cpuUsage, cpuRequest, memoryUsage, memoryRequest, memoryLimit)})
+	}
+	return columns, records, rows.Err()
+}
+
+// assessUsage summarises a container's provisioning, most pressing first.
+func assessUsage(cpu, cpuRequest, memory, memoryRequest, memoryLimit resource.Quantity) string {
+	var notes []string
+	if !memoryLimit.IsZero() && ratio(memory, memoryLimit) > nearLimitRatio {
+		notes = append(notes, "near memory limit")
+	}
+	for _, r := range []struct {
+		name           string
+		usage, request resource.Quantity
+	}{{"cpu", cpu, cpuRequest}, {"memory", memory, memoryRequest}} {
+		switch {
+		case r.request.IsZero():
+			notes = append(notes, "no "+r.name+" request")
+		case ratio(r.usage, r.request) > 1:
+			notes = append(notes, r.name+" under-provisioned")
+		case ratio(r.usage, r.request) < overProvisionedRatio:
+			notes = append(notes, r.name+" over-provisioned")
+		}
+	}
+	if len(notes) == 0 {
+		return "ok"
+	}
+	return strings.Join(notes, ", ")
+}
+
+func quantityOrNone(q resource.Quantity) string {
+	if q.IsZero() {
+		return "<none>"
+	}
+	return q.String()
+}
+
+func percentOf(usage, request resource.Quantity) string {
+	if request.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%.0f%%", 100*ratio(usage, request))
+}
+
+func ratio(a, b resource.Quantity) float64 {
+	return a.AsApproximateFloat64() / b.AsApproximateFloat64()
+}
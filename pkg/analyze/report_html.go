@@ -0,0 +1,327 @@
+package analyze
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"os"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"kube-query/pkg/store"
+)
+
+// htmlReportCertWindow is how far ahead the HTML report looks for expiring
+// certificates, as analyze does by default.
+const htmlReportCertWindow = 30 * 24 * time.Hour
+
+// Layout of the topology diagrams, in pixels: three columns of boxes, for
+// services, workloads and pods.
+const (
+	topologyColumnWidth = 270
+	topologyBoxWidth    = 240
+	topologyBoxHeight   = 20
+	topologyRowHeight   = 28
+	topologyTop         = 30
+)
+
+// htmlReport is what the HTML report template renders.
+type htmlReport struct {
+	Run         *store.RunInfo
+	GeneratedAt time.Time
+	Inventory   []kindCount
+	Findings    []Finding
+	// Omitted counts the info findings left out of the report.
+	Omitted    int
+	LogErrors  []Finding
+	Topologies []topology
+}
+
+type kindCount struct {
+	Kind  string
+	Count int
+}
+
+// topology is a namespace's services, workloads and pods, drawn as an SVG
+// diagram with an edge from each service to what it selects and from each
+// workload to its pods.
+type topology struct {
+	Namespace     string
+	Width, Height int
+	Boxes         []topologyBox
+	Edges         []topologyEdge
+}
+
+type topologyBox struct {
+	X, Y, Width, Height int
+	Label               string
+	Class               string
+}
+
+type topologyEdge struct {
+	X1, Y1, X2, Y2 int
+}
+
+// WriteHTMLReport writes a self-contained HTML report of a run to path: its
+// inventory, the analyzers' warning and critical findings, a topology diagram
+// of each namespace and the most frequent errors in its logs. It needs
+// nothing but a browser to read, so it can be attached to incident tickets.
+func WriteHTMLReport(db *sql.DB, runID int64, path string) error {
+	run, err := store.GetRun(db, runID)
+	if err != nil {
+		return fmt.Errorf("Error reading run %d: %v", runID, err)
+	}
+	inventory, err := countResources(db, runID)
+	if err != nil {
+		return err
+	}
+	findings, err := Run(db, runID, nil, htmlReportCertWindow, "")
+	if err != nil {
+		return err
+	}
+	in, err := loadAnalysisInput(db, runID)
+	if err != nil {
+		return err
+	}
+
+	report := htmlReport{Run: run, GeneratedAt: time.Now().UTC(), Inventory: inventory, Topologies: buildTopologies(in)}
+	for _, f := range findings {
+		switch {
+		case f.Rule == "log-patterns":
+			// Warnings in logs are too noisy to be worth a reader's time here.
+			if f.Severity >= SeverityWarning {
+				report.LogErrors = append(report.LogErrors, f)
+			}
+		case f.Severity == SeverityInfo:
+			report.Omitted++
+		default:
+			report.Findings = append(report.Findings, f)
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("Error creating %s: %v", path, err)
+	}
+	if err := htmlReportTemplate.Execute(f, report); err != nil {
+		f.Close()
+		return fmt.Errorf("Error writing %s: %v", path, err)
+	}
+	return f.Close()
+}
+
+// countResources counts the objects of each kind gathered in a run.
+func countResources(db *sql.DB, runID int64) ([]kindCount, error) {
+	tables, err := store.RunResourceTables(db, runID)
+	if err != nil {
+		return nil, err
+	}
+	var counts []kindCount
+	for _, t := range tables {
+		var count int
+		query := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s`, t.Table, t.Where())
+		if err := db.QueryRow(query, t.Args(runID)...).Scan(&count); err != nil {
+			return nil, fmt.Errorf("Error counting %s: %v", t.Kind, err)
+		}
+		if count > 0 {
+			counts = append(counts, kindCount{t.Kind, count})
+		}
+	}
+	return counts, nil
+}
+
+// buildTopologies lays out a diagram for each namespace with gathered
+// services, workloads or pods. Services point at the workloads whose pods or
+// pod templates they select, or at pods no workload owns.
+func buildTopologies(in *Input) []topology {
+	type node struct {
+		kind, name string
+		labels     labels.Set
+	}
+	services := map[string][]node{}
+	workloads := map[string][]node{}
+	pods := map[string][]node{}
+	podOwners := map[[2]string]string{}
+	seen := map[[3]string]bool{}
+	addWorkload := func(namespace, kind, name string, set labels.Set) {
+		if !seen[[3]string{namespace, kind, name}] {
+			seen[[3]string{namespace, kind, name}] = true
+			workloads[namespace] = append(workloads[namespace], node{kind, name, set})
+		}
+	}
+	for _, w := range in.podTemplates() {
+		if w.Kind != "pod" {
+			addWorkload(w.Namespace, w.Kind, w.Name, w.Template.Labels)
+		}
+	}
+	for _, pod := range in.pods {
+		pods[pod.Namespace] = append(pods[pod.Namespace], node{"pod", pod.Name, pod.Labels})
+		if kind, name := podWorkload(pod); kind != "pod" {
+			addWorkload(pod.Namespace, kind, name, nil)
+			podOwners[[2]string{pod.Namespace, pod.Name}] = kind + "/" + name
+		}
+	}
+	for _, svc := range in.services {
+		services[svc.Namespace] = append(services[svc.Namespace], node{"service", svc.Name, svc.Spec.Selector})
+	}
+
+	namespaces := map[string]bool{}
+	for _, m := range []map[string][]node{services, workloads, pods} {
+		for namespace, nodes := range m {
+			sort.Slice(nodes, func(i, j int) bool {
+				if nodes[i].kind != nodes[j].kind {
+					return nodes[i].kind < nodes[j].kind
+				}
+				return nodes[i].name < nodes[j].name
+			})
+			namespaces[namespace] = true
+		}
+	}
+
+	phases := map[[2]string]string{}
+	for _, pod := range in.pods {
+		phases[[2]string{pod.Namespace, pod.Name}] = string(pod.Status.Phase)
+	}
+
+	var topologies []topology
+	for _, namespace := range store.SortedKeys(namespaces) {
+		t := topology{Namespace: namespace}
+		// Where each node's box is, by "kind/name".
+		boxes := map[string]topologyBox{}
+		for column, nodes := range [][]node{services[namespace], workloads[namespace], pods[namespace]} {
+			for row, n := range nodes {
+				b := topologyBox{X: 10 + column*topologyColumnWidth, Y: topologyTop + row*topologyRowHeight,
+					Width: topologyBoxWidth, Height: topologyBoxHeight, Label: truncate(n.kind+"/"+n.name, 36), Class: n.kind}
+				switch column {
+				case 1:
+					b.Class = "workload"
+				case 2:
+					b.Class = "pod " + phases[[2]string{namespace, n.name}]
+				}
+				boxes[n.kind+"/"+n.name] = b
+				t.Boxes = append(t.Boxes, b)
+				if bottom := b.Y + topologyRowHeight; bottom > t.Height {
+					t.Height = bottom
+				}
+			}
+		}
+		t.Width = 10 + 2*topologyColumnWidth + topologyBoxWidth + 10
+
+		connect := func(from, to string) {
+			a, b := boxes[from], boxes[to]
+			t.Edges = append(t.Edges, topologyEdge{a.X + a.Width, a.Y + a.Height/2, b.X, b.Y + b.Height/2})
+		}
+		for _, n := range pods[namespace] {
+			if owner, ok := podOwners[[2]string{namespace, n.name}]; ok {
+				connect(owner, "pod/"+n.name)
+			}
+		}
+		for _, svc := range services[namespace] {
+			if len(svc.labels) == 0 {
+				continue
+			}
+			selector := labels.SelectorFromSet(svc.labels)
+			targets := map[string]bool{}
+			for _, w := range workloads[namespace] {
+				if w.labels != nil && selector.Matches(w.labels) {
+					targets[w.kind+"/"+w.name] = true
+				}
+			}
+			for _, p := range pods[namespace] {
+				if !selector.Matches(p.labels) {
+					continue
+				}
+				if owner, ok := podOwners[[2]string{namespace, p.name}]; ok {
+					targets[owner] = true
+				} else {
+					targets["pod/"+p.name] = true
+				}
+			}
+			for _, target := range store.SortedKeys(targets) {
+				connect("service/"+svc.name, target)
+			}
+		}
+		topologies = append(topologies, t)
+	}
+	return topologies
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>kube-gather report: run {{.Run.ID}}</title>
+<style>
+  body { font-family: sans-serif; font-size: 14px; margin: 0 24px 24px; }
+  header { margin: 0 -24px 16px; padding: 8px 24px; background: #326ce5; color: #fff; }
+  header h1 { font-size: 18px; margin: 0; }
+  h2 { font-size: 16px; margin-top: 24px; border-bottom: 1px solid #ddd; }
+  table { border-collapse: collapse; }
+  th, td { text-align: left; padding: 2px 12px 2px 0; vertical-align: top; }
+  td.message { font-family: monospace; font-size: 12px; }
+  .critical { color: #c62828; font-weight: bold; }
+  .warning { color: #b26a00; }
+  .muted { color: #888; }
+  svg text { font-size: 11px; font-family: sans-serif; }
+  svg rect { stroke: #999; fill: #f5f5f5; }
+  svg rect.service { fill: #e8eefc; }
+  svg rect.workload { fill: #ede7f6; }
+  svg rect.Running, svg rect.Succeeded { fill: #e6ffed; }
+  svg rect.Pending { fill: #fff8e1; }
+  svg rect.Failed { fill: #ffeef0; }
+  svg line { stroke: #999; }
+</style>
+</head>
+<body>
+<header><h1>kube-gather report: run {{.Run.ID}}</h1></header>
+<p>
+  Gathered {{with .Run.StartedAt}}{{.UTC.Format "2006-01-02 15:04:05 MST"}}{{else}}at an unknown time{{end}}{{with .Run.FinishedAt}} to {{.UTC.Format "2006-01-02 15:04:05 MST"}}{{end}}.
+  <span class="muted">Report generated {{.GeneratedAt.Format "2006-01-02 15:04:05 MST"}}.</span>
+</p>
+
+<h2>Inventory</h2>
+{{if .Inventory}}<table>
+<tr><th>Kind</th><th>Count</th></tr>
+{{range .Inventory}}<tr><td>{{.Kind}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+{{else}}<p class="muted">Nothing was gathered.</p>
+{{end}}
+
+<h2>Health findings</h2>
+{{if .Findings}}<table>
+<tr><th>Severity</th><th>Rule</th><th>Object</th><th>Message</th></tr>
+{{range .Findings}}<tr class="{{.Severity}}"><td>{{.Severity}}</td><td>{{.Rule}}</td><td>{{.Kind}} {{if .Namespace}}{{.Namespace}}/{{end}}{{.Name}}</td><td>{{.Message}}</td></tr>
+{{end}}</table>
+{{else}}<p>No warnings or critical problems found.</p>
+{{end}}{{if .Omitted}}<p class="muted">{{.Omitted}} informational findings are not shown; run <code>kube-gather analyze</code> for all of them.</p>
+{{end}}
+
+<h2>Topology</h2>
+{{range .Topologies}}<h3>{{.Namespace}}</h3>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}">
+  <text x="10" y="18">Services</text><text x="280" y="18">Workloads</text><text x="550" y="18">Pods</text>
+{{range .Edges}}  <line x1="{{.X1}}" y1="{{.Y1}}" x2="{{.X2}}" y2="{{.Y2}}"/>
+{{end}}{{range .Boxes}}  <rect class="{{.Class}}" x="{{.X}}" y="{{.Y}}" width="{{.Width}}" height="{{.Height}}" rx="3"/><text x="{{.X}}" y="{{.Y}}" dx="6" dy="14">{{.Label}}</text>
+{{end}}</svg>
+{{else}}<p class="muted">No services, workloads or pods were gathered.</p>
+{{end}}
+
+<h2>Top log errors</h2>
+{{if .LogErrors}}<table>
+<tr><th>Workload</th><th>Pattern</th></tr>
+{{range .LogErrors}}<tr><td>{{.Kind}} {{.Namespace}}/{{.Name}}</td><td class="message">{{.Message}}</td></tr>
+{{end}}</table>
+{{else}}<p class="muted">No errors in the gathered logs.</p>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,119 @@
+package analyze
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"kube-query/pkg/store"
+)
+
+// restartEventReasons are the pod event reasons that explain a restart or
+// termination.
+var restartEventReasons = map[string]bool{
+	"BackOff":    true,
+	"Killing":    true,
+	"Unhealthy":  true,
+	"OOMKilling": true,
+	"Evicted":    true,
+	"Preempted":  true,
+}
+
+// restartsReport lists every gathered pod that has restarted, been OOM killed
+// or been evicted, with the most recent termination from its container
+// statuses and a tally of the related events gathered with it.
+func restartsReport(db *sql.DB, runID int64) ([]string, [][]interface{}, error) {
+	var pods []corev1.Pod
+	if err := store.LoadObjects(db, runID, "pod", &pods); err != nil {
+		return nil, nil, err
+	}
+	events, err := podRestartEvents(db, runID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	columns := []string{"namespace", "pod", "restarts", "restarted", "oom_killed", "evicted",
+		"last_reason", "last_exit_code", "last_finished", "events"}
+	var records [][]interface{}
+	for _, pod := range pods {
+		var restarts int32
+		var restarted, oomKilled []string
+		var last *corev1.ContainerStateTerminated
+		lastContainer := ""
+		for _, cs := range append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...) {
+			restarts += cs.RestartCount
+			if cs.RestartCount > 0 {
+				restarted = append(restarted, cs.Name)
+			}
+			for _, t := range []*corev1.ContainerStateTerminated{cs.State.Terminated, cs.LastTerminationState.Terminated} {
+				if t == nil {
+					continue
+				}
+				if t.Reason == "OOMKilled" && (len(oomKilled) == 0 || oomKilled[len(oomKilled)-1] != cs.Name) {
+					oomKilled = append(oomKilled, cs.Name)
+				}
+				if last == nil || t.FinishedAt.After(last.FinishedAt.Time) {
+					last, lastContainer = t, cs.Name
+				}
+			}
+		}
+		evicted := ""
+		if pod.Status.Reason == "Evicted" {
+			evicted = pod.Status.Message
+			if evicted == "" {
+				evicted = "yes"
+			}
+		}
+		tally := events[[2]string{pod.Namespace, pod.Name}]
+		if restarts == 0 && len(oomKilled) == 0 && evicted == "" && tally == "" {
+			continue
+		}
+
+		var lastReason, lastExitCode, lastFinished interface{}
+		if last != nil {
+			lastReason = fmt.Sprintf("%s (%s)", last.Reason, lastContainer)
+			lastExitCode = last.ExitCode
+			if !last.FinishedAt.IsZero() {
+				lastFinished = last.FinishedAt.UTC().Format(time.RFC3339)
+			}
+		}
+		records = append(records, []interface{}{pod.Namespace, pod.Name, restarts, strings.Join(restarted, ","),
+			strings.Join(oomKilled, ","), evicted, lastReason, lastExitCode, lastFinished, tally})
+	}
+	return columns, records, nil
+}
+
+// podRestartEvents tallies the restart-related events gathered for each pod
+// in a run, as "BackOff x12, Killing x2", keyed by namespace and pod name.
+func podRestartEvents(db *sql.DB, runID int64) (map[[2]string]string, error) {
+	rows, err := db.Query(`
+		SELECT namespace, involved_name, reason, SUM(COALESCE(NULLIF(count, 0), 1)) FROM events
+		WHERE run_id = ? AND involved_kind = 'Pod'
+		GROUP BY namespace, involved_name, reason ORDER BY namespace, involved_name, reason
+	`, runID)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying events: %v", err)
+	}
+	defer rows.Close()
+
+	tallies := map[[2]string]string{}
+	for rows.Next() {
+		var namespace, pod, reason string
+		var count int64
+		if err := rows.Scan(&namespace, &pod, &reason, &count); err != nil {
+			return nil, fmt.Errorf("Error scanning events: %v", err)
+		}
+		if !restartEventReasons[reason] {
+			continue
+		}
+		key := [2]string{namespace, pod}
+		if tallies[key] != "" {
+			tallies[key] += ", "
+		}
+		tallies[key] += fmt.Sprintf("%s x%d", reason, count)
+	}
+	return tallies, rows.Err()
+}
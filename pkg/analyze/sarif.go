@@ -0,0 +1,123 @@
+package analyze
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The SARIF 2.1.0 subset needed to report findings. Objects have no source
+// files, so each finding is located by a path of the form
+// <kind>/<namespace>/<name>, which code-scanning dashboards require, and by a
+// logical location naming the object.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID              string            `json:"ruleId"`
+	Level               string            `json:"level"`
+	Message             sarifMessage      `json:"message"`
+	Locations           []sarifLocation   `json:"locations"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation  `json:"physicalLocation"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// sarifLevels maps finding severities to SARIF result levels.
+var sarifLevels = map[Severity]string{
+	SeverityInfo:     "note",
+	SeverityWarning:  "warning",
+	SeverityCritical: "error",
+}
+
+// WriteSARIF writes findings as a SARIF log. Each result is fingerprinted by
+// its rule and object, not its message, so that a finding whose counts or
+// times change between runs is still tracked as the same one. Findings of a
+// rule on the same object are told apart by their order.
+func WriteSARIF(w io.Writer, findings []Finding) error {
+	run := sarifRun{Tool: sarifTool{Driver: sarifDriver{Name: "kube-gather", Rules: []sarifRule{}}}, Results: []sarifResult{}}
+	rules := map[string]bool{}
+	occurrences := map[string]int{}
+	for _, f := range findings {
+		if !rules[f.Rule] {
+			rules[f.Rule] = true
+			description := f.Rule
+			if a, err := findAnalyzer(f.Rule); err == nil {
+				description = a.Description
+			}
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: f.Rule, ShortDescription: sarifMessage{description}})
+		}
+
+		path := strings.Join([]string{f.Kind, f.Namespace, f.Name}, "/")
+		if f.Namespace == "" {
+			path = f.Kind + "/" + f.Name
+		}
+		key := f.Rule + "\x00" + path
+		fingerprint := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d", key, occurrences[key])))
+		occurrences[key]++
+		run.Results = append(run.Results, sarifResult{
+			RuleID:  f.Rule,
+			Level:   sarifLevels[f.Severity],
+			Message: sarifMessage{f.Message},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: path}},
+				LogicalLocations: []sarifLogicalLocation{{Name: f.Name, FullyQualifiedName: path, Kind: f.Kind}},
+			}},
+			PartialFingerprints: map[string]string{"objectRule/v1": hex.EncodeToString(fingerprint[:])},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
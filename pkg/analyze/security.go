@@ -0,0 +1,163 @@
+package analyze
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/pkg/store"
+)
+
+// storedRole is a Role or ClusterRole as kept in the objects table, where
+// top-level fields other than metadata and status are stored as the spec.
+type storedRole struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              struct {
+		Rules []rbacv1.PolicyRule `json:"rules"`
+	} `json:"spec"`
+}
+
+// podTemplates returns the pod templates of every gathered workload and the
+// specs of pods no controller owns, which the security checks cover alike.
+func (in *Input) podTemplates() []workloadTemplate {
+	templates := in.workloads()
+	for _, j := range in.jobs {
+		templates = append(templates, workloadTemplate{"job", j.Namespace, j.Name, nil, j.Spec.Template})
+	}
+	for _, cj := range in.cronjobs {
+		templates = append(templates, workloadTemplate{"cronjob", cj.Namespace, cj.Name, nil, cj.Spec.JobTemplate.Spec.Template})
+	}
+	for _, pod := range in.pods {
+		if metav1.GetControllerOf(&pod) == nil {
+			templates = append(templates, workloadTemplate{"pod", pod.Namespace, pod.Name, nil, corev1.PodTemplateSpec{ObjectMeta: pod.ObjectMeta, Spec: pod.Spec}})
+		}
+	}
+	return templates
+}
+
+// analyzeSecurity reports privileged containers, access to the host's
+// namespaces and filesystem, containers that may run as root or without a
+// seccomp profile, and wildcard RBAC rules.
+func analyzeSecurity(in *Input) ([]Finding, error) {
+	var findings []Finding
+	for _, w := range in.podTemplates() {
+		spec := w.Template.Spec
+		for _, host := range []struct {
+			name string
+			set  bool
+		}{{"hostNetwork", spec.HostNetwork}, {"hostPID", spec.HostPID}, {"hostIPC", spec.HostIPC}} {
+			if host.set {
+				findings = append(findings, w.finding(SeverityWarning, "host-namespaces", "uses %s", host.name))
+			}
+		}
+		for _, v := range spec.Volumes {
+			if v.HostPath != nil {
+				findings = append(findings, w.finding(SeverityWarning, "host-path", "volume %s mounts host path %s", v.Name, v.HostPath.Path))
+			}
+		}
+
+		podContext := spec.SecurityContext
+		if podContext == nil {
+			podContext = &corev1.PodSecurityContext{}
+		}
+		for _, c := range append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...) {
+			sc := c.SecurityContext
+			if sc == nil {
+				sc = &corev1.SecurityContext{}
+			}
+			if sc.Privileged != nil && *sc.Privileged {
+				findings = append(findings, w.finding(SeverityCritical, "privileged", "container %s is privileged", c.Name))
+			}
+
+			// Container settings override the pod's.
+			runAsUser, runAsNonRoot := podContext.RunAsUser, podContext.RunAsNonRoot
+			if sc.RunAsUser != nil {
+				runAsUser = sc.RunAsUser
+			}
+			if sc.RunAsNonRoot != nil {
+				runAsNonRoot = sc.RunAsNonRoot
+			}
+			switch {
+			case runAsUser != nil && *runAsUser == 0:
+				findings = append(findings, w.finding(SeverityWarning, "run-as-root", "container %s runs as root (runAsUser 0)", c.Name))
+			case runAsUser == nil && (runAsNonRoot == nil || !*runAsNonRoot):
+				findings = append(findings, w.finding(SeverityInfo, "run-as-root", "container %s may run as root: neither runAsNonRoot nor runAsUser is set", c.Name))
+			}
+
+			seccomp := podContext.SeccompProfile
+			if sc.SeccompProfile != nil {
+				seccomp = sc.SeccompProfile
+			}
+			switch {
+			case seccomp == nil:
+				findings = append(findings, w.finding(SeverityInfo, "seccomp", "container %s has no seccomp profile", c.Name))
+			case seccomp.Type == corev1.SeccompProfileTypeUnconfined:
+				findings = append(findings, w.finding(SeverityWarning, "seccomp", "container %s runs with seccomp Unconfined", c.Name))
+			}
+		}
+	}
+
+	rbac, err := analyzeRBACWildcards(in)
+	if err != nil {
+		return nil, err
+	}
+	return append(findings, rbac...), nil
+}
+
+// analyzeRBACWildcards reports Role and ClusterRole rules granting every verb,
+// resource or API group. The cluster's bootstrap roles, such as cluster-admin,
+// are skipped.
+func analyzeRBACWildcards(in *Input) ([]Finding, error) {
+	var findings []Finding
+	for _, kind := range []string{"role", "clusterrole"} {
+		var roles []storedRole
+		if err := store.LoadObjects(in.db, in.runID, kind, &roles); err != nil {
+			return nil, err
+		}
+		for _, role := range roles {
+			if role.Labels["kubernetes.io/bootstrapping"] == "rbac-defaults" || strings.HasPrefix(role.Name, "system:") {
+				continue
+			}
+			for _, rule := range role.Spec.Rules {
+				var wildcards []string
+				for _, field := range []struct {
+					name   string
+					values []string
+				}{{"verbs", rule.Verbs}, {"resources", rule.Resources}, {"apiGroups", rule.APIGroups}} {
+					for _, v := range field.values {
+						if v == rbacv1.VerbAll {
+							wildcards = append(wildcards, field.name)
+							break
+						}
+					}
+				}
+				if len(wildcards) == 0 {
+					continue
+				}
+				sev := SeverityWarning
+				if len(wildcards) == 3 {
+					sev = SeverityCritical
+				}
+				findings = append(findings, Finding{Severity: sev, Rule: "rbac-wildcard", Kind: kind, Namespace: role.Namespace, Name: role.Name,
+					Message: fmt.Sprintf("wildcard %s: grants %s on %s in API groups %s", strings.Join(wildcards, " and "),
+						strings.Join(rule.Verbs, ","), strings.Join(rule.Resources, ","), strings.Join(quoteEmpty(rule.APIGroups), ","))})
+			}
+		}
+	}
+	return findings, nil
+}
+
+// quoteEmpty shows the core API group, an empty string, as "".
+func quoteEmpty(values []string) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = v
+		if v == "" {
+			out[i] = `""`
+		}
+	}
+	return out
+}
@@ -0,0 +1,249 @@
+package export
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// attachPluginPrefix names executables on PATH that attach bundles to
+// ticket systems without a built-in backend: `--attach-to foo:T-1` runs
+// kube-gather-attach-foo with the ticket and the bundle's path as arguments.
+const attachPluginPrefix = "kube-gather-attach-"
+
+// attachClient uploads bundles, which can take a while for large databases.
+var attachClient = &http.Client{Timeout: 10 * time.Minute}
+
+// TicketBackend attaches a file to an existing ticket. Check reports missing
+// configuration before the gather starts, rather than after. URL, if set,
+// returns a link to a ticket for notifications.
+type TicketBackend struct {
+	Name   string
+	Check  func() error
+	Attach func(ticket, path string) error
+	URL    func(ticket string) string
+}
+
+var ticketBackends = []TicketBackend{
+	{Name: "jira", Check: checkJira, Attach: attachJira, URL: jiraURL},
+	{Name: "servicenow", Check: checkServiceNow, Attach: attachServiceNow, URL: serviceNowURL},
+}
+
+// ParseAttachTo splits --attach-to backend:ticket and finds the backend: a
+// built-in one, or else a kube-gather-attach-<backend> plugin on PATH.
+func ParseAttachTo(arg string) (TicketBackend, string, error) {
+	name, ticket, ok := strings.Cut(arg, ":")
+	if !ok || name == "" || ticket == "" {
+		return TicketBackend{}, "", fmt.Errorf("invalid --attach-to %q, expected backend:ticket, e.g. jira:OPS-123", arg)
+	}
+	for _, b := range ticketBackends {
+		if b.Name == name {
+			return b, ticket, b.Check()
+		}
+	}
+	plugin, err := exec.LookPath(attachPluginPrefix + name)
+	if err != nil {
+		names := make([]string, 0, len(ticketBackends))
+		for _, b := range ticketBackends {
+			names = append(names, b.Name)
+		}
+		return TicketBackend{}, "", fmt.Errorf("unknown ticket backend %q, available: %s, or a %s%s plugin on PATH", name, strings.Join(names, ", "), attachPluginPrefix, name)
+	}
+	return TicketBackend{Name: name, Check: func() error { return nil }, Attach: func(ticket, path string) error {
+		out, err := exec.Command(plugin, ticket, path).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}}, ticket, nil
+}
+
+// AttachBundle compresses the database and attaches it to a ticket.
+func AttachBundle(backend TicketBackend, ticket, dbFile string) error {
+	dir, err := os.MkdirTemp("", "kube-gather-attach")
+	if err != nil {
+		return fmt.Errorf("Error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	bundle := filepath.Join(dir, filepath.Base(dbFile)+".gz")
+	if err := gzipFile(dbFile, bundle); err != nil {
+		return err
+	}
+	if err := backend.Attach(ticket, bundle); err != nil {
+		return fmt.Errorf("Error attaching bundle to %s ticket %s: %v", backend.Name, ticket, err)
+	}
+	return nil
+}
+
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("Error opening %s: %v", src, err)
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("Error creating %s: %v", dst, err)
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		return fmt.Errorf("Error compressing %s: %v", src, err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("Error compressing %s: %v", src, err)
+	}
+	return out.Close()
+}
+
+// Jira is reached at $JIRA_URL with a personal access token in $JIRA_TOKEN
+// (Data Center) or an account's $JIRA_USER and $JIRA_API_TOKEN (Cloud).
+func checkJira() error {
+	if os.Getenv("JIRA_URL") == "" {
+		return fmt.Errorf("attaching to Jira needs JIRA_URL")
+	}
+	if os.Getenv("JIRA_TOKEN") == "" && (os.Getenv("JIRA_USER") == "" || os.Getenv("JIRA_API_TOKEN") == "") {
+		return fmt.Errorf("attaching to Jira needs JIRA_TOKEN, or JIRA_USER and JIRA_API_TOKEN")
+	}
+	return nil
+}
+
+func jiraURL(issue string) string {
+	return strings.TrimSuffix(os.Getenv("JIRA_URL"), "/") + "/browse/" + url.PathEscape(issue)
+}
+
+func attachJira(issue, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Stream the multipart body rather than holding the bundle in memory.
+	body, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		part, err := form.CreateFormFile("file", filepath.Base(path))
+		if err == nil {
+			_, err = io.Copy(part, f)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	endpoint := strings.TrimSuffix(os.Getenv("JIRA_URL"), "/") + "/rest/api/2/issue/" + url.PathEscape(issue) + "/attachments"
+	req, err := http.NewRequest(http.MethodPost, endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("X-Atlassian-Token", "no-check")
+	if token := os.Getenv("JIRA_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		req.SetBasicAuth(os.Getenv("JIRA_USER"), os.Getenv("JIRA_API_TOKEN"))
+	}
+	_, err = doAttachRequest(req)
+	return err
+}
+
+// ServiceNow is reached at $SERVICENOW_URL as $SERVICENOW_USER with
+// $SERVICENOW_PASSWORD. Tickets are given by number, such as INC0012345, and
+// may be any kind of task.
+func checkServiceNow() error {
+	for _, name := range []string{"SERVICENOW_URL", "SERVICENOW_USER", "SERVICENOW_PASSWORD"} {
+		if os.Getenv(name) == "" {
+			return fmt.Errorf("attaching to ServiceNow needs SERVICENOW_URL, SERVICENOW_USER and SERVICENOW_PASSWORD")
+		}
+	}
+	return nil
+}
+
+func serviceNowURL(number string) string {
+	query := url.Values{"sysparm_query": {"number=" + number}}
+	return strings.TrimSuffix(os.Getenv("SERVICENOW_URL"), "/") + "/task.do?" + query.Encode()
+}
+
+func attachServiceNow(number, path string) error {
+	instance := strings.TrimSuffix(os.Getenv("SERVICENOW_URL"), "/")
+
+	// The attachment API wants the ticket's table and sys_id.
+	query := url.Values{
+		"sysparm_query":  {"number=" + number},
+		"sysparm_fields": {"sys_id,sys_class_name"},
+		"sysparm_limit":  {"1"},
+	}
+	req, err := http.NewRequest(http.MethodGet, instance+"/api/now/table/task?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(os.Getenv("SERVICENOW_USER"), os.Getenv("SERVICENOW_PASSWORD"))
+	response, err := doAttachRequest(req)
+	if err != nil {
+		return fmt.Errorf("Error looking up %s: %v", number, err)
+	}
+	var found struct {
+		Result []struct {
+			SysID     string `json:"sys_id"`
+			ClassName string `json:"sys_class_name"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(response, &found); err != nil {
+		return fmt.Errorf("Error decoding %s: %v", number, err)
+	}
+	if len(found.Result) == 0 {
+		return fmt.Errorf("no ticket %s", number)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	query = url.Values{
+		"table_name":   {found.Result[0].ClassName},
+		"table_sys_id": {found.Result[0].SysID},
+		"file_name":    {filepath.Base(path)},
+	}
+	req, err = http.NewRequest(http.MethodPost, instance+"/api/now/attachment/file?"+query.Encode(), f)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(os.Getenv("SERVICENOW_USER"), os.Getenv("SERVICENOW_PASSWORD"))
+	_, err = doAttachRequest(req)
+	return err
+}
+
+// doAttachRequest sends a request, returning the response body, or an error
+// for a non-2xx response.
+func doAttachRequest(req *http.Request) ([]byte, error) {
+	resp, err := attachClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode/100 != 2 {
+		excerpt := strings.TrimSpace(string(body))
+		if len(excerpt) > 500 {
+			excerpt = excerpt[:500] + "..."
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, excerpt)
+	}
+	return body, nil
+}
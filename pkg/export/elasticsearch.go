@@ -0,0 +1,256 @@
+package export
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/pkg/analyze"
+	"kube-query/pkg/store"
+)
+
+// elasticsearchBatchSize bounds how many documents go in one bulk request.
+const elasticsearchBatchSize = 500
+
+// Mappings for the objects and logs indices. Object content is kept in
+// _source but not indexed, as every kind's fields would otherwise add to the
+// mapping until the field limit is hit; labels are indexed as "key=value"
+// keywords instead. Both Elasticsearch and OpenSearch accept them.
+var (
+	elasticsearchObjectMapping = map[string]interface{}{
+		"dynamic": false,
+		"properties": map[string]interface{}{
+			"run_id":      map[string]string{"type": "long"},
+			"cluster":     map[string]string{"type": "keyword"},
+			"kind":        map[string]string{"type": "keyword"},
+			"namespace":   map[string]string{"type": "keyword"},
+			"name":        map[string]string{"type": "keyword"},
+			"labels":      map[string]string{"type": "keyword"},
+			"created_at":  map[string]string{"type": "date"},
+			"gathered_at": map[string]string{"type": "date"},
+			"metadata":    map[string]interface{}{"type": "object", "enabled": false},
+			"spec":        map[string]interface{}{"type": "object", "enabled": false},
+			"status":      map[string]interface{}{"type": "object", "enabled": false},
+			"data":        map[string]interface{}{"type": "object", "enabled": false},
+			"binaryData":  map[string]interface{}{"type": "object", "enabled": false},
+		},
+	}
+	elasticsearchLogMapping = map[string]interface{}{
+		"dynamic": false,
+		"properties": map[string]interface{}{
+			"run_id":     map[string]string{"type": "long"},
+			"cluster":    map[string]string{"type": "keyword"},
+			"namespace":  map[string]string{"type": "keyword"},
+			"pod":        map[string]string{"type": "keyword"},
+			"container":  map[string]string{"type": "keyword"},
+			"level":      map[string]string{"type": "keyword"},
+			"@timestamp": map[string]string{"type": "date"},
+			"line":       map[string]string{"type": "text"},
+		},
+	}
+	elasticsearchBulkError = regexp.MustCompile(`"reason"\s*:\s*"((?:[^"\\]|\\.)*)"`)
+)
+
+// elasticsearchExport bulk-indexes one run into an Elasticsearch or
+// OpenSearch cluster.
+type elasticsearchExport struct {
+	url     string
+	headers map[string]string
+	prefix  string
+	cluster string
+	runID   int64
+	// gatheredAt is when the run was gathered, which log lines without a
+	// timestamp are dated by.
+	gatheredAt *time.Time
+	// body is the pending bulk request, with count documents.
+	body  bytes.Buffer
+	count int
+	sent  int
+}
+
+// Elasticsearch indexes a run's gathered objects into
+// <prefix>-objects and its log lines into <prefix>-logs, creating the indices
+// with their mappings if they don't exist. Documents are keyed by run and
+// object or line, so exporting a run again overwrites rather than duplicates
+// it. Secrets are indexed without their values. It returns how many
+// documents were indexed.
+func Elasticsearch(db *sql.DB, runID int64, url, headers, prefix, cluster string) (int, error) {
+	parsed, err := parseHeaders(headers)
+	if err != nil {
+		return 0, err
+	}
+	run, err := store.GetRun(db, runID)
+	if err != nil {
+		return 0, fmt.Errorf("Error reading run %d: %v", runID, err)
+	}
+	e := &elasticsearchExport{url: strings.TrimSuffix(url, "/"), headers: parsed, prefix: prefix, cluster: cluster, runID: runID, gatheredAt: run.StartedAt}
+	if err := e.createIndex("objects", elasticsearchObjectMapping); err != nil {
+		return 0, err
+	}
+	if err := e.createIndex("logs", elasticsearchLogMapping); err != nil {
+		return 0, err
+	}
+
+	resources, err := store.ListResources(db, runID, "")
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range resources {
+		resource, err := store.GetResource(db, runID, r.Kind, r.Namespace, r.Name)
+		if err != nil {
+			return 0, err
+		}
+		if err := e.add("objects", fmt.Sprintf("%d/%s/%s/%s", runID, r.Kind, r.Namespace, r.Name), e.objectDocument(resource)); err != nil {
+			return 0, err
+		}
+	}
+
+	containers, err := podLogContainers(db, runID)
+	if err != nil {
+		return 0, err
+	}
+	err = store.ForEachPodLogBatch(db, runID, elasticsearchBatchSize, func(namespace, pod string, lines []store.LogLine) error {
+		for _, l := range lines {
+			doc := map[string]interface{}{"run_id": runID, "namespace": namespace, "pod": pod, "line": l.Line}
+			if container := containers[[2]string{namespace, pod}]; container != "" {
+				doc["container"] = container
+			}
+			if e.cluster != "" {
+				doc["cluster"] = e.cluster
+			}
+			if l.Timestamp.Valid {
+				doc["@timestamp"] = l.Timestamp.Time
+			} else if e.gatheredAt != nil {
+				doc["@timestamp"] = *e.gatheredAt
+			}
+			if sev, ok := analyze.LogLevel(l.Line); ok {
+				doc["level"] = "warning"
+				if sev >= analyze.SeverityWarning {
+					doc["level"] = "error"
+				}
+			}
+			if err := e.add("logs", fmt.Sprintf("%d/%d", runID, l.ID), doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := e.flush(); err != nil {
+		return 0, err
+	}
+	return e.sent, nil
+}
+
+// objectDocument flattens a stored resource into a document of its identity,
+// labels and content columns.
+func (e *elasticsearchExport) objectDocument(r *store.Resource) map[string]interface{} {
+	doc := map[string]interface{}{"run_id": e.runID, "kind": r.Kind, "namespace": r.Namespace, "name": r.Name}
+	if e.cluster != "" {
+		doc["cluster"] = e.cluster
+	}
+	if e.gatheredAt != nil {
+		doc["gathered_at"] = *e.gatheredAt
+	}
+	for column, raw := range r.Content {
+		doc[column] = raw
+	}
+
+	var meta metav1.ObjectMeta
+	if json.Unmarshal(r.Content["metadata"], &meta) == nil {
+		var labels []string
+		for key, value := range meta.Labels {
+			labels = append(labels, key+"="+value)
+		}
+		sort.Strings(labels)
+		doc["labels"] = labels
+		if !meta.CreationTimestamp.IsZero() {
+			doc["created_at"] = meta.CreationTimestamp.Time
+		}
+	}
+	if r.Kind == "secret" {
+		var data map[string]json.RawMessage
+		if json.Unmarshal(r.Content["data"], &data) == nil {
+			redacted := map[string]string{}
+			for key := range data {
+				redacted[key] = "<redacted>"
+			}
+			doc["data"] = redacted
+		} else {
+			delete(doc, "data")
+		}
+	}
+	return doc
+}
+
+// createIndex creates <prefix>-<name> with a mapping, leaving an existing
+// index as it is.
+func (e *elasticsearchExport) createIndex(name string, mapping map[string]interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"mappings": mapping})
+	if err != nil {
+		return err
+	}
+	index := e.prefix + "-" + name
+	_, err = sendBody(http.MethodPut, e.url+"/"+index, "application/json", e.headers, body)
+	if err != nil && !strings.Contains(err.Error(), "resource_already_exists_exception") {
+		return fmt.Errorf("Error creating index %s: %v", index, err)
+	}
+	return nil
+}
+
+// add queues a document for indexing, sending the bulk request once it is
+// full.
+func (e *elasticsearchExport) add(index, id string, doc map[string]interface{}) error {
+	action, err := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": e.prefix + "-" + index, "_id": id}})
+	if err != nil {
+		return err
+	}
+	source, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	e.body.Write(action)
+	e.body.WriteByte('\n')
+	e.body.Write(source)
+	e.body.WriteByte('\n')
+	if e.count++; e.count >= elasticsearchBatchSize {
+		return e.flush()
+	}
+	return nil
+}
+
+// flush sends the pending bulk request. A bulk request succeeds even if some
+// of its documents fail, in which case the first failure is reported.
+func (e *elasticsearchExport) flush() error {
+	if e.count == 0 {
+		return nil
+	}
+	response, err := sendBody(http.MethodPost, e.url+"/_bulk", "application/x-ndjson", e.headers, e.body.Bytes())
+	if err != nil {
+		return fmt.Errorf("Error indexing documents: %v", err)
+	}
+	if bytes.Contains(response, []byte(`"errors":true`)) {
+		reason := "unknown reason"
+		if m := elasticsearchBulkError.FindSubmatch(response); m != nil {
+			if unquoted, err := strconv.Unquote(`"` + string(m[1]) + `"`); err == nil {
+				reason = unquoted
+			}
+		}
+		return fmt.Errorf("Error indexing documents: %s", reason)
+	}
+	e.sent += e.count
+	e.body.Reset()
+	e.count = 0
+	return nil
+}
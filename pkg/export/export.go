@@ -0,0 +1,384 @@
+// Package export writes gathered runs out to other tools' formats and
+// systems, and imports support bundles as runs.
+package export
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+
+	"kube-query/pkg/gather"
+	"kube-query/pkg/store"
+)
+
+// CSV writes exactly one of a table, a SQL query or a named query as
+// CSV to the file out, or to stdout if out is empty.
+func CSV(db *sql.DB, runID int64, out, table, statement, name string) error {
+	var args []interface{}
+	switch {
+	case table != "" && statement == "" && name == "":
+		columns, err := tableColumns(db, table)
+		if err != nil {
+			return err
+		}
+		statement = fmt.Sprintf("SELECT * FROM %q", table)
+		for _, column := range columns {
+			if column == "run_id" {
+				statement += " WHERE run_id = ?"
+				args = append(args, runID)
+			}
+		}
+	case name != "" && table == "" && statement == "":
+		q, err := store.FindNamedQuery(name)
+		if err != nil {
+			return err
+		}
+		statement = q.SQL
+	case statement != "" && table == "" && name == "":
+	default:
+		return fmt.Errorf("--format csv needs exactly one of --table, --query or --name")
+	}
+
+	rows, err := db.Query(statement, args...)
+	if err != nil {
+		return fmt.Errorf("Error executing query: %v", err)
+	}
+	defer rows.Close()
+
+	if out == "" {
+		return WriteRows(os.Stdout, "csv", rows)
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("Error creating %s: %v", out, err)
+	}
+	if err := WriteRows(f, "csv", rows); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// tableColumns returns the columns of a table in the database, failing if
+// there is no such table.
+func tableColumns(db *sql.DB, table string) ([]string, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, fmt.Errorf("Error describing table %s: %v", table, err)
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, fmt.Errorf("Error describing table %s: %v", table, err)
+		}
+		columns = append(columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Error describing table %s: %v", table, err)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no such table: %s", table)
+	}
+	return columns, nil
+}
+
+// exportType is how a stored kind is named in the API, for the exported
+// objects' apiVersion and kind and the directories they are written to.
+type exportType struct {
+	Group    string
+	Version  string
+	Resource string
+	Kind     string
+	// Flatten is set for types without a spec, such as Roles, whose
+	// top-level fields are stored in the spec column.
+	Flatten bool
+}
+
+func (t exportType) apiVersion() string {
+	if t.Group == "" {
+		return t.Version
+	}
+	return t.Group + "/" + t.Version
+}
+
+func (t exportType) groupDir() string {
+	if t.Group == "" {
+		return "core"
+	}
+	return t.Group
+}
+
+func MustGather(db *sql.DB, runID int64, dir string) error {
+	info, err := store.GetRun(db, runID)
+	if err != nil {
+		return err
+	}
+	tables, err := store.RunResourceTables(db, runID)
+	if err != nil {
+		return err
+	}
+
+	objects := 0
+	for _, t := range tables {
+		resources, err := store.ListResources(db, runID, t.Kind)
+		if err != nil {
+			return err
+		}
+		if len(resources) == 0 {
+			continue
+		}
+		typ, err := resolveExportType(db, runID, t.Kind)
+		if err != nil {
+			return err
+		}
+		for _, r := range resources {
+			stored, err := store.GetResource(db, runID, r.Kind, r.Namespace, r.Name)
+			if err != nil {
+				return err
+			}
+			obj := exportObject(typ, stored)
+			path := filepath.Join(dir, "cluster-scoped-resources", typ.groupDir(), typ.Resource, r.Name+".yaml")
+			if r.Namespace != "" {
+				path = filepath.Join(dir, "namespaces", r.Namespace, typ.groupDir(), typ.Resource, r.Name+".yaml")
+			}
+			if err := writeYAMLFile(path, obj); err != nil {
+				return err
+			}
+			objects++
+
+			if t.Kind == "pod" {
+				if err := exportPodLogs(db, runID, dir, r.Namespace, r.Name, obj); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if err := exportEvents(db, runID, dir); err != nil {
+		return err
+	}
+
+	// must-gather records when collection started and finished.
+	var timestamps []string
+	for _, t := range []*time.Time{info.StartedAt, info.FinishedAt} {
+		if t != nil {
+			timestamps = append(timestamps, t.UTC().Format(time.RFC3339))
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "timestamp"), []byte(strings.Join(timestamps, "\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("Error writing timestamp: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d objects from run %d to %s\n", objects, runID, dir)
+	return nil
+}
+
+// resolveExportType names a stored kind: from its definition for custom
+// resources gathered with --crds, from the built-in type registered with
+// client-go for known kinds, and otherwise by its stored name.
+func resolveExportType(db *sql.DB, runID int64, kind string) (exportType, error) {
+	var definition sql.NullString
+	err := db.QueryRow(`
+		SELECT d.spec FROM objects o JOIN objects d ON d.id = o.definition_id
+		WHERE o.run_id = ? AND o.kind = ? LIMIT 1
+	`, runID, kind).Scan(&definition)
+	if err != nil && err != sql.ErrNoRows {
+		return exportType{}, fmt.Errorf("Error fetching definition of %s: %v", kind, err)
+	}
+	if definition.Valid {
+		var spec gather.CRDSpec
+		if err := json.Unmarshal([]byte(definition.String), &spec); err == nil {
+			t := exportType{Group: spec.Group, Resource: spec.Names.Plural, Kind: spec.Names.Kind}
+			for _, v := range spec.Versions {
+				if v.Storage {
+					t.Version = v.Name
+				}
+			}
+			return t, nil
+		}
+	}
+
+	name, group, _ := strings.Cut(kind, ".")
+	t := exportType{Group: group, Resource: name, Kind: name}
+	if k, ok := store.FindObjectKind(kind); ok {
+		t.Group, t.Version, t.Resource = k.Resource.Group, k.Resource.Version, k.Resource.Resource
+	}
+	// Without a registered version, take the most stable one client-go knows.
+	var best schema.GroupVersionKind
+	for gvk := range scheme.Scheme.AllKnownTypes() {
+		if gvk.Group != t.Group || strings.ToLower(gvk.Kind) != name || t.Version != "" && gvk.Version != t.Version {
+			continue
+		}
+		if best.Empty() || version.CompareKubeAwareVersionStrings(gvk.Version, best.Version) > 0 {
+			best = gvk
+		}
+	}
+	if !best.Empty() {
+		t.Version, t.Kind = best.Version, best.Kind
+		if goType := scheme.Scheme.AllKnownTypes()[best]; goType.Kind() == reflect.Struct {
+			_, hasSpec := goType.FieldByName("Spec")
+			t.Flatten = !hasSpec
+		}
+	}
+	return t, nil
+}
+
+// exportObject reassembles a stored resource into a complete object.
+func exportObject(t exportType, r *store.Resource) map[string]interface{} {
+	obj := map[string]interface{}{"apiVersion": t.apiVersion(), "kind": t.Kind}
+	for column, raw := range r.Content {
+		var value interface{}
+		if json.Unmarshal(raw, &value) != nil || value == nil {
+			continue
+		}
+		if fields, ok := value.(map[string]interface{}); ok && column == "spec" && t.Flatten {
+			for key, v := range fields {
+				obj[key] = v
+			}
+			continue
+		}
+		obj[column] = value
+	}
+
+	// Rows gathered before metadata was stored have none.
+	meta, _ := obj["metadata"].(map[string]interface{})
+	if meta == nil {
+		meta = map[string]interface{}{}
+		obj["metadata"] = meta
+	}
+	if meta["name"] == nil {
+		meta["name"] = r.Name
+	}
+	if meta["namespace"] == nil && r.Namespace != "" {
+		meta["namespace"] = r.Namespace
+	}
+	return obj
+}
+
+// exportPodLogs writes a pod's gathered logs where must-gather keeps them.
+func exportPodLogs(db *sql.DB, runID int64, dir, namespace, name string, obj map[string]interface{}) error {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	var pod corev1.Pod
+	if err := json.Unmarshal(raw, &pod); err != nil {
+		return fmt.Errorf("Error decoding pod: %v", err)
+	}
+	logs, err := store.GetPodLogs(db, runID, namespace, name)
+	if err != nil || logs == "" {
+		return err
+	}
+	container := defaultContainer(&pod)
+	path := filepath.Join(dir, "namespaces", namespace, "pods", name, container, container, "logs", "current.log")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("Error creating %s: %v", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(logs), 0644); err != nil {
+		return fmt.Errorf("Error writing %s: %v", path, err)
+	}
+	return nil
+}
+
+// defaultContainer names the container whose logs are gathered for a pod, as
+// kubectl picks it.
+func defaultContainer(pod *corev1.Pod) string {
+	if container := pod.Annotations["kubectl.kubernetes.io/default-container"]; container != "" {
+		return container
+	}
+	if len(pod.Spec.Containers) > 0 {
+		return pod.Spec.Containers[0].Name
+	}
+	return ""
+}
+
+// podLogContainers names the container each gathered pod's logs came from,
+// keyed by namespace and pod name.
+func podLogContainers(db *sql.DB, runID int64) (map[[2]string]string, error) {
+	var pods []corev1.Pod
+	if err := store.LoadObjects(db, runID, "pod", &pods); err != nil {
+		return nil, err
+	}
+	containers := map[[2]string]string{}
+	for i := range pods {
+		containers[[2]string{pods[i].Namespace, pods[i].Name}] = defaultContainer(&pods[i])
+	}
+	return containers, nil
+}
+
+// exportEvents writes each namespace's gathered events as an EventList.
+func exportEvents(db *sql.DB, runID int64, dir string) error {
+	rows, err := db.Query(`
+		SELECT namespace, involved_kind, involved_name, reason, type, message, count, first_seen, last_seen, source_component
+		FROM events WHERE run_id = ? ORDER BY namespace, last_seen
+	`, runID)
+	if err != nil {
+		return fmt.Errorf("Error querying events: %v", err)
+	}
+	defer rows.Close()
+
+	lists := map[string]*corev1.EventList{}
+	var namespaces []string
+	for rows.Next() {
+		var namespace string
+		var kind, name, reason, eventType, message, component sql.NullString
+		var count sql.NullInt64
+		var first, last sql.NullTime
+		if err := rows.Scan(&namespace, &kind, &name, &reason, &eventType, &message, &count, &first, &last, &component); err != nil {
+			return fmt.Errorf("Error scanning events: %v", err)
+		}
+		if lists[namespace] == nil {
+			lists[namespace] = &corev1.EventList{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "EventList"}}
+			namespaces = append(namespaces, namespace)
+		}
+		list := lists[namespace]
+		list.Items = append(list.Items, corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Namespace: namespace, Name: fmt.Sprintf("%s.%d", name.String, len(list.Items))},
+			InvolvedObject: corev1.ObjectReference{Kind: kind.String, Namespace: namespace, Name: name.String},
+			Reason:         reason.String,
+			Type:           eventType.String,
+			Message:        message.String,
+			Count:          int32(count.Int64),
+			FirstTimestamp: metav1.NewTime(first.Time),
+			LastTimestamp:  metav1.NewTime(last.Time),
+			Source:         corev1.EventSource{Component: component.String},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("Error reading events: %v", err)
+	}
+
+	for _, namespace := range namespaces {
+		if err := writeYAMLFile(filepath.Join(dir, "namespaces", namespace, "core", "events.yaml"), lists[namespace]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeYAMLFile(path string, v interface{}) error {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("Error marshalling %s: %v", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("Error creating %s: %v", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("Error writing %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package export
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+
+	"kube-query/pkg/store"
+)
+
+// Where support bundles keep pod logs: must-gather under
+// namespaces/<ns>/pods/<pod>/<container>/<container>/logs/current.log, and
+// troubleshoot.sh support bundles under
+// cluster-resources/pods/logs/<ns>/<pod>/<container>.log.
+var (
+	mustGatherLogPath    = regexp.MustCompile(`(^|/)namespaces/([^/]+)/pods/([^/]+)/[^/]+/[^/]+/logs/current\.log$`)
+	supportBundleLogPath = regexp.MustCompile(`(^|/)cluster-resources/pods/logs/([^/]+)/([^/]+)/[^/]+\.log$`)
+)
+
+// ImportBundle loads a support bundle directory or .tar.gz archive into s as
+// a new run, dated by when the bundle was collected if it says.
+func ImportBundle(s *store.Store, path string) (*store.Run, error) {
+	summary := store.NewRun(time.Now())
+	if err := summary.Begin(s.DB); err != nil {
+		return nil, err
+	}
+	imp := &bundleImport{db: s.DB, summary: summary}
+	var err error
+	if strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz") {
+		err = imp.readArchive(path)
+	} else {
+		err = imp.readDir(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The run is dated by when the bundle was collected, if it says.
+	end := time.Now()
+	if !imp.collectedAt[0].IsZero() {
+		summary.StartedAt, end = imp.collectedAt[0], imp.collectedAt[1]
+		if end.IsZero() {
+			end = summary.StartedAt
+		}
+		if _, err := s.Exec(`UPDATE runs SET started_at = ? WHERE id = ?`, summary.StartedAt, summary.RunID); err != nil {
+			return nil, fmt.Errorf("Error updating run in database: %v", err)
+		}
+	}
+	summary.Finish(end, s.Path)
+	return summary, summary.Complete(s.DB, true)
+}
+
+// bundleImport stores the files of one bundle into a run.
+type bundleImport struct {
+	db      *sql.DB
+	summary *store.Run
+	// collectedAt is when the bundle's collection started and finished,
+	// from its timestamp file.
+	collectedAt [2]time.Time
+}
+
+func (imp *bundleImport) readDir(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("Error reading %s: %v", path, err)
+		}
+		rel, _ := filepath.Rel(dir, path)
+		imp.importFile(filepath.ToSlash(rel), data)
+		return nil
+	})
+}
+
+func (imp *bundleImport) readArchive(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Error opening %s: %v", path, err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %v", path, err)
+	}
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading %s: %v", path, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(archive)
+		if err != nil {
+			return fmt.Errorf("Error reading %s from %s: %v", header.Name, path, err)
+		}
+		imp.importFile(strings.TrimPrefix(header.Name, "./"), data)
+	}
+}
+
+// importFile stores a bundle file by what it is: pod logs, the timestamp
+// file, or YAML or JSON objects. Anything else is skipped.
+func (imp *bundleImport) importFile(name string, data []byte) {
+	if m := mustGatherLogPath.FindStringSubmatch(name); m != nil {
+		imp.importLogs(m[2], m[3], data)
+		return
+	}
+	if m := supportBundleLogPath.FindStringSubmatch(name); m != nil && !strings.HasSuffix(name, "-previous.log") {
+		imp.importLogs(m[2], m[3], data)
+		return
+	}
+	if filepath.Base(name) == "timestamp" {
+		imp.parseTimestamps(data)
+		return
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml", ".json":
+	default:
+		return
+	}
+
+	for _, doc := range bytes.Split(data, []byte("\n---")) {
+		jsonBytes, err := yaml.YAMLToJSON(doc)
+		if err != nil || len(bytes.TrimSpace(jsonBytes)) == 0 || jsonBytes[0] != '{' {
+			continue
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(jsonBytes); err != nil {
+			// Not a Kubernetes object, such as a tool's own metadata.
+			continue
+		}
+		if !obj.IsList() {
+			imp.importObject(obj)
+			continue
+		}
+		list, err := obj.ToList()
+		if err != nil {
+			continue
+		}
+		itemKind := strings.TrimSuffix(list.GetKind(), "List")
+		for i := range list.Items {
+			item := &list.Items[i]
+			if item.GetKind() == "" {
+				item.SetKind(itemKind)
+			}
+			if item.GetAPIVersion() == "" {
+				item.SetAPIVersion(list.GetAPIVersion())
+			}
+			imp.importObject(item)
+		}
+	}
+}
+
+func (imp *bundleImport) importLogs(namespace, pod string, data []byte) {
+	imp.summary.AddLogBytes(namespace, pod, int64(len(data)))
+	if err := store.StoreLogLines(imp.db, imp.summary.RunID, 0, namespace, pod, data); err != nil {
+		imp.summary.AddResourceError(store.PhaseStore, "pod", namespace, pod, fmt.Errorf("Error inserting log lines for pod %s: %w", pod, err))
+	}
+}
+
+// importObject stores an object as a gather would, in its kind's table.
+func (imp *bundleImport) importObject(obj *unstructured.Unstructured) {
+	if obj.GetKind() == "" || obj.GetName() == "" {
+		return
+	}
+	gvk := obj.GroupVersionKind()
+	var err error
+	switch {
+	case gvk.Group == "apps" && gvk.Kind == "Deployment":
+		var deployment appsv1.Deployment
+		if err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &deployment); err == nil {
+			store.StoreDeployment(imp.db, imp.summary, &deployment)
+		}
+	case gvk.Group == "" && gvk.Kind == "Pod":
+		var pod corev1.Pod
+		if err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &pod); err == nil {
+			store.StorePod(imp.db, imp.summary, &pod, 0, 0)
+		}
+	case gvk.Group == "" && gvk.Kind == "ConfigMap":
+		var configMap corev1.ConfigMap
+		if err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &configMap); err == nil {
+			store.StoreConfigMap(imp.db, imp.summary, &configMap)
+		}
+	case gvk.Group == "" && gvk.Kind == "Secret":
+		var secret corev1.Secret
+		if err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &secret); err == nil {
+			store.StoreSecret(imp.db, imp.summary, &secret)
+		}
+	case gvk.Group == "" && gvk.Kind == "Node":
+		var node corev1.Node
+		if err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &node); err == nil {
+			var objectID int64
+			if objectID, err = store.StoreObject(imp.db, imp.summary, "node", &node.ObjectMeta, node.Spec, node.Status); err == nil {
+				store.StoreNodeStatus(imp.db, imp.summary, &node, objectID)
+			}
+		}
+	case gvk.Group == "" && gvk.Kind == "ResourceQuota":
+		var quota corev1.ResourceQuota
+		if err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &quota); err == nil {
+			var objectID int64
+			if objectID, err = store.StoreObject(imp.db, imp.summary, "resourcequota", &quota.ObjectMeta, quota.Spec, quota.Status); err == nil {
+				store.StoreQuotaUsage(imp.db, imp.summary, &quota, objectID)
+			}
+		}
+	case gvk.Group == "gateway.networking.k8s.io" && gvk.Kind == "HTTPRoute":
+		var route store.HTTPRoute
+		if err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &route); err == nil {
+			spec, status := store.UnstructuredContent(obj)
+			var objectID int64
+			if objectID, err = store.StoreObject(imp.db, imp.summary, "httproute", &route.ObjectMeta, spec, status); err == nil {
+				store.StoreRouteBackends(imp.db, imp.summary, &route, objectID)
+			}
+		}
+	case gvk.Group == "autoscaling.k8s.io" && gvk.Kind == "VerticalPodAutoscaler":
+		var vpa store.VerticalPodAutoscaler
+		if err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &vpa); err == nil {
+			spec, status := store.UnstructuredContent(obj)
+			var objectID int64
+			if objectID, err = store.StoreObject(imp.db, imp.summary, "verticalpodautoscaler", &vpa.ObjectMeta, spec, status); err == nil {
+				store.StoreVPARecommendations(imp.db, imp.summary, &vpa, objectID)
+			}
+		}
+	case gvk.Group == "certificates.k8s.io" && gvk.Kind == "CertificateSigningRequest":
+		var csr certificatesv1.CertificateSigningRequest
+		if err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &csr); err == nil {
+			var objectID int64
+			if objectID, err = store.StoreObject(imp.db, imp.summary, "certificatesigningrequest", &csr.ObjectMeta, csr.Spec, csr.Status); err == nil {
+				store.StoreCSRStatus(imp.db, imp.summary, &csr, objectID)
+			}
+		}
+	case gvk.Group == "" && gvk.Kind == "Event":
+		var event corev1.Event
+		if err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &event); err == nil {
+			store.StoreEvent(imp.db, imp.summary, &event, 0)
+		}
+	default:
+		meta, metaErr := store.UnstructuredMeta(obj)
+		if err = metaErr; err == nil {
+			spec, status := store.UnstructuredContent(obj)
+			_, err = store.StoreObject(imp.db, imp.summary, importedKind(gvk.Group, gvk.Kind), meta, spec, status)
+		}
+	}
+	if err != nil {
+		imp.summary.AddResourceError(store.PhaseStore, importedKind(gvk.Group, gvk.Kind), obj.GetNamespace(), obj.GetName(), fmt.Errorf("Error importing %s %s/%s: %w", gvk.Kind, obj.GetNamespace(), obj.GetName(), err))
+	}
+}
+
+// importedKind names a kind as gathering it would: by its registered name, or
+// else by the lowercased kind qualified with its API group.
+func importedKind(group, kind string) string {
+	for _, k := range store.ObjectKinds {
+		if k.Resource.Group == group && k.Kind == strings.ToLower(kind) {
+			return k.Kind
+		}
+	}
+	if group == "" {
+		return strings.ToLower(kind)
+	}
+	return strings.ToLower(kind) + "." + group
+}
+
+// parseTimestamps reads a bundle's timestamp file: collection start and end
+// times, one per line, in RFC 3339 or as Go prints times, which is what
+// must-gather writes.
+func (imp *bundleImport) parseTimestamps(data []byte) {
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	for i := 0; i < len(lines) && i < len(imp.collectedAt); i++ {
+		line := strings.TrimSpace(lines[i])
+		// Drop the monotonic clock reading, as in "... +0000 UTC m=+0.08".
+		if j := strings.Index(line, " m="); j >= 0 {
+			line = line[:j]
+		}
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999 -0700 MST"} {
+			if t, err := time.Parse(layout, line); err == nil {
+				imp.collectedAt[i] = t.UTC()
+				break
+			}
+		}
+	}
+}
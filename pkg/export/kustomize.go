@@ -0,0 +1,226 @@
+package export
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"kube-query/pkg/gather"
+	"kube-query/pkg/store"
+)
+
+// Metadata the API server sets, which has no place in a manifest.
+var serverSetMetadata = []string{
+	"uid", "resourceVersion", "generation", "creationTimestamp", "managedFields", "selfLink",
+	"deletionTimestamp", "deletionGracePeriodSeconds",
+}
+
+// Annotations controllers and kubectl add to objects, by name or prefix.
+var (
+	serverSetAnnotations = []string{
+		"kubectl.kubernetes.io/last-applied-configuration",
+		"deployment.kubernetes.io/revision",
+	}
+	serverSetAnnotationPrefixes = []string{
+		"pv.kubernetes.io/", "volume.beta.kubernetes.io/", "volume.kubernetes.io/", "control-plane.alpha.kubernetes.io/",
+	}
+	// jobControllerLabels are the labels the Job controller adds to a job's
+	// selector and pod template.
+	jobControllerLabels = []string{"controller-uid", "batch.kubernetes.io/controller-uid", "job-name", "batch.kubernetes.io/job-name"}
+)
+
+// kustomizeObject is an object chosen for a kustomize base.
+type kustomizeObject struct {
+	kind, namespace, name string
+	obj                   map[string]interface{}
+}
+
+// Kustomize writes the objects of the --app kinds matching a label
+// selector, and the ConfigMaps and Secrets their pod templates refer to, as
+// cleaned manifests with a kustomization.yaml listing them: a base to start
+// managing a hand-deployed application from Git. Secret values are left out,
+// to be filled in or replaced with a sealed secret before committing.
+func Kustomize(db *sql.DB, runID int64, dir, app string) error {
+	selector, err := labels.Parse(app)
+	if err != nil {
+		return fmt.Errorf("invalid --app selector %q: %v", app, err)
+	}
+
+	var objects []kustomizeObject
+	chosen := map[[3]string]bool{}
+	referenced := map[[3]string]bool{}
+	for _, kind := range gather.AppKinds {
+		resources, err := store.ListResources(db, runID, kind)
+		if err != nil {
+			return err
+		}
+		if len(resources) == 0 {
+			continue
+		}
+		typ, err := resolveExportType(db, runID, kind)
+		if err != nil {
+			return err
+		}
+		for _, r := range resources {
+			stored, err := store.GetResource(db, runID, r.Kind, r.Namespace, r.Name)
+			if err != nil {
+				return err
+			}
+			obj := exportObject(typ, stored)
+			if !selector.Matches(labels.Set((&unstructured.Unstructured{Object: obj}).GetLabels())) {
+				continue
+			}
+			objects = append(objects, kustomizeObject{kind, r.Namespace, r.Name, obj})
+			chosen[[3]string{kind, r.Namespace, r.Name}] = true
+
+			if spec, ok := stored.Content["spec"]; ok {
+				refs, err := store.FindConfigReferences(spec)
+				if err != nil {
+					return err
+				}
+				for _, name := range refs.ConfigMaps {
+					referenced[[3]string{"configmap", r.Namespace, name}] = true
+				}
+				for _, name := range refs.Secrets {
+					referenced[[3]string{"secret", r.Namespace, name}] = true
+				}
+			}
+		}
+	}
+	if len(objects) == 0 {
+		return fmt.Errorf("nothing in run %d matches %s", runID, selector)
+	}
+
+	// Config the workloads use goes with them, labelled or not.
+	keys := make([][3]string, 0, len(referenced))
+	for key := range referenced {
+		if !chosen[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return strings.Join(keys[i][:], "/") < strings.Join(keys[j][:], "/") })
+	for _, key := range keys {
+		stored, err := store.GetResource(db, runID, key[0], key[1], key[2])
+		if errors.Is(err, store.ErrNotFound) {
+			fmt.Fprintf(os.Stderr, "Skipping %s %s/%s: referenced but not gathered\n", key[0], key[1], key[2])
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		typ, err := resolveExportType(db, runID, key[0])
+		if err != nil {
+			return err
+		}
+		objects = append(objects, kustomizeObject{key[0], key[1], key[2], exportObject(typ, stored)})
+	}
+
+	namespaces := map[string]bool{}
+	for _, o := range objects {
+		namespaces[o.namespace] = true
+	}
+	kustomization := map[string]interface{}{
+		"apiVersion": "kustomize.config.k8s.io/v1beta1",
+		"kind":       "Kustomization",
+	}
+	// A single namespace is set by the kustomization, so the base can be
+	// deployed to another.
+	single := len(namespaces) == 1
+	if single && objects[0].namespace != "" {
+		kustomization["namespace"] = objects[0].namespace
+	}
+
+	var resources []string
+	for _, o := range objects {
+		if o.kind == "secret" && o.obj["type"] == "kubernetes.io/service-account-token" {
+			continue
+		}
+		cleanManifest(o.kind, o.obj)
+		if single {
+			unstructured.RemoveNestedField(o.obj, "metadata", "namespace")
+		}
+		if o.kind == "secret" {
+			fmt.Fprintf(os.Stderr, "Secret %s/%s: values left empty, fill them in or replace it with a sealed secret\n", o.namespace, o.name)
+		}
+
+		file := strings.ToLower(fmt.Sprint(o.obj["kind"])) + "-" + o.name + ".yaml"
+		if !single && o.namespace != "" {
+			file = o.namespace + "-" + file
+		}
+		if err := writeYAMLFile(filepath.Join(dir, file), o.obj); err != nil {
+			return err
+		}
+		resources = append(resources, file)
+	}
+	sort.Strings(resources)
+	kustomization["resources"] = resources
+	if err := writeYAMLFile(filepath.Join(dir, "kustomization.yaml"), kustomization); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d objects from run %d to %s\n", len(resources), runID, dir)
+	return nil
+}
+
+// cleanManifest strips what the cluster filled in from an exported object,
+// leaving what its author would have written: status, server-set metadata
+// and annotations, allocated cluster IPs and volume bindings, and the
+// labels the Job controller adds. Secret values are replaced by empty
+// strings.
+func cleanManifest(kind string, obj map[string]interface{}) {
+	delete(obj, "status")
+	for _, field := range serverSetMetadata {
+		unstructured.RemoveNestedField(obj, "metadata", field)
+	}
+	u := &unstructured.Unstructured{Object: obj}
+	if annotations := u.GetAnnotations(); annotations != nil {
+		for key := range annotations {
+			for _, prefix := range serverSetAnnotationPrefixes {
+				if strings.HasPrefix(key, prefix) {
+					delete(annotations, key)
+				}
+			}
+		}
+		for _, key := range serverSetAnnotations {
+			delete(annotations, key)
+		}
+		if len(annotations) == 0 {
+			annotations = nil
+		}
+		u.SetAnnotations(annotations)
+	}
+	unstructured.RemoveNestedField(obj, "spec", "template", "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(obj, "spec", "template", "metadata", "annotations", "kubectl.kubernetes.io/restartedAt")
+
+	switch kind {
+	case "service":
+		// Headless services are declared with clusterIP None.
+		if ip, _, _ := unstructured.NestedString(obj, "spec", "clusterIP"); ip != "None" {
+			unstructured.RemoveNestedField(obj, "spec", "clusterIP")
+			unstructured.RemoveNestedField(obj, "spec", "clusterIPs")
+		}
+	case "persistentvolumeclaim":
+		unstructured.RemoveNestedField(obj, "spec", "volumeName")
+	case "job":
+		unstructured.RemoveNestedField(obj, "spec", "selector")
+		for _, label := range jobControllerLabels {
+			unstructured.RemoveNestedField(obj, "spec", "template", "metadata", "labels", label)
+		}
+	case "secret":
+		data, _, _ := unstructured.NestedMap(obj, "data")
+		empty := map[string]interface{}{}
+		for key := range data {
+			empty[key] = ""
+		}
+		delete(obj, "data")
+		if len(empty) > 0 {
+			obj["stringData"] = empty
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package export
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"kube-query/pkg/store"
+)
+
+// lokiBatchSize bounds how many lines are pushed to Loki in one request.
+const lokiBatchSize = 1000
+
+// lokiPushRequest is the JSON body of Loki's push API: streams of
+// [timestamp in nanoseconds, line] pairs under a set of labels.
+type lokiPushRequest struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// Loki pushes a run's gathered pod logs to Loki, one stream per pod
+// labelled with its cluster, namespace, pod and container as Grafana's
+// Kubernetes dashboards expect. Lines keep the timestamps they were logged
+// with; lines without one are dated when the run was gathered. Pushing the
+// same run twice is harmless, as Loki drops identical entries. It returns
+// how many lines were pushed.
+func Loki(db *sql.DB, runID int64, url, headers, cluster string) (int, error) {
+	url = strings.TrimSuffix(url, "/")
+	if !strings.HasSuffix(url, "/loki/api/v1/push") {
+		url += "/loki/api/v1/push"
+	}
+	parsed, err := parseHeaders(headers)
+	if err != nil {
+		return 0, err
+	}
+	run, err := store.GetRun(db, runID)
+	if err != nil {
+		return 0, fmt.Errorf("Error reading run %d: %v", runID, err)
+	}
+	gathered := ""
+	if run.StartedAt != nil {
+		gathered = strconv.FormatInt(run.StartedAt.UnixNano(), 10)
+	}
+	containers, err := podLogContainers(db, runID)
+	if err != nil {
+		return 0, err
+	}
+
+	pushed := 0
+	err = store.ForEachPodLogBatch(db, runID, lokiBatchSize, func(namespace, pod string, lines []store.LogLine) error {
+		labels := map[string]string{"job": "kube-gather", "namespace": namespace, "pod": pod}
+		if container := containers[[2]string{namespace, pod}]; container != "" {
+			labels["container"] = container
+		}
+		if cluster != "" {
+			labels["cluster"] = cluster
+		}
+		stream := lokiStream{Stream: labels}
+		for _, l := range lines {
+			timestamp := gathered
+			if l.Timestamp.Valid {
+				timestamp = strconv.FormatInt(l.Timestamp.Time.UnixNano(), 10)
+			}
+			if timestamp == "" {
+				continue
+			}
+			stream.Values = append(stream.Values, [2]string{timestamp, l.Line})
+		}
+		if len(stream.Values) == 0 {
+			return nil
+		}
+		if _, err := postJSON(url, parsed, lokiPushRequest{Streams: []lokiStream{stream}}); err != nil {
+			return fmt.Errorf("Error pushing logs for pod %s/%s: %v", namespace, pod, err)
+		}
+		pushed += len(stream.Values)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return pushed, nil
+}
@@ -0,0 +1,118 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"kube-query/pkg/store"
+)
+
+var notifyClient = &http.Client{Timeout: 30 * time.Second}
+
+// maxNotifiedFailures is how many of a run's errors a notification lists.
+const maxNotifiedFailures = 3
+
+// Notification reports a finished gather to --notify-url.
+type Notification struct {
+	// Run is the gather's summary, or nil if it failed before recording a
+	// run.
+	Run *store.Run `json:"run,omitempty"`
+	// Err is why the gather failed, if it did.
+	Err error `json:"-"`
+	// Link is where the bundle was uploaded, such as the ticket it was
+	// attached to, if it was.
+	Link string `json:"link,omitempty"`
+}
+
+// Notify posts a notification to a Slack or Microsoft Teams incoming webhook
+// as a message, or to any other URL as JSON with the message in text, the
+// run's summary in run and the outcome in status.
+func Notify(notifyURL string, n Notification) error {
+	u, err := url.Parse(notifyURL)
+	if err != nil {
+		return fmt.Errorf("Error parsing --notify-url: %v", err)
+	}
+	var payload interface{} = map[string]string{"text": n.text()}
+	if u.Host != "hooks.slack.com" && !strings.HasSuffix(u.Host, ".webhook.office.com") {
+		var errText string
+		if n.Err != nil {
+			errText = n.Err.Error()
+		}
+		payload = struct {
+			Text   string `json:"text"`
+			Status string `json:"status"`
+			Error  string `json:"error,omitempty"`
+			Notification
+		}{n.text(), n.status(), errText, n}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("Error encoding notification: %v", err)
+	}
+
+	resp, err := notifyClient.Post(notifyURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Error sending notification: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, 500))
+		return fmt.Errorf("Error sending notification: %s: %s", resp.Status, strings.TrimSpace(string(excerpt)))
+	}
+	return nil
+}
+
+// status is failed, interrupted, errors (finished with some) or succeeded.
+func (n Notification) status() string {
+	switch {
+	case n.Err != nil || n.Run == nil:
+		return "failed"
+	case n.Run.Interrupted:
+		return "interrupted"
+	case n.Run.Errors > 0:
+		return "errors"
+	}
+	return "succeeded"
+}
+
+// text summarises the gather in a few lines, listing its first errors.
+func (n Notification) text() string {
+	if n.Run == nil {
+		return fmt.Sprintf("kube-gather failed: %v", n.Err)
+	}
+	r := n.Run
+	var b strings.Builder
+	outcome := "finished"
+	if r.Interrupted {
+		outcome = "was interrupted"
+	}
+	var resources int
+	for _, count := range r.Gathered {
+		resources += count
+	}
+	fmt.Fprintf(&b, "kube-gather run %d %s in %s: %d resources, %d warnings, %d errors",
+		r.RunID, outcome, r.Duration.Round(time.Second), resources, r.Warnings, r.Errors)
+	if r.Trigger != "" {
+		fmt.Fprintf(&b, " (%s)", r.Trigger)
+	}
+	if n.Err != nil {
+		fmt.Fprintf(&b, "\n%v", n.Err)
+	}
+	for i, err := range r.Failures {
+		if i == maxNotifiedFailures {
+			fmt.Fprintf(&b, "\n... and %d more errors", len(r.Failures)-i)
+			break
+		}
+		fmt.Fprintf(&b, "\n%v", err)
+	}
+	if n.Link != "" {
+		fmt.Fprintf(&b, "\nBundle: %s", n.Link)
+	}
+	return b.String()
+}
@@ -0,0 +1,126 @@
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"kube-query/pkg/store"
+)
+
+// notifyServer serves the notifications posted to any URL with status,
+// returning the bodies and hosts of those it received.
+func notifyServer(t *testing.T, status int) (bodies, hosts *[]string) {
+	t.Helper()
+	bodies, hosts = new([]string), new([]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*bodies = append(*bodies, string(body))
+		*hosts = append(*hosts, r.Host)
+		w.WriteHeader(status)
+		fmt.Fprint(w, "no_text")
+	}))
+	t.Cleanup(srv.Close)
+
+	// Every URL is sent to the test server, keeping its host, so Slack
+	// and Teams webhooks can be told apart.
+	target, _ := url.Parse(srv.URL)
+	old := notifyClient
+	notifyClient = &http.Client{Transport: roundTripper(func(r *http.Request) (*http.Response, error) {
+		r = r.Clone(r.Context())
+		r.Host = r.URL.Host
+		r.URL.Scheme, r.URL.Host = target.Scheme, target.Host
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	t.Cleanup(func() { notifyClient = old })
+	return bodies, hosts
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestNotify(t *testing.T) {
+	bodies, hosts := notifyServer(t, http.StatusOK)
+	run := &store.Run{RunID: 7, Duration: 90 * time.Second, Gathered: map[string]int{"pod": 3}}
+	for _, u := range []string{"https://hooks.slack.com/services/T0/B0/x", "https://acme.webhook.office.com/webhookb2/x"} {
+		if err := Notify(u, Notification{Run: run}); err != nil {
+			t.Fatalf("notifying %s: %v", u, err)
+		}
+	}
+	if err := Notify("https://ops.example.com/hooks/gather", Notification{Run: run, Link: "https://tickets.example.com/OPS-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*bodies) != 3 {
+		t.Fatalf("got %d notifications, want 3", len(*bodies))
+	}
+
+	// Slack and Teams get just the message.
+	for i, body := range (*bodies)[:2] {
+		var got map[string]interface{}
+		if err := json.Unmarshal([]byte(body), &got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || !strings.HasPrefix(fmt.Sprint(got["text"]), "kube-gather run 7 finished in 1m30s: 3 resources") {
+			t.Errorf("%s got %s, want only the message in text", (*hosts)[i], body)
+		}
+	}
+
+	// Anything else gets the outcome and the run as well.
+	var got struct {
+		Text, Status, Error, Link string
+		Run                       *store.Run
+	}
+	if err := json.Unmarshal([]byte((*bodies)[2]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != "succeeded" || got.Error != "" || got.Run == nil || got.Run.RunID != 7 || got.Link != "https://tickets.example.com/OPS-1" {
+		t.Errorf("got %s, want the succeeded run 7 and its link", (*bodies)[2])
+	}
+	if !strings.HasSuffix(got.Text, "\nBundle: https://tickets.example.com/OPS-1") {
+		t.Errorf("got text %q, want it to end with the bundle's link", got.Text)
+	}
+}
+
+func TestNotifyFailures(t *testing.T) {
+	bodies, _ := notifyServer(t, http.StatusOK)
+	run := &store.Run{RunID: 8, Errors: 4, Failures: store.ErrorReport{
+		errors.New("listing pods: forbidden"), errors.New("b"), errors.New("c"), errors.New("d"),
+	}}
+	if err := Notify("https://ops.example.com/hooks/gather", Notification{Run: run}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Notify("https://ops.example.com/hooks/gather", Notification{Err: errors.New("no cluster")}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []struct{ Text, Status, Error string }
+	for _, body := range *bodies {
+		var n struct{ Text, Status, Error string }
+		if err := json.Unmarshal([]byte(body), &n); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, n)
+	}
+	if got[0].Status != "errors" || !strings.Contains(got[0].Text, "\nlisting pods: forbidden\n") || !strings.HasSuffix(got[0].Text, "\n... and 1 more errors") {
+		t.Errorf("got %+v, want status errors listing the first 3 failures", got[0])
+	}
+	if got[1].Status != "failed" || got[1].Error != "no cluster" || got[1].Text != "kube-gather failed: no cluster" {
+		t.Errorf("got %+v, want status failed with the error", got[1])
+	}
+}
+
+func TestNotifyRejected(t *testing.T) {
+	notifyServer(t, http.StatusBadRequest)
+	err := Notify("https://hooks.slack.com/services/T0/B0/x", Notification{Run: &store.Run{RunID: 9}})
+	if err == nil || !strings.Contains(err.Error(), "400 Bad Request: no_text") {
+		t.Errorf("got %v, want the webhook's 400 response as an error", err)
+	}
+}
@@ -0,0 +1,235 @@
+package export
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"kube-query/pkg/analyze"
+	"kube-query/pkg/gather"
+	"kube-query/pkg/store"
+)
+
+// otlpBatchSize bounds how many log records are sent in one request, so a
+// large log doesn't exceed the collector's request size limit.
+const otlpBatchSize = 1000
+
+// OpenTelemetry log severity numbers for the levels logLevel tells apart.
+const (
+	otlpSeverityWarn  = 13
+	otlpSeverityError = 17
+)
+
+// The OTLP/HTTP JSON encoding of a logs export request. 64-bit integers are
+// encoded as strings, as the protobuf JSON mapping requires.
+type otlpLogsRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         string    `json:"timeUnixNano,omitempty"`
+	ObservedTimeUnixNano string    `json:"observedTimeUnixNano,omitempty"`
+	SeverityNumber       int       `json:"severityNumber,omitempty"`
+	SeverityText         string    `json:"severityText,omitempty"`
+	Body                 otlpValue `json:"body"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// otlpExporter sends log records to a collector's OTLP/HTTP logs endpoint.
+type otlpExporter struct {
+	url     string
+	headers map[string]string
+	// rejected counts the records collectors dropped, with the reason the
+	// last of them gave.
+	rejected       int
+	rejectedReason string
+}
+
+// OTLP replays a run's gathered pod logs to an OpenTelemetry collector,
+// one resource per pod, attributed with the cluster, namespace, pod and
+// container the logs came from. Lines are timestamped as logged and observed
+// when the run was gathered. It returns how many lines the collector
+// accepted, with an error if it rejected some.
+func OTLP(db *sql.DB, runID int64, endpoint, headers, cluster string) (int, error) {
+	exporter, err := newOTLPExporter(endpoint, headers)
+	if err != nil {
+		return 0, err
+	}
+	run, err := store.GetRun(db, runID)
+	if err != nil {
+		return 0, fmt.Errorf("Error reading run %d: %v", runID, err)
+	}
+	observed := ""
+	if run.StartedAt != nil {
+		observed = strconv.FormatInt(run.StartedAt.UnixNano(), 10)
+	}
+
+	containers, err := podLogContainers(db, runID)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	err = store.ForEachPodLogBatch(db, runID, otlpBatchSize, func(namespace, pod string, lines []store.LogLine) error {
+		records := make([]otlpLogRecord, len(lines))
+		for i, l := range lines {
+			records[i] = otlpLogRecord{ObservedTimeUnixNano: observed, Body: otlpValue{l.Line}}
+			if l.Timestamp.Valid {
+				records[i].TimeUnixNano = strconv.FormatInt(l.Timestamp.Time.UnixNano(), 10)
+			}
+			if sev, ok := analyze.LogLevel(l.Line); ok {
+				records[i].SeverityNumber, records[i].SeverityText = otlpSeverityWarn, "WARN"
+				if sev >= analyze.SeverityWarning {
+					records[i].SeverityNumber, records[i].SeverityText = otlpSeverityError, "ERROR"
+				}
+			}
+		}
+
+		attributes := []otlpAttribute{
+			{"k8s.namespace.name", otlpValue{namespace}},
+			{"k8s.pod.name", otlpValue{pod}},
+			{"kube_gather.run_id", otlpValue{strconv.FormatInt(runID, 10)}},
+		}
+		if container := containers[[2]string{namespace, pod}]; container != "" {
+			attributes = append(attributes, otlpAttribute{"k8s.container.name", otlpValue{container}})
+		}
+		if cluster != "" {
+			attributes = append(attributes, otlpAttribute{"k8s.cluster.name", otlpValue{cluster}})
+		}
+		err := exporter.send(otlpLogsRequest{ResourceLogs: []otlpResourceLogs{{
+			Resource:  otlpResource{Attributes: attributes},
+			ScopeLogs: []otlpScopeLogs{{Scope: otlpScope{Name: "kube-gather"}, LogRecords: records}},
+		}}})
+		if err != nil {
+			return fmt.Errorf("Error exporting logs for pod %s/%s: %v", namespace, pod, err)
+		}
+		sent += len(records)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if exporter.rejected > 0 {
+		return sent - exporter.rejected, fmt.Errorf("Collector rejected %d of %d log records: %s", exporter.rejected, sent, exporter.rejectedReason)
+	}
+	return sent, nil
+}
+
+// newOTLPExporter sends to endpoint's /v1/logs path, unless the endpoint
+// already names it, with headers given as "key=value,key=value" as in
+// OTEL_EXPORTER_OTLP_HEADERS.
+func newOTLPExporter(endpoint, headers string) (*otlpExporter, error) {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/logs") {
+		url += "/v1/logs"
+	}
+	parsed, err := parseHeaders(headers)
+	if err != nil {
+		return nil, err
+	}
+	return &otlpExporter{url: url, headers: parsed}, nil
+}
+
+// parseHeaders parses HTTP headers given as "key=value,key=value".
+func parseHeaders(headers string) (map[string]string, error) {
+	parsed := map[string]string{}
+	for _, header := range gather.SplitList(headers) {
+		key, value, ok := strings.Cut(header, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid header %q, expected key=value", header)
+		}
+		parsed[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return parsed, nil
+}
+
+// pushClient sends exported logs, bounding how long an unresponsive
+// backend can hold up an export.
+var pushClient = &http.Client{Timeout: 30 * time.Second}
+
+// postJSON posts a JSON body with the given headers, returning the start of
+// the response body, or an error for a non-2xx response.
+func postJSON(url string, headers map[string]string, payload interface{}) ([]byte, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return sendBody(http.MethodPost, url, "application/json", headers, body)
+}
+
+// sendBody sends a request body as postJSON does, for other methods and
+// content types.
+func sendBody(method, url, contentType string, headers map[string]string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := pushClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(response)))
+	}
+	return response, nil
+}
+
+func (e *otlpExporter) send(request otlpLogsRequest) error {
+	response, err := postJSON(e.url, e.headers, request)
+	if err != nil {
+		return err
+	}
+
+	// A collector that drops some records still succeeds, saying so.
+	var result struct {
+		PartialSuccess struct {
+			RejectedLogRecords json.Number `json:"rejectedLogRecords"`
+			ErrorMessage       string      `json:"errorMessage"`
+		} `json:"partialSuccess"`
+	}
+	if json.Unmarshal(response, &result) == nil {
+		if rejected, err := result.PartialSuccess.RejectedLogRecords.Int64(); err == nil && rejected > 0 {
+			e.rejected += int(rejected)
+			e.rejectedReason = result.PartialSuccess.ErrorMessage
+		}
+	}
+	return nil
+}
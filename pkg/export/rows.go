@@ -0,0 +1,114 @@
+package export
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// WriteRows renders a result set in one of the supported output formats.
+func WriteRows(w io.Writer, format string, rows *sql.Rows) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("Error reading result columns: %v", err)
+	}
+
+	var records [][]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return fmt.Errorf("Error scanning result row: %v", err)
+		}
+		for i, v := range values {
+			switch v := v.(type) {
+			case []byte:
+				values[i] = string(v)
+			case time.Time:
+				values[i] = v.Format(time.RFC3339Nano)
+			}
+		}
+		records = append(records, values)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("Error reading result rows: %v", err)
+	}
+
+	return WriteRecords(w, format, columns, records)
+}
+
+// WriteRecords renders rows of values in one of the supported output formats.
+func WriteRecords(w io.Writer, format string, columns []string, records [][]interface{}) error {
+	switch format {
+	case "table":
+		return writeTable(w, columns, records)
+	case "json":
+		return writeJSON(w, columns, records)
+	case "csv":
+		return writeCSV(w, columns, records)
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+func formatValue(v interface{}) string {
+	if v == nil {
+		return "NULL"
+	}
+	return fmt.Sprint(v)
+}
+
+func writeTable(w io.Writer, columns []string, records [][]interface{}) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t")))
+	for _, record := range records {
+		cells := make([]string, len(record))
+		for i, v := range record {
+			cells[i] = strings.ReplaceAll(formatValue(v), "\n", " ")
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+func writeJSON(w io.Writer, columns []string, records [][]interface{}) error {
+	objects := make([]map[string]interface{}, 0, len(records))
+	for _, record := range records {
+		object := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			object[column] = record[i]
+		}
+		objects = append(objects, object)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(objects)
+}
+
+func writeCSV(w io.Writer, columns []string, records [][]interface{}) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	for _, record := range records {
+		cells := make([]string, len(record))
+		for i, v := range record {
+			if v != nil {
+				cells[i] = fmt.Sprint(v)
+			}
+		}
+		if err := cw.Write(cells); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,76 @@
+package gather
+
+import (
+	"fmt"
+	"strings"
+
+	"kube-query/pkg/store"
+)
+
+// alertWorkloadLabels are the labels kube-state-metrics puts on alerts about
+// an object, each named after the object's resource type.
+var alertWorkloadLabels = []string{"deployment", "statefulset", "daemonset", "pod", "persistentvolumeclaim"}
+
+// alertRule gathers a preset for alerts whose label has a value, such as
+// alertname=KubeDNSDown.
+type alertRule struct {
+	label, value, preset string
+}
+
+// AlertRules map firing alerts to the presets gathered for them.
+type AlertRules []alertRule
+
+// ParseAlertRules parses rules, each label=value:preset, such as
+// alertname=KubeDNSDown:dns.
+func ParseAlertRules(entries []string) (AlertRules, error) {
+	var rules AlertRules
+	for _, entry := range entries {
+		i := strings.LastIndex(entry, ":")
+		j := strings.Index(entry, "=")
+		if i < 0 || j <= 0 || j > i {
+			return nil, fmt.Errorf("Invalid alert rule %q: want label=value:preset", entry)
+		}
+		rule := alertRule{label: entry[:j], value: entry[j+1 : i], preset: entry[i+1:]}
+		if _, err := findPreset(rule.preset); err != nil {
+			return nil, fmt.Errorf("Invalid alert rule %q: %v", entry, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Selection returns the preset and --resources entries gathering what
+// alerts are about. Alerts labelled with a namespace and a deployment,
+// statefulset, daemonset, pod or persistentvolumeclaim gather that object;
+// the preset is that of the first rule matching any alert, if one does.
+func (r AlertRules) Selection(alerts []store.Alert) (string, []string) {
+	var preset string
+	for _, rule := range r {
+		for _, alert := range alerts {
+			if v, ok := alert.Labels[rule.label]; ok && v == rule.value && preset == "" {
+				preset = rule.preset
+			}
+		}
+	}
+
+	var resources []string
+	seen := map[string]bool{}
+	for _, alert := range alerts {
+		namespace := alert.Labels["namespace"]
+		if namespace == "" {
+			continue
+		}
+		for _, kind := range alertWorkloadLabels {
+			name := alert.Labels[kind]
+			if name == "" {
+				continue
+			}
+			res := namespace + ":" + kind + ":" + name
+			if !seen[res] {
+				seen[res] = true
+				resources = append(resources, res)
+			}
+		}
+	}
+	return preset, resources
+}
@@ -0,0 +1,42 @@
+package gather
+
+import (
+	"reflect"
+	"testing"
+
+	"kube-query/pkg/store"
+)
+
+func TestAlertRulesSelection(t *testing.T) {
+	rules, err := ParseAlertRules([]string{"alertname=KubeDNSDown:dns", "namespace=kube-system:kube-system"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alerts := []store.Alert{
+		{Labels: map[string]string{"alertname": "KubePodCrashLooping", "namespace": "prod", "pod": "web-5d9c7-abcde"}},
+		{Labels: map[string]string{"alertname": "KubeDeploymentReplicasMismatch", "namespace": "prod", "deployment": "web"}},
+		{Labels: map[string]string{"alertname": "KubeDeploymentReplicasMismatch", "namespace": "prod", "deployment": "web", "pod": "web-5d9c7-abcde"}},
+		{Labels: map[string]string{"alertname": "KubeDNSDown"}},
+	}
+	preset, resources := rules.Selection(alerts)
+	if preset != "dns" {
+		t.Errorf("got preset %q, want dns", preset)
+	}
+	want := []string{"prod:pod:web-5d9c7-abcde", "prod:deployment:web"}
+	if !reflect.DeepEqual(resources, want) {
+		t.Errorf("got resources %v, want %v", resources, want)
+	}
+
+	if preset, resources := rules.Selection(alerts[:1]); preset != "" || len(resources) != 1 {
+		t.Errorf("got preset %q and resources %v for a pod alert, want the pod alone", preset, resources)
+	}
+}
+
+func TestParseAlertRulesInvalid(t *testing.T) {
+	for _, entry := range []string{"alertname:dns", "alertname=KubeDNSDown", "=KubeDNSDown:dns", "alertname=KubeDNSDown:nope"} {
+		if _, err := ParseAlertRules([]string{entry}); err == nil {
+			t.Errorf("ParseAlertRules(%q) succeeded, want an error", entry)
+		}
+	}
+}
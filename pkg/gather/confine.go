@@ -0,0 +1,133 @@
+package gather
+
+import (
+	"fmt"
+	"strings"
+
+	"kube-query/pkg/store"
+)
+
+// confineResources limits --resources entries to one namespace, for gathers
+// made on behalf of someone allowed only that namespace. Entries the caller
+// chose are refused if they reach beyond it: all namespaces, another
+// namespace, or a cluster-scoped kind. Entries of a preset or app, which
+// list alternatives across namespaces, are narrowed to the namespace where
+// they span all of them and dropped otherwise.
+func confineResources(resources []string, namespace string, chosen bool) ([]string, error) {
+	var confined []string
+	for _, res := range resources {
+		parts := strings.SplitN(res, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("Invalid resource %q, expected namespace:resourceType:resourceName", res)
+		}
+		clusterScoped := false
+		if k, ok := store.FindObjectKind(parts[1]); ok && !k.Namespaced {
+			clusterScoped = true
+		}
+		switch {
+		case chosen && (parts[0] != namespace || clusterScoped):
+			return nil, fmt.Errorf("Resource %q is outside namespace %s", res, namespace)
+		case clusterScoped || (parts[0] != namespace && parts[0] != "*"):
+			continue
+		}
+		confined = append(confined, namespace+":"+parts[1]+":"+parts[2])
+	}
+	return confined, nil
+}
+
+// confine limits resources to the gather's namespace, if it has one.
+func (g *Gatherer) confine(resources []string, chosen bool) ([]string, error) {
+	if g.options.Namespace == "" {
+		return resources, nil
+	}
+	return confineResources(resources, g.options.Namespace, chosen)
+}
+
+// checkConfined refuses the options that gather across the cluster in a
+// gather confined to a namespace.
+func checkConfined(options Options) error {
+	if options.Namespace == "" {
+		return nil
+	}
+	for _, ns := range options.NamespaceDump {
+		if ns != options.Namespace {
+			return fmt.Errorf("Namespace dump of %s is outside namespace %s", ns, options.Namespace)
+		}
+	}
+	for _, o := range []struct {
+		flag string
+		set  bool
+	}{
+		{"--operators", options.Operators}, {"--crds", len(options.CRDs) > 0}, {"--inventory", options.Inventory},
+		{"--plugins", options.Plugins}, {"--metrics", options.Metrics}, {"--node-stats", options.NodeStats}, {"--node-logs", options.NodeLogs},
+	} {
+		if o.set {
+			return fmt.Errorf("%s gathers across the cluster, outside namespace %s", o.flag, options.Namespace)
+		}
+	}
+	return nil
+}
+
+// Selection returns the --resources entries the gatherer gathers, with
+// those of its preset and app, and an ns:*:* entry for each namespace it
+// dumps whole.
+func (g *Gatherer) Selection() []string {
+	selection := append([]string(nil), g.resources...)
+	for _, ns := range g.options.NamespaceDump {
+		selection = append(selection, ns+":*:*")
+	}
+	return selection
+}
+
+// Within refuses a gatherer reaching beyond another's selection, such as a
+// gather a daemon runs on request, which is held to the daemon's own. Each
+// entry of its selection must be one of the other's, or fall under one
+// whose namespace, kind or name is "*".
+func (g *Gatherer) Within(other *Gatherer) error {
+	allowed := other.Selection()
+	for _, res := range g.Selection() {
+		if !selectionCovers(allowed, res) {
+			return fmt.Errorf("Resource %q is outside the selection %s", res, strings.Join(allowed, ","))
+		}
+	}
+	return nil
+}
+
+// Covering splits resource entries into those within the gatherer's
+// selection, as Within requires of them, and those outside it.
+func (g *Gatherer) Covering(resources []string) (within, outside []string) {
+	allowed := g.Selection()
+	for _, res := range resources {
+		if selectionCovers(allowed, res) {
+			within = append(within, res)
+		} else {
+			outside = append(outside, res)
+		}
+	}
+	return within, outside
+}
+
+// selectionCovers reports whether a resource entry is one of the allowed
+// entries or falls under one with "*" in its place.
+func selectionCovers(allowed []string, res string) bool {
+	parts := strings.SplitN(res, ":", 3)
+	for _, a := range allowed {
+		if a == res {
+			return true
+		}
+		allowedParts := strings.SplitN(a, ":", 3)
+		if len(parts) != 3 || len(allowedParts) != 3 {
+			continue
+		}
+		if matchesPart(allowedParts[0], parts[0]) && matchesPart(allowedParts[1], parts[1]) && matchesPart(allowedParts[2], parts[2]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPart reports whether one part of an allowed entry, "*" for any,
+// matches that part of a resource entry.
+func matchesPart(allowed, part string) bool {
+	return allowed == "*" || allowed == part
+}
@@ -0,0 +1,96 @@
+package gather
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/client-go/rest"
+)
+
+func TestNewConfinedToNamespace(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		options Options
+		want    []string
+		wantErr bool
+	}{
+		{name: "own namespace", options: Options{Resources: []string{"shop:deployment:web", "shop:secret:*"}}, want: []string{"shop:deployment:web", "shop:secret:*"}},
+		{name: "another namespace's secrets", options: Options{Resources: []string{"shop:deployment:web", "kube-system:secret:*"}}, wantErr: true},
+		{name: "all namespaces", options: Options{Resources: []string{"*:secret:*"}}, wantErr: true},
+		{name: "cluster-scoped kind", options: Options{Resources: []string{"shop:node:*"}}, wantErr: true},
+		{name: "app narrowed to the namespace", options: Options{App: "app=shop"}, want: confinedApp("shop", "app=shop")},
+		{name: "preset of other namespaces", options: Options{Preset: "dns"}, wantErr: true},
+		{name: "cluster-wide option", options: Options{Resources: []string{"shop:deployment:web"}, NodeLogs: true}, wantErr: true},
+		{name: "another namespace's dump", options: Options{NamespaceDump: []string{"payments"}}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.options.Namespace = "shop"
+			g, err := New(&rest.Config{}, tt.options)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got resources %v, want an error", g.resources)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(g.resources, tt.want) {
+				t.Errorf("got resources %v, want %v", g.resources, tt.want)
+			}
+		})
+	}
+}
+
+func confinedApp(namespace, selector string) []string {
+	var resources []string
+	for _, kind := range AppKinds {
+		resources = append(resources, namespace+":"+kind+":"+selector)
+	}
+	return resources
+}
+
+func TestWithin(t *testing.T) {
+	daemon, err := New(&rest.Config{}, Options{Resources: []string{"prod:deployment:*", "prod:configmap:web-config"}, NamespaceDump: []string{"staging"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		options Options
+		wantErr bool
+	}{
+		{name: "one of the selection's deployments", options: Options{Resources: []string{"prod:deployment:web"}}},
+		{name: "named entry", options: Options{Resources: []string{"prod:configmap:web-config"}}},
+		{name: "dumped namespace", options: Options{Resources: []string{"staging:secret:db-credentials"}}},
+		{name: "another configmap", options: Options{Resources: []string{"prod:configmap:payments-config"}}, wantErr: true},
+		{name: "another namespace's secrets", options: Options{Resources: []string{"kube-system:secret:*"}}, wantErr: true},
+		{name: "all namespaces", options: Options{Resources: []string{"*:deployment:*"}}, wantErr: true},
+		{name: "preset", options: Options{Preset: "dns"}, wantErr: true},
+		{name: "app", options: Options{App: "app=web"}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := New(&rest.Config{}, tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := g.Within(daemon); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCovering(t *testing.T) {
+	daemon, err := New(&rest.Config{}, Options{Resources: []string{"prod:deployment:*", "prod:pod:*"}, NamespaceDump: []string{"staging"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	within, outside := daemon.Covering([]string{"prod:deployment:web", "kube-system:pod:kube-proxy-abcde", "staging:persistentvolumeclaim:data", "prod:persistentvolumeclaim:data"})
+	if want := []string{"prod:deployment:web", "staging:persistentvolumeclaim:data"}; !reflect.DeepEqual(within, want) {
+		t.Errorf("got %v within, want %v", within, want)
+	}
+	if want := []string{"kube-system:pod:kube-proxy-abcde", "prod:persistentvolumeclaim:data"}; !reflect.DeepEqual(outside, want) {
+		t.Errorf("got %v outside, want %v", outside, want)
+	}
+}
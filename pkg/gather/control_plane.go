@@ -0,0 +1,150 @@
+package gather
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"kube-query/pkg/store"
+)
+
+// apiServiceList mirrors the parts of apiregistration.k8s.io/v1 APIServices
+// we record, avoiding a dependency on the aggregator client.
+type apiServiceList struct {
+	Items []struct {
+		Metadata metav1.ObjectMeta `json:"metadata"`
+		Spec     struct {
+			Service *struct {
+				Namespace string `json:"namespace"`
+				Name      string `json:"name"`
+			} `json:"service"`
+		} `json:"spec"`
+		Status struct {
+			Conditions []metav1.Condition `json:"conditions"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// processControlPlane records API server health endpoints, its version,
+// aggregated APIService availability and the state of kube-system pods, so
+// control-plane trouble shows up alongside the application being gathered.
+// On OpenShift the ClusterOperators' conditions are recorded as well.
+func processControlPlane(ctx context.Context, clientset kubernetes.Interface, dyn dynamic.Interface, apis *apiResources, db *sql.DB, summary *store.Run, hooks Hooks) {
+	hooks.progress("Processing control plane")
+
+	for _, endpoint := range []string{"readyz", "livez"} {
+		body, err := clientset.Discovery().RESTClient().Get().AbsPath("/"+endpoint).Param("verbose", "").Do(ctx).Raw()
+		status, detail := "ok", string(body)
+		if err != nil {
+			status = "failed"
+			if detail == "" {
+				detail = err.Error()
+			}
+		}
+		storeControlPlane(db, summary, "apiserver", endpoint, status, detail)
+	}
+
+	version, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		summary.AddResourceError(store.PhaseFetch, "apiserver", "", "version", fmt.Errorf("Error fetching server version: %w", err))
+	} else {
+		versionBytes, _ := json.Marshal(version)
+		storeControlPlane(db, summary, "apiserver", "version", version.GitVersion, string(versionBytes))
+	}
+
+	processAPIServices(ctx, clientset, db, summary)
+	if kindServed(apis, "clusteroperator") {
+		processClusterOperators(ctx, dyn, db, summary, hooks)
+	}
+
+	pods, err := clientset.CoreV1().Pods("kube-system").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		summary.AddResourceError(store.PhaseList, "pod", "kube-system", "", fmt.Errorf("Error listing kube-system pods: %w", err))
+		return
+	}
+	for _, pod := range pods.Items {
+		ready, restarts := PodReadiness(&pod.Status)
+		detail := fmt.Sprintf("ready %s, %d restarts, node %s", ready, restarts, pod.Spec.NodeName)
+		storeControlPlane(db, summary, "pod", pod.Name, PodStatusReason(&pod.ObjectMeta, &pod.Status), detail)
+	}
+}
+
+func processAPIServices(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run) {
+	body, err := clientset.Discovery().RESTClient().Get().AbsPath("/apis/apiregistration.k8s.io/v1/apiservices").Do(ctx).Raw()
+	if err != nil {
+		summary.AddResourceError(store.PhaseList, "apiservice", "", "", fmt.Errorf("Error listing APIServices: %w", err))
+		return
+	}
+	var list apiServiceList
+	if err := json.Unmarshal(body, &list); err != nil {
+		summary.AddResourceError(store.PhaseDecode, "apiservice", "", "", fmt.Errorf("Error decoding APIServices: %w", err))
+		return
+	}
+
+	for _, svc := range list.Items {
+		status, detail := "Unknown", "Local"
+		if svc.Spec.Service != nil {
+			detail = "Service " + svc.Spec.Service.Namespace + "/" + svc.Spec.Service.Name
+		}
+		for _, c := range svc.Status.Conditions {
+			if c.Type == "Available" {
+				status = string(c.Status)
+				if c.Status != metav1.ConditionTrue {
+					detail = strings.TrimSpace(detail + ": " + c.Reason + " " + c.Message)
+				}
+			}
+		}
+		storeControlPlane(db, summary, "apiservice", svc.Metadata.Name, status, detail)
+	}
+}
+
+// processClusterOperators records whether each OpenShift ClusterOperator is
+// Available and whether it is Degraded, with the message explaining it.
+func processClusterOperators(ctx context.Context, dyn dynamic.Interface, db *sql.DB, summary *store.Run, hooks Hooks) {
+	hooks.progress("Detected OpenShift")
+	k, _ := store.FindObjectKind("clusteroperator")
+	list, err := k.Client(dyn, "").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		summary.AddResourceError(store.PhaseList, "clusteroperator", "", "", fmt.Errorf("Error listing ClusterOperators: %w", err))
+		return
+	}
+
+	for _, item := range list.Items {
+		conditions, _, _ := unstructured.NestedSlice(item.Object, "status", "conditions")
+		status, detail := "Unknown", ""
+		for _, c := range conditions {
+			condition, _ := c.(map[string]interface{})
+			conditionType, _ := condition["type"].(string)
+			conditionStatus, _ := condition["status"].(string)
+			message, _ := condition["message"].(string)
+			switch {
+			case conditionType == "Available":
+				status = conditionStatus
+				if conditionStatus != "True" {
+					detail = strings.TrimSpace(detail + " " + message)
+				}
+			case conditionType == "Degraded" && conditionStatus == "True":
+				detail = strings.TrimSpace("Degraded: " + message + " " + detail)
+			}
+		}
+		storeControlPlane(db, summary, "clusteroperator", item.GetName(), status, detail)
+	}
+}
+
+func storeControlPlane(db *sql.DB, summary *store.Run, component, name, status, detail string) {
+	_, err := store.ExecWrite(db, `
+		INSERT INTO control_plane (run_id, component, name, status, detail) VALUES (?, ?, ?, ?, ?)
+	`, summary.RunID, component, name, status, detail)
+	if err != nil {
+		summary.AddResourceError(store.PhaseStore, component, "", name, fmt.Errorf("Error inserting control plane status into database: %w", err))
+		return
+	}
+	summary.AddGathered("control_plane")
+}
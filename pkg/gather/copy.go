@@ -0,0 +1,127 @@
+package gather
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/pkg/store"
+)
+
+// NonEmptyLines splits a one-per-line flag value, dropping blank lines.
+func NonEmptyLines(arg string) []string {
+	var lines []string
+	for _, line := range strings.Split(arg, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// processPodFiles copies paths out of every container of the running pods
+// matching labelSelector, the way kubectl cp does: by running tar in the
+// container and unpacking its output. Directories are copied recursively.
+// At most limit bytes are read per path; files cut short are stored with
+// truncated set, and those beyond the limit are omitted.
+func processPodFiles(ctx context.Context, executor *podExecutor, db *sql.DB, summary *store.Run, hooks Hooks, namespace, labelSelector string, paths []string, limit int) {
+	pods, err := executor.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		summary.AddResourceError(store.PhaseList, "pod", namespace, labelSelector, fmt.Errorf("Error listing pods: %w", err))
+		return
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		for _, container := range pod.Spec.Containers {
+			for _, path := range paths {
+				hooks.progress("Copying %s from %s/%s", path, pod.Name, container.Name)
+				ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+				result, err := executor.exec(ctx, namespace, pod.Name, container.Name, []string{"tar", "cf", "-", path}, limit)
+				cancel()
+				if err == nil && result.ExitCode != 0 && len(result.Stdout) == 0 {
+					err = fmt.Errorf("tar exited with code %d: %s", result.ExitCode, strings.TrimSpace(string(result.Stderr)))
+				}
+				if err != nil {
+					storePodFile(db, summary, namespace, pod.Name, container.Name, path, nil, nil, false, err)
+					continue
+				}
+
+				storeTarFiles(db, summary, namespace, pod.Name, container.Name, path, result)
+			}
+		}
+	}
+}
+
+// storeTarFiles stores each regular file in tar output. A truncated archive
+// ends with a partial entry, which is kept with truncated set.
+func storeTarFiles(db *sql.DB, summary *store.Run, namespace, pod, container, path string, result *execResult) {
+	tr := tar.NewReader(bytes.NewReader(result.Stdout))
+	found := false
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !result.Truncated {
+				storePodFile(db, summary, namespace, pod, container, path, nil, nil, false, fmt.Errorf("Error reading tar output: %v", err))
+			}
+			break
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		found = true
+
+		content, err := io.ReadAll(tr)
+		truncated := err == io.ErrUnexpectedEOF
+		if err != nil && !truncated {
+			storePodFile(db, summary, namespace, pod, container, "/"+header.Name, header, nil, false, err)
+			continue
+		}
+		storePodFile(db, summary, namespace, pod, container, "/"+header.Name, header, content, truncated, nil)
+		if truncated {
+			break
+		}
+	}
+	if !found && result.Truncated {
+		storePodFile(db, summary, namespace, pod, container, path, nil, nil, true, fmt.Errorf("first file exceeds the copy limit"))
+	}
+}
+
+func storePodFile(db *sql.DB, summary *store.Run, namespace, pod, container, path string, header *tar.Header, content []byte, truncated bool, copyErr error) {
+	var size, mode sql.NullInt64
+	var modifiedAt sql.NullTime
+	if header != nil {
+		size = sql.NullInt64{Int64: header.Size, Valid: true}
+		mode = sql.NullInt64{Int64: header.Mode, Valid: true}
+		modifiedAt = sql.NullTime{Time: header.ModTime.UTC(), Valid: true}
+	}
+	var errText sql.NullString
+	if copyErr != nil {
+		errText = sql.NullString{String: copyErr.Error(), Valid: true}
+	}
+
+	_, err := store.ExecWrite(db, `
+		INSERT INTO pod_files (run_id, namespace, pod, container, path, size, mode, modified_at, content, truncated, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, summary.RunID, namespace, pod, container, path, size, mode, modifiedAt, content, truncated, errText)
+	if err != nil {
+		summary.AddResourceError(store.PhaseStore, "pod", namespace, pod, fmt.Errorf("Error inserting pod file into database: %w", err))
+		return
+	}
+	if copyErr == nil {
+		summary.AddGathered("file")
+	}
+}
@@ -0,0 +1,163 @@
+package gather
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"kube-query/pkg/store"
+)
+
+// CRDSpec mirrors the parts of an apiextensions.k8s.io/v1
+// CustomResourceDefinition spec we need, avoiding a dependency on the
+// apiextensions client.
+type CRDSpec struct {
+	Group string `json:"group"`
+	Names struct {
+		Kind   string `json:"kind"`
+		Plural string `json:"plural"`
+	} `json:"names"`
+	Versions []struct {
+		Name    string          `json:"name"`
+		Served  bool            `json:"served"`
+		Storage bool            `json:"storage"`
+		Schema  json.RawMessage `json:"schema"`
+		// Subresources.Scale is set if the version has a /scale subresource.
+		Subresources struct {
+			Scale json.RawMessage `json:"scale"`
+		} `json:"subresources"`
+	} `json:"versions"`
+}
+
+// processCRDs gathers the CustomResourceDefinitions matching any of patterns,
+// each a CRD name such as certificates.cert-manager.io, an API group, or "*",
+// together with every instance of them in the cluster outside the namespaces
+// deny denies.
+func processCRDs(ctx context.Context, dyn dynamic.Interface, db *sql.DB, summary *store.Run, snap *listSnapshot, deny namespaceDenyList, hooks Hooks, patterns []string) {
+	k, _ := store.FindObjectKind("customresourcedefinition")
+	list, err := k.Client(dyn, "").List(ctx, snap.listOptions(metav1.ListOptions{}))
+	if err != nil {
+		summary.AddResourceError(store.PhaseList, "customresourcedefinition", "", "", fmt.Errorf("Error listing CustomResourceDefinitions: %w", err))
+		return
+	}
+	snap.record(k.Kind, "", "", list.GetResourceVersion())
+
+	matched := false
+	for i := range list.Items {
+		crd := &list.Items[i]
+		group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+		if !matchesAny([]string{crd.GetName(), group, "*"}, patterns) {
+			continue
+		}
+		matched = true
+		hooks.resourceStart(k.Kind, "", crd.GetName())
+		processCRD(ctx, dyn, db, summary, snap, deny, hooks, k, crd.GetName())
+		hooks.resourceDone(k.Kind, "", crd.GetName())
+	}
+	if !matched {
+		hooks.progress("No CustomResourceDefinitions found matching %s", strings.Join(patterns, ","))
+	}
+}
+
+// processCRD stores a CustomResourceDefinition, the schema of each of its
+// versions, and its instances linked to it by definition_id.
+func processCRD(ctx context.Context, dyn dynamic.Interface, db *sql.DB, summary *store.Run, snap *listSnapshot, deny namespaceDenyList, hooks Hooks, k store.ObjectKind, name string) {
+	obj, err := k.Client(dyn, "").Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		summary.AddResourceError(store.PhaseFetch, k.Kind, "", name, fmt.Errorf("Error fetching CustomResourceDefinition: %w", err))
+		return
+	}
+	meta, err := store.UnstructuredMeta(obj)
+	if err != nil {
+		summary.AddResourceError(store.PhaseDecode, k.Kind, "", name, fmt.Errorf("Error decoding CustomResourceDefinition metadata: %w", err))
+		return
+	}
+	spec, status := store.UnstructuredContent(obj)
+	crdID, err := store.StoreObject(db, summary, k.Kind, meta, spec, status)
+	if err != nil {
+		summary.AddResourceError(store.PhaseStore, k.Kind, "", name, fmt.Errorf("Error storing CustomResourceDefinition: %w", err))
+		return
+	}
+
+	var def CRDSpec
+	specBytes, _ := json.Marshal(spec)
+	if err := json.Unmarshal(specBytes, &def); err != nil {
+		summary.AddResourceError(store.PhaseDecode, k.Kind, "", name, fmt.Errorf("Error decoding CustomResourceDefinition %s: %w", name, err))
+		return
+	}
+
+	// Instances are listed at the storage version, or the first served
+	// version if that is no longer served.
+	var version string
+	for _, v := range def.Versions {
+		if v.Served && (version == "" || v.Storage) {
+			version = v.Name
+		}
+		schema := sql.NullString{String: string(v.Schema), Valid: len(v.Schema) > 0}
+		_, err := store.ExecWrite(db, `
+			INSERT INTO crd_schemas (run_id, crd_id, version, served, storage, schema) VALUES (?, ?, ?, ?, ?, ?)
+		`, summary.RunID, crdID, v.Name, v.Served, v.Storage, schema)
+		if err != nil {
+			summary.AddResourceError(store.PhaseStore, "customresourcedefinition", "", name, fmt.Errorf("Error inserting CRD schema into database: %w", err))
+		}
+	}
+	if version == "" {
+		hooks.progress("CustomResourceDefinition %s serves no versions", name)
+		return
+	}
+
+	processCRDInstances(ctx, dyn, db, summary, snap, deny, def, version, crdID)
+}
+
+func processCRDInstances(ctx context.Context, dyn dynamic.Interface, db *sql.DB, summary *store.Run, snap *listSnapshot, deny namespaceDenyList, def CRDSpec, version string, crdID int64) {
+	gvr := schema.GroupVersionResource{Group: def.Group, Version: version, Resource: def.Names.Plural}
+	// Instances keep the name a namespace dump would give them.
+	kind := strings.ToLower(def.Names.Kind) + "." + def.Group
+	if known, ok := findObjectKindByResource(gvr); ok {
+		kind = known.Kind
+	}
+
+	options := metav1.ListOptions{Limit: inventoryPageSize}
+	for {
+		page, err := dyn.Resource(gvr).List(ctx, snap.listOptions(options))
+		if err != nil {
+			summary.AddResourceError(store.PhaseList, kind, "", "", fmt.Errorf("Error listing %s: %w", kind, err))
+			return
+		}
+		if options.Continue == "" {
+			snap.record(kind, "", "", page.GetResourceVersion())
+		}
+		for i := range page.Items {
+			obj := &page.Items[i]
+			if deny.denies(obj.GetNamespace(), kind) {
+				continue
+			}
+			meta, err := store.UnstructuredMeta(obj)
+			if err != nil {
+				summary.AddResourceError(store.PhaseDecode, kind, obj.GetNamespace(), obj.GetName(), fmt.Errorf("Error decoding %s metadata: %w", kind, err))
+				continue
+			}
+			spec, status := store.UnstructuredContent(obj)
+			objectID, err := store.StoreObject(db, summary, kind, meta, spec, status)
+			if err != nil {
+				summary.AddResourceError(store.PhaseStore, kind, obj.GetNamespace(), obj.GetName(), fmt.Errorf("Error storing %s: %w", kind, err))
+				continue
+			}
+			if _, err := store.ExecWrite(db, `UPDATE objects SET definition_id = ? WHERE id = ?`, crdID, objectID); err != nil {
+				summary.AddResourceError(store.PhaseStore, kind, obj.GetNamespace(), obj.GetName(), fmt.Errorf("Error linking %s to its definition: %w", kind, err))
+			}
+		}
+		if page.GetContinue() == "" {
+			snap.cover(gatherScope{kind: kind, deny: deny})
+			return
+		}
+		options.Continue = page.GetContinue()
+	}
+}
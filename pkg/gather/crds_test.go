@@ -0,0 +1,52 @@
+package gather
+
+import (
+	"context"
+	"testing"
+
+	"kube-query/internal/kubetest"
+)
+
+func TestProcessCRDs(t *testing.T) {
+	tests := []struct {
+		name      string
+		patterns  []string
+		crds      int
+		schemas   int
+		instances int
+	}{
+		{name: "by group", patterns: []string{"example.com"}, crds: 1, schemas: 1, instances: 2},
+		{name: "by name", patterns: []string{"widgets.example.com"}, crds: 1, schemas: 1, instances: 2},
+		{name: "all", patterns: []string{"*"}, crds: 1, schemas: 1, instances: 2},
+		{name: "no match", patterns: []string{"cert-manager.io"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dyn := kubetest.NewDynamicClient(t, "testdata/widgets.yaml")
+			s := kubetest.NewStore(t)
+			summary := kubetest.NewRun(t, s)
+
+			var started []string
+			hooks := Hooks{OnResourceStart: func(kind, namespace, name string) {
+				started = append(started, name)
+			}}
+			processCRDs(context.Background(), dyn, s.DB, summary, nil, nil, hooks, tt.patterns)
+			if len(started) != tt.crds {
+				t.Errorf("OnResourceStart called for %v, want %d CRDs", started, tt.crds)
+			}
+			if got := kubetest.Count(t, s.DB, "objects", "run_id = ? AND kind = ?", summary.RunID, "customresourcedefinition"); got != tt.crds {
+				t.Errorf("got %d CRDs, want %d", got, tt.crds)
+			}
+			if got := kubetest.Count(t, s.DB, "crd_schemas", "run_id = ?", summary.RunID); got != tt.schemas {
+				t.Errorf("got %d schemas, want %d", got, tt.schemas)
+			}
+			if got := kubetest.Count(t, s.DB, "objects", "run_id = ? AND kind = ?", summary.RunID, "widget.example.com"); got != tt.instances {
+				t.Errorf("got %d widgets, want %d", got, tt.instances)
+			}
+			if summary.Errors != 0 {
+				t.Errorf("unexpected errors: %v", summary.Err())
+			}
+		})
+	}
+}
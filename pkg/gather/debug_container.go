@@ -0,0 +1,176 @@
+package gather
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+
+	"kube-query/pkg/store"
+)
+
+// debugMarker delimits each command's output in the debug container's log.
+const debugMarker = "@@kube-gather"
+
+// processPodDebug runs commands in an ephemeral debug container attached to
+// each running pod matching labelSelector, for images without the tools (or
+// shell) exec needs. The container targets the pod's first container so it
+// shares its process namespace, runs the commands once and exits.
+// Ephemeral containers cannot be removed from a pod, so "cleaning up" means
+// the container terminates on its own and holds no resources afterwards; it
+// stays listed in the pod's status until the pod is replaced.
+func processPodDebug(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run, hooks Hooks, namespace, labelSelector, image string, commands [][]string) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		summary.AddResourceError(store.PhaseList, "pod", namespace, labelSelector, fmt.Errorf("Error listing pods: %w", err))
+		return
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning || len(pod.Spec.Containers) == 0 {
+			continue
+		}
+		target := pod.Spec.Containers[0].Name
+		name := "kube-gather-debug-" + rand.String(5)
+		hooks.progress("Running diagnostics in ephemeral container %s on %s/%s", name, pod.Name, target)
+
+		pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+			EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+				Name:                     name,
+				Image:                    image,
+				Command:                  []string{"sh", "-c", debugScript(commands)},
+				TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+			},
+			TargetContainerName: target,
+		})
+		startedAt := time.Now().UTC()
+		_, err := clientset.CoreV1().Pods(namespace).UpdateEphemeralContainers(ctx, pod.Name, &pod, metav1.UpdateOptions{})
+		if err != nil {
+			summary.AddResourceError(store.PhaseExec, "pod", namespace, pod.Name, fmt.Errorf("Error adding debug container to pod %s: %w", pod.Name, err))
+			continue
+		}
+
+		if err := waitForEphemeralContainer(ctx, clientset, namespace, pod.Name, name, 2*time.Minute); err != nil {
+			summary.AddResourceError(store.PhaseExec, "pod", namespace, pod.Name, fmt.Errorf("Error waiting for debug container on pod %s: %w", pod.Name, err))
+			continue
+		}
+
+		logs, err := clientset.CoreV1().Pods(namespace).GetLogs(pod.Name, &corev1.PodLogOptions{Container: name}).Stream(ctx)
+		if err != nil {
+			summary.AddResourceError(store.PhaseExec, "pod", namespace, pod.Name, fmt.Errorf("Error fetching debug container logs for pod %s: %w", pod.Name, err))
+			continue
+		}
+		output, err := io.ReadAll(io.LimitReader(logs, int64(len(commands))*execOutputLimit))
+		logs.Close()
+		if err != nil {
+			summary.AddResourceError(store.PhaseExec, "pod", namespace, pod.Name, fmt.Errorf("Error reading debug container logs for pod %s: %w", pod.Name, err))
+			continue
+		}
+
+		for i, result := range splitDebugOutput(output, len(commands)) {
+			_, err = store.ExecWrite(db, `
+				INSERT INTO pod_exec (run_id, namespace, pod, container, debug_container, command, started_at, exit_code, stdout, stderr, truncated, error)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, '', ?, ?)
+			`, summary.RunID, namespace, pod.Name, target, name, strings.Join(commands[i], " "), startedAt,
+				result.ExitCode, string(result.Stdout), result.Truncated, result.err)
+			if err != nil {
+				summary.AddResourceError(store.PhaseStore, "pod", namespace, pod.Name, fmt.Errorf("Error inserting exec output into database: %w", err))
+				continue
+			}
+			summary.AddGathered("exec")
+		}
+	}
+}
+
+// debugScript runs each command in turn, bracketing its combined output with
+// markers that record the exit code.
+func debugScript(commands [][]string) string {
+	var b strings.Builder
+	for i, command := range commands {
+		quoted := make([]string, len(command))
+		for j, arg := range command {
+			quoted[j] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		fmt.Fprintf(&b, "echo '%s %d begin'; %s 2>&1; echo \"%s %d exit $?\"; ", debugMarker, i, strings.Join(quoted, " "), debugMarker, i)
+	}
+	return b.String()
+}
+
+type debugResult struct {
+	execResult
+	err sql.NullString
+}
+
+// splitDebugOutput recovers per-command output from the debug container log.
+// Commands whose markers are missing, because the log was cut short or the
+// container was killed, are reported with an error.
+func splitDebugOutput(output []byte, n int) []debugResult {
+	results := make([]debugResult, n)
+	for i := range results {
+		results[i].err = sql.NullString{String: "no output captured", Valid: true}
+	}
+
+	current := -1
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), execOutputLimit)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if fields := strings.Fields(line); len(fields) >= 3 && fields[0] == debugMarker {
+			i, err := strconv.Atoi(fields[1])
+			if err == nil && i >= 0 && i < n {
+				switch fields[2] {
+				case "begin":
+					current = i
+					results[i].err = sql.NullString{String: "command did not finish", Valid: true}
+					continue
+				case "exit":
+					if len(fields) == 4 {
+						results[i].ExitCode, _ = strconv.Atoi(fields[3])
+						results[i].err = sql.NullString{}
+					}
+					current = -1
+					continue
+				}
+			}
+		}
+		if current >= 0 {
+			results[current].Stdout = appendLimited(&results[current].execResult, results[current].Stdout, []byte(line+"\n"), execOutputLimit)
+		}
+	}
+	return results
+}
+
+// waitForEphemeralContainer polls until the named ephemeral container has
+// terminated.
+func waitForEphemeralContainer(ctx context.Context, clientset kubernetes.Interface, namespace, pod, name string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		p, err := clientset.CoreV1().Pods(namespace).Get(ctx, pod, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for _, status := range p.Status.EphemeralContainerStatuses {
+			if status.Name != name {
+				continue
+			}
+			if status.State.Terminated != nil {
+				return nil
+			}
+			if w := status.State.Waiting; w != nil && (w.Reason == "ErrImagePull" || w.Reason == "ImagePullBackOff" || w.Reason == "InvalidImageName") {
+				return fmt.Errorf("debug container is not starting: %s: %s", w.Reason, w.Message)
+			}
+		}
+		time.Sleep(2 * time.Second)
+	}
+	return fmt.Errorf("timed out after %v", timeout)
+}
@@ -0,0 +1,130 @@
+package gather
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"kube-query/pkg/store"
+)
+
+// gatherScope is a part of the cluster a run looked at in full: the objects
+// of a kind in a namespace ("" or "*" for all, less those the deny list
+// kept it out of), optionally only those matching a label selector or with
+// one name. Any object in it that the run didn't gather no longer existed.
+type gatherScope struct {
+	kind, namespace, selector, name string
+	deny                            namespaceDenyList
+}
+
+// namedScope is the scope of a --resources entry naming one object.
+func namedScope(resourceType, namespace, name string) gatherScope {
+	if k, ok := store.FindObjectKind(resourceType); ok && !k.Namespaced {
+		namespace = ""
+	}
+	return gatherScope{kind: resourceType, namespace: namespace, name: name}
+}
+
+func (s gatherScope) covers(r store.Resource, objectLabels map[string]string) bool {
+	if r.Kind != s.kind || (s.name != "" && r.Name != s.name) {
+		return false
+	}
+	if s.namespace != "" && s.namespace != "*" && r.Namespace != s.namespace {
+		return false
+	}
+	if s.deny.denies(r.Namespace, r.Kind) {
+		return false
+	}
+	if s.selector == "" {
+		return true
+	}
+	selector, err := labels.Parse(s.selector)
+	return err == nil && selector.Matches(labels.Set(objectLabels))
+}
+
+// detectDeletions compares a run with the previous one in the database,
+// recording a tombstone for each object the previous run gathered that is
+// within the scopes this run looked at but was not gathered by it. Objects
+// outside them, which this run didn't look for, are left alone.
+func detectDeletions(db *sql.DB, summary *store.Run, scopes []gatherScope) {
+	if len(scopes) == 0 {
+		return
+	}
+	previous, err := previousRun(db, summary.RunID)
+	if err != nil {
+		summary.AddError(err)
+		return
+	}
+	if previous == 0 {
+		return
+	}
+
+	before, err := store.ListResources(db, previous, "")
+	if err != nil {
+		summary.AddError(fmt.Errorf("Error listing resources of run %d: %w", previous, err))
+		return
+	}
+	after, err := store.ListResources(db, summary.RunID, "")
+	if err != nil {
+		summary.AddError(fmt.Errorf("Error listing resources of run %d: %w", summary.RunID, err))
+		return
+	}
+	gathered := map[store.ResourceKey]bool{}
+	for _, r := range after {
+		gathered[store.ResourceKey{Kind: r.Kind, Namespace: r.Namespace, Name: r.Name}] = true
+	}
+
+	for _, r := range before {
+		key := store.ResourceKey{Kind: r.Kind, Namespace: r.Namespace, Name: r.Name}
+		if gathered[key] || !inScope(db, r, scopes) {
+			continue
+		}
+		gathered[key] = true
+		_, err := store.ExecWrite(db, `
+			INSERT INTO tombstones (run_id, kind, namespace, name, last_run_id, detected_at) VALUES (?, ?, ?, ?, ?, ?)
+		`, summary.RunID, r.Kind, r.Namespace, r.Name, previous, summary.StartedAt)
+		if err != nil {
+			summary.AddResourceError(store.PhaseStore, r.Kind, r.Namespace, r.Name, fmt.Errorf("Error inserting tombstone into database: %w", err))
+			continue
+		}
+		summary.Deleted++
+	}
+}
+
+// inScope reports whether a previously gathered resource is in any of the
+// scopes, loading its labels only if a scope has a selector.
+func inScope(db *sql.DB, r store.Resource, scopes []gatherScope) bool {
+	var objectLabels map[string]string
+	loaded := false
+	for _, scope := range scopes {
+		if scope.selector != "" && !loaded && scope.kind == r.Kind {
+			objectLabels, loaded = resourceLabels(db, r), true
+		}
+		if scope.covers(r, objectLabels) {
+			return true
+		}
+	}
+	return false
+}
+
+func resourceLabels(db *sql.DB, r store.Resource) map[string]string {
+	stored, err := store.GetResource(db, r.RunID, r.Kind, r.Namespace, r.Name)
+	if err != nil {
+		return nil
+	}
+	var meta metav1.ObjectMeta
+	json.Unmarshal(stored.Content["metadata"], &meta)
+	return meta.Labels
+}
+
+// previousRun returns the latest run before runID, or 0 if there is none.
+func previousRun(db *sql.DB, runID int64) (int64, error) {
+	var previous sql.NullInt64
+	if err := db.QueryRow(`SELECT MAX(id) FROM runs WHERE id < ?`, runID).Scan(&previous); err != nil {
+		return 0, fmt.Errorf("Error finding the previous run: %v", err)
+	}
+	return previous.Int64, nil
+}
@@ -0,0 +1,49 @@
+package gather
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/internal/kubetest"
+)
+
+func TestDetectDeletions(t *testing.T) {
+	ctx := context.Background()
+	clientset := kubetest.NewClientset(t, "testdata/cluster.yaml")
+	s := kubetest.NewStore(t)
+
+	first := kubetest.NewRun(t, s)
+	processDeployment(ctx, clientset, s.DB, first, nil, "prod", "web", logOptions{})
+	processConfigMap(ctx, clientset, s.DB, first, "prod", "web-config")
+
+	if err := clientset.CoreV1().Pods("prod").Delete(ctx, "web-5d9c7-fghij", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// The second run looks at the deployment alone, so the configmap it
+	// didn't gather isn't taken for deleted.
+	second := kubetest.NewRun(t, s)
+	snap := newListSnapshot(s.DB, second, "")
+	snap.cover(namedScope("deployment", "prod", "web"))
+	processDeployment(ctx, clientset, s.DB, second, snap, "prod", "web", logOptions{})
+	detectDeletions(s.DB, second, snap.covered)
+
+	if second.Deleted != 1 {
+		t.Errorf("detected %d deletions, want 1", second.Deleted)
+	}
+	if got := kubetest.Count(t, s.DB, "tombstones", "run_id = ? AND kind = 'pod' AND namespace = 'prod' AND name = 'web-5d9c7-fghij' AND last_run_id = ?", second.RunID, first.RunID); got != 1 {
+		t.Errorf("deleted pod has %d tombstones, want 1", got)
+	}
+	if got := kubetest.Count(t, s.DB, "tombstones", "run_id = ?", second.RunID); got != 1 {
+		t.Errorf("run has %d tombstones, want 1", got)
+	}
+
+	// The first run in a database has nothing to compare with.
+	fresh := kubetest.NewStore(t)
+	run := kubetest.NewRun(t, fresh)
+	detectDeletions(fresh.DB, run, []gatherScope{{kind: "pod"}})
+	if run.Deleted != 0 || run.Errors != 0 {
+		t.Errorf("first run detected %d deletions, %d errors", run.Deleted, run.Errors)
+	}
+}
@@ -0,0 +1,52 @@
+package gather
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultDenyNamespaces is the deny list used when Options.DenyNamespaces is
+// nil. kube-node-lease holds a Lease per node, renewed every few seconds,
+// which says nothing about an application and floods every wildcard gather.
+var DefaultDenyNamespaces = []string{"kube-node-lease"}
+
+// denyRule keeps wildcard gathers out of a namespace, or only out of one kind
+// of object in it when kind is set.
+type denyRule struct {
+	namespace, kind string
+}
+
+// namespaceDenyList lists what gathers across all namespaces leave alone.
+// Entries naming the namespace explicitly, such as kube-system:secret:*, or
+// a --namespace-dump of it, are not affected.
+type namespaceDenyList []denyRule
+
+// parseDenyList parses deny-list entries, each a namespace or
+// namespace:kind, such as kube-system:secret.
+func parseDenyList(entries []string) (namespaceDenyList, error) {
+	var deny namespaceDenyList
+	for _, entry := range entries {
+		parts := strings.Split(entry, ":")
+		if len(parts) > 2 || parts[0] == "" || parts[0] == "*" {
+			return nil, fmt.Errorf("Invalid deny-list entry %q: want namespace or namespace:kind", entry)
+		}
+		rule := denyRule{namespace: parts[0]}
+		if len(parts) == 2 {
+			rule.kind = strings.ToLower(parts[1])
+		}
+		deny = append(deny, rule)
+	}
+	return deny, nil
+}
+
+// denies reports whether an object of kind in namespace is to be left out of
+// gathers across all namespaces. Kinds are compared case-insensitively, so
+// discovery's kinds (Secret) match resource types (secret).
+func (d namespaceDenyList) denies(namespace, kind string) bool {
+	for _, rule := range d {
+		if rule.namespace == namespace && (rule.kind == "" || rule.kind == strings.ToLower(kind)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package gather
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/pkg/store"
+)
+
+// defaultExecCommands are read-only diagnostics that are safe to run in any
+// container that has them. Commands are split on whitespace and run without
+// a shell.
+var defaultExecCommands = []string{
+	"env",
+	"cat /etc/resolv.conf",
+	"cat /proc/net/sockstat",
+	"nslookup kubernetes.default",
+}
+
+// execOutputLimit caps the output kept per stream of each exec.
+const execOutputLimit = 1 << 20
+
+// ParseExecCommands splits a newline-separated command list, falling back to
+// defaultExecCommands when it is empty.
+func ParseExecCommands(arg string) [][]string {
+	lines := defaultExecCommands
+	if strings.TrimSpace(arg) != "" {
+		lines = strings.Split(arg, "\n")
+	}
+	var commands [][]string
+	for _, line := range lines {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			commands = append(commands, fields)
+		}
+	}
+	return commands
+}
+
+// processPodExec runs each command in every container of the running pods
+// matching labelSelector. A command that fails to start, typically because
+// the image lacks it, is stored with its error rather than counted as a
+// gather error.
+func processPodExec(ctx context.Context, executor *podExecutor, db *sql.DB, summary *store.Run, hooks Hooks, namespace, labelSelector string, commands [][]string) {
+	pods, err := executor.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		summary.AddResourceError(store.PhaseList, "pod", namespace, labelSelector, fmt.Errorf("Error listing pods: %w", err))
+		return
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		for _, container := range pod.Spec.Containers {
+			for _, command := range commands {
+				hooks.progress("Running %q in %s/%s", strings.Join(command, " "), pod.Name, container.Name)
+				startedAt := time.Now().UTC()
+				ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+				result, err := executor.exec(ctx, namespace, pod.Name, container.Name, command, execOutputLimit)
+				cancel()
+
+				var execError sql.NullString
+				if err != nil {
+					execError = sql.NullString{String: err.Error(), Valid: true}
+				}
+				if result == nil {
+					result = &execResult{}
+				}
+
+				_, err = store.ExecWrite(db, `
+					INSERT INTO pod_exec (run_id, namespace, pod, container, command, started_at, exit_code, stdout, stderr, truncated, error)
+					VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+				`, summary.RunID, namespace, pod.Name, container.Name, strings.Join(command, " "), startedAt,
+					result.ExitCode, string(result.Stdout), string(result.Stderr), result.Truncated, execError)
+				if err != nil {
+					summary.AddResourceError(store.PhaseStore, "pod", namespace, pod.Name, fmt.Errorf("Error inserting exec output into database: %w", err))
+					continue
+				}
+				summary.AddGathered("exec")
+			}
+		}
+	}
+}
@@ -0,0 +1,513 @@
+// Package gather collects resources, logs and diagnostics from a cluster into
+// a store.
+package gather
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+
+	"kube-query/pkg/metrics"
+	"kube-query/pkg/store"
+)
+
+// Options says what a gather collects, mirroring the command-line
+// flags.
+type Options struct {
+	// Resources are namespace:resourceType:resourceName triples, where the
+	// namespace and name may be patterns.
+	Resources []string
+	// Preset and App add a built-in set of resources, and the resources of
+	// the application matching a label selector.
+	Preset string
+	App    string
+	// Namespace, if set, confines the gather to one namespace, for gathers
+	// made on behalf of someone allowed only that namespace: Resources
+	// reaching beyond it are refused, Preset and App entries are narrowed
+	// to it, the options gathering across the cluster are refused, and the
+	// control plane and PriorityClasses are left out.
+	Namespace string
+	// NamespaceDump gathers every namespaced resource of these namespaces,
+	// limited to or excluding kinds or resources by DumpInclude and
+	// DumpExclude.
+	NamespaceDump []string
+	DumpInclude   []string
+	DumpExclude   []string
+	// Operators adds the resources of the operators detected in the cluster.
+	Operators bool
+	// CRDs are CustomResourceDefinition names or API groups gathered with
+	// every instance, or * for all.
+	CRDs []string
+	// Inventory records a catalog of every object in the cluster.
+	Inventory bool
+	// Plugins runs the collector plugins found on PATH, each for at most
+	// PluginTimeout.
+	Plugins       bool
+	PluginTimeout time.Duration
+	// LogTail keeps only this many of the most recent log lines per
+	// container; zero keeps all.
+	LogTail int64
+	// LogSampleEvery, if above one, keeps only every so many log lines of
+	// each container, and LogRate, if positive, at most so many lines of
+	// each second, for containers logging too much to keep whole.
+	LogSampleEvery int
+	LogRate        int
+	// LogRecordStart, if set, are regular expressions matching the first
+	// line of each log record. Lines matching none, such as those of a
+	// stack trace, are stored as part of the record before them.
+	LogRecordStart []string
+	// LogInclude and LogExclude, if set, are regular expressions keeping
+	// only the log lines matching LogInclude and dropping those matching
+	// LogExclude before they are stored.
+	LogInclude, LogExclude string
+	// LogMinLevel, if set, keeps only log lines of this severity or worse,
+	// one of store.LogLevels, with the lines (such as a stack trace's)
+	// following them without a level of their own.
+	LogMinLevel string
+	// Since and Until, if set, keep only the log lines logged and the
+	// events seen between them. Each is an RFC 3339 time, or a duration
+	// such as 2h counting back from the start of each gather.
+	Since, Until string
+	// Metrics collects pod and node usage from metrics-server, and Scrape
+	// snapshots pods' Prometheus endpoints, on ScrapePort for pods without
+	// a prometheus.io/port annotation.
+	Metrics    bool
+	Scrape     bool
+	ScrapePort string
+	// Exec runs ExecCommands, or the default commands if nil, in each
+	// container of gathered pods, in an ephemeral container of DebugImage if
+	// set.
+	Exec         bool
+	ExecCommands [][]string
+	DebugImage   string
+	// CopyPaths are copied out of each container of gathered pods, up to
+	// CopyLimit bytes each.
+	CopyPaths []string
+	CopyLimit int
+	// FinishedJobs gathers, first of all, the finished Jobs that are
+	// deleted with their pods' logs after their ttlSecondsAfterFinished,
+	// and any finished since Since.
+	FinishedJobs bool
+	// NodeStats collects kubelet summary stats and PLEG metrics from every
+	// node.
+	NodeStats bool
+	// NodeLogs stores excerpts of every node's kubelet and containerd
+	// journals, read through the node proxy or, where that fails and
+	// NodeLogsImage is set, a privileged pod of that image run in
+	// NodeLogsNamespace.
+	NodeLogs          bool
+	NodeLogsImage     string
+	NodeLogsNamespace string
+	// StoreSummary stores the end-of-run summary in the runs table.
+	StoreSummary bool
+	// KeepManagedFields stores objects' managedFields and
+	// last-applied-configuration annotations instead of stripping them.
+	KeepManagedFields bool
+	// ResourceVersionMatch is how consistent the run's lists are with each
+	// other. By default each list is served at the latest resource version,
+	// so objects listed later may be newer than those listed earlier.
+	// NotOlderThan pins every list to a version no older than the run's
+	// first list, and may be served from the API server's cache; Exact
+	// pins them to exactly that version, a point-in-time snapshot that
+	// fails once etcd has compacted the version away. The version each list
+	// was served at is recorded in the list_versions table.
+	ResourceVersionMatch string
+	// DenyNamespaces lists namespaces, or kinds within them written
+	// namespace:kind (such as kube-system:secret), that gathers across all
+	// namespaces leave alone: "*" namespaces in Resources, and the CRDs and
+	// Inventory gathers. Naming the namespace explicitly overrides it. Nil means
+	// DefaultDenyNamespaces; an empty list denies nothing.
+	DenyNamespaces []string
+	// MaxObjectBytes, if positive, stores objects whose spec and status (or
+	// data) marshal to more bytes than this with their metadata alone,
+	// recording them in the oversized_objects table.
+	MaxObjectBytes int64
+	// Subresources also stores the /scale subresource of every gathered
+	// object that has one, such as the Deployments and custom resources an
+	// HPA scales, in the scales table. /status needs no fetching of its own:
+	// objects are stored with their status.
+	Subresources bool
+	// Trigger, if set, says what started the gather, such as a schedule or
+	// a crash-looping pod, and is recorded with the run.
+	Trigger string
+	// Hooks are told of the gather's progress.
+	Hooks Hooks
+}
+
+// Gatherer gathers resources from a cluster into a store.
+type Gatherer struct {
+	config  *rest.Config
+	options Options
+	// resources are the resources to gather, with the optional ones that
+	// are skipped quietly if absent.
+	resources []string
+	optional  map[string]bool
+	deny      namespaceDenyList
+}
+
+// New returns a gatherer reaching the cluster with config, or an error
+// if the options ask for nothing or name an unknown preset. Its API requests
+// are timed for the metrics endpoint.
+func New(config *rest.Config, options Options) (*Gatherer, error) {
+	config = rest.CopyConfig(config)
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return metrics.Transport{Next: rt}
+	})
+	if options.ExecCommands == nil {
+		options.ExecCommands = ParseExecCommands("")
+	}
+	if options.DenyNamespaces == nil {
+		options.DenyNamespaces = DefaultDenyNamespaces
+	}
+	g := &Gatherer{config: config, options: options, optional: map[string]bool{}}
+	if err := checkConfined(options); err != nil {
+		return nil, err
+	}
+
+	resources, err := g.confine(options.Resources, true)
+	if err != nil {
+		return nil, err
+	}
+	g.resources = append(g.resources, resources...)
+	// Preset and --app resources that don't exist in the cluster are skipped
+	// quietly, since they list alternatives (such as CNI plugins or kinds an
+	// application may not use) that are often absent.
+	if options.Preset != "" {
+		p, err := findPreset(options.Preset)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading preset: %v", err)
+		}
+		resources, err := g.confine(p.Resources, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, res := range resources {
+			g.resources = append(g.resources, res)
+			g.optional[res] = true
+		}
+		if g.options.LogTail == 0 {
+			g.options.LogTail = p.LogTail
+		}
+	}
+	if options.App != "" {
+		resources, err := g.confine(appResources(options.App), false)
+		if err != nil {
+			return nil, err
+		}
+		for _, res := range resources {
+			g.resources = append(g.resources, res)
+			g.optional[res] = true
+		}
+	}
+	if err := checkResourceVersionMatch(options.ResourceVersionMatch); err != nil {
+		return nil, err
+	}
+	if options.LogSampleEvery < 0 || options.LogRate < 0 {
+		return nil, fmt.Errorf("Invalid log sampling: --log-sample-every and --log-rate can't be negative")
+	}
+	if _, err := newTimeWindow(options.Since, options.Until, time.Now()); err != nil {
+		return nil, err
+	}
+	if _, err := parseRecordStart(options.LogRecordStart); err != nil {
+		return nil, err
+	}
+	if _, err := parseMinLevel(options.LogMinLevel); err != nil {
+		return nil, err
+	}
+	if _, err := newLineFilter(options.LogInclude, options.LogExclude); err != nil {
+		return nil, err
+	}
+	deny, err := parseDenyList(options.DenyNamespaces)
+	if err != nil {
+		return nil, err
+	}
+	g.deny = deny
+	if len(g.resources) == 0 && len(options.NamespaceDump) == 0 && !options.Operators && !options.Inventory && !options.Plugins && len(options.CRDs) == 0 && !options.FinishedJobs {
+		return nil, fmt.Errorf("No resources provided. Use the --resources, --preset, --app, --namespace-dump, --operators, --crds, --finished-jobs or --inventory flag to specify resources.")
+	}
+	return g, nil
+}
+
+// Gather collects everything the options ask for as a new run in s, and
+// returns the run's summary. Failures to gather individual resources are
+// collected in the summary's error report (see store.Run.Err) rather than
+// returned; an error is returned only if the run could not be made. Once
+// ctx is cancelled no more resources are started; what was gathered is
+// kept and the run is completed as interrupted.
+func (g *Gatherer) Gather(ctx context.Context, s *store.Store) (*store.Run, error) {
+	opts := g.options
+	summary := store.NewRun(time.Now())
+	// The window, record start patterns, minimum level and line filter were
+	// checked by New.
+	window, _ := newTimeWindow(opts.Since, opts.Until, summary.StartedAt)
+	records, _ := parseRecordStart(opts.LogRecordStart)
+	level, _ := parseMinLevel(opts.LogMinLevel)
+	lines, _ := newLineFilter(opts.LogInclude, opts.LogExclude)
+	logs := logOptions{tail: opts.LogTail, window: window, lines: lines, minLevel: level, sampling: sampling{every: opts.LogSampleEvery, perSecond: opts.LogRate}, records: records}
+	warnings := newWarningRecorder()
+	hooks := warnings.track(opts.Hooks)
+	summary.OnError, summary.OnLogBytes = hooks.OnError, hooks.OnLogBytes
+	summary.KeepManagedFields = opts.KeepManagedFields
+	summary.MaxObjectBytes = opts.MaxObjectBytes
+	summary.Trigger = opts.Trigger
+	resources := append([]string(nil), g.resources...)
+	optional := map[string]bool{}
+	for res := range g.optional {
+		optional[res] = true
+	}
+
+	// The run's clients record the warnings the API server sends back, and
+	// every request they make.
+	config := rest.CopyConfig(g.config)
+	config.WarningHandler = warnings
+	requests := newRequestRecorder()
+	config.Wrap(requests.wrap)
+
+	// Create Kubernetes client
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating Kubernetes client: %v", err)
+	}
+
+	dyn, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating dynamic client: %v", err)
+	}
+
+	clients := Clients{Kubernetes: clientset, Dynamic: dyn}
+	apis := newAPIResources(clientset)
+
+	if len(opts.NamespaceDump) > 0 {
+		dumped, err := namespaceDumpResources(clientset, summary, opts.NamespaceDump, opts.DumpInclude, opts.DumpExclude)
+		if err != nil {
+			return nil, fmt.Errorf("Error preparing namespace dump: %v", err)
+		}
+		for _, res := range dumped {
+			resources = append(resources, res)
+			optional[res] = true
+		}
+	}
+
+	if opts.Operators {
+		detected, err := operatorResources(ctx, clientset, apis, hooks)
+		if err != nil {
+			return nil, fmt.Errorf("Error detecting operators: %v", err)
+		}
+		for _, res := range detected {
+			resources = append(resources, res)
+			optional[res] = true
+		}
+	}
+
+	if opts.FinishedJobs {
+		jobs, err := finishedJobResources(ctx, clientset, g.deny, window)
+		if err != nil {
+			return nil, fmt.Errorf("Error listing finished jobs: %v", err)
+		}
+		if jobs, err = g.confine(jobs, false); err != nil {
+			return nil, err
+		}
+		// Finished jobs go first, racing their deletion, and are skipped
+		// quietly if they lose.
+		for _, res := range jobs {
+			optional[res] = true
+		}
+		resources = append(jobs, resources...)
+	}
+
+	var executor *podExecutor
+	if (opts.Exec && opts.DebugImage == "") || len(opts.CopyPaths) > 0 {
+		executor, err = newPodExecutor(config, clientset)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating pod executor: %v", err)
+		}
+	}
+
+	db := s.DB
+	err = summary.Begin(db)
+	if err != nil {
+		return nil, fmt.Errorf("Error recording run: %v", err)
+	}
+	hooks.runStart(summary.RunID)
+
+	snap := newListSnapshot(db, summary, opts.ResourceVersionMatch)
+
+	// The control plane is the cluster's, not a namespace's.
+	if opts.Namespace == "" {
+		processControlPlane(ctx, clientset, dyn, apis, db, summary, hooks)
+	}
+
+	if opts.Inventory && ctx.Err() == nil {
+		metadataClient, err := metadata.NewForConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating metadata client: %v", err)
+		}
+		processInventory(ctx, clientset, metadataClient, db, summary, hooks, snap, g.deny)
+	}
+
+	// collectPods runs the pod collectors over a namespace's pods matching
+	// podSelector.
+	collectPods := func(namespace, podSelector string) {
+		if opts.Metrics {
+			processPodMetrics(ctx, clientset, db, summary, namespace, podSelector)
+		}
+		if opts.Scrape {
+			processPodScrapes(ctx, clientset, db, summary, namespace, podSelector, opts.ScrapePort)
+		}
+		if opts.Exec && opts.DebugImage != "" {
+			processPodDebug(ctx, clientset, db, summary, hooks, namespace, podSelector, opts.DebugImage, opts.ExecCommands)
+		} else if opts.Exec {
+			processPodExec(ctx, executor, db, summary, hooks, namespace, podSelector, opts.ExecCommands)
+		}
+		if len(opts.CopyPaths) > 0 {
+			processPodFiles(ctx, executor, db, summary, hooks, namespace, podSelector, opts.CopyPaths, opts.CopyLimit)
+		}
+	}
+
+	// gatherResource gathers a single named resource, then runs the pod
+	// collectors over a workload's pods, or a pods:-l entry's.
+	gatherResource := func(namespace, resourceType, resourceName string) {
+		hooks.resourceStart(resourceType, namespace, resourceName)
+		defer hooks.resourceDone(resourceType, namespace, resourceName)
+
+		var podSelector string
+		switch resourceType {
+		case "pods":
+			for _, ns := range processSelectedPods(ctx, clientset, db, summary, snap, g.deny, namespace, resourceName, logs) {
+				collectPods(ns, resourceName)
+			}
+			return
+		case "deployment":
+			podSelector = processDeployment(ctx, clientset, db, summary, snap, namespace, resourceName, logs)
+		case "pod":
+			processStandalonePod(ctx, clientset, db, summary, namespace, resourceName, logs)
+		case "configmap":
+			processConfigMap(ctx, clientset, db, summary, namespace, resourceName)
+		case "secret":
+			processSecret(ctx, clientset, db, summary, namespace, resourceName)
+		default:
+			k, ok := store.FindObjectKind(resourceType)
+			if !ok {
+				summary.AddResourceError(store.PhaseList, resourceType, namespace, resourceName, fmt.Errorf("Unsupported resource type: %s", resourceType))
+				return
+			}
+			podSelector = processObject(ctx, clientset, dyn, db, summary, snap, k, namespace, resourceName, logs)
+		}
+		if podSelector != "" {
+			collectPods(namespace, podSelector)
+		}
+	}
+
+	// Process each resource
+	for _, res := range resources {
+		if ctx.Err() != nil {
+			break
+		}
+		parts := strings.Split(res, ":")
+		if len(parts) != 3 {
+			summary.AddError(fmt.Errorf("Invalid resource format: %s", res))
+			continue
+		}
+
+		namespace, resourceType, resourceName := parts[0], parts[1], parts[2]
+
+		// pods:-l entries gather pods by label selector, whatever their
+		// controller.
+		if resourceType == "pods" {
+			selector, ok := strings.CutPrefix(resourceName, "-l ")
+			if !ok || strings.TrimSpace(selector) == "" {
+				summary.AddError(fmt.Errorf("Invalid resource format: %s (pods entries are namespace:pods:-l selector)", res))
+				continue
+			}
+			gatherResource(namespace, resourceType, strings.TrimSpace(selector))
+			continue
+		}
+
+		if rg, ok := findResourceGatherer(resourceType); ok {
+			hooks.resourceStart(resourceType, namespace, resourceName)
+			processRegistered(ctx, rg, clients, db, summary, g.deny, namespace, resourceName)
+			hooks.resourceDone(resourceType, namespace, resourceName)
+			continue
+		}
+		if optional[res] && !kindServed(apis, resourceType) {
+			summary.AddSkipped()
+			continue
+		}
+		if !isPattern(namespace, resourceName) {
+			snap.cover(namedScope(resourceType, namespace, resourceName))
+		}
+		if optional[res] && !isPattern(namespace, resourceName) && !resourceExists(ctx, dyn, namespace, resourceType, resourceName) {
+			summary.AddSkipped()
+			continue
+		}
+
+		targets, err := expandResource(ctx, dyn, snap, g.deny, namespace, resourceType, resourceName)
+		if err != nil {
+			summary.AddResourceError(store.PhaseList, resourceType, namespace, resourceName, err)
+			continue
+		}
+		if len(targets) == 0 && !optional[res] {
+			hooks.progress("No %s found matching %s/%s", resourceType, namespace, resourceName)
+		}
+		for _, target := range targets {
+			if ctx.Err() != nil {
+				break
+			}
+			gatherResource(target[0], resourceType, target[1])
+		}
+	}
+
+	// Gathered pods' priorities only make sense beside the PriorityClasses,
+	// which are few, but cluster-scoped.
+	if summary.Gathered["pod"] > 0 && summary.Gathered["priorityclass"] == 0 && opts.Namespace == "" && ctx.Err() == nil {
+		processPriorityClasses(ctx, clientset, db, summary, snap)
+	}
+	// VPAs explain the evictions of the workloads they resize, so those of
+	// gathered workloads are gathered too, where the cluster serves them.
+	if summary.Gathered["verticalpodautoscaler"] == 0 && ctx.Err() == nil {
+		k, _ := store.FindObjectKind("verticalpodautoscaler")
+		if served, _ := apis.serves(k.Resource); served {
+			processVPAs(ctx, dyn, db, summary, snap)
+		}
+	}
+	if len(opts.CRDs) > 0 && ctx.Err() == nil {
+		processCRDs(ctx, dyn, db, summary, snap, g.deny, hooks, opts.CRDs)
+	}
+
+	if opts.Subresources && ctx.Err() == nil {
+		processScales(ctx, dyn, apis, db, summary)
+	}
+
+	if opts.Metrics && ctx.Err() == nil {
+		processNodeMetrics(ctx, clientset, db, summary)
+	}
+	if opts.NodeStats && ctx.Err() == nil {
+		processNodeStats(ctx, clientset, db, summary, hooks)
+	}
+	if opts.NodeLogs && ctx.Err() == nil {
+		processNodeLogs(ctx, clientset, db, summary, hooks, logs, opts.NodeLogsImage, opts.NodeLogsNamespace)
+	}
+	if opts.Plugins && ctx.Err() == nil {
+		processPlugins(ctx, db, summary, hooks, s.Path, config.Host, opts.PluginTimeout)
+	}
+
+	warnings.store(db, summary)
+	requests.store(db, summary)
+	summary.Interrupted = ctx.Err() != nil
+	// An interrupted run didn't look at everything it meant to.
+	if !summary.Interrupted {
+		detectDeletions(db, summary, snap.covered)
+	}
+	summary.Finish(time.Now(), s.Path)
+	if err := summary.Complete(db, opts.StoreSummary); err != nil {
+		summary.AddError(fmt.Errorf("Error storing run summary: %w", err))
+	}
+	return summary, nil
+}
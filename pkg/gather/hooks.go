@@ -0,0 +1,53 @@
+package gather
+
+import "fmt"
+
+// Hooks are called as a gather progresses, for progress output or to feed
+// another system. Any of them may be nil. They are called from the
+// goroutine running Gather, so a slow hook slows the gather.
+type Hooks struct {
+	// OnRunStart is called with the new run's ID once it has been recorded,
+	// before anything is gathered.
+	OnRunStart func(runID int64)
+	// OnResourceStart and OnResourceDone bracket the gathering of each
+	// resource named by the options or found from their patterns, including
+	// the pods, logs and diagnostics gathered with it. Kind is the resource
+	// type as written in --resources entries, and namespace is "" for
+	// cluster-scoped resources.
+	OnResourceStart func(kind, namespace, name string)
+	OnResourceDone  func(kind, namespace, name string)
+	// OnError is called with each error collected in the run's error report.
+	// The run logs them when it is nil.
+	OnError func(err error)
+	// OnLogBytes is called as each container's logs are gathered.
+	OnLogBytes func(namespace, pod string, n int64)
+	// OnProgress is called with a line describing each step of the gather
+	// that isn't a resource of its own, such as running a plugin in a pod
+	// or reading a node's journals, and with what the gather found along
+	// the way, such as an installed operator.
+	OnProgress func(message string)
+}
+
+func (h Hooks) runStart(runID int64) {
+	if h.OnRunStart != nil {
+		h.OnRunStart(runID)
+	}
+}
+
+func (h Hooks) resourceStart(kind, namespace, name string) {
+	if h.OnResourceStart != nil {
+		h.OnResourceStart(kind, namespace, name)
+	}
+}
+
+func (h Hooks) resourceDone(kind, namespace, name string) {
+	if h.OnResourceDone != nil {
+		h.OnResourceDone(kind, namespace, name)
+	}
+}
+
+func (h Hooks) progress(format string, args ...any) {
+	if h.OnProgress != nil {
+		h.OnProgress(fmt.Sprintf(format, args...))
+	}
+}
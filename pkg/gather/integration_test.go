@@ -0,0 +1,266 @@
+//go:build integration
+
+package gather_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/gather"
+	"kube-query/pkg/store"
+)
+
+// The integration tests gather from a real API server and etcd started by
+// envtest, which has no scheduler, controllers or kubelets: deployments
+// create no pods, and pods are never scheduled. Run them with
+//
+//	make test-integration
+//
+// or with KUBEBUILDER_ASSETS pointing at the envtest binaries and
+// -tags integration. Without the binaries they are skipped.
+var (
+	testEnv   *envtest.Environment
+	config    *rest.Config
+	clientset kubernetes.Interface
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		fmt.Println("KUBEBUILDER_ASSETS not set, skipping integration tests")
+		os.Exit(0)
+	}
+	testEnv = &envtest.Environment{}
+	var err error
+	config, err = testEnv.Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting envtest: %v\n", err)
+		os.Exit(1)
+	}
+	clientset = kubernetes.NewForConfigOrDie(config)
+
+	code := m.Run()
+	if err := testEnv.Stop(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error stopping envtest: %v\n", err)
+	}
+	os.Exit(code)
+}
+
+// create creates objects in the test API server, failing the test on error.
+func create(t *testing.T, namespace string, objects ...interface{}) {
+	t.Helper()
+	ctx := context.Background()
+	var err error
+	for _, obj := range objects {
+		switch o := obj.(type) {
+		case *corev1.Namespace:
+			_, err = clientset.CoreV1().Namespaces().Create(ctx, o, metav1.CreateOptions{})
+		case *corev1.ConfigMap:
+			_, err = clientset.CoreV1().ConfigMaps(namespace).Create(ctx, o, metav1.CreateOptions{})
+		case *corev1.Secret:
+			_, err = clientset.CoreV1().Secrets(namespace).Create(ctx, o, metav1.CreateOptions{})
+		case *corev1.Pod:
+			_, err = clientset.CoreV1().Pods(namespace).Create(ctx, o, metav1.CreateOptions{})
+		case *appsv1.Deployment:
+			_, err = clientset.AppsV1().Deployments(namespace).Create(ctx, o, metav1.CreateOptions{})
+		case *rbacv1.Role:
+			_, err = clientset.RbacV1().Roles(namespace).Create(ctx, o, metav1.CreateOptions{})
+		case *rbacv1.RoleBinding:
+			_, err = clientset.RbacV1().RoleBindings(namespace).Create(ctx, o, metav1.CreateOptions{})
+		default:
+			t.Fatalf("create: unsupported type %T", obj)
+		}
+		if err != nil {
+			t.Fatalf("Error creating %T: %v", obj, err)
+		}
+	}
+}
+
+func namespace(name string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+}
+
+func webPod(name string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"app": "web"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx:1.25"}}},
+	}
+}
+
+// runGather gathers with options into a new store, returning the store and
+// the run.
+func runGather(t *testing.T, config *rest.Config, options gather.Options) (*store.Store, *store.Run) {
+	t.Helper()
+	var started int64
+	options.Hooks.OnRunStart = func(runID int64) { started = runID }
+	g, err := gather.New(config, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := kubetest.NewStore(t)
+	summary, err := g.Gather(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if started != summary.RunID {
+		t.Errorf("OnRunStart got run %d, want %d", started, summary.RunID)
+	}
+	return s, summary
+}
+
+func TestGatherDeployment(t *testing.T) {
+	ns := "it-deployment"
+	replicas := int32(1)
+	create(t, "", namespace(ns))
+	create(t, ns,
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "web-config"}, Data: map[string]string{"LOG_LEVEL": "debug"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "web-secret"}, StringData: map[string]string{"PASSWORD": "hunter2"}},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "web"},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}},
+					Spec:       webPod("").Spec,
+				},
+			},
+		},
+		// Without controllers the deployment's pod is created by hand.
+		webPod("web-5d9c7-abcde"),
+	)
+
+	s, summary := runGather(t, config, gather.Options{Resources: []string{
+		ns + ":deployment:web",
+		ns + ":configmap:web-config",
+		ns + ":secret:web-secret",
+		ns + ":pod:*",
+	}})
+
+	for table, want := range map[string]int{"deployments": 1, "configmaps": 1, "secrets": 1, "pods": 1} {
+		if got := kubetest.Count(t, s.DB, table, "run_id = ?", summary.RunID); got != want {
+			t.Errorf("%s has %d rows, want %d", table, got, want)
+		}
+	}
+
+	// The pod was never scheduled, so the log subresource refuses it; that
+	// must fail the pod's logs alone.
+	if summary.Errors == 0 {
+		t.Errorf("expected the unscheduled pod's logs to fail")
+	}
+	for _, err := range summary.Failures {
+		if !strings.Contains(err.Error(), "Error fetching logs for pod web-5d9c7-abcde") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestInventoryPagination(t *testing.T) {
+	ns := "it-inventory"
+	// More ConfigMaps than fit in one inventory page.
+	const configMaps = 600
+	create(t, "", namespace(ns))
+	for i := 0; i < configMaps; i++ {
+		create(t, ns, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("cm-%03d", i)}})
+	}
+
+	s, summary := runGather(t, config, gather.Options{Inventory: true})
+
+	// The namespace also has the kube-root-ca.crt ConfigMap, if the root CA
+	// publisher is running, which envtest doesn't start.
+	got := kubetest.Count(t, s.DB, "inventory", "run_id = ? AND kind = 'ConfigMap' AND namespace = ? AND name LIKE 'cm-%'", summary.RunID, ns)
+	if got != configMaps {
+		t.Errorf("inventory has %d ConfigMaps, want %d", got, configMaps)
+	}
+}
+
+func TestGatherForbidden(t *testing.T) {
+	ns := "it-rbac"
+	create(t, "", namespace(ns))
+	create(t, ns,
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "web-config"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "web-secret"}},
+		&rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{Name: "configmap-reader"},
+			Rules: []rbacv1.PolicyRule{{
+				APIGroups: []string{""},
+				Resources: []string{"configmaps"},
+				Verbs:     []string{"get", "list"},
+			}},
+		},
+		&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "viewer-configmap-reader"},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "configmap-reader"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "viewer"}},
+		},
+	)
+	viewer, err := testEnv.AddUser(envtest.User{Name: "viewer"}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, summary := runGather(t, viewer.Config(), gather.Options{Resources: []string{
+		ns + ":configmap:web-config",
+		ns + ":secret:web-secret",
+	}})
+
+	if got := kubetest.Count(t, s.DB, "configmaps", "run_id = ?", summary.RunID); got != 1 {
+		t.Errorf("gathered %d configmaps, want the readable one", got)
+	}
+	if got := kubetest.Count(t, s.DB, "secrets", "run_id = ?", summary.RunID); got != 0 {
+		t.Errorf("gathered %d secrets without permission", got)
+	}
+	// The control plane checks are forbidden too; what matters is that the
+	// secret's failure is reported as such and the run went on.
+	forbidden := false
+	for _, err := range summary.Failures {
+		if strings.Contains(err.Error(), "Error fetching secret") && apierrors.IsForbidden(err) {
+			forbidden = true
+		}
+	}
+	if !forbidden {
+		t.Errorf("no forbidden error for the secret in %v", summary.Err())
+	}
+}
+
+func TestGatherConfined(t *testing.T) {
+	ns := "it-confined"
+	create(t, "", namespace(ns))
+	create(t, ns, webPod("web"), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "web-config"}})
+	create(t, "kube-system", webPod("kube-proxy-abcde"))
+
+	s, summary := runGather(t, config, gather.Options{Namespace: ns, Resources: []string{
+		ns + ":pod:*",
+		ns + ":configmap:*",
+	}})
+
+	// Nothing of kube-system or the cluster: not its pods' states, the API
+	// server's health or APIServices in control_plane, nor PriorityClasses,
+	// which envtest's API server creates. The store holds this run alone.
+	outside := "namespace != '" + ns + "'"
+	for table, where := range map[string]string{
+		"pods":          outside,
+		"configmaps":    outside,
+		"control_plane": "",
+		"objects":       outside + " OR kind = 'priorityclass'",
+	} {
+		if got := kubetest.Count(t, s.DB, table, where); got != 0 {
+			t.Errorf("%s has %d rows outside namespace %s", table, got, ns)
+		}
+	}
+	if summary.Gathered["pod"] != 1 {
+		t.Errorf("gathered %d pods, want 1", summary.Gathered["pod"])
+	}
+}
@@ -0,0 +1,130 @@
+package gather
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+
+	"kube-query/pkg/metrics"
+	"kube-query/pkg/store"
+)
+
+// inventoryPageSize bounds each list request so large clusters are read in
+// pages rather than in one response.
+const inventoryPageSize = 500
+
+// processInventory records a census of every object in the cluster: its
+// kind, namespace, name, labels, creation time and owner. Only object
+// metadata is fetched, so specs, secrets' data and logs are never read.
+// Events are left out as they churn too fast to be part of a census, and
+// objects deny denies are left out as well.
+func processInventory(ctx context.Context, clientset kubernetes.Interface, meta metadata.Interface, db *sql.DB, summary *store.Run, hooks Hooks, snap *listSnapshot, deny namespaceDenyList) {
+	hooks.progress("Processing inventory")
+
+	lists, err := clientset.Discovery().ServerPreferredResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			summary.AddResourceError(store.PhaseList, "", "", "", fmt.Errorf("Error discovering API resources: %w", err))
+			return
+		}
+		summary.AddResourceError(store.PhaseList, "", "", "", fmt.Errorf("Error discovering some API groups: %w", err))
+	}
+
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, r := range list.APIResources {
+			if strings.Contains(r.Name, "/") || !hasVerbs(r.Verbs, "list") || r.Name == "events" {
+				continue
+			}
+			if err := storeInventory(ctx, meta, db, summary, snap, deny, gv.WithResource(r.Name), r.Kind); err != nil {
+				summary.AddResourceError(store.PhaseList, r.Kind, "", "", fmt.Errorf("Error recording inventory of %s: %w", r.Name, err))
+			}
+		}
+	}
+}
+
+func storeInventory(ctx context.Context, meta metadata.Interface, db *sql.DB, summary *store.Run, snap *listSnapshot, deny namespaceDenyList, gvr schema.GroupVersionResource, kind string) error {
+	start := time.Now()
+	defer func() { metrics.DBWriteDuration.Observe(time.Since(start)) }()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO inventory (run_id, kind, api_version, namespace, name, labels, created_at, owner_kind, owner_name)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("Error preparing inventory insert: %v", err)
+	}
+	defer stmt.Close()
+
+	// The list's version is recorded once the transaction is done with the
+	// database.
+	var resourceVersion string
+	options := metav1.ListOptions{Limit: inventoryPageSize}
+	for {
+		page, err := meta.Resource(gvr).List(ctx, snap.listOptions(options))
+		if err != nil {
+			return err
+		}
+		if options.Continue == "" {
+			resourceVersion = page.ResourceVersion
+		}
+		for _, item := range page.Items {
+			if deny.denies(item.Namespace, kind) {
+				continue
+			}
+			labels, _ := json.Marshal(item.Labels)
+			var ownerKind, ownerName sql.NullString
+			if owner := inventoryOwner(item.OwnerReferences); owner != nil {
+				ownerKind = sql.NullString{String: owner.Kind, Valid: true}
+				ownerName = sql.NullString{String: owner.Name, Valid: true}
+			}
+			_, err := stmt.Exec(summary.RunID, kind, gvr.GroupVersion().String(), item.Namespace, item.Name,
+				string(labels), item.CreationTimestamp.UTC(), ownerKind, ownerName)
+			if err != nil {
+				return fmt.Errorf("Error inserting inventory row: %v", err)
+			}
+			summary.AddGathered("inventory")
+		}
+		if page.Continue == "" {
+			break
+		}
+		options.Continue = page.Continue
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	snap.record(kind, "", "", resourceVersion)
+	return nil
+}
+
+// inventoryOwner returns the controlling owner, or the first owner of an
+// object without a controller.
+func inventoryOwner(refs []metav1.OwnerReference) *metav1.OwnerReference {
+	for i := range refs {
+		if refs[i].Controller != nil && *refs[i].Controller {
+			return &refs[i]
+		}
+	}
+	if len(refs) > 0 {
+		return &refs[0]
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package gather
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"kube-query/internal/kubetest"
+)
+
+func TestStoreInventory(t *testing.T) {
+	tests := []struct {
+		resource, kind string
+		rows           int
+	}{
+		{"pods", "Pod", 4},
+		{"configmaps", "ConfigMap", 1},
+		{"persistentvolumeclaims", "PersistentVolumeClaim", 0},
+	}
+
+	meta := kubetest.NewMetadataClient(t, "testdata/cluster.yaml")
+	s := kubetest.NewStore(t)
+	summary := kubetest.NewRun(t, s)
+	for _, tt := range tests {
+		gvr := schema.GroupVersionResource{Version: "v1", Resource: tt.resource}
+		if err := storeInventory(context.Background(), meta, s.DB, summary, nil, nil, gvr, tt.kind); err != nil {
+			t.Errorf("storeInventory(%s): %v", tt.resource, err)
+			continue
+		}
+		if got := kubetest.Count(t, s.DB, "inventory", "run_id = ? AND kind = ?", summary.RunID, tt.kind); got != tt.rows {
+			t.Errorf("got %d %s in the inventory, want %d", got, tt.resource, tt.rows)
+		}
+	}
+}
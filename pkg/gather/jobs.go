@@ -0,0 +1,68 @@
+package gather
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// finishedJobResources returns the --resources entries of the finished Jobs
+// in namespaces not denied that are at risk of being deleted with their pods
+// and logs: those with ttlSecondsAfterFinished set and, if the window has a
+// start, any finished since then.
+func finishedJobResources(ctx context.Context, clientset kubernetes.Interface, deny namespaceDenyList, window timeWindow) ([]string, error) {
+	jobs, err := clientset.BatchV1().Jobs(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var resources []string
+	for _, job := range jobs.Items {
+		if deny.denies(job.Namespace, "job") {
+			continue
+		}
+		finished := jobFinished(&job)
+		if finished.IsZero() {
+			continue
+		}
+		if job.Spec.TTLSecondsAfterFinished != nil || (!window.since.IsZero() && !finished.Before(window.since)) {
+			resources = append(resources, job.Namespace+":job:"+job.Name)
+		}
+	}
+	return resources, nil
+}
+
+// jobFinished returns when a Job completed or failed, or the zero time if it
+// hasn't.
+func jobFinished(job *batchv1.Job) time.Time {
+	for _, c := range job.Status.Conditions {
+		if (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) && c.Status == corev1.ConditionTrue {
+			if !c.LastTransitionTime.IsZero() {
+				return c.LastTransitionTime.Time
+			}
+			if job.Status.CompletionTime != nil {
+				return job.Status.CompletionTime.Time
+			}
+			// Finished at an unrecorded time.
+			return time.Unix(0, 0)
+		}
+	}
+	return time.Time{}
+}
+
+// jobExpiring returns a Trigger for a Job that has just finished and will be
+// deleted after its ttlSecondsAfterFinished, or false.
+func jobExpiring(oldJob, newJob *batchv1.Job) (Trigger, bool) {
+	ttl := newJob.Spec.TTLSecondsAfterFinished
+	if ttl == nil || !jobFinished(oldJob).IsZero() || jobFinished(newJob).IsZero() {
+		return Trigger{}, false
+	}
+	t := Trigger{Kind: "job", Namespace: newJob.Namespace, Name: newJob.Name,
+		Reason: fmt.Sprintf("job %s finished and is deleted after %ds", newJob.Name, *ttl)}
+	t.ConfigMaps, t.Secrets = configReferences(newJob.Spec.Template.Spec)
+	return t, true
+}
@@ -0,0 +1,69 @@
+package gather
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/internal/kubetest"
+)
+
+func TestFinishedJobResources(t *testing.T) {
+	clientset := kubetest.NewClientset(t, "testdata/jobs.yaml")
+	deny, err := parseDenyList(DefaultDenyNamespaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		since time.Time
+		want  []string
+	}{
+		{time.Time{}, []string{"batch:job:report-28571234"}},
+		{time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), []string{"batch:job:migrate", "batch:job:report-28571234"}},
+	} {
+		got, err := finishedJobResources(context.Background(), clientset, deny, timeWindow{since: tt.since})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("since %v: got %v, want %v", tt.since, got, tt.want)
+		}
+	}
+}
+
+func TestJobExpiring(t *testing.T) {
+	ttl := int32(300)
+	running := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "batch", Name: "report"},
+		Spec:       batchv1.JobSpec{TTLSecondsAfterFinished: &ttl},
+	}
+	finished := running.DeepCopy()
+	finished.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue, LastTransitionTime: metav1.Now()}}
+
+	if _, ok := jobExpiring(running, running); ok {
+		t.Error("a running job fired")
+	}
+	if _, ok := jobExpiring(finished, finished); ok {
+		t.Error("a job already finished fired")
+	}
+	trigger, ok := jobExpiring(running, finished)
+	if !ok {
+		t.Fatal("a job finishing with a TTL didn't fire")
+	}
+	if got := trigger.Resources(); !reflect.DeepEqual(got, []string{"batch:job:report"}) {
+		t.Errorf("trigger gathers %v", got)
+	}
+	finished.Spec.TTLSecondsAfterFinished = nil
+	running.Spec.TTLSecondsAfterFinished = nil
+	if _, ok := jobExpiring(running, finished); ok {
+		t.Error("a job without a TTL fired")
+	}
+}
@@ -0,0 +1,171 @@
+package gather
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"kube-query/pkg/store"
+)
+
+// The kubelet's stats/summary response, reduced to the fields we extract.
+// The full response is stored as well for anything not broken out here.
+type kubeletSummary struct {
+	Node struct {
+		NodeName string          `json:"nodeName"`
+		Fs       *kubeletFsStats `json:"fs"`
+		Runtime  *struct {
+			ImageFs *kubeletFsStats `json:"imageFs"`
+		} `json:"runtime"`
+		Memory *struct {
+			AvailableBytes  *uint64 `json:"availableBytes"`
+			WorkingSetBytes *uint64 `json:"workingSetBytes"`
+		} `json:"memory"`
+	} `json:"node"`
+	Pods []struct {
+		PodRef struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"podRef"`
+		CPU *struct {
+			UsageNanoCores *uint64 `json:"usageNanoCores"`
+		} `json:"cpu"`
+		Memory *struct {
+			WorkingSetBytes *uint64 `json:"workingSetBytes"`
+		} `json:"memory"`
+		EphemeralStorage *kubeletFsStats `json:"ephemeral-storage"`
+		Volumes          []struct {
+			Name string `json:"name"`
+			kubeletFsStats
+		} `json:"volume"`
+	} `json:"pods"`
+}
+
+type kubeletFsStats struct {
+	AvailableBytes *uint64 `json:"availableBytes"`
+	CapacityBytes  *uint64 `json:"capacityBytes"`
+	UsedBytes      *uint64 `json:"usedBytes"`
+	InodesFree     *uint64 `json:"inodesFree"`
+	Inodes         *uint64 `json:"inodes"`
+}
+
+// processNodeStats fetches each node's kubelet summary and PLEG metrics
+// through the API server's node proxy.
+func processNodeStats(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run, hooks Hooks) {
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		summary.AddResourceError(store.PhaseList, "node", "", "", fmt.Errorf("Error listing nodes: %w", err))
+		return
+	}
+
+	for _, node := range nodes.Items {
+		hooks.progress("Processing node stats: %s", node.Name)
+
+		raw, err := clientset.CoreV1().RESTClient().Get().
+			Resource("nodes").Name(node.Name).SubResource("proxy").Suffix("stats/summary").
+			DoRaw(ctx)
+		if err != nil {
+			summary.AddResourceError(store.PhaseFetch, "node", "", node.Name, fmt.Errorf("Error fetching stats summary for node %s: %w", node.Name, err))
+			continue
+		}
+
+		var stats kubeletSummary
+		if err := json.Unmarshal(raw, &stats); err != nil {
+			summary.AddResourceError(store.PhaseDecode, "node", "", node.Name, fmt.Errorf("Error decoding stats summary for node %s: %w", node.Name, err))
+			continue
+		}
+
+		// PLEG health is only exposed as kubelet metrics, not in the summary.
+		var pleg sql.NullString
+		metrics, err := clientset.CoreV1().RESTClient().Get().
+			Resource("nodes").Name(node.Name).SubResource("proxy").Suffix("metrics").
+			DoRaw(ctx)
+		if err != nil {
+			summary.AddResourceError(store.PhaseFetch, "node", "", node.Name, fmt.Errorf("Error fetching kubelet metrics for node %s: %w", node.Name, err))
+		} else {
+			pleg = sql.NullString{String: filterMetrics(metrics, "kubelet_pleg_"), Valid: true}
+		}
+
+		var fs, imageFs kubeletFsStats
+		if stats.Node.Fs != nil {
+			fs = *stats.Node.Fs
+		}
+		if stats.Node.Runtime != nil && stats.Node.Runtime.ImageFs != nil {
+			imageFs = *stats.Node.Runtime.ImageFs
+		}
+		var memoryAvailable *uint64
+		if stats.Node.Memory != nil {
+			memoryAvailable = stats.Node.Memory.AvailableBytes
+		}
+
+		_, err = store.ExecWrite(db, `
+			INSERT INTO node_stats (run_id, node, fs_available_bytes, fs_capacity_bytes, fs_inodes_free,
+				imagefs_available_bytes, imagefs_capacity_bytes, memory_available_bytes, pleg_metrics, summary)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, summary.RunID, node.Name, nullUint(fs.AvailableBytes), nullUint(fs.CapacityBytes), nullUint(fs.InodesFree),
+			nullUint(imageFs.AvailableBytes), nullUint(imageFs.CapacityBytes), nullUint(memoryAvailable), pleg, string(raw))
+		if err != nil {
+			summary.AddResourceError(store.PhaseStore, "node", "", node.Name, fmt.Errorf("Error inserting node stats into database: %w", err))
+			continue
+		}
+		summary.AddGathered("node_stats")
+
+		for _, pod := range stats.Pods {
+			var cpu, memory, ephemeral *uint64
+			if pod.CPU != nil {
+				cpu = pod.CPU.UsageNanoCores
+			}
+			if pod.Memory != nil {
+				memory = pod.Memory.WorkingSetBytes
+			}
+			if pod.EphemeralStorage != nil {
+				ephemeral = pod.EphemeralStorage.UsedBytes
+			}
+			volumesBytes, err := json.Marshal(pod.Volumes)
+			if err != nil {
+				summary.AddResourceError(store.PhaseStore, "pod", pod.PodRef.Namespace, pod.PodRef.Name, fmt.Errorf("Error marshalling volume stats: %w", err))
+				continue
+			}
+
+			_, err = store.ExecWrite(db, `
+				INSERT INTO pod_stats (run_id, node, namespace, pod, cpu_nanocores, memory_working_set_bytes, ephemeral_storage_used_bytes, volumes)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			`, summary.RunID, node.Name, pod.PodRef.Namespace, pod.PodRef.Name, nullUint(cpu), nullUint(memory), nullUint(ephemeral), string(volumesBytes))
+			if err != nil {
+				summary.AddResourceError(store.PhaseStore, "pod", pod.PodRef.Namespace, pod.PodRef.Name, fmt.Errorf("Error inserting pod stats into database: %w", err))
+			}
+		}
+	}
+}
+
+// filterMetrics keeps the samples and HELP/TYPE lines of a Prometheus text
+// exposition whose metric names start with prefix.
+func filterMetrics(exposition []byte, prefix string) string {
+	var b strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(exposition))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		name := strings.TrimPrefix(strings.TrimPrefix(line, "# HELP "), "# TYPE ")
+		if strings.HasPrefix(name, prefix) {
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// nullUint stores optional kubelet counters as NULL when unreported.
+func nullUint(v *uint64) sql.NullInt64 {
+	if v == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(*v), Valid: true}
+}
@@ -0,0 +1,59 @@
+package gather
+
+import (
+	"fmt"
+	"strings"
+
+	"kube-query/pkg/store"
+)
+
+// minLevel is the place in store.LogLevels of the least severe log lines
+// kept; lines of every level, and none, are kept when it is zero.
+type minLevel int
+
+// parseMinLevel parses a --log-min-level, one of store.LogLevels or
+// "warning", or "" to keep every line.
+func parseMinLevel(name string) (minLevel, error) {
+	name = strings.ToLower(name)
+	if name == "" || name == "debug" {
+		return 0, nil
+	}
+	if name == "warning" {
+		name = "warn"
+	}
+	for i, level := range store.LogLevels {
+		if level == name {
+			return minLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid --log-min-level %q: must be one of %s", name, strings.Join(store.LogLevels, ", "))
+}
+
+// keeper returns a function reporting whether each line of a container's
+// log, passed to it in order, is at least as severe as m. Lines without a
+// level, such as those of a stack trace, and lines continuing a record are
+// kept or dropped with the line before them.
+func (m minLevel) keeper(records recordStart) func(line string) bool {
+	keep := false
+	return func(line string) bool {
+		if m == 0 {
+			return true
+		}
+		if !records.continues(line) {
+			if level := store.DetectLogLevel(logMessage(line)); level != "" {
+				keep = levelRank(level) >= int(m)
+			}
+		}
+		return keep
+	}
+}
+
+// levelRank returns a level's place in store.LogLevels.
+func levelRank(level string) int {
+	for i, l := range store.LogLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,42 @@
+package gather
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMinLevel(t *testing.T) {
+	if _, err := parseMinLevel("loud"); err == nil {
+		t.Error("parseMinLevel accepted an unknown level")
+	}
+	warn, err := parseMinLevel("WARNING")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := `2024-05-01T10:00:00Z INFO starting
+2024-05-01T10:00:01Z ERROR request failed
+2024-05-01T10:00:01Z 	at com.example.Pool.get(Pool.java:42)
+2024-05-01T10:00:02Z INFO retried after ERROR
+2024-05-01T10:00:02Z 	last ERROR was a timeout
+2024-05-01T10:00:03Z W0501 10:00:03.000000 1 cache.go:9] stale
+`
+	kept := keptLines(log, warn.keeper(nil))
+	for _, w := range []string{"ERROR request failed", "at com.example.Pool.get", "last ERROR", "stale"} {
+		if !strings.Contains(kept, w) {
+			t.Errorf("kept %q, want it to contain %q", kept, w)
+		}
+	}
+	for _, w := range []string{"starting", "retried"} {
+		if strings.Contains(kept, w) {
+			t.Errorf("kept %q, want %q dropped", kept, w)
+		}
+	}
+
+	// With record start patterns, a record's lines go with its first.
+	records, _ := parseRecordStart([]string{`^[A-Z]+ `, `^[IWEF]\d{4} `})
+	kept = keptLines(log, warn.keeper(records))
+	if strings.Contains(kept, "last ERROR") || !strings.Contains(kept, "at com.example.Pool.get") {
+		t.Errorf("kept %q, want only the error record and warning", kept)
+	}
+}
@@ -0,0 +1,55 @@
+package gather
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// lineFilter drops log lines before they are stored: those not matching
+// include, when set, and those matching exclude, when set, such as health
+// check access logs. Lines are matched without the kubelet's timestamp.
+type lineFilter struct {
+	include, exclude *regexp.Regexp
+}
+
+// newLineFilter compiles --log-include-regex and --log-exclude-regex
+// values, either of which may be "".
+func newLineFilter(include, exclude string) (lineFilter, error) {
+	var f lineFilter
+	var err error
+	if include != "" {
+		if f.include, err = regexp.Compile(include); err != nil {
+			return lineFilter{}, fmt.Errorf("Invalid --log-include-regex: %v", err)
+		}
+	}
+	if exclude != "" {
+		if f.exclude, err = regexp.Compile(exclude); err != nil {
+			return lineFilter{}, fmt.Errorf("Invalid --log-exclude-regex: %v", err)
+		}
+	}
+	return f, nil
+}
+
+func (f lineFilter) enabled() bool {
+	return f.include != nil || f.exclude != nil
+}
+
+// keeps reports whether a log line is kept.
+func (f lineFilter) keeps(line string) bool {
+	message := logMessage(line)
+	return (f.include == nil || f.include.MatchString(message)) && (f.exclude == nil || !f.exclude.MatchString(message))
+}
+
+// keeper returns a function reporting whether each line of a container's
+// log, passed to it in order, is kept. Lines continuing a record are kept or
+// dropped with its first line.
+func (f lineFilter) keeper(records recordStart) func(line string) bool {
+	keep, first := false, true
+	return func(line string) bool {
+		if first || !records.continues(line) {
+			keep = f.keeps(line)
+		}
+		first = false
+		return keep
+	}
+}
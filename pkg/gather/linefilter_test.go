@@ -0,0 +1,39 @@
+package gather
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLineFilter(t *testing.T) {
+	if _, err := newLineFilter("(", ""); err == nil {
+		t.Error("newLineFilter accepted an invalid include pattern")
+	}
+
+	log := `2024-05-01T10:00:00Z GET /healthz 200
+2024-05-01T10:00:01Z GET /api/orders 500
+2024-05-01T10:00:01Z ERROR order lookup failed
+2024-05-01T10:00:01Z 	at orders.lookup(orders.go:42)
+2024-05-01T10:00:02Z GET /readyz 200
+`
+	exclude, err := newLineFilter("", `^GET /(healthz|readyz) `)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(keptLines(log, exclude.keeper(nil)), "\n"); got != 3 {
+		t.Errorf("exclude kept %d lines, want 3", got)
+	}
+
+	include, err := newLineFilter("ERROR", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept := keptLines(log, include.keeper(nil)); kept != "2024-05-01T10:00:01Z ERROR order lookup failed\n" {
+		t.Errorf("include kept %q", kept)
+	}
+	// A record's lines go with its first.
+	records, _ := parseRecordStart([]string{`^\S`})
+	if got := strings.Count(keptLines(log, include.keeper(records)), "\n"); got != 2 {
+		t.Errorf("include kept %d lines of records, want 2", got)
+	}
+}
@@ -0,0 +1,144 @@
+package gather
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"kube-query/pkg/store"
+)
+
+// maxLogBatch is the most log lines, or records, held before they are
+// stored.
+const maxLogBatch = 1000
+
+// processPodLogs stores the log lines of each of a pod's containers, under
+// its deployment if deploymentID isn't 0. Each log is stored as it is read,
+// and its stream closed once it has been.
+func processPodLogs(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run, pod *corev1.Pod, deploymentID int64, logs logOptions) {
+	for _, container := range podContainers(pod) {
+		processContainerLogs(ctx, clientset, db, summary, pod, container, deploymentID, logs)
+	}
+}
+
+func processContainerLogs(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run, pod *corev1.Pod, container string, deploymentID int64, logs logOptions) {
+	logStream, err := clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, logs.podLogOptions(container)).Stream(ctx)
+	if err != nil {
+		summary.AddResourceError(store.PhaseLogs, "pod", pod.Namespace, pod.Name, fmt.Errorf("Error fetching logs for pod %s container %s: %w", pod.Name, container, err))
+		return
+	}
+	defer logStream.Close()
+	logs.read(db, summary, deploymentID, pod.Namespace, pod.Name, container, logStream)
+}
+
+// podContainers returns the names of a pod's init, regular and ephemeral
+// containers.
+func podContainers(pod *corev1.Pod) []string {
+	var containers []string
+	for _, c := range pod.Spec.InitContainers {
+		containers = append(containers, c.Name)
+	}
+	for _, c := range pod.Spec.Containers {
+		containers = append(containers, c.Name)
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		containers = append(containers, c.Name)
+	}
+	return containers
+}
+
+// read reads a container's log a line at a time, storing the lines kept by
+// the line filter, window, minimum level and sampling, in that order, a batch
+// at a time, so that no more of the log than a batch is held in memory. How
+// much sampling kept is recorded once the log has been read, so each
+// container is sampled on its own. Failures to read or store the log are
+// added to the summary; the lines read before one are kept.
+func (o logOptions) read(db *sql.DB, summary *store.Run, deploymentID int64, namespace, pod, container string, stream io.Reader) {
+	filtered := o.lines.keeper(o.records)
+	severe := o.minLevel.keeper(o.records)
+	sampled := o.sampling.sampler(o.records)
+	keeps := func(line string) bool {
+		if !filtered(line) || !o.window.keepsLine(line) || !severe(line) {
+			return false
+		}
+		return !o.sampling.enabled() || sampled.keeps(line)
+	}
+
+	w := &logWriter{db: db, runID: summary.RunID, deploymentID: deploymentID, namespace: namespace, pod: pod, container: container, records: o.records}
+	var readErr, storeErr error
+	reader := bufio.NewReader(stream)
+	for readErr == nil && storeErr == nil {
+		var line string
+		line, readErr = reader.ReadString('\n')
+		if line = strings.TrimSuffix(line, "\n"); line != "" || readErr == nil {
+			if keeps(line) {
+				storeErr = w.write(line)
+			}
+		}
+	}
+	if storeErr == nil {
+		storeErr = w.flush()
+	}
+	if readErr != nil && readErr != io.EOF {
+		summary.AddResourceError(store.PhaseLogs, "pod", namespace, pod, fmt.Errorf("Error reading logs for pod %s container %s: %w", pod, container, readErr))
+	}
+	if storeErr != nil {
+		summary.AddResourceError(store.PhaseStore, "pod", namespace, pod, fmt.Errorf("Error inserting log lines for pod %s container %s: %w", pod, container, storeErr))
+	}
+	summary.AddLogBytes(namespace, pod, w.bytes)
+	if o.sampling.enabled() {
+		store.StoreLogSampling(db, summary, namespace, pod, container, o.sampling.String(), sampled.lines, sampled.kept)
+	}
+}
+
+// logWriter stores the lines of a container's log in log_lines a batch at a
+// time, a row per line or, with record start patterns, per record: each
+// continuation line is appended to its record after a newline without its
+// timestamp, and a record keeps the timestamp of its first line.
+// Continuation lines at the start of the log, whose record began before it,
+// make a record of their own.
+type logWriter struct {
+	db                  *sql.DB
+	runID, deploymentID int64
+	namespace, pod      string
+	container           string
+	records             recordStart
+	batch               []string
+	// stored is how many rows were stored before the batch, and bytes the
+	// size of the lines written.
+	stored int
+	bytes  int64
+}
+
+// write adds a line to the batch, storing the batch first if it is full and
+// the line starts a record, so records are never split across batches.
+func (w *logWriter) write(line string) error {
+	w.bytes += int64(len(line)) + 1
+	if len(w.batch) > 0 && w.records.continues(line) {
+		w.batch[len(w.batch)-1] += "\n" + logMessage(line)
+		return nil
+	}
+	if len(w.batch) == maxLogBatch {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	w.batch = append(w.batch, line)
+	return nil
+}
+
+// flush stores the batch.
+func (w *logWriter) flush() error {
+	if err := store.AppendLogLines(w.db, w.runID, w.deploymentID, w.namespace, w.pod, w.container, w.stored+1, w.batch); err != nil {
+		return err
+	}
+	w.stored += len(w.batch)
+	w.batch = w.batch[:0]
+	return nil
+}
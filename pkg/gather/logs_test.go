@@ -0,0 +1,177 @@
+package gather
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/internal/kubetest"
+)
+
+// keptLines returns the lines of log a keeper keeps, passed to it in order.
+func keptLines(log string, keeps func(line string) bool) string {
+	var kept strings.Builder
+	for _, line := range strings.SplitAfter(log, "\n") {
+		if line != "" && keeps(strings.TrimSuffix(line, "\n")) {
+			kept.WriteString(line)
+		}
+	}
+	return kept.String()
+}
+
+// storedLines reads log with the options into a new store, returning the
+// log_lines rows stored, without their timestamps.
+func storedLines(t *testing.T, o logOptions, log string) []string {
+	t.Helper()
+	s := kubetest.NewStore(t)
+	summary := kubetest.NewRun(t, s)
+	o.read(s.DB, summary, 0, "prod", "web", "web", strings.NewReader(log))
+	if summary.Errors != 0 {
+		t.Fatalf("reading log: %v", summary.Err())
+	}
+	rows, err := s.DB.Query(`SELECT line FROM log_lines WHERE run_id = ? ORDER BY line_number`, summary.RunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestReadLog(t *testing.T) {
+	log := `2024-05-01T10:00:00Z INFO GET /healthz 200
+2024-05-01T10:00:01Z ERROR order lookup failed
+2024-05-01T10:00:01Z 	at orders.lookup(orders.go:42)
+2024-05-01T10:00:02Z WARN retrying order lookup
+2024-05-01T10:00:03Z ERROR order lookup failed again
+2024-05-01T11:00:00Z ERROR order lookup failed after the window
+ERROR order lookup failed without a timestamp or newline`
+	lines, _ := newLineFilter("order", "")
+	level, _ := parseMinLevel("error")
+	records, _ := parseRecordStart([]string{`^\S`})
+	o := logOptions{
+		lines:    lines,
+		window:   timeWindow{until: time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)},
+		minLevel: level,
+		sampling: sampling{every: 2},
+		records:  records,
+	}
+
+	// The filter keeps the lines about orders, the level their errors, the
+	// window those before 10:30 and sampling every other one.
+	got := storedLines(t, o, log)
+	want := []string{"ERROR order lookup failed\n\tat orders.lookup(orders.go:42)", "ERROR order lookup failed without a timestamp or newline"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("stored %q, want %q", got, want)
+	}
+
+	// Batches are stored whole records at a time, numbered in order.
+	var long strings.Builder
+	for i := 0; i < maxLogBatch*2+1; i++ {
+		long.WriteString("2024-05-01T10:00:00Z record\n  continued\n")
+	}
+	got = storedLines(t, logOptions{records: records}, long.String())
+	if len(got) != maxLogBatch*2+1 || got[maxLogBatch] != "record\n  continued" {
+		t.Errorf("stored %d records, want %d of two lines each", len(got), maxLogBatch*2+1)
+	}
+}
+
+func TestReadLogStreams(t *testing.T) {
+	include, err := newLineFilter("order", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	level, err := parseMinLevel("error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := logOptions{lines: include, minLevel: level, window: timeWindow{until: time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)}, sampling: sampling{perSecond: 1000}}
+	s := kubetest.NewStore(t)
+	summary := kubetest.NewRun(t, s)
+
+	// A 64MB log of health checks with an error every 10000 lines, whose
+	// reader notes how much of the heap is live once most of it was read.
+	const lines = 1 << 20
+	var before, during runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	log := &generatedLog{lines: lines, atLine: lines * 3 / 4, at: func() {
+		runtime.GC()
+		runtime.ReadMemStats(&during)
+	}}
+	o.read(s.DB, summary, 0, "prod", "web", "web", log)
+	if summary.Errors != 0 {
+		t.Fatal(summary.Err())
+	}
+
+	if got := kubetest.Count(t, s.DB, "log_lines", "run_id = ?", summary.RunID); got != lines/10000+1 {
+		t.Errorf("stored %d lines, want %d", got, lines/10000+1)
+	}
+	// Buffering the log at any stage would hold the 48MB read so far.
+	if grown := int64(during.HeapAlloc) - int64(before.HeapAlloc); grown > 8<<20 {
+		t.Errorf("heap grew by %d bytes while reading the log, want the dropped lines not to be kept", grown)
+	}
+}
+
+// generatedLog is a log of 64-byte lines, every 10000th of them an error,
+// calling at once atLine lines have been read.
+type generatedLog struct {
+	lines, atLine, line int
+	at                  func()
+	pending             []byte
+}
+
+func (g *generatedLog) Read(p []byte) (int, error) {
+	if len(g.pending) == 0 {
+		if g.line == g.lines {
+			return 0, io.EOF
+		}
+		if g.line == g.atLine {
+			g.at()
+		}
+		g.pending = []byte("2024-05-01T10:00:00Z GET /healthz 200 .........................\n")
+		if g.line%10000 == 0 {
+			g.pending = []byte("2024-05-01T10:00:01Z ERROR order lookup failed ................\n")
+		}
+		g.line++
+	}
+	n := copy(p, g.pending)
+	g.pending = g.pending[n:]
+	return n, nil
+}
+
+func TestProcessPodLogsPerContainer(t *testing.T) {
+	clientset := kubetest.NewClientset(t, "testdata/logs.yaml")
+	pod, err := clientset.CoreV1().Pods("prod").Get(context.Background(), "web-5d9c7-abcde", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := kubetest.NewStore(t)
+	summary := kubetest.NewRun(t, s)
+
+	// The fake clientset serves every container the same one-line log.
+	processPodLogs(context.Background(), clientset, s.DB, summary, pod, 0, logOptions{sampling: sampling{every: 2}})
+	if summary.Errors != 0 {
+		t.Fatal(summary.Err())
+	}
+	for _, container := range []string{"migrate", "web", "proxy"} {
+		if got := kubetest.Count(t, s.DB, "log_sampling", "run_id = ? AND container = ? AND lines = 1 AND kept = 1", summary.RunID, container); got != 1 {
+			t.Errorf("got %d log_sampling rows for container %s, want 1 of its own", got, container)
+		}
+		if got := kubetest.Count(t, s.DB, "log_lines", "run_id = ? AND container = ?", summary.RunID, container); got != 1 {
+			t.Errorf("got %d log lines of container %s, want 1", got, container)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package gather
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+
+	"kube-query/pkg/store"
+)
+
+// namespaceDumpResources discovers every namespaced resource the server can
+// list and returns "ns:kind:*" entries gathering all of them in each
+// namespace. Kinds not already known are registered so they are stored in the
+// objects table, named "kind.group" outside the core group. Pods come last so
+// those owned by a gathered workload are already stored and only standalone
+// pods remain. include and exclude filter by kind, plural resource name or
+// resource.group; an empty include allows everything.
+func namespaceDumpResources(clientset kubernetes.Interface, summary *store.Run, namespaces, include, exclude []string) ([]string, error) {
+	lists, err := clientset.Discovery().ServerPreferredNamespacedResources()
+	if err != nil {
+		// Unavailable aggregated APIs shouldn't stop the rest of the dump.
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, fmt.Errorf("Error discovering API resources: %v", err)
+		}
+		summary.AddError(fmt.Errorf("Error discovering some API groups: %w", err))
+	}
+
+	var kinds []string
+	hasPods := false
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, r := range list.APIResources {
+			if strings.Contains(r.Name, "/") || !hasVerbs(r.Verbs, "get", "list") {
+				continue
+			}
+			gvr := gv.WithResource(r.Name)
+			k, ok := findObjectKindByResource(gvr)
+			if !ok {
+				k = store.ObjectKind{Kind: strings.ToLower(r.Kind), Resource: gvr, Namespaced: true}
+				if gv.Group != "" {
+					k.Kind += "." + gv.Group
+				}
+				store.ObjectKinds = append(store.ObjectKinds, k)
+			}
+
+			names := []string{k.Kind, r.Name, r.Name + "." + gv.Group}
+			if (len(include) > 0 && !matchesAny(names, include)) || matchesAny(names, exclude) {
+				continue
+			}
+			if k.Kind == "pod" {
+				hasPods = true
+				continue
+			}
+			kinds = append(kinds, k.Kind)
+		}
+	}
+	if hasPods {
+		kinds = append(kinds, "pod")
+	}
+
+	var resources []string
+	for _, ns := range namespaces {
+		for _, kind := range kinds {
+			resources = append(resources, ns+":"+kind+":*")
+		}
+	}
+	return resources, nil
+}
+
+func findObjectKindByResource(gvr schema.GroupVersionResource) (store.ObjectKind, bool) {
+	for _, k := range store.ObjectKinds {
+		if k.Resource.Group == gvr.Group && k.Resource.Resource == gvr.Resource {
+			return k, true
+		}
+	}
+	return store.ObjectKind{}, false
+}
+
+func hasVerbs(verbs []string, required ...string) bool {
+	for _, r := range required {
+		found := false
+		for _, v := range verbs {
+			if v == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAny(names, patterns []string) bool {
+	for _, name := range names {
+		for _, p := range patterns {
+			if strings.EqualFold(name, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SplitList splits a comma-separated flag value, dropping blanks.
+func SplitList(arg string) []string {
+	var items []string
+	for _, item := range strings.Split(arg, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
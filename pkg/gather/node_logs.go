@@ -0,0 +1,197 @@
+package gather
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+
+	"kube-query/pkg/store"
+)
+
+// nodeLogUnits are the systemd units whose journals --node-logs excerpts.
+var nodeLogUnits = []string{"kubelet", "containerd"}
+
+// defaultNodeLogLines is how many of each unit's most recent journal lines
+// are kept when --log-tail doesn't say.
+const defaultNodeLogLines = 1000
+
+// processNodeLogs stores an excerpt of each node's kubelet and containerd
+// journals in node_logs: the last lines within the logs' window, as many as
+// their tail (or defaultNodeLogLines). They are asked of the kubelet through
+// the API server's node proxy, which needs the NodeLogQuery feature. Where
+// that fails and image is set, a short-lived privileged pod of image is run
+// on the node in namespace instead, reading the host's journal with
+// journalctl, and deleted once its output is read.
+func processNodeLogs(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run, hooks Hooks, logs logOptions, image, namespace string) {
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		summary.AddResourceError(store.PhaseList, "node", "", "", fmt.Errorf("Error listing nodes: %w", err))
+		return
+	}
+
+	for _, node := range nodes.Items {
+		if ctx.Err() != nil {
+			return
+		}
+		hooks.progress("Processing node logs: %s", node.Name)
+
+		var failed []string
+		for _, unit := range nodeLogUnits {
+			// The trailing slash is part of the kubelet's path; Suffix would
+			// drop it.
+			req := clientset.CoreV1().RESTClient().Get().
+				AbsPath("/api/v1/nodes/"+node.Name+"/proxy/logs/").
+				Param("query", unit).Param("tailLines", strconv.FormatInt(logs.nodeLogLines(), 10))
+			if !logs.window.since.IsZero() {
+				req.Param("sinceTime", logs.window.since.UTC().Format(time.RFC3339))
+			}
+			if !logs.window.until.IsZero() {
+				req.Param("untilTime", logs.window.until.UTC().Format(time.RFC3339))
+			}
+			raw, err := req.DoRaw(ctx)
+			if err == nil {
+				storeNodeLog(db, summary, node.Name, unit, "node-proxy", raw, false, sql.NullString{})
+				continue
+			}
+			if image == "" {
+				summary.AddResourceError(store.PhaseFetch, "node", "", node.Name, fmt.Errorf("Error fetching %s journal of node %s: %w", unit, node.Name, err))
+				continue
+			}
+			failed = append(failed, unit)
+		}
+		if len(failed) > 0 {
+			journalPod(ctx, clientset, db, summary, hooks, node.Name, failed, logs, image, namespace)
+		}
+	}
+}
+
+// journalPod runs a privileged pod on a node that prints the journal of each
+// unit, storing each excerpt, then deletes it.
+func journalPod(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run, hooks Hooks, node string, units []string, logs logOptions, image, namespace string) {
+	commands := make([][]string, len(units))
+	for i, unit := range units {
+		commands[i] = journalCommand(unit, logs)
+	}
+	name := "kube-gather-journal-" + rand.String(5)
+	hooks.progress("Reading journals of node %s in pod %s/%s", node, namespace, name)
+
+	privileged := true
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"app.kubernetes.io/managed-by": "kube-gather"}},
+		Spec: corev1.PodSpec{
+			NodeName:      node,
+			HostPID:       true,
+			RestartPolicy: corev1.RestartPolicyNever,
+			// Run whatever the node's taints are.
+			Tolerations: []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+			Containers: []corev1.Container{{
+				Name:            "journal",
+				Image:           image,
+				Command:         []string{"sh", "-c", debugScript(commands)},
+				SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+				VolumeMounts:    []corev1.VolumeMount{{Name: "host", MountPath: "/host", ReadOnly: true}},
+			}},
+			Volumes: []corev1.Volume{{Name: "host", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/"}}}},
+		},
+	}
+	if _, err := clientset.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		summary.AddResourceError(store.PhaseExec, "node", "", node, fmt.Errorf("Error creating journal pod for node %s: %w", node, err))
+		return
+	}
+	// The pod is deleted even if the gather is interrupted.
+	defer func() {
+		if err := clientset.CoreV1().Pods(namespace).Delete(context.Background(), name, metav1.DeleteOptions{}); err != nil {
+			summary.AddResourceError(store.PhaseExec, "node", "", node, fmt.Errorf("Error deleting journal pod %s/%s: %w", namespace, name, err))
+		}
+	}()
+
+	if err := waitForPodCompletion(ctx, clientset, namespace, name, 2*time.Minute); err != nil {
+		summary.AddResourceError(store.PhaseExec, "node", "", node, fmt.Errorf("Error waiting for journal pod on node %s: %w", node, err))
+		return
+	}
+	stream, err := clientset.CoreV1().Pods(namespace).GetLogs(name, &corev1.PodLogOptions{}).Stream(ctx)
+	if err != nil {
+		summary.AddResourceError(store.PhaseExec, "node", "", node, fmt.Errorf("Error fetching journal pod logs for node %s: %w", node, err))
+		return
+	}
+	output, err := io.ReadAll(io.LimitReader(stream, int64(len(commands))*execOutputLimit))
+	stream.Close()
+	if err != nil {
+		summary.AddResourceError(store.PhaseExec, "node", "", node, fmt.Errorf("Error reading journal pod logs for node %s: %w", node, err))
+		return
+	}
+
+	for i, result := range splitDebugOutput(output, len(commands)) {
+		if !result.err.Valid && result.ExitCode != 0 {
+			result.err = sql.NullString{String: fmt.Sprintf("journalctl exited with %d", result.ExitCode), Valid: true}
+		}
+		storeNodeLog(db, summary, node, units[i], name, result.Stdout, result.Truncated, result.err)
+	}
+}
+
+// journalCommand prints the last lines of a unit's journal within the logs'
+// window, chrooted into the host's filesystem.
+func journalCommand(unit string, logs logOptions) []string {
+	command := []string{"chroot", "/host", "journalctl", "--no-pager", "--output", "short-iso-precise",
+		"--unit", unit, "--lines", strconv.FormatInt(logs.nodeLogLines(), 10)}
+	if !logs.window.since.IsZero() {
+		command = append(command, "--since", "@"+strconv.FormatInt(logs.window.since.Unix(), 10))
+	}
+	if !logs.window.until.IsZero() {
+		command = append(command, "--until", "@"+strconv.FormatInt(logs.window.until.Unix(), 10))
+	}
+	return command
+}
+
+// nodeLogLines is how many of each unit's most recent journal lines are kept.
+func (o logOptions) nodeLogLines() int64 {
+	if o.tail > 0 {
+		return o.tail
+	}
+	return defaultNodeLogLines
+}
+
+func storeNodeLog(db *sql.DB, summary *store.Run, node, unit, source string, content []byte, truncated bool, errMsg sql.NullString) {
+	_, err := store.ExecWrite(db, `
+		INSERT INTO node_logs (run_id, node, unit, source, content, truncated, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, summary.RunID, node, unit, source, string(content), truncated, errMsg)
+	if err != nil {
+		summary.AddResourceError(store.PhaseStore, "node", "", node, fmt.Errorf("Error inserting node logs into database: %w", err))
+		return
+	}
+	summary.AddGathered("node_logs")
+}
+
+// waitForPodCompletion polls until a pod has succeeded or failed.
+func waitForPodCompletion(ctx context.Context, clientset kubernetes.Interface, namespace, name string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		p, err := clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			return nil
+		}
+		for _, status := range p.Status.ContainerStatuses {
+			if w := status.State.Waiting; w != nil && (w.Reason == "ErrImagePull" || w.Reason == "ImagePullBackOff" || w.Reason == "InvalidImageName") {
+				return fmt.Errorf("journal pod is not starting: %s: %s", w.Reason, w.Message)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+	return fmt.Errorf("timed out after %v", timeout)
+}
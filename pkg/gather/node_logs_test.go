@@ -0,0 +1,23 @@
+package gather
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJournalCommand(t *testing.T) {
+	got := journalCommand("kubelet", logOptions{})
+	want := []string{"chroot", "/host", "journalctl", "--no-pager", "--output", "short-iso-precise", "--unit", "kubelet", "--lines", "1000"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	window := timeWindow{since: time.Unix(1714550400, 0), until: time.Unix(1714554000, 0)}
+	got = journalCommand("containerd", logOptions{tail: 50, window: window})
+	want = []string{"chroot", "/host", "journalctl", "--no-pager", "--output", "short-iso-precise", "--unit", "containerd", "--lines", "50",
+		"--since", "@1714550400", "--until", "@1714554000"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,232 @@
+package gather
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"kube-query/pkg/store"
+)
+
+// expandResource resolves a --resources entry to concrete namespace and name
+// pairs. The namespace may be "*" for all namespaces, less what deny denies
+// (and is ignored for cluster-scoped kinds), and the name may be "*" for
+// every object or a label selector such as "app=web", which names can never
+// contain. Entries that name a single object are returned as they are.
+func expandResource(ctx context.Context, dyn dynamic.Interface, snap *listSnapshot, deny namespaceDenyList, namespace, resourceType, name string) ([][2]string, error) {
+	k, ok := store.FindObjectKind(resourceType)
+	if ok && !k.Namespaced {
+		namespace = ""
+	}
+	if !ok || !isPattern(namespace, name) {
+		return [][2]string{{namespace, name}}, nil
+	}
+
+	listNamespace := namespace
+	if namespace == "*" {
+		listNamespace = metav1.NamespaceAll
+	} else {
+		deny = nil
+	}
+	options := metav1.ListOptions{}
+	if strings.Contains(name, "=") {
+		options.LabelSelector = name
+	}
+	list, err := k.Client(dyn, listNamespace).List(ctx, snap.listOptions(options))
+	if err != nil {
+		return nil, fmt.Errorf("Error listing %s: %v", resourceType, err)
+	}
+	snap.record(k.Kind, namespace, options.LabelSelector, list.GetResourceVersion())
+	scope := gatherScope{kind: k.Kind, namespace: namespace, selector: options.LabelSelector, deny: deny}
+	if name != "*" && options.LabelSelector == "" {
+		scope.name = name
+	}
+	snap.cover(scope)
+
+	var targets [][2]string
+	for _, item := range list.Items {
+		if deny.denies(item.GetNamespace(), k.Kind) {
+			continue
+		}
+		if name == "*" || strings.Contains(name, "=") || item.GetName() == name {
+			targets = append(targets, [2]string{item.GetNamespace(), item.GetName()})
+		}
+	}
+	return targets, nil
+}
+
+// isPattern reports whether a --resources entry matches objects by wildcard
+// or selector rather than naming one.
+func isPattern(namespace, name string) bool {
+	return namespace == "*" || name == "*" || strings.Contains(name, "=")
+}
+
+// apiResources records which API resources the server serves, discovering
+// each group version once.
+type apiResources struct {
+	clientset kubernetes.Interface
+	// served holds the resources of each group version, or an empty set for
+	// group versions the server doesn't have.
+	served map[string]map[string]bool
+}
+
+func newAPIResources(clientset kubernetes.Interface) *apiResources {
+	return &apiResources{clientset: clientset, served: map[string]map[string]bool{}}
+}
+
+func (a *apiResources) serves(gvr schema.GroupVersionResource) (bool, error) {
+	groupVersion := gvr.GroupVersion().String()
+	resources, ok := a.served[groupVersion]
+	if !ok {
+		list, err := a.clientset.Discovery().ServerResourcesForGroupVersion(groupVersion)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return false, fmt.Errorf("Error discovering %s: %v", groupVersion, err)
+		}
+		resources = map[string]bool{}
+		if err == nil {
+			for _, r := range list.APIResources {
+				resources[r.Name] = true
+			}
+		}
+		a.served[groupVersion] = resources
+	}
+	return resources[gvr.Resource], nil
+}
+
+// kindServed reports whether the cluster serves a kind, so optional entries
+// for APIs it lacks, such as OpenShift Routes elsewhere, can be skipped.
+// Errors count as served so the gather reports them.
+func kindServed(apis *apiResources, resourceType string) bool {
+	k, ok := store.FindObjectKind(resourceType)
+	if !ok {
+		return true
+	}
+	served, err := apis.serves(k.Resource)
+	return served || err != nil
+}
+
+// resourceExists reports whether a resource is present in the cluster. Errors
+// other than NotFound count as present so the gather reports them.
+func resourceExists(ctx context.Context, dyn dynamic.Interface, namespace, resourceType, name string) bool {
+	k, ok := store.FindObjectKind(resourceType)
+	if !ok {
+		return true
+	}
+	_, err := k.Client(dyn, namespace).Get(ctx, name, metav1.GetOptions{})
+	return !apierrors.IsNotFound(err)
+}
+
+// processObject stores a resource of a generic kind in the objects table.
+// Kinds without a spec, such as StorageClass or Endpoints, have their
+// remaining top-level fields stored as the spec instead. For workloads it
+// also gathers their pods and logs, returning the pods' selector; otherwise
+// it returns "".
+func processObject(ctx context.Context, clientset kubernetes.Interface, dyn dynamic.Interface, db *sql.DB, summary *store.Run, snap *listSnapshot, k store.ObjectKind, namespace, name string, logs logOptions) string {
+	obj, err := k.Client(dyn, namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		summary.AddResourceError(store.PhaseFetch, k.Kind, namespace, name, fmt.Errorf("Error fetching %s: %w", k.Kind, err))
+		return ""
+	}
+
+	meta, err := store.UnstructuredMeta(obj)
+	if err != nil {
+		summary.AddResourceError(store.PhaseDecode, k.Kind, namespace, name, fmt.Errorf("Error decoding %s metadata: %w", k.Kind, err))
+		return ""
+	}
+
+	spec, status := store.UnstructuredContent(obj)
+	objectID, err := store.StoreObject(db, summary, k.Kind, meta, spec, status)
+	if err != nil {
+		summary.AddResourceError(store.PhaseStore, k.Kind, namespace, name, fmt.Errorf("Error storing %s: %w", k.Kind, err))
+		return ""
+	}
+	storeColumns(db, summary, k, obj, objectID)
+	if !k.Workload {
+		return ""
+	}
+
+	selector, err := workloadSelector(k, obj)
+	if err != nil {
+		summary.AddResourceError(store.PhaseDecode, k.Kind, namespace, name, fmt.Errorf("Error parsing %s selector: %w", k.Kind, err))
+		return ""
+	}
+	if selector == nil || selector.Empty() {
+		return ""
+	}
+	if k.Kind == "statefulset" || k.Kind == "daemonset" {
+		processRollouts(ctx, clientset, db, summary, snap, k.Kind, namespace, name, obj.GetUID(), selector.String())
+	}
+	processWorkloadPods(ctx, clientset, db, summary, snap, namespace, selector.String(), objectID, logs)
+	return selector.String()
+}
+
+// storeColumns stores what is extracted into tables of its own from some
+// kinds: nodes' capacity and conditions, quotas' usage, HTTPRoutes' backends,
+// VPAs' recommendations and CSRs' states.
+func storeColumns(db *sql.DB, summary *store.Run, k store.ObjectKind, obj *unstructured.Unstructured, objectID int64) {
+	var typed interface{}
+	switch k.Kind {
+	case "node":
+		typed = &corev1.Node{}
+	case "resourcequota":
+		typed = &corev1.ResourceQuota{}
+	case "httproute":
+		typed = &store.HTTPRoute{}
+	case "verticalpodautoscaler":
+		typed = &store.VerticalPodAutoscaler{}
+	case "certificatesigningrequest":
+		typed = &certificatesv1.CertificateSigningRequest{}
+	default:
+		return
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, typed); err != nil {
+		summary.AddResourceError(store.PhaseDecode, k.Kind, obj.GetNamespace(), obj.GetName(), fmt.Errorf("Error decoding %s: %w", k.Kind, err))
+		return
+	}
+	switch typed := typed.(type) {
+	case *corev1.Node:
+		store.StoreNodeStatus(db, summary, typed, objectID)
+	case *corev1.ResourceQuota:
+		store.StoreQuotaUsage(db, summary, typed, objectID)
+	case *store.HTTPRoute:
+		store.StoreRouteBackends(db, summary, typed, objectID)
+	case *store.VerticalPodAutoscaler:
+		store.StoreVPARecommendations(db, summary, typed, objectID)
+	case *certificatesv1.CertificateSigningRequest:
+		store.StoreCSRStatus(db, summary, typed, objectID)
+	}
+}
+
+// workloadSelector returns the selector a workload picks its pods with, or
+// nil if it has none.
+func workloadSelector(k store.ObjectKind, obj *unstructured.Unstructured) (labels.Selector, error) {
+	if k.SelectorMap {
+		set, _, err := unstructured.NestedStringMap(obj.Object, "spec", "selector")
+		if err != nil || set == nil {
+			return nil, err
+		}
+		return labels.SelectorFromSet(set), nil
+	}
+
+	rawSelector, _, _ := unstructured.NestedMap(obj.Object, "spec", "selector")
+	if rawSelector == nil {
+		return nil, nil
+	}
+	var labelSelector metav1.LabelSelector
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(rawSelector, &labelSelector); err != nil {
+		return nil, err
+	}
+	return metav1.LabelSelectorAsSelector(&labelSelector)
+}
@@ -0,0 +1,109 @@
+package gather
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/store"
+)
+
+func TestProcessObject(t *testing.T) {
+	tests := []struct {
+		name         string
+		kind         string
+		objectName   string
+		wantSelector string
+		objects      int
+		pods         int
+		errors       int
+	}{
+		{name: "workload with pods", kind: "daemonset", objectName: "agent", wantSelector: "app=agent", objects: 1, pods: 1},
+		{name: "object without pods", kind: "service", objectName: "web", objects: 1},
+		{name: "missing object", kind: "service", objectName: "api", errors: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := kubetest.NewClientset(t, "testdata/cluster.yaml")
+			dyn := kubetest.NewDynamicClient(t, "testdata/cluster.yaml")
+			s := kubetest.NewStore(t)
+			summary := kubetest.NewRun(t, s)
+			k, _ := store.FindObjectKind(tt.kind)
+
+			selector := processObject(context.Background(), clientset, dyn, s.DB, summary, nil, k, "prod", tt.objectName, logOptions{})
+			if selector != tt.wantSelector {
+				t.Errorf("got selector %q, want %q", selector, tt.wantSelector)
+			}
+			if got := kubetest.Count(t, s.DB, "objects", "run_id = ? AND kind = ?", summary.RunID, tt.kind); got != tt.objects {
+				t.Errorf("got %d objects, want %d", got, tt.objects)
+			}
+			if got := kubetest.Count(t, s.DB, "pods", "run_id = ?", summary.RunID); got != tt.pods {
+				t.Errorf("got %d pods, want %d", got, tt.pods)
+			}
+			if summary.Errors != tt.errors {
+				t.Errorf("got %d errors (%v), want %d", summary.Errors, summary.Err(), tt.errors)
+			}
+		})
+	}
+}
+
+func TestExpandResource(t *testing.T) {
+	tests := []struct {
+		namespace, resourceType, name string
+		want                          [][2]string
+	}{
+		{"prod", "pod", "debug", [][2]string{{"prod", "debug"}}},
+		{"prod", "pod", "app=web", [][2]string{{"prod", "web-5d9c7-abcde"}, {"prod", "web-5d9c7-fghij"}}},
+		{"*", "daemonset", "*", [][2]string{{"prod", "agent"}}},
+		{"*", "service", "web", [][2]string{{"prod", "web"}}},
+		{"staging", "service", "*", nil},
+	}
+
+	dyn := kubetest.NewDynamicClient(t, "testdata/cluster.yaml")
+	for _, tt := range tests {
+		got, err := expandResource(context.Background(), dyn, nil, nil, tt.namespace, tt.resourceType, tt.name)
+		if err != nil {
+			t.Errorf("expandResource(%s:%s:%s): %v", tt.namespace, tt.resourceType, tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandResource(%s:%s:%s) = %v, want %v", tt.namespace, tt.resourceType, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExpandResourceDenyList(t *testing.T) {
+	deny, err := parseDenyList([]string{"kube-node-lease", "prod:Service"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		namespace, resourceType, name string
+		want                          [][2]string
+	}{
+		{"*", "service", "*", nil},
+		{"*", "daemonset", "*", [][2]string{{"prod", "agent"}}},
+		// Naming the namespace overrides the deny list.
+		{"prod", "service", "*", [][2]string{{"prod", "web"}}},
+	}
+
+	dyn := kubetest.NewDynamicClient(t, "testdata/cluster.yaml")
+	for _, tt := range tests {
+		got, err := expandResource(context.Background(), dyn, nil, deny, tt.namespace, tt.resourceType, tt.name)
+		if err != nil {
+			t.Errorf("expandResource(%s:%s:%s): %v", tt.namespace, tt.resourceType, tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandResource(%s:%s:%s) = %v, want %v", tt.namespace, tt.resourceType, tt.name, got, tt.want)
+		}
+	}
+
+	for _, entry := range []string{"", "*", "a:b:c"} {
+		if _, err := parseDenyList([]string{entry}); err == nil {
+			t.Errorf("parseDenyList(%q) succeeded, want an error", entry)
+		}
+	}
+}
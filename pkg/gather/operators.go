@@ -0,0 +1,99 @@
+package gather
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"kube-query/pkg/store"
+)
+
+// operatorCollector gathers a well-known operator's custom resources,
+// controller pods and logs, and admission webhook configuration, in the
+// --resources format. Operators are only gathered where they are installed,
+// and entries of kinds the cluster doesn't serve, such as ApplicationSets on
+// an older Argo CD, are skipped like any optional resource.
+type operatorCollector struct {
+	Name string
+	// Marker is a kind whose presence means the operator is installed.
+	// Operators without custom resources are detected by a deployment label
+	// Selector instead.
+	Marker    string
+	Selector  string
+	Resources []string
+}
+
+var operatorCollectors = []operatorCollector{
+	{
+		Name:   "cert-manager",
+		Marker: "certificate",
+		Resources: []string{
+			"*:certificate:*",
+			"*:certificaterequest:*",
+			"*:issuer:*",
+			"*:clusterissuer:*",
+			"*:deployment:app.kubernetes.io/instance=cert-manager",
+			"*:configmap:app.kubernetes.io/instance=cert-manager",
+			"*:validatingwebhookconfiguration:cert-manager-webhook",
+			"*:mutatingwebhookconfiguration:cert-manager-webhook",
+		},
+	},
+	{
+		Name:     "ingress-nginx",
+		Selector: "app.kubernetes.io/name=ingress-nginx",
+		Resources: []string{
+			"*:ingressclass:*",
+			"*:deployment:app.kubernetes.io/name=ingress-nginx",
+			"*:daemonset:app.kubernetes.io/name=ingress-nginx",
+			"*:configmap:app.kubernetes.io/name=ingress-nginx",
+			"*:service:app.kubernetes.io/name=ingress-nginx",
+			"*:validatingwebhookconfiguration:ingress-nginx-admission",
+		},
+	},
+	{
+		Name:   "argocd",
+		Marker: "application",
+		Resources: []string{
+			"*:application:*",
+			"*:applicationset:*",
+			"*:appproject:*",
+			"*:deployment:app.kubernetes.io/part-of=argocd",
+			"*:statefulset:app.kubernetes.io/part-of=argocd",
+			"*:configmap:app.kubernetes.io/part-of=argocd",
+		},
+	},
+}
+
+// operatorResources detects which operators are installed and returns their
+// resources.
+func operatorResources(ctx context.Context, clientset kubernetes.Interface, apis *apiResources, hooks Hooks) ([]string, error) {
+	var resources []string
+	for _, op := range operatorCollectors {
+		var installed bool
+		var err error
+		if op.Marker != "" {
+			k, _ := store.FindObjectKind(op.Marker)
+			installed, err = apis.serves(k.Resource)
+		} else {
+			installed, err = deploymentsExist(ctx, clientset, op.Selector)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if installed {
+			hooks.progress("Detected operator: %s", op.Name)
+			resources = append(resources, op.Resources...)
+		}
+	}
+	return resources, nil
+}
+
+func deploymentsExist(ctx context.Context, clientset kubernetes.Interface, selector string) (bool, error) {
+	list, err := clientset.AppsV1().Deployments(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: selector, Limit: 1})
+	if err != nil {
+		return false, fmt.Errorf("Error listing deployments: %v", err)
+	}
+	return len(list.Items) > 0, nil
+}
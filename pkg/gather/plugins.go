@@ -0,0 +1,178 @@
+package gather
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/pkg/store"
+)
+
+// Collector plugins are executables on PATH named kube-gather-collector-*,
+// run after the built-in collectors like kubectl runs its plugins. Each is
+// given the gather's context in the environment:
+//
+//	KUBE_GATHER_DB      absolute path of the database, which the plugin may
+//	                    write its own tables to
+//	KUBE_GATHER_RUN_ID  the run to attribute rows to
+//	KUBE_GATHER_SERVER  the API server being gathered; KUBECONFIG and the
+//	                    rest of the environment are passed through
+//
+// Lines a plugin writes to stdout are read as JSON objects of the form
+// {"kind": ..., "metadata": {...}, "spec": ..., "status": ...} and stored in
+// the objects table.
+const pluginPrefix = "kube-gather-collector-"
+
+// pluginStderrLimit bounds the stderr kept per plugin in the plugins table.
+const pluginStderrLimit = 64 << 10
+
+type pluginObject struct {
+	Kind     string            `json:"kind"`
+	Metadata metav1.ObjectMeta `json:"metadata"`
+	Spec     interface{}       `json:"spec"`
+	Status   interface{}       `json:"status"`
+}
+
+// findPlugins returns the collector plugins on PATH by name. As with shell
+// lookup, earlier PATH entries win.
+func findPlugins() map[string]string {
+	plugins := map[string]string{}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name, ok := strings.CutPrefix(entry.Name(), pluginPrefix)
+			if !ok || name == "" || entry.IsDir() {
+				continue
+			}
+			if _, seen := plugins[name]; seen {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			if info, err := os.Stat(path); err != nil || info.Mode()&0111 == 0 {
+				continue
+			}
+			plugins[name] = path
+		}
+	}
+	return plugins
+}
+
+// processPlugins runs every collector plugin on PATH, each limited to
+// timeout.
+func processPlugins(ctx context.Context, db *sql.DB, summary *store.Run, hooks Hooks, dbFile, server string, timeout time.Duration) {
+	plugins := findPlugins()
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dbPath, err := filepath.Abs(dbFile)
+	if err != nil {
+		summary.AddResourceError(store.PhasePlugin, "plugin", "", "", fmt.Errorf("Error resolving database path: %w", err))
+		return
+	}
+	for _, name := range names {
+		runPlugin(ctx, db, summary, hooks, name, plugins[name], dbPath, server, timeout)
+	}
+}
+
+func runPlugin(ctx context.Context, db *sql.DB, summary *store.Run, hooks Hooks, name, path, dbPath, server string, timeout time.Duration) {
+	hooks.progress("Running plugin: %s", name)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Env = append(os.Environ(),
+		"KUBE_GATHER_DB="+dbPath,
+		"KUBE_GATHER_RUN_ID="+strconv.FormatInt(summary.RunID, 10),
+		"KUBE_GATHER_SERVER="+server,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &limitedBuffer{buf: &stderr, limit: pluginStderrLimit}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		summary.AddResourceError(store.PhasePlugin, "plugin", "", name, fmt.Errorf("Error running plugin %s: %w", name, err))
+		return
+	}
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		summary.AddResourceError(store.PhasePlugin, "plugin", "", name, fmt.Errorf("Error running plugin %s: %w", name, err))
+		return
+	}
+
+	objects := 0
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64<<10), 16<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var obj pluginObject
+		if err := json.Unmarshal(line, &obj); err != nil || obj.Kind == "" || obj.Metadata.Name == "" {
+			summary.AddResourceError(store.PhaseDecode, "plugin", "", name, fmt.Errorf("Error decoding object from plugin %s: %s", name, line))
+			continue
+		}
+		if _, err := store.StoreObject(db, summary, strings.ToLower(obj.Kind), &obj.Metadata, obj.Spec, obj.Status); err != nil {
+			summary.AddResourceError(store.PhaseStore, "plugin", "", name, fmt.Errorf("Error storing object from plugin %s: %w", name, err))
+			continue
+		}
+		objects++
+	}
+	if err := scanner.Err(); err != nil {
+		summary.AddResourceError(store.PhasePlugin, "plugin", "", name, fmt.Errorf("Error reading output of plugin %s: %w", name, err))
+	}
+
+	exitCode := 0
+	if err := cmd.Wait(); err != nil {
+		exitCode = -1
+		if cmd.ProcessState != nil {
+			exitCode = cmd.ProcessState.ExitCode()
+		}
+		summary.AddResourceError(store.PhasePlugin, "plugin", "", name, fmt.Errorf("Error running plugin %s: %w", name, err))
+	}
+
+	_, err = store.ExecWrite(db, `
+		INSERT INTO plugins (run_id, name, path, exit_code, duration_ms, objects, stderr) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, summary.RunID, name, path, exitCode, time.Since(start).Milliseconds(), objects, stderr.String())
+	if err != nil {
+		summary.AddResourceError(store.PhaseStore, "plugin", "", name, fmt.Errorf("Error inserting plugin run into database: %w", err))
+		return
+	}
+	summary.AddGathered("plugin")
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the
+// rest, so a noisy plugin can't exhaust memory.
+type limitedBuffer struct {
+	buf   *bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,255 @@
+package gather
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// Pod exec is spoken over the API server's WebSocket channel protocol rather
+// than SPDY, which keeps the stream dependencies out of the build. Each binary
+// message is prefixed with a channel byte: 1 stdout, 2 stderr and 3 the
+// final status.
+const execProtocol = "v4.channel.k8s.io"
+
+// execResult is the outcome of a command run in a container. Output beyond
+// the caller's limit is dropped and Truncated set.
+type execResult struct {
+	Stdout    []byte
+	Stderr    []byte
+	ExitCode  int
+	Truncated bool
+}
+
+// podExecutor runs commands in containers over HTTP/1.1 connections, which
+// WebSocket upgrades require.
+type podExecutor struct {
+	clientset kubernetes.Interface
+	transport http.RoundTripper
+}
+
+func newPodExecutor(config *rest.Config, clientset kubernetes.Interface) (*podExecutor, error) {
+	config = rest.CopyConfig(config)
+	config.NextProtos = []string{"http/1.1"}
+	transport, err := rest.TransportFor(config)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating exec transport: %v", err)
+	}
+	return &podExecutor{clientset: clientset, transport: transport}, nil
+}
+
+// exec runs command in a container without stdin or a TTY, keeping at most
+// limit bytes of each output stream.
+func (e *podExecutor) exec(ctx context.Context, namespace, pod, container string, command []string, limit int) (*execResult, error) {
+	url := e.clientset.CoreV1().RESTClient().Get().
+		Namespace(namespace).Resource("pods").Name(pod).SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec).URL()
+
+	keyBytes := make([]byte, 16)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(keyBytes)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Protocol", execProtocol)
+
+	resp, err := e.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("exec upgrade failed: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	accept := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(accept[:]) {
+		return nil, fmt.Errorf("exec upgrade failed: invalid Sec-WebSocket-Accept")
+	}
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		return nil, fmt.Errorf("exec upgrade failed: connection is not writable")
+	}
+
+	result := &execResult{}
+	reader := &webSocketReader{r: bufio.NewReader(conn)}
+	for {
+		opcode, payload, err := reader.next()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		switch opcode {
+		case 0x8: // close
+			return result, nil
+		case 0x9: // ping
+			if err := writeWebSocketFrame(conn, 0xA, payload); err != nil {
+				return result, err
+			}
+			continue
+		case 0x2:
+		default:
+			continue
+		}
+		if len(payload) == 0 {
+			continue
+		}
+
+		switch channel, data := payload[0], payload[1:]; channel {
+		case 1:
+			result.Stdout = appendLimited(result, result.Stdout, data, limit)
+		case 2:
+			result.Stderr = appendLimited(result, result.Stderr, data, limit)
+		case 3:
+			code, err := execExitCode(data)
+			if err != nil {
+				return result, err
+			}
+			result.ExitCode = code
+		}
+	}
+}
+
+func appendLimited(result *execResult, buf, data []byte, limit int) []byte {
+	if room := limit - len(buf); len(data) > room {
+		result.Truncated = true
+		if room <= 0 {
+			return buf
+		}
+		data = data[:room]
+	}
+	return append(buf, data...)
+}
+
+// execExitCode interprets the status sent on the error channel. A non-zero
+// exit is reported as a NonZeroExitCode failure whose cause carries the code;
+// any other failure is returned as an error.
+func execExitCode(data []byte) (int, error) {
+	var status metav1.Status
+	if err := json.Unmarshal(data, &status); err != nil {
+		return 0, fmt.Errorf("Error decoding exec status: %v", err)
+	}
+	if status.Status == metav1.StatusSuccess {
+		return 0, nil
+	}
+	if status.Reason == "NonZeroExitCode" && status.Details != nil {
+		for _, cause := range status.Details.Causes {
+			if cause.Type == "ExitCode" {
+				return strconv.Atoi(cause.Message)
+			}
+		}
+	}
+	return 0, fmt.Errorf("exec failed: %s", status.Message)
+}
+
+// webSocketReader reads messages from a connection, joining continuation
+// frames. Server frames are never masked.
+type webSocketReader struct {
+	r *bufio.Reader
+	// opcode and message hold a fragmented message across interleaved
+	// control frames.
+	opcode  byte
+	message []byte
+}
+
+// next returns the next complete message or control frame.
+func (ws *webSocketReader) next() (byte, []byte, error) {
+	r := ws.r
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return 0, nil, err
+		}
+		fin, frameOpcode := header[0]&0x80 != 0, header[0]&0x0F
+		length := uint64(header[1] & 0x7F)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return 0, nil, err
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return 0, nil, err
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+		if length > 64<<20 {
+			return 0, nil, fmt.Errorf("websocket frame too large: %d bytes", length)
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return 0, nil, err
+		}
+		// Control frames may arrive between the fragments of a message.
+		if frameOpcode >= 0x8 {
+			return frameOpcode, payload, nil
+		}
+		if frameOpcode != 0 {
+			ws.opcode, ws.message = frameOpcode, nil
+		}
+		ws.message = append(ws.message, payload...)
+		if fin {
+			message := ws.message
+			ws.message = nil
+			return ws.opcode, message, nil
+		}
+	}
+}
+
+// writeWebSocketFrame writes a single masked frame, as clients must.
+func writeWebSocketFrame(w io.Writer, opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := w.Write(frame)
+	return err
+}
@@ -0,0 +1,186 @@
+package gather
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// preset is a built-in list of resources, in the --resources format, for
+// gathers people run often enough not to want to remember object names.
+type preset struct {
+	Name        string
+	Description string
+	Resources   []string
+	// LogTail is the default --log-tail for the preset.
+	LogTail int64
+}
+
+// cniResources are the daemonsets and configmaps of common CNI plugins; only
+// the ones installed are gathered.
+var cniResources = []string{
+	"kube-system:daemonset:calico-node",
+	"kube-system:configmap:calico-config",
+	"calico-system:daemonset:calico-node",
+	"kube-system:daemonset:cilium",
+	"kube-system:configmap:cilium-config",
+	"kube-system:daemonset:kube-flannel-ds",
+	"kube-system:configmap:kube-flannel-cfg",
+	"kube-flannel:daemonset:kube-flannel-ds",
+	"kube-flannel:configmap:kube-flannel-cfg",
+	"kube-system:daemonset:aws-node",
+	"kube-system:configmap:amazon-vpc-cni",
+	"kube-system:daemonset:weave-net",
+	"kube-system:daemonset:kindnet",
+	"kube-system:daemonset:antrea-agent",
+	"kube-system:configmap:antrea-config",
+	"openshift-ovn-kubernetes:daemonset:ovnkube-node",
+	"openshift-sdn:daemonset:sdn",
+}
+
+// dnsResources covers cluster DNS: CoreDNS (found by the kube-dns label it
+// keeps for compatibility), NodeLocal DNSCache and the kube-dns Service.
+var dnsResources = []string{
+	"kube-system:deployment:k8s-app=kube-dns",
+	"kube-system:configmap:coredns",
+	"kube-system:daemonset:node-local-dns",
+	"kube-system:configmap:node-local-dns",
+	"kube-system:service:kube-dns",
+	"kube-system:endpointslice:kubernetes.io/service-name=kube-dns",
+	"openshift-dns:daemonset:dns-default",
+	"openshift-dns:configmap:dns-default",
+}
+
+// openshiftIngressResources are OpenShift's Routes and its HAProxy router.
+var openshiftIngressResources = []string{
+	"*:route:*",
+	"openshift-ingress:deployment:router-default",
+	"openshift-ingress-operator:deployment:ingress-operator",
+}
+
+// presets use the --resources format, where a namespace of "*" means all
+// namespaces and a name of "*" or a label selector matches many objects.
+// Entries for kinds the cluster doesn't serve, like OpenShift's outside
+// OpenShift, are skipped.
+var presets = map[string]preset{
+	"kube-system": {
+		Name:        "kube-system",
+		Description: "CoreDNS, kube-proxy, common CNI daemonsets and their configmaps, with recent logs",
+		Resources: concat(dnsResources, []string{
+			"kube-system:daemonset:kube-proxy",
+			"kube-system:configmap:kube-proxy",
+		}, cniResources),
+		LogTail: 1000,
+	},
+	"dns": {
+		Name:        "dns",
+		Description: "CoreDNS and NodeLocal DNSCache with their configuration, Service and endpoints",
+		Resources:   dnsResources,
+		LogTail:     2000,
+	},
+	"networking": {
+		Name:        "networking",
+		Description: "kube-proxy, CNI plugins, and every Service, EndpointSlice and NetworkPolicy",
+		Resources: concat([]string{
+			"kube-system:daemonset:kube-proxy",
+			"kube-system:configmap:kube-proxy",
+			"*:service:*",
+			"*:endpointslice:*",
+			"*:networkpolicy:*",
+		}, cniResources),
+		LogTail: 1000,
+	},
+	"ingress": {
+		Name:        "ingress",
+		Description: "Ingresses, IngressClasses, Gateway API Gateways and HTTPRoutes, OpenShift Routes and the common ingress controllers with their configuration",
+		Resources: concat([]string{
+			"*:ingress:*",
+			"*:ingressclass:*",
+			"*:gatewayclass:*",
+			"*:gateway:*",
+			"*:httproute:*",
+			"*:deployment:app.kubernetes.io/name=ingress-nginx",
+			"*:daemonset:app.kubernetes.io/name=ingress-nginx",
+			"*:configmap:app.kubernetes.io/name=ingress-nginx",
+			"*:service:app.kubernetes.io/name=ingress-nginx",
+			"*:deployment:app.kubernetes.io/name=traefik",
+			"*:daemonset:app.kubernetes.io/name=traefik",
+			"*:deployment:app.kubernetes.io/name=haproxy-ingress",
+			"*:deployment:app.kubernetes.io/name=aws-load-balancer-controller",
+		}, openshiftIngressResources),
+		LogTail: 2000,
+	},
+	"storage": {
+		Name:        "storage",
+		Description: "StorageClasses, PersistentVolumes and claims, CSI drivers, nodes and volume attachments and common CSI node and controller pods",
+		Resources: []string{
+			"*:storageclass:*",
+			"*:csidriver:*",
+			"*:csinode:*",
+			"*:volumeattachment:*",
+			"*:persistentvolume:*",
+			"*:persistentvolumeclaim:*",
+			"kube-system:daemonset:ebs-csi-node",
+			"kube-system:deployment:ebs-csi-controller",
+			"kube-system:daemonset:efs-csi-node",
+			"kube-system:daemonset:csi-azuredisk-node",
+			"kube-system:deployment:csi-azuredisk-controller",
+			"gce-pd-csi-driver:daemonset:csi-gce-pd-node",
+			"longhorn-system:daemonset:longhorn-manager",
+			"rook-ceph:deployment:rook-ceph-operator",
+		},
+		LogTail: 500,
+	},
+	"openshift": {
+		Name:        "openshift",
+		Description: "ClusterVersion, ClusterOperators, Routes and the OpenShift API server, OAuth, ingress, registry and machine-config pods",
+		Resources: concat([]string{
+			"*:clusterversion:*",
+			"*:clusteroperator:*",
+			"openshift-apiserver:deployment:apiserver",
+			"openshift-oauth-apiserver:deployment:apiserver",
+			"openshift-authentication:deployment:oauth-openshift",
+			"openshift-image-registry:deployment:image-registry",
+			"openshift-machine-config-operator:daemonset:machine-config-daemon",
+		}, openshiftIngressResources),
+		LogTail: 1000,
+	},
+}
+
+// AppKinds are the kinds an application is assembled from when gathered by
+// label with --app.
+var AppKinds = []string{
+	"deployment", "statefulset", "daemonset", "job", "cronjob", "deploymentconfig",
+	"service", "ingress", "route", "configmap", "secret", "persistentvolumeclaim", "horizontalpodautoscaler",
+	"poddisruptionbudget",
+}
+
+// appResources lists every object carrying a label, in all namespaces.
+func appResources(selector string) []string {
+	resources := make([]string, 0, len(AppKinds))
+	for _, kind := range AppKinds {
+		resources = append(resources, "*:"+kind+":"+selector)
+	}
+	return resources
+}
+
+func concat(lists ...[]string) []string {
+	var out []string
+	for _, list := range lists {
+		out = append(out, list...)
+	}
+	return out
+}
+
+func findPreset(name string) (preset, error) {
+	p, ok := presets[name]
+	if !ok {
+		names := make([]string, 0, len(presets))
+		for n := range presets {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return preset{}, fmt.Errorf("unknown preset %q, available: %s", name, strings.Join(names, ", "))
+	}
+	return p, nil
+}
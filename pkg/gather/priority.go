@@ -0,0 +1,40 @@
+package gather
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+
+	"kube-query/pkg/store"
+)
+
+// processPriorityClasses stores every PriorityClass in the cluster as a
+// priorityclass object, as gathering *:priorityclass:* would, so that the
+// priorities of gathered pods can be compared when reconstructing which
+// preempted which.
+func processPriorityClasses(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run, snap *listSnapshot) {
+	list, err := clientset.SchedulingV1().PriorityClasses().List(ctx, snap.listOptions(metav1.ListOptions{}))
+	if err != nil {
+		summary.AddResourceError(store.PhaseList, "priorityclass", "", "", fmt.Errorf("Error listing PriorityClasses: %w", err))
+		return
+	}
+	snap.record("priorityclass", "", "", list.ResourceVersion)
+	snap.cover(gatherScope{kind: "priorityclass"})
+
+	for _, pc := range list.Items {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&pc)
+		if err != nil {
+			summary.AddResourceError(store.PhaseDecode, "priorityclass", "", pc.Name, fmt.Errorf("Error converting PriorityClass: %w", err))
+			continue
+		}
+		spec, status := store.UnstructuredContent(&unstructured.Unstructured{Object: content})
+		if _, err := store.StoreObject(db, summary, "priorityclass", &pc.ObjectMeta, spec, status); err != nil {
+			summary.AddResourceError(store.PhaseStore, "priorityclass", "", pc.Name, fmt.Errorf("Error storing priorityclass: %w", err))
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package gather
+
+import (
+	"context"
+	"testing"
+
+	"kube-query/internal/kubetest"
+)
+
+func TestProcessPriorityClasses(t *testing.T) {
+	clientset := kubetest.NewClientset(t, "testdata/priority.yaml")
+	s := kubetest.NewStore(t)
+	summary := kubetest.NewRun(t, s)
+
+	processPriorityClasses(context.Background(), clientset, s.DB, summary, nil)
+	if err := summary.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got := kubetest.Count(t, s.DB, "objects", "kind = 'priorityclass'"); got != 2 {
+		t.Errorf("got %d PriorityClasses, want 2", got)
+	}
+	if got := kubetest.Count(t, s.DB, "objects", "kind = 'priorityclass' AND name = 'shop-critical' AND json_extract(spec, '$.value') = 100000 AND json_extract(spec, '$.preemptionPolicy') = 'PreemptLowerPriority'"); got != 1 {
+		t.Errorf("got %d shop-critical PriorityClasses with their value and policy, want 1", got)
+	}
+}
@@ -0,0 +1,50 @@
+package gather
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// recordStart matches the first line of each multi-line log record, such as
+// a line starting with a date; lines matching none of its patterns continue
+// the record before them, as the lines of a stack trace do. With no patterns
+// every line is a record.
+type recordStart []*regexp.Regexp
+
+// parseRecordStart compiles --log-record-start patterns.
+func parseRecordStart(patterns []string) (recordStart, error) {
+	var r recordStart
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --log-record-start pattern %q: %v", pattern, err)
+		}
+		r = append(r, re)
+	}
+	return r, nil
+}
+
+// continues reports whether a log line, after the timestamp the kubelet
+// prefixes to it, continues the record before it.
+func (r recordStart) continues(line string) bool {
+	if len(r) == 0 {
+		return false
+	}
+	message := logMessage(line)
+	for _, re := range r {
+		if re.MatchString(message) {
+			return false
+		}
+	}
+	return true
+}
+
+// logMessage strips the timestamp the kubelet prefixes to a log line.
+func logMessage(line string) string {
+	if _, ok := lineTimestamp(line); ok {
+		_, message, _ := strings.Cut(line, " ")
+		return message
+	}
+	return line
+}
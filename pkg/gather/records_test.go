@@ -0,0 +1,52 @@
+package gather
+
+import (
+	"strings"
+	"testing"
+)
+
+const javaLog = `2024-05-01T10:00:00Z 2024-05-01 10:00:00 INFO starting
+2024-05-01T10:00:01Z 2024-05-01 10:00:01 ERROR request failed
+2024-05-01T10:00:01Z java.lang.IllegalStateException: closed
+2024-05-01T10:00:01Z 	at com.example.Pool.get(Pool.java:42)
+2024-05-01T10:00:02Z 2024-05-01 10:00:02 INFO retrying
+`
+
+func TestRecordStart(t *testing.T) {
+	if _, err := parseRecordStart([]string{"(unclosed"}); err == nil {
+		t.Error("parseRecordStart accepted an invalid pattern")
+	}
+	records, err := parseRecordStart([]string{`^\d{4}-\d{2}-\d{2} `})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := storedLines(t, logOptions{records: records}, javaLog)
+	want := []string{
+		"2024-05-01 10:00:00 INFO starting",
+		"2024-05-01 10:00:01 ERROR request failed\njava.lang.IllegalStateException: closed\n\tat com.example.Pool.get(Pool.java:42)",
+		"2024-05-01 10:00:02 INFO retrying",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got records %q, want %q", got, want)
+	}
+
+	// Without patterns every line is a record.
+	if got := storedLines(t, logOptions{}, javaLog); len(got) != 5 {
+		t.Errorf("got %d records without patterns, want 5", len(got))
+	}
+
+	// Sampling keeps or drops a record's continuation lines with it.
+	sampler := sampling{every: 2}.sampler(records)
+	kept := keptLines(javaLog, sampler.keeps)
+	if sampler.lines != 5 {
+		t.Errorf("got %d lines, want 5", sampler.lines)
+	}
+	if lines := strings.Count(kept, "\n"); lines != 2 || !strings.Contains(kept, "retrying") {
+		t.Errorf("kept %q, want the first and third records", kept)
+	}
+	kept = keptLines("x\n"+javaLog, sampling{every: 2}.sampler(records).keeps)
+	if lines := strings.Count(kept, "\n"); lines != 4 || strings.Contains(kept, "retrying") {
+		t.Errorf("kept %q, want the first and second records", kept)
+	}
+}
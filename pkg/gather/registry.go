@@ -0,0 +1,111 @@
+package gather
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"kube-query/pkg/store"
+)
+
+// ResourceGatherer gathers a resource type the built-in kinds don't cover.
+// Registered gatherers are used for --resources entries of their kind; the
+// objects they return are stored in the objects table under that kind, and
+// can then be queried, described and exported like any other.
+type ResourceGatherer interface {
+	// Kind is the resource type named in --resources entries, such as
+	// "widget" in prod:widget:*.
+	Kind() string
+	// Gather returns the objects a selector picks out, if any.
+	Gather(ctx context.Context, clients Clients, selector Selector) ([]Object, error)
+}
+
+// Clients are the API clients a ResourceGatherer may use.
+type Clients struct {
+	Kubernetes kubernetes.Interface
+	Dynamic    dynamic.Interface
+}
+
+// Selector is the part of a --resources entry after its kind.
+type Selector struct {
+	// Namespace is the namespace to gather from, or "" for all namespaces
+	// (given as "*") or a cluster-scoped kind.
+	Namespace string
+	// Name is the object to gather, or "" for every object, or those
+	// matching LabelSelector.
+	Name          string
+	LabelSelector string
+}
+
+// Object is a gathered object, stored as its metadata, spec and status.
+// Spec and Status are marshalled to JSON, and either may be nil.
+type Object struct {
+	Meta   metav1.ObjectMeta
+	Spec   interface{}
+	Status interface{}
+}
+
+var resourceGatherers []ResourceGatherer
+
+// RegisterResourceGatherer adds a gatherer for its kind, taking precedence
+// over built-in support for the kind. It panics if the kind already has a
+// registered gatherer, and is meant to be called from init functions.
+func RegisterResourceGatherer(g ResourceGatherer) {
+	if _, ok := findResourceGatherer(g.Kind()); ok {
+		panic(fmt.Sprintf("gather: resource gatherer for %s registered twice", g.Kind()))
+	}
+	resourceGatherers = append(resourceGatherers, g)
+}
+
+func findResourceGatherer(kind string) (ResourceGatherer, bool) {
+	for _, g := range resourceGatherers {
+		if g.Kind() == kind {
+			return g, true
+		}
+	}
+	return nil, false
+}
+
+// parseSelector turns the namespace and name of a --resources entry into a
+// Selector, with the same wildcards as expandResource.
+func parseSelector(namespace, name string) Selector {
+	s := Selector{Namespace: namespace, Name: name}
+	if s.Namespace == "*" {
+		s.Namespace = metav1.NamespaceAll
+	}
+	switch {
+	case name == "*":
+		s.Name = ""
+	case strings.Contains(name, "="):
+		s.Name, s.LabelSelector = "", name
+	}
+	return s
+}
+
+// processRegistered gathers a --resources entry with a registered gatherer,
+// storing the objects it returns in the objects table. For an entry across
+// all namespaces, objects in namespaces deny denies are left out, as
+// expandResource leaves them out for built-in kinds.
+func processRegistered(ctx context.Context, g ResourceGatherer, clients Clients, db *sql.DB, summary *store.Run, deny namespaceDenyList, namespace, name string) {
+	objects, err := g.Gather(ctx, clients, parseSelector(namespace, name))
+	if err != nil {
+		summary.AddResourceError(store.PhaseFetch, g.Kind(), namespace, name, fmt.Errorf("Error gathering %s %s/%s: %w", g.Kind(), namespace, name, err))
+		return
+	}
+	if namespace != "*" {
+		deny = nil
+	}
+	for _, obj := range objects {
+		if deny.denies(obj.Meta.Namespace, g.Kind()) {
+			continue
+		}
+		if _, err := store.StoreObject(db, summary, g.Kind(), &obj.Meta, obj.Spec, obj.Status); err != nil {
+			summary.AddResourceError(store.PhaseStore, g.Kind(), obj.Meta.Namespace, obj.Meta.Name, fmt.Errorf("Error storing %s: %w", g.Kind(), err))
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package gather
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/internal/kubetest"
+)
+
+// testGatherer returns fixed objects, or fails.
+type testGatherer struct {
+	kind    string
+	objects []Object
+	err     error
+}
+
+func (g testGatherer) Kind() string { return g.kind }
+
+func (g testGatherer) Gather(ctx context.Context, clients Clients, selector Selector) ([]Object, error) {
+	return g.objects, g.err
+}
+
+func TestProcessRegistered(t *testing.T) {
+	spread := testGatherer{kind: "gadget", objects: []Object{
+		{Meta: metav1.ObjectMeta{Namespace: "prod", Name: "a"}},
+		{Meta: metav1.ObjectMeta{Namespace: "kube-node-lease", Name: "b"}},
+		{Meta: metav1.ObjectMeta{Namespace: "kube-system", Name: "c"}},
+	}}
+	tests := []struct {
+		name      string
+		gatherer  testGatherer
+		namespace string
+		objects   int
+		errors    int
+	}{
+		{
+			name: "objects stored",
+			gatherer: testGatherer{kind: "gadget", objects: []Object{
+				{Meta: metav1.ObjectMeta{Namespace: "prod", Name: "a"}, Spec: map[string]int{"size": 1}},
+				{Meta: metav1.ObjectMeta{Namespace: "prod", Name: "b"}, Status: "ok"},
+			}},
+			namespace: "prod",
+			objects:   2,
+		},
+		{
+			name:      "gather failed",
+			gatherer:  testGatherer{kind: "gadget", err: errors.New("gadgets unavailable")},
+			namespace: "prod",
+			errors:    1,
+		},
+		{
+			name:      "denied namespaces left out",
+			gatherer:  spread,
+			namespace: "*",
+			objects:   1,
+		},
+		{
+			name:      "denied namespace named",
+			gatherer:  testGatherer{kind: "gadget", objects: spread.objects[1:2]},
+			namespace: "kube-node-lease",
+			objects:   1,
+		},
+	}
+	deny, err := parseDenyList([]string{"kube-node-lease", "kube-system:gadget"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := kubetest.NewStore(t)
+			summary := kubetest.NewRun(t, s)
+
+			processRegistered(context.Background(), tt.gatherer, Clients{}, s.DB, summary, deny, tt.namespace, "*")
+			if got := kubetest.Count(t, s.DB, "objects", "run_id = ? AND kind = ?", summary.RunID, "gadget"); got != tt.objects {
+				t.Errorf("got %d objects, want %d", got, tt.objects)
+			}
+			if summary.Errors != tt.errors {
+				t.Errorf("got %d errors (%v), want %d", summary.Errors, summary.Err(), tt.errors)
+			}
+		})
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		namespace, name string
+		want            Selector
+	}{
+		{"prod", "web", Selector{Namespace: "prod", Name: "web"}},
+		{"*", "*", Selector{}},
+		{"prod", "app=web", Selector{Namespace: "prod", LabelSelector: "app=web"}},
+	}
+	for _, tt := range tests {
+		if got := parseSelector(tt.namespace, tt.name); got != tt.want {
+			t.Errorf("parseSelector(%q, %q) = %+v, want %+v", tt.namespace, tt.name, got, tt.want)
+		}
+	}
+}
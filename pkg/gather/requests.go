@@ -0,0 +1,176 @@
+package gather
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"kube-query/pkg/store"
+)
+
+// requestRecorder wraps the transport of a gather's clients, recording every
+// API request they make: what it asked for, how long it took and how it was
+// answered.
+type requestRecorder struct {
+	mu       sync.Mutex
+	requests []apiRequest
+	// pending counts the retryable responses each method and URL has had in
+	// a row, which client-go retries.
+	pending map[string]int
+}
+
+// apiRequest is one attempt at an API request.
+type apiRequest struct {
+	startedAt time.Time
+	duration  time.Duration
+	method    string
+	path      string
+	info      requestInfo
+	// code is the response's status code, or zero if there was none.
+	code    int
+	err     error
+	retries int
+}
+
+func newRequestRecorder() *requestRecorder {
+	return &requestRecorder{pending: map[string]int{}}
+}
+
+// wrap is a rest.Config transport wrapper.
+func (r *requestRecorder) wrap(rt http.RoundTripper) http.RoundTripper {
+	return recordingTransport{next: rt, recorder: r}
+}
+
+type recordingTransport struct {
+	next     http.RoundTripper
+	recorder *requestRecorder
+}
+
+func (t recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	t.recorder.record(req, resp, err, start, time.Since(start))
+	return resp, err
+}
+
+func (r *requestRecorder) record(req *http.Request, resp *http.Response, err error, start time.Time, duration time.Duration) {
+	path := req.URL.Path
+	if req.URL.RawQuery != "" {
+		path += "?" + req.URL.RawQuery
+	}
+	entry := apiRequest{startedAt: start, duration: duration, method: req.Method, path: path, info: parseRequestPath(req.Method, req.URL.Path, req.URL.Query().Get("watch")), err: err}
+	if resp != nil {
+		entry.code = resp.StatusCode
+	}
+
+	key := req.Method + " " + req.URL.String()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry.retries = r.pending[key]
+	if resp != nil && retryable(resp) {
+		r.pending[key]++
+	} else {
+		delete(r.pending, key)
+	}
+	r.requests = append(r.requests, entry)
+}
+
+// retryable reports whether client-go retries a response: one asking the
+// client to wait with Retry-After, as the API server does when throttling.
+func retryable(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return false
+	}
+	return resp.Header.Get("Retry-After") != ""
+}
+
+// store writes the requests recorded so far to the requests table.
+func (r *requestRecorder) store(db *sql.DB, summary *store.Run) {
+	r.mu.Lock()
+	requests := r.requests
+	r.requests = nil
+	r.mu.Unlock()
+
+	for _, req := range requests {
+		var code sql.NullInt64
+		if req.code != 0 {
+			code = sql.NullInt64{Int64: int64(req.code), Valid: true}
+		}
+		var errMsg sql.NullString
+		if req.err != nil {
+			errMsg = sql.NullString{String: req.err.Error(), Valid: true}
+		}
+		_, err := store.ExecWrite(db, `
+			INSERT INTO requests (run_id, started_at, verb, method, path, api_group, resource, subresource, namespace, name, code, error, duration_ms, retries)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, summary.RunID, req.startedAt.UTC(), req.info.verb, req.method, req.path, req.info.group, req.info.resource, req.info.subresource,
+			req.info.namespace, req.info.name, code, errMsg, float64(req.duration.Microseconds())/1000, req.retries)
+		if err != nil {
+			summary.AddError(fmt.Errorf("Error inserting API request into database: %w", err))
+			return
+		}
+	}
+}
+
+// requestInfo is what an API request asks for, as the API server's audit log
+// names it.
+type requestInfo struct {
+	verb, group, resource, subresource, namespace, name string
+}
+
+// parseRequestPath reads an API request's verb and resource from its method
+// and path, such as GET /apis/apps/v1/namespaces/shop/deployments/web. Paths
+// outside the resource API, such as /version, have the method as their verb
+// and no resource.
+func parseRequestPath(method, path, watch string) requestInfo {
+	info := requestInfo{verb: strings.ToLower(method)}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) >= 3 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 4 && parts[0] == "apis":
+		info.group, parts = parts[1], parts[3:]
+	default:
+		return info
+	}
+	// A namespace's own path is /namespaces/<name>, and its resources'
+	// /namespaces/<name>/<resource>.
+	if len(parts) >= 3 && parts[0] == "namespaces" {
+		info.namespace, parts = parts[1], parts[2:]
+	}
+	info.resource = parts[0]
+	if len(parts) >= 2 {
+		info.name = parts[1]
+	}
+	if len(parts) >= 3 {
+		info.subresource = strings.Join(parts[2:], "/")
+	}
+
+	switch method {
+	case http.MethodGet:
+		switch {
+		case watch == "true" || watch == "1":
+			info.verb = "watch"
+		case info.name == "":
+			info.verb = "list"
+		default:
+			info.verb = "get"
+		}
+	case http.MethodPost:
+		info.verb = "create"
+	case http.MethodPut:
+		info.verb = "update"
+	case http.MethodPatch:
+		info.verb = "patch"
+	case http.MethodDelete:
+		if info.name == "" {
+			info.verb = "deletecollection"
+		} else {
+			info.verb = "delete"
+		}
+	}
+	return info
+}
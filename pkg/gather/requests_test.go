@@ -0,0 +1,79 @@
+package gather
+
+import (
+	"net/http"
+	"testing"
+
+	"kube-query/internal/kubetest"
+)
+
+func TestParseRequestPath(t *testing.T) {
+	for _, tt := range []struct {
+		method, path, watch string
+		want                requestInfo
+	}{
+		{"GET", "/api/v1/namespaces/shop/pods", "", requestInfo{verb: "list", resource: "pods", namespace: "shop"}},
+		{"GET", "/api/v1/namespaces/shop/pods", "true", requestInfo{verb: "watch", resource: "pods", namespace: "shop"}},
+		{"GET", "/api/v1/namespaces/shop/pods/web-1/log", "", requestInfo{verb: "get", resource: "pods", subresource: "log", namespace: "shop", name: "web-1"}},
+		{"GET", "/apis/apps/v1/namespaces/shop/deployments/web", "", requestInfo{verb: "get", group: "apps", resource: "deployments", namespace: "shop", name: "web"}},
+		{"GET", "/api/v1/namespaces/shop", "", requestInfo{verb: "get", resource: "namespaces", name: "shop"}},
+		{"GET", "/api/v1/namespaces", "", requestInfo{verb: "list", resource: "namespaces"}},
+		{"GET", "/api/v1/nodes/worker-1/proxy/logs/", "", requestInfo{verb: "get", resource: "nodes", subresource: "proxy/logs", name: "worker-1"}},
+		{"POST", "/api/v1/namespaces/kube-system/pods", "", requestInfo{verb: "create", resource: "pods", namespace: "kube-system"}},
+		{"DELETE", "/api/v1/namespaces/kube-system/pods/journal", "", requestInfo{verb: "delete", resource: "pods", namespace: "kube-system", name: "journal"}},
+		{"GET", "/version", "", requestInfo{verb: "get"}},
+		{"GET", "/apis/apps/v1", "", requestInfo{verb: "get"}},
+	} {
+		if got := parseRequestPath(tt.method, tt.path, tt.watch); got != tt.want {
+			t.Errorf("%s %s: got %+v, want %+v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+// responder answers requests with a list of status codes in turn.
+type responder []int
+
+func (r *responder) RoundTrip(req *http.Request) (*http.Response, error) {
+	code := (*r)[0]
+	*r = (*r)[1:]
+	resp := &http.Response{StatusCode: code, Header: http.Header{}, Request: req}
+	if code == http.StatusTooManyRequests {
+		resp.Header.Set("Retry-After", "1")
+	}
+	return resp, nil
+}
+
+func TestRequestRecorder(t *testing.T) {
+	recorder := newRequestRecorder()
+	transport := recorder.wrap(&responder{429, 429, 200, 404})
+	for _, url := range []string{
+		"https://cluster/apis/apps/v1/namespaces/shop/deployments/web",
+		"https://cluster/apis/apps/v1/namespaces/shop/deployments/web",
+		"https://cluster/apis/apps/v1/namespaces/shop/deployments/web",
+		"https://cluster/api/v1/namespaces/shop/configmaps?labelSelector=app%3Dweb",
+	} {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := transport.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := kubetest.NewStore(t)
+	summary := kubetest.NewRun(t, s)
+	recorder.store(s.DB, summary)
+	if err := summary.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got := kubetest.Count(t, s.DB, "requests", "verb = 'get' AND resource = 'deployments' AND name = 'web'"); got != 3 {
+		t.Errorf("got %d gets of deployment web, want 3", got)
+	}
+	if got := kubetest.Count(t, s.DB, "requests", "code = 200 AND retries = 2"); got != 1 {
+		t.Errorf("got %d successes after two retries, want 1", got)
+	}
+	if got := kubetest.Count(t, s.DB, "requests", "verb = 'list' AND code = 404 AND retries = 0 AND path = '/api/v1/namespaces/shop/configmaps?labelSelector=app%3Dweb'"); got != 1 {
+		t.Errorf("got %d failed lists of configmaps, want 1", got)
+	}
+}
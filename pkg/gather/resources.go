@@ -0,0 +1,109 @@
+package gather
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"kube-query/pkg/store"
+)
+
+// processDeployment stores a deployment with its pods, logs and events, and
+// returns the selector of its pods, or "" if it could not be gathered.
+func processDeployment(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run, snap *listSnapshot, namespace, name string, logs logOptions) string {
+	deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		summary.AddResourceError(store.PhaseFetch, "deployment", namespace, name, fmt.Errorf("Error fetching deployment: %w", err))
+		return ""
+	}
+
+	deploymentID := store.StoreDeployment(db, summary, deployment)
+	if deploymentID == 0 {
+		return ""
+	}
+
+	podSelector := fmt.Sprintf("app=%s", name)
+	if deployment.Spec.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+		if err != nil {
+			summary.AddResourceError(store.PhaseDecode, "deployment", namespace, name, fmt.Errorf("Error parsing deployment selector: %w", err))
+			return ""
+		}
+		podSelector = selector.String()
+	}
+
+	processRollouts(ctx, clientset, db, summary, snap, "deployment", namespace, name, deployment.UID, podSelector)
+	processDeploymentLogs(ctx, clientset, db, summary, snap, namespace, podSelector, deploymentID, logs)
+	processDeploymentEvents(ctx, clientset, db, summary, snap, namespace, name, deploymentID, logs.window)
+	//linkDependentResources(db, namespace, deployment, deploymentID)
+	return podSelector
+}
+
+func processDeploymentLogs(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run, snap *listSnapshot, namespace, podSelector string, deploymentID int64, logs logOptions) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, snap.listOptions(metav1.ListOptions{
+		LabelSelector: podSelector,
+	}))
+	if err != nil {
+		summary.AddResourceError(store.PhaseList, "pod", namespace, podSelector, fmt.Errorf("Error listing pods: %w", err))
+		return
+	}
+	snap.record("pod", namespace, podSelector, pods.ResourceVersion)
+	snap.cover(gatherScope{kind: "pod", namespace: namespace, selector: podSelector})
+
+	for _, pod := range pods.Items {
+		store.StorePod(db, summary, &pod, deploymentID, 0)
+		processPodLogs(ctx, clientset, db, summary, &pod, deploymentID, logs)
+	}
+}
+
+// processDeploymentEvents stores the events concerning a deployment, its
+// ReplicaSets and their pods, which are named with the deployment as prefix.
+func processDeploymentEvents(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run, snap *listSnapshot, namespace, deploymentName string, deploymentID int64, window timeWindow) {
+	events, err := clientset.CoreV1().Events(namespace).List(ctx, snap.listOptions(metav1.ListOptions{}))
+	if err != nil {
+		summary.AddResourceError(store.PhaseList, "event", namespace, deploymentName, fmt.Errorf("Error listing events: %w", err))
+		return
+	}
+	snap.record("event", namespace, "", events.ResourceVersion)
+
+	for _, event := range events.Items {
+		involved := event.InvolvedObject
+		switch {
+		case involved.Kind == "Deployment" && involved.Name == deploymentName:
+		case (involved.Kind == "ReplicaSet" || involved.Kind == "Pod") && strings.HasPrefix(involved.Name, deploymentName+"-"):
+		default:
+			continue
+		}
+		if !window.containsEvent(&event) {
+			continue
+		}
+
+		store.StoreEvent(db, summary, &event, deploymentID)
+	}
+}
+
+func processConfigMap(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run, namespace, name string) {
+	configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		summary.AddResourceError(store.PhaseFetch, "configmap", namespace, name, fmt.Errorf("Error fetching configmap: %w", err))
+		return
+	}
+
+	// TODO: Link to dependent deployments if applicable
+	store.StoreConfigMap(db, summary, configMap)
+}
+
+func processSecret(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run, namespace, name string) {
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		summary.AddResourceError(store.PhaseFetch, "secret", namespace, name, fmt.Errorf("Error fetching secret: %w", err))
+		return
+	}
+
+	// TODO: Link to dependent deployments if applicable
+	store.StoreSecret(db, summary, secret)
+}
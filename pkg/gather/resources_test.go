@@ -0,0 +1,117 @@
+package gather
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"k8s.io/client-go/kubernetes"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/store"
+)
+
+func TestProcessResources(t *testing.T) {
+	tests := []struct {
+		name    string
+		process func(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run)
+		// rows is the number of rows expected in each table for the run.
+		rows   map[string]int
+		errors int
+	}{
+		{
+			name: "deployment with pods, logs and events",
+			process: func(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run) {
+				if selector := processDeployment(ctx, clientset, db, summary, nil, "prod", "web", logOptions{}); selector != "app=web" {
+					t.Errorf("processDeployment returned selector %q, want app=web", selector)
+				}
+			},
+			rows:   map[string]int{"deployments": 1, "pods": 2, "log_lines": 2, "events": 2},
+			errors: 0,
+		},
+		{
+			name: "missing deployment",
+			process: func(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run) {
+				if selector := processDeployment(ctx, clientset, db, summary, nil, "prod", "api", logOptions{}); selector != "" {
+					t.Errorf("processDeployment returned selector %q, want none", selector)
+				}
+			},
+			rows:   map[string]int{"deployments": 0, "pods": 0, "gather_errors": 1},
+			errors: 1,
+		},
+		{
+			name: "standalone pod",
+			process: func(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run) {
+				processStandalonePod(ctx, clientset, db, summary, "prod", "debug", logOptions{})
+			},
+			rows: map[string]int{"pods": 1, "log_lines": 1},
+		},
+		{
+			name: "pod already gathered with its deployment",
+			process: func(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run) {
+				processDeployment(ctx, clientset, db, summary, nil, "prod", "web", logOptions{})
+				processStandalonePod(ctx, clientset, db, summary, "prod", "web-5d9c7-abcde", logOptions{})
+			},
+			rows: map[string]int{"pods": 2, "log_lines": 2},
+		},
+		{
+			name: "pods by selector",
+			process: func(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run) {
+				namespaces := processSelectedPods(ctx, clientset, db, summary, nil, nil, "*", "app=web", logOptions{})
+				if len(namespaces) != 1 || namespaces[0] != "prod" {
+					t.Errorf("processSelectedPods returned namespaces %q, want [prod]", namespaces)
+				}
+			},
+			rows: map[string]int{"pods": 2, "log_lines": 2, "deployments": 0},
+		},
+		{
+			name: "pods by selector already gathered with their deployment",
+			process: func(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run) {
+				processDeployment(ctx, clientset, db, summary, nil, "prod", "web", logOptions{})
+				processSelectedPods(ctx, clientset, db, summary, nil, nil, "prod", "app=web", logOptions{})
+			},
+			rows: map[string]int{"pods": 2, "log_lines": 2},
+		},
+		{
+			name: "configmap",
+			process: func(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run) {
+				processConfigMap(ctx, clientset, db, summary, "prod", "web-config")
+			},
+			rows: map[string]int{"configmaps": 1},
+		},
+		{
+			name: "missing configmap",
+			process: func(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run) {
+				processConfigMap(ctx, clientset, db, summary, "staging", "web-config")
+			},
+			rows:   map[string]int{"configmaps": 0, "gather_errors": 1},
+			errors: 1,
+		},
+		{
+			name: "secret",
+			process: func(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run) {
+				processSecret(ctx, clientset, db, summary, "prod", "web-secret")
+			},
+			rows: map[string]int{"secrets": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := kubetest.NewClientset(t, "testdata/cluster.yaml")
+			s := kubetest.NewStore(t)
+			summary := kubetest.NewRun(t, s)
+
+			tt.process(context.Background(), clientset, s.DB, summary)
+
+			for table, want := range tt.rows {
+				if got := kubetest.Count(t, s.DB, table, "run_id = ?", summary.RunID); got != want {
+					t.Errorf("%s has %d rows, want %d", table, got, want)
+				}
+			}
+			if summary.Errors != tt.errors {
+				t.Errorf("got %d errors (%v), want %d", summary.Errors, summary.Err(), tt.errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package gather
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"kube-query/pkg/store"
+)
+
+// Annotations the Deployment controller and kubectl set on revisions.
+const (
+	revisionAnnotation    = "deployment.kubernetes.io/revision"
+	changeCauseAnnotation = "kubernetes.io/change-cause"
+)
+
+// rollout is one revision of a workload's pod template.
+type rollout struct {
+	sourceKind, sourceName string
+	revision               int64
+	changeCause            string
+	createdAt              metav1.Time
+	replicas               sql.NullInt64
+	template               []byte
+	// objectID is the row of the revision's ControllerRevision in objects.
+	objectID int64
+}
+
+// processRollouts stores a workload's revision history in rollouts, a row
+// per revision with its change cause and pod template: the ReplicaSets a
+// Deployment owns, or the ControllerRevisions of a StatefulSet or DaemonSet.
+// The highest revision is the current one. ControllerRevisions are also
+// stored whole, as controllerrevision objects their rows link to, since
+// unlike ReplicaSets they hold nothing else of the revision.
+func processRollouts(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run, snap *listSnapshot, kind, namespace, name string, uid types.UID, selector string) {
+	var revisions []rollout
+	if kind == "deployment" {
+		list, err := clientset.AppsV1().ReplicaSets(namespace).List(ctx, snap.listOptions(metav1.ListOptions{LabelSelector: selector}))
+		if err != nil {
+			summary.AddResourceError(store.PhaseList, "replicaset", namespace, selector, fmt.Errorf("Error listing ReplicaSets of deployment %s: %w", name, err))
+			return
+		}
+		snap.record("replicaset", namespace, selector, list.ResourceVersion)
+		for _, rs := range list.Items {
+			if !ownedBy(rs.OwnerReferences, uid) {
+				continue
+			}
+			revision, err := strconv.ParseInt(rs.Annotations[revisionAnnotation], 10, 64)
+			if err != nil {
+				continue
+			}
+			template, err := json.Marshal(rs.Spec.Template)
+			if err != nil {
+				summary.AddResourceError(store.PhaseStore, "replicaset", namespace, rs.Name, fmt.Errorf("Error marshalling ReplicaSet template: %w", err))
+				continue
+			}
+			revisions = append(revisions, rollout{sourceKind: "replicaset", sourceName: rs.Name, revision: revision,
+				changeCause: rs.Annotations[changeCauseAnnotation], createdAt: rs.CreationTimestamp,
+				replicas: sql.NullInt64{Int64: int64(rs.Status.Replicas), Valid: true}, template: template})
+		}
+	} else {
+		list, err := clientset.AppsV1().ControllerRevisions(namespace).List(ctx, snap.listOptions(metav1.ListOptions{LabelSelector: selector}))
+		if err != nil {
+			summary.AddResourceError(store.PhaseList, "controllerrevision", namespace, selector, fmt.Errorf("Error listing ControllerRevisions of %s %s: %w", kind, name, err))
+			return
+		}
+		snap.record("controllerrevision", namespace, selector, list.ResourceVersion)
+		for _, cr := range list.Items {
+			if !ownedBy(cr.OwnerReferences, uid) {
+				continue
+			}
+			// The revision's data is the patch restoring its template.
+			var data struct {
+				Spec struct {
+					Template json.RawMessage `json:"template"`
+				} `json:"spec"`
+			}
+			if err := json.Unmarshal(cr.Data.Raw, &data); err != nil {
+				summary.AddResourceError(store.PhaseDecode, "controllerrevision", namespace, cr.Name, fmt.Errorf("Error decoding ControllerRevision data: %w", err))
+				continue
+			}
+			revisions = append(revisions, rollout{sourceKind: "controllerrevision", sourceName: cr.Name, revision: cr.Revision,
+				changeCause: cr.Annotations[changeCauseAnnotation], createdAt: cr.CreationTimestamp, template: data.Spec.Template,
+				objectID: storeControllerRevision(db, summary, &cr)})
+		}
+	}
+
+	for _, r := range revisions {
+		var createdAt sql.NullTime
+		if !r.createdAt.IsZero() {
+			createdAt = sql.NullTime{Time: r.createdAt.UTC(), Valid: true}
+		}
+		_, err := store.ExecWrite(db, `
+			INSERT INTO rollouts (run_id, kind, namespace, workload, revision, source_kind, source_name, change_cause, created_at, replicas, template, object_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, summary.RunID, kind, namespace, name, r.revision, r.sourceKind, r.sourceName, r.changeCause, createdAt, r.replicas, string(r.template),
+			sql.NullInt64{Int64: r.objectID, Valid: r.objectID != 0})
+		if err != nil {
+			summary.AddResourceError(store.PhaseStore, kind, namespace, name, fmt.Errorf("Error inserting rollout into database: %w", err))
+			continue
+		}
+		summary.AddGathered("rollout")
+	}
+}
+
+// storeControllerRevision stores a ControllerRevision as a controllerrevision
+// object, as gathering it by name would, returning its row ID, or zero if it
+// couldn't be stored.
+func storeControllerRevision(db *sql.DB, summary *store.Run, cr *appsv1.ControllerRevision) int64 {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(cr)
+	if err != nil {
+		summary.AddResourceError(store.PhaseDecode, "controllerrevision", cr.Namespace, cr.Name, fmt.Errorf("Error converting ControllerRevision: %w", err))
+		return 0
+	}
+	spec, status := store.UnstructuredContent(&unstructured.Unstructured{Object: content})
+	objectID, err := store.StoreObject(db, summary, "controllerrevision", &cr.ObjectMeta, spec, status)
+	if err != nil {
+		summary.AddResourceError(store.PhaseStore, "controllerrevision", cr.Namespace, cr.Name, fmt.Errorf("Error storing controllerrevision: %w", err))
+		return 0
+	}
+	return objectID
+}
+
+// ownedBy reports whether an object is owned by the object with a UID.
+func ownedBy(owners []metav1.OwnerReference, uid types.UID) bool {
+	for _, owner := range owners {
+		if owner.UID == uid {
+			return true
+		}
+	}
+	return false
+}
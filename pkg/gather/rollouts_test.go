@@ -0,0 +1,54 @@
+package gather
+
+import (
+	"context"
+	"testing"
+
+	"kube-query/internal/kubetest"
+)
+
+func TestProcessRollouts(t *testing.T) {
+	clientset := kubetest.NewClientset(t, "testdata/rollouts.yaml")
+	s := kubetest.NewStore(t)
+	summary := kubetest.NewRun(t, s)
+
+	processRollouts(context.Background(), clientset, s.DB, summary, nil, "deployment", "shop", "web", "0b6f3a1e-web", "app=web")
+	processRollouts(context.Background(), clientset, s.DB, summary, nil, "statefulset", "shop", "db", "8a4c1f20-db", "app=db")
+	if err := summary.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := kubetest.Count(t, s.DB, "objects", "kind = 'controllerrevision'"); got != 2 {
+		t.Errorf("got %d ControllerRevisions, want db's 2", got)
+	}
+	if got := kubetest.Count(t, s.DB, "rollouts", `workload = 'db' AND EXISTS (
+		SELECT 1 FROM objects o WHERE o.id = rollouts.object_id AND o.name = rollouts.source_name AND json_extract(o.spec, '$.revision') = rollouts.revision)`); got != 2 {
+		t.Errorf("got %d revisions of db linked to their ControllerRevisions, want 2", got)
+	}
+	if got := kubetest.Count(t, s.DB, "rollouts", "workload = 'web'"); got != 2 {
+		t.Errorf("got %d revisions of web, want 2 (not the canary's)", got)
+	}
+	for _, tt := range []struct {
+		workload    string
+		revision    int
+		image       string
+		changeCause string
+	}{
+		{"web", 1, "web:1.0", "kubectl apply --filename=web.yaml"},
+		{"web", 2, "web:1.1", "kubectl set image deployment/web web=web:1.1"},
+		{"db", 1, "postgres:15", ""},
+		{"db", 2, "postgres:16", ""},
+	} {
+		var image, changeCause string
+		err := s.DB.QueryRow(`
+			SELECT json_extract(template, '$.spec.containers[0].image'), change_cause
+			FROM rollouts WHERE workload = ? AND revision = ?
+		`, tt.workload, tt.revision).Scan(&image, &changeCause)
+		if err != nil {
+			t.Fatalf("%s revision %d: %v", tt.workload, tt.revision, err)
+		}
+		if image != tt.image || changeCause != tt.changeCause {
+			t.Errorf("%s revision %d: got %s (%q), want %s (%q)", tt.workload, tt.revision, image, changeCause, tt.image, tt.changeCause)
+		}
+	}
+}
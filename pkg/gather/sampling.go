@@ -0,0 +1,80 @@
+package gather
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sampling thins out the logs of chatty containers, keeping every Nth line
+// when every is above one and at most perSecond lines of each second when it
+// is positive.
+type sampling struct {
+	every, perSecond int
+}
+
+func (s sampling) enabled() bool {
+	return s.every > 1 || s.perSecond > 0
+}
+
+// String describes the sampling as recorded with the lines it kept, such as
+// "1 in 10 lines, 100/s".
+func (s sampling) String() string {
+	var parts []string
+	if s.every > 1 {
+		parts = append(parts, fmt.Sprintf("1 in %d lines", s.every))
+	}
+	if s.perSecond > 0 {
+		parts = append(parts, fmt.Sprintf("%d/s", s.perSecond))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sampler applies a sampling to the lines of one container's log, passed to
+// keeps in order, counting the lines it was passed and kept.
+type sampler struct {
+	sampling
+	records     recordStart
+	second      time.Time
+	inSecond    int
+	n           int
+	keep        bool
+	lines, kept int
+}
+
+func (s sampling) sampler(records recordStart) *sampler {
+	return &sampler{sampling: s, records: records}
+}
+
+// keeps reports whether a log line is kept. Lines without a timestamp count
+// towards the second of the line before them. Records are sampled whole:
+// lines continuing a record are kept with its first line or dropped with it.
+func (s *sampler) keeps(line string) bool {
+	s.lines++
+	if s.n == 0 || !s.records.continues(line) {
+		s.keep = s.keepsRecord(line)
+	}
+	if s.keep {
+		s.kept++
+	}
+	return s.keep
+}
+
+// keepsRecord reports whether the record a line starts is kept.
+func (s *sampler) keepsRecord(line string) bool {
+	i := s.n
+	s.n++
+	if s.every > 1 && i%s.every != 0 {
+		return false
+	}
+	if s.perSecond > 0 {
+		if ts, ok := lineTimestamp(line); ok && !ts.Truncate(time.Second).Equal(s.second) {
+			s.second, s.inSecond = ts.Truncate(time.Second), 0
+		}
+		if s.inSecond == s.perSecond {
+			return false
+		}
+		s.inSecond++
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package gather
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSampling(t *testing.T) {
+	// Two seconds of five lines each, then an unstamped continuation line.
+	var log strings.Builder
+	for second := 0; second < 2; second++ {
+		for i := 0; i < 5; i++ {
+			fmt.Fprintf(&log, "2024-05-01T10:00:0%d.%dZ line %d\n", second, i, second*5+i)
+		}
+	}
+	log.WriteString("  at main.go:42\n")
+
+	for _, tt := range []struct {
+		sampling sampling
+		want     []string
+		desc     string
+	}{
+		{sampling{every: 4}, []string{"line 0", "line 4", "line 8"}, "1 in 4 lines"},
+		{sampling{perSecond: 2}, []string{"line 0", "line 1", "line 5", "line 6"}, "2/s"},
+		{sampling{every: 2, perSecond: 2}, []string{"line 0", "line 2", "line 6", "line 8"}, "1 in 2 lines, 2/s"},
+	} {
+		sampler := tt.sampling.sampler(nil)
+		kept := keptLines(log.String(), sampler.keeps)
+		if sampler.lines != 11 {
+			t.Errorf("%v: got %d lines, want 11", tt.sampling, sampler.lines)
+		}
+		if got := strings.Count(kept, "\n"); sampler.kept != got {
+			t.Errorf("%v: counted %d lines kept, want %d", tt.sampling, sampler.kept, got)
+		}
+		var got []string
+		for _, line := range strings.Split(strings.TrimSuffix(kept, "\n"), "\n") {
+			_, text, _ := strings.Cut(line, " ")
+			got = append(got, text)
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%v: kept %q, want %q", tt.sampling, got, tt.want)
+		}
+		if desc := tt.sampling.String(); desc != tt.desc {
+			t.Errorf("described as %q, want %q", desc, tt.desc)
+		}
+	}
+}
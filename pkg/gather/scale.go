@@ -0,0 +1,147 @@
+package gather
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"kube-query/pkg/store"
+)
+
+// scaleTarget is a gathered object with a /scale subresource.
+type scaleTarget struct {
+	resource               schema.GroupVersionResource
+	kind, namespace, name  string
+	deploymentID, objectID int64
+}
+
+// processScales stores the /scale subresource of every object the run has
+// gathered that has one in scales: the replicas asked for and running and
+// the pods' selector, as an HPA or other autoscaler sees the object. Kinds
+// the cluster serves /scale for have one, and custom resources whose
+// definition the run has gathered have one if it declares it.
+func processScales(ctx context.Context, dyn dynamic.Interface, apis *apiResources, db *sql.DB, summary *store.Run) {
+	targets, err := scaleTargets(db, apis, summary.RunID)
+	if err != nil {
+		summary.AddError(err)
+		return
+	}
+	for _, t := range targets {
+		if ctx.Err() != nil {
+			return
+		}
+		var client dynamic.ResourceInterface = dyn.Resource(t.resource)
+		if t.namespace != "" {
+			client = dyn.Resource(t.resource).Namespace(t.namespace)
+		}
+		u, err := client.Get(ctx, t.name, metav1.GetOptions{}, "scale")
+		if err != nil {
+			summary.AddResourceError(store.PhaseFetch, t.kind, t.namespace, t.name, fmt.Errorf("Error fetching %s scale: %w", t.kind, err))
+			continue
+		}
+		var scale autoscalingv1.Scale
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &scale); err != nil {
+			summary.AddResourceError(store.PhaseDecode, t.kind, t.namespace, t.name, fmt.Errorf("Error decoding %s scale: %w", t.kind, err))
+			continue
+		}
+		_, err = store.ExecWrite(db, `
+			INSERT INTO scales (run_id, deployment_id, object_id, kind, namespace, name, spec_replicas, status_replicas, selector)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, summary.RunID, sql.NullInt64{Int64: t.deploymentID, Valid: t.deploymentID != 0}, sql.NullInt64{Int64: t.objectID, Valid: t.objectID != 0},
+			t.kind, t.namespace, t.name, scale.Spec.Replicas, scale.Status.Replicas, scale.Status.Selector)
+		if err != nil {
+			summary.AddResourceError(store.PhaseStore, t.kind, t.namespace, t.name, fmt.Errorf("Error inserting scale into database: %w", err))
+			continue
+		}
+		summary.AddGathered("scale")
+	}
+}
+
+// scaleTargets returns the objects a run has gathered that have a /scale
+// subresource.
+func scaleTargets(db *sql.DB, apis *apiResources, runID int64) ([]scaleTarget, error) {
+	var targets []scaleTarget
+	deployments, _ := store.FindObjectKind("deployment")
+	if scalable(apis, deployments.Resource) {
+		rows, err := db.Query(`SELECT id, namespace, name FROM deployments WHERE run_id = ? ORDER BY id`, runID)
+		if err != nil {
+			return nil, fmt.Errorf("Error listing gathered deployments: %v", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			t := scaleTarget{resource: deployments.Resource, kind: "deployment"}
+			if err := rows.Scan(&t.deploymentID, &t.namespace, &t.name); err != nil {
+				return nil, fmt.Errorf("Error listing gathered deployments: %v", err)
+			}
+			targets = append(targets, t)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("Error listing gathered deployments: %v", err)
+		}
+	}
+
+	rows, err := db.Query(`
+		SELECT o.id, o.kind, COALESCE(o.namespace, ''), o.name, d.spec
+		FROM objects o LEFT JOIN objects d ON d.id = o.definition_id
+		WHERE o.run_id = ? ORDER BY o.id
+	`, runID)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing gathered objects: %v", err)
+	}
+	defer rows.Close()
+	served := map[string]bool{}
+	for rows.Next() {
+		t := scaleTarget{}
+		var definition sql.NullString
+		if err := rows.Scan(&t.objectID, &t.kind, &t.namespace, &t.name, &definition); err != nil {
+			return nil, fmt.Errorf("Error listing gathered objects: %v", err)
+		}
+		if k, ok := store.FindObjectKind(t.kind); ok {
+			if _, checked := served[t.kind]; !checked {
+				served[t.kind] = scalable(apis, k.Resource)
+			}
+			if !served[t.kind] {
+				continue
+			}
+			t.resource = k.Resource
+		} else if resource, ok := definitionScale(definition.String); ok {
+			t.resource = resource
+		} else {
+			continue
+		}
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
+
+// scalable reports whether the cluster serves a resource's /scale
+// subresource.
+func scalable(apis *apiResources, resource schema.GroupVersionResource) bool {
+	resource.Resource += "/scale"
+	served, _ := apis.serves(resource)
+	return served
+}
+
+// definitionScale returns the resource of a custom resource definition's
+// served version declaring a /scale subresource, preferring the storage
+// version.
+func definitionScale(definition string) (schema.GroupVersionResource, bool) {
+	var def CRDSpec
+	if definition == "" || json.Unmarshal([]byte(definition), &def) != nil {
+		return schema.GroupVersionResource{}, false
+	}
+	version := ""
+	for _, v := range def.Versions {
+		if v.Served && v.Subresources.Scale != nil && (version == "" || v.Storage) {
+			version = v.Name
+		}
+	}
+	return schema.GroupVersionResource{Group: def.Group, Version: version, Resource: def.Names.Plural}, version != ""
+}
@@ -0,0 +1,78 @@
+package gather
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/store"
+)
+
+func TestProcessScales(t *testing.T) {
+	clientset := kubetest.NewClientset(t)
+	clientset.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "apps/v1",
+		APIResources: []metav1.APIResource{{Name: "deployments"}, {Name: "deployments/scale"}, {Name: "daemonsets"}},
+	}}
+	dyn := kubetest.NewDynamicClient(t)
+	dyn.PrependReactor("get", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		get := action.(k8stesting.GetAction)
+		if get.GetSubresource() != "scale" {
+			return false, nil, nil
+		}
+		replicas := map[string]int64{"web": 3, "canary": 1}[get.GetName()]
+		return true, &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "autoscaling/v1",
+			"kind":       "Scale",
+			"metadata":   map[string]interface{}{"namespace": get.GetNamespace(), "name": get.GetName()},
+			"spec":       map[string]interface{}{"replicas": replicas},
+			"status":     map[string]interface{}{"replicas": replicas - 1, "selector": "app=" + get.GetName()},
+		}}, nil
+	})
+
+	s := kubetest.NewStore(t)
+	summary := kubetest.NewRun(t, s)
+	store.StoreDeployment(s.DB, summary, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web"}})
+	if _, err := store.StoreObject(s.DB, summary, "daemonset", &metav1.ObjectMeta{Namespace: "shop", Name: "agent"}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	definition := map[string]interface{}{
+		"group": "argoproj.io",
+		"names": map[string]interface{}{"kind": "Rollout", "plural": "rollouts"},
+		"versions": []interface{}{map[string]interface{}{
+			"name": "v1alpha1", "served": true, "storage": true,
+			"subresources": map[string]interface{}{"scale": map[string]interface{}{"specReplicasPath": ".spec.replicas"}},
+		}},
+	}
+	crdID, err := store.StoreObject(s.DB, summary, "customresourcedefinition", &metav1.ObjectMeta{Name: "rollouts.argoproj.io"}, definition, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rolloutID, err := store.StoreObject(s.DB, summary, "rollout.argoproj.io", &metav1.ObjectMeta{Namespace: "shop", Name: "canary"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.DB.Exec(`UPDATE objects SET definition_id = ? WHERE id = ?`, crdID, rolloutID); err != nil {
+		t.Fatal(err)
+	}
+
+	processScales(context.Background(), dyn, newAPIResources(clientset), s.DB, summary)
+	if err := summary.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got := kubetest.Count(t, s.DB, "scales", ""); got != 2 {
+		t.Errorf("got %d scales, want web's and canary's, not the DaemonSet's", got)
+	}
+	if got := kubetest.Count(t, s.DB, "scales", "kind = 'deployment' AND name = 'web' AND deployment_id IS NOT NULL AND spec_replicas = 3 AND status_replicas = 2 AND selector = 'app=web'"); got != 1 {
+		t.Errorf("got %d scales of web linked to its deployment, want 1", got)
+	}
+	if got := kubetest.Count(t, s.DB, "scales", "kind = 'rollout.argoproj.io' AND object_id = ? AND spec_replicas = 1", rolloutID); got != 1 {
+		t.Errorf("got %d scales of the canary Rollout, want 1", got)
+	}
+}
@@ -0,0 +1,62 @@
+package gather
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"kube-query/pkg/store"
+)
+
+// processPodScrapes snapshots the Prometheus endpoint of each running pod
+// matching labelSelector. Requests are tunnelled through the API server's pod
+// proxy, which reaches the same port a port-forward would without needing a
+// streaming connection. The port and path come from the pod's
+// prometheus.io/port and prometheus.io/path annotations, falling back to
+// defaultPort and /metrics; pods with neither are skipped.
+func processPodScrapes(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run, namespace, labelSelector, defaultPort string) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		summary.AddResourceError(store.PhaseList, "pod", namespace, labelSelector, fmt.Errorf("Error listing pods: %w", err))
+		return
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		port, path := pod.Annotations["prometheus.io/port"], pod.Annotations["prometheus.io/path"]
+		if port == "" {
+			port = defaultPort
+		}
+		if path == "" {
+			path = "/metrics"
+		}
+		if _, err := strconv.Atoi(port); err != nil {
+			continue
+		}
+		scheme := pod.Annotations["prometheus.io/scheme"]
+
+		scrapedAt := time.Now().UTC()
+		body, err := clientset.CoreV1().Pods(namespace).ProxyGet(scheme, pod.Name, port, path, nil).DoRaw(ctx)
+		if err != nil {
+			summary.AddResourceError(store.PhaseFetch, "pod", namespace, pod.Name, fmt.Errorf("Error scraping %s:%s%s on pod %s: %w", scheme, port, path, pod.Name, err))
+			continue
+		}
+
+		_, err = store.ExecWrite(db, `
+			INSERT INTO pod_scrapes (run_id, namespace, pod, port, path, scraped_at, body) VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, summary.RunID, namespace, pod.Name, port, path, scrapedAt, string(body))
+		if err != nil {
+			summary.AddResourceError(store.PhaseStore, "pod", namespace, pod.Name, fmt.Errorf("Error inserting pod scrape into database: %w", err))
+			continue
+		}
+		summary.AddGathered("pod_scrape")
+	}
+}
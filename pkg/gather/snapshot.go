@@ -0,0 +1,73 @@
+package gather
+
+import (
+	"database/sql"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/pkg/store"
+)
+
+// listSnapshot applies a run's list consistency to the lists that decide
+// what is gathered, and records the resource version each was served at in
+// the list_versions table. It also collects the scopes those lists covered
+// in full, for detectDeletions. A nil listSnapshot leaves lists as they are
+// and records nothing.
+type listSnapshot struct {
+	db      *sql.DB
+	summary *store.Run
+	match   metav1.ResourceVersionMatch
+	covered []gatherScope
+}
+
+func newListSnapshot(db *sql.DB, summary *store.Run, match string) *listSnapshot {
+	summary.ResourceVersionMatch = match
+	return &listSnapshot{db: db, summary: summary, match: metav1.ResourceVersionMatch(match)}
+}
+
+// checkResourceVersionMatch returns an error for an unknown consistency.
+func checkResourceVersionMatch(match string) error {
+	switch metav1.ResourceVersionMatch(match) {
+	case "", metav1.ResourceVersionMatchNotOlderThan, metav1.ResourceVersionMatchExact:
+		return nil
+	}
+	return fmt.Errorf("unknown resource version match %q, want %s or %s", match,
+		metav1.ResourceVersionMatchNotOlderThan, metav1.ResourceVersionMatchExact)
+}
+
+// listOptions pins the first page of a list to the run's resource version
+// when the run asks for consistent lists and one has been served.
+// Continuations keep the version of their first page.
+func (s *listSnapshot) listOptions(options metav1.ListOptions) metav1.ListOptions {
+	if s == nil || s.match == "" || s.summary.ResourceVersion == "" || options.Continue != "" {
+		return options
+	}
+	options.ResourceVersion = s.summary.ResourceVersion
+	options.ResourceVersionMatch = s.match
+	return options
+}
+
+// record notes the resource version a list of kind was served at. The run's
+// first list sets the version later ones are pinned to.
+func (s *listSnapshot) record(kind, namespace, selector, resourceVersion string) {
+	if s == nil || resourceVersion == "" {
+		return
+	}
+	if s.summary.ResourceVersion == "" {
+		s.summary.ResourceVersion = resourceVersion
+	}
+	_, err := store.ExecWrite(s.db, `
+		INSERT INTO list_versions (run_id, kind, namespace, selector, resource_version) VALUES (?, ?, ?, ?, ?)
+	`, s.summary.RunID, kind, namespace, selector, resourceVersion)
+	if err != nil {
+		s.summary.AddError(fmt.Errorf("Error inserting list version into database: %w", err))
+	}
+}
+
+// cover notes a scope the run gathered everything in.
+func (s *listSnapshot) cover(scope gatherScope) {
+	if s != nil {
+		s.covered = append(s.covered, scope)
+	}
+}
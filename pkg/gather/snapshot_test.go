@@ -0,0 +1,61 @@
+package gather
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/internal/kubetest"
+)
+
+func TestListSnapshot(t *testing.T) {
+	tests := []struct {
+		match string
+		// want is the version and match of a list after the first.
+		want metav1.ListOptions
+	}{
+		{"", metav1.ListOptions{LabelSelector: "app=web"}},
+		{"NotOlderThan", metav1.ListOptions{LabelSelector: "app=web", ResourceVersion: "1042", ResourceVersionMatch: metav1.ResourceVersionMatchNotOlderThan}},
+		{"Exact", metav1.ListOptions{LabelSelector: "app=web", ResourceVersion: "1042", ResourceVersionMatch: metav1.ResourceVersionMatchExact}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.match, func(t *testing.T) {
+			s := kubetest.NewStore(t)
+			summary := kubetest.NewRun(t, s)
+			snap := newListSnapshot(s.DB, summary, tt.match)
+
+			first := metav1.ListOptions{LabelSelector: "app=web"}
+			if got := snap.listOptions(first); got != first {
+				t.Errorf("first list options = %+v, want them unchanged", got)
+			}
+			snap.record("deployment", "prod", "app=web", "1042")
+			if got := snap.listOptions(first); got != tt.want {
+				t.Errorf("later list options = %+v, want %+v", got, tt.want)
+			}
+			next := metav1.ListOptions{Limit: 500, Continue: "token"}
+			if got := snap.listOptions(next); got != next {
+				t.Errorf("continuation options = %+v, want them unchanged", got)
+			}
+			snap.record("pod", "prod", "app=web", "1057")
+
+			if summary.ResourceVersion != "1042" || summary.ResourceVersionMatch != tt.match {
+				t.Errorf("run resource version = %q (%q), want 1042 (%q)", summary.ResourceVersion, summary.ResourceVersionMatch, tt.match)
+			}
+			if got := kubetest.Count(t, s.DB, "list_versions", "run_id = ?", summary.RunID); got != 2 {
+				t.Errorf("list_versions has %d rows, want 2", got)
+			}
+		})
+	}
+
+	var snap *listSnapshot
+	options := metav1.ListOptions{LabelSelector: "app=web"}
+	if got := snap.listOptions(options); got != options {
+		t.Errorf("nil snapshot changed list options to %+v", got)
+	}
+	snap.record("pod", "prod", "", "1042")
+
+	if err := checkResourceVersionMatch("Latest"); err == nil {
+		t.Errorf("checkResourceVersionMatch accepted Latest")
+	}
+}
@@ -0,0 +1,303 @@
+package gather
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"kube-query/pkg/store"
+)
+
+// tailRetry is how long a follower waits to ask for a container's logs
+// again after the stream ends, as it does when the container exits, or
+// fails, as it does before the container first starts.
+var tailRetry = 5 * time.Second
+
+// maxTailBatch is the most lines a follower holds before writing them out.
+const maxTailBatch = 1000
+
+// TailOptions says whose logs TailLogs follows and how long it keeps them.
+type TailOptions struct {
+	// Namespace and Selector choose the pods followed: those in the
+	// namespace ("*" for all) matching the label selector ("" for all).
+	Namespace, Selector string
+	// Flush is how often followed lines are written to the database.
+	Flush time.Duration
+	// Retain, if positive, deletes lines logged longer ago than this as
+	// newer ones arrive.
+	Retain time.Duration
+	// Include and Exclude, if set, are regular expressions keeping only the
+	// lines matching Include and dropping those matching Exclude.
+	Include, Exclude string
+}
+
+// TailLogs follows the logs of every container of the chosen pods into a
+// new run until ctx is done, then completes the run. Pods are followed as
+// they are created, and containers across restarts, each line stored once.
+// Init containers are followed with the rest, and ephemeral containers as
+// they are added.
+func TailLogs(ctx context.Context, clientset kubernetes.Interface, s *store.Store, opts TailOptions) (*store.Run, error) {
+	if opts.Flush <= 0 {
+		opts.Flush = 5 * time.Second
+	}
+	namespace := opts.Namespace
+	if namespace == "*" {
+		namespace = metav1.NamespaceAll
+	}
+	lines, err := newLineFilter(opts.Include, opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := store.NewRun(time.Now())
+	summary.Trigger = "tail"
+	if err := summary.Begin(s.DB); err != nil {
+		return nil, fmt.Errorf("Error recording run: %v", err)
+	}
+
+	t := &logTailer{ctx: ctx, clientset: clientset, store: s, summary: summary, flush: opts.Flush, lines: lines, following: map[string]*followedPod{}}
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) { options.LabelSelector = opts.Selector }))
+	_, err = factory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				t.follow(pod)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				t.follow(pod)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				t.unfollow(pod)
+			}
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error watching pods: %v", err)
+	}
+	factory.Start(ctx.Done())
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case <-ctx.Done():
+			running = false
+		case <-ticker.C:
+			if opts.Retain > 0 {
+				t.prune(time.Now().Add(-opts.Retain))
+			}
+		}
+	}
+	// Once the informer has stopped, no followers are started.
+	factory.Shutdown()
+	t.followers.Wait()
+
+	summary.Finish(time.Now(), s.Path)
+	if err := summary.Complete(s.DB, false); err != nil {
+		summary.AddError(fmt.Errorf("Error storing run summary: %w", err))
+	}
+	return summary, nil
+}
+
+// logTailer follows containers' logs into a run, one follower per
+// container.
+type logTailer struct {
+	ctx       context.Context
+	clientset kubernetes.Interface
+	store     *store.Store
+	summary   *store.Run
+	flush     time.Duration
+	lines     lineFilter
+
+	// writes serializes the followers' writes to the database and run.
+	writes sync.Mutex
+
+	mu sync.Mutex
+	// following is the pods followed, by namespace/pod.
+	following map[string]*followedPod
+	followers sync.WaitGroup
+}
+
+// followedPod is a pod whose containers are followed.
+type followedPod struct {
+	// ctx is done once the pod is deleted, stopping the followers of its
+	// containers.
+	ctx        context.Context
+	cancel     context.CancelFunc
+	containers map[string]bool
+}
+
+// follow starts following the containers of a pod that aren't already, its
+// init and ephemeral containers among them.
+func (t *logTailer) follow(pod *corev1.Pod) {
+	key := pod.Namespace + "/" + pod.Name
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	followed, ok := t.following[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(t.ctx)
+		followed = &followedPod{ctx: ctx, cancel: cancel, containers: map[string]bool{}}
+		t.following[key] = followed
+	}
+	for _, container := range podContainers(pod) {
+		if followed.containers[container] {
+			continue
+		}
+		followed.containers[container] = true
+		t.followers.Add(1)
+		go func(container string) {
+			defer t.followers.Done()
+			t.followContainer(followed.ctx, pod.Namespace, pod.Name, container)
+		}(container)
+	}
+}
+
+// unfollow stops following a deleted pod, once the logs already streamed
+// are stored.
+func (t *logTailer) unfollow(pod *corev1.Pod) {
+	key := pod.Namespace + "/" + pod.Name
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if followed, ok := t.following[key]; ok {
+		followed.cancel()
+		delete(t.following, key)
+	}
+}
+
+// followContainer streams a container's logs until ctx is done, asking again
+// each time the stream ends so that restarts are followed. The lines logged
+// since the last one stored are asked for, and any repeated are skipped.
+func (t *logTailer) followContainer(ctx context.Context, namespace, pod, container string) {
+	var last time.Time
+	var lines int
+	reported := false
+	for ctx.Err() == nil {
+		options := &corev1.PodLogOptions{Container: container, Follow: true, Timestamps: true}
+		if !last.IsZero() {
+			since := metav1.NewTime(last)
+			options.SinceTime = &since
+		}
+		stream, err := t.clientset.CoreV1().Pods(namespace).GetLogs(pod, options).Stream(ctx)
+		if err == nil {
+			reported = false
+			last, lines = t.copyLines(stream, namespace, pod, container, last, lines)
+			stream.Close()
+		} else if !reported && ctx.Err() == nil {
+			// Report a container's logs failing once, not on every retry.
+			reported = true
+			t.writes.Lock()
+			t.summary.AddResourceError(store.PhaseLogs, "pod", namespace, pod, fmt.Errorf("Error following logs of container %s: %w", container, err))
+			t.writes.Unlock()
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(tailRetry):
+		}
+	}
+}
+
+// copyLines stores the lines of a log stream after the timestamp last, a
+// batch every flush, until the stream ends. It returns the timestamp of the
+// last line stored and the number of lines stored so far.
+func (t *logTailer) copyLines(stream io.Reader, namespace, pod, container string, last time.Time, lines int) (time.Time, int) {
+	read := make(chan string)
+	go func() {
+		defer close(read)
+		scanner := bufio.NewScanner(stream)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		for scanner.Scan() {
+			read <- scanner.Text()
+		}
+	}()
+
+	ticker := time.NewTicker(t.flush)
+	defer ticker.Stop()
+	var batch []string
+	// resumed is set once a line newer than last has been read.
+	resumed := false
+	write := func() {
+		if len(batch) == 0 {
+			return
+		}
+		t.writes.Lock()
+		defer t.writes.Unlock()
+		if err := store.AppendLogLines(t.store.DB, t.summary.RunID, 0, namespace, pod, container, lines+1, batch); err != nil {
+			t.summary.AddResourceError(store.PhaseStore, "pod", namespace, pod, fmt.Errorf("Error inserting log lines for pod %s: %w", pod, err))
+		}
+		var n int64
+		for _, line := range batch {
+			n += int64(len(line)) + 1
+		}
+		t.summary.AddLogBytes(namespace, pod, n)
+		lines += len(batch)
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case line, ok := <-read:
+			if !ok {
+				write()
+				return last, lines
+			}
+			// Asking from the last line's second repeats lines already
+			// stored, up to the first line newer than it. Lines after that
+			// are new, however many share a timestamp.
+			if ts, ok := lineTimestamp(line); ok {
+				if !resumed {
+					if !ts.After(last) {
+						continue
+					}
+					resumed = true
+				}
+				last = ts
+			}
+			if !t.lines.keeps(line) {
+				continue
+			}
+			batch = append(batch, line)
+			if len(batch) >= maxTailBatch {
+				write()
+			}
+		case <-ticker.C:
+			write()
+		}
+	}
+}
+
+// prune deletes the lines logged before a time.
+func (t *logTailer) prune(before time.Time) {
+	t.writes.Lock()
+	defer t.writes.Unlock()
+	if _, err := store.PruneLogLines(t.store.DB, t.summary.RunID, before); err != nil {
+		t.summary.AddError(err)
+	}
+}
+
+// lineTimestamp returns the timestamp the kubelet prefixes to a log line.
+func lineTimestamp(line string) (time.Time, bool) {
+	prefix, _, found := strings.Cut(line, " ")
+	if !found {
+		return time.Time{}, false
+	}
+	ts, err := time.Parse(time.RFC3339Nano, prefix)
+	return ts, err == nil
+}
@@ -0,0 +1,88 @@
+package gather
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/store"
+)
+
+func TestTailLogs(t *testing.T) {
+	defer func(retry time.Duration) { tailRetry = retry }(tailRetry)
+	tailRetry = 10 * time.Millisecond
+
+	s := kubetest.NewStore(t)
+	clientset := kubetest.NewClientset(t, "testdata/tail.yaml")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type result struct {
+		summary *store.Run
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		summary, err := TailLogs(ctx, clientset, s, TailOptions{Namespace: "prod", Selector: "app=web", Flush: 10 * time.Millisecond})
+		done <- result{summary, err}
+	}()
+
+	// The fake clientset serves every container's logs as one line, so
+	// each container is followed once its line is stored.
+	deadline := time.Now().Add(10 * time.Second)
+	for _, container := range []string{"migrate", "web", "proxy"} {
+		for kubetest.Count(t, s.DB, "log_lines", "container = ?", container) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for container %s's logs", container)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	cancel()
+
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if err := r.summary.Err(); err != nil {
+		t.Error(err)
+	}
+	if got := kubetest.Count(t, s.DB, "log_lines", "pod != 'web-5d9c7-abcde'"); got != 0 {
+		t.Errorf("got %d log lines of pods not matching the selector, want 0", got)
+	}
+	if got := kubetest.Count(t, s.DB, "runs", "trigger = 'tail' AND finished_at IS NOT NULL"); got != 1 {
+		t.Errorf("got %d finished tail runs, want 1", got)
+	}
+}
+
+func TestTailCopyLinesSameTimestamp(t *testing.T) {
+	s := kubetest.NewStore(t)
+	tailer := &logTailer{store: s, summary: kubetest.NewRun(t, s), flush: time.Hour}
+
+	// A first stream's lines are all new, whatever their timestamps.
+	first := `2024-05-01T10:00:00Z starting
+2024-05-01T10:00:00Z listening on :8080
+2024-05-01T10:00:01Z GET /api/orders 200
+`
+	last, lines := tailer.copyLines(strings.NewReader(first), "prod", "web-5d9c7-abcde", "web", time.Time{}, 0)
+	if lines != 3 {
+		t.Fatalf("stored %d lines of the first stream, want 3", lines)
+	}
+
+	// Resuming from the last line's second repeats it; the lines after the
+	// first newer one are kept even when they share its second.
+	resumed := `2024-05-01T10:00:00Z listening on :8080
+2024-05-01T10:00:01Z GET /api/orders 200
+2024-05-01T10:00:02Z GET /api/orders 500
+2024-05-01T10:00:02Z ERROR order lookup failed
+2024-05-01T10:00:02Z GET /api/orders 200
+`
+	if _, lines = tailer.copyLines(strings.NewReader(resumed), "prod", "web-5d9c7-abcde", "web", last, lines); lines != 6 {
+		t.Errorf("stored %d lines after resuming, want 6", lines)
+	}
+	if got := kubetest.Count(t, s.DB, "log_lines", "line LIKE '%ERROR order lookup failed'"); got != 1 {
+		t.Errorf("got %d copies of the line sharing the previous one's timestamp, want 1", got)
+	}
+}
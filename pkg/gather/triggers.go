@@ -0,0 +1,217 @@
+package gather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"kube-query/pkg/store"
+)
+
+// Trigger is a workload WatchTriggers found in trouble, or about to be
+// deleted, to be gathered while the evidence is fresh.
+type Trigger struct {
+	// Kind is the workload's resource type as written in --resources
+	// entries, such as deployment, or pod for a pod without a controller.
+	Kind, Namespace, Name string
+	// Reason says what went wrong, such as "pod web-5d9c7-abcde container
+	// web is in CrashLoopBackOff".
+	Reason string
+	// ConfigMaps and Secrets are those the workload's pods refer to.
+	ConfigMaps, Secrets []string
+}
+
+// Resources returns the --resources entries gathering the workload, with its
+// pods, logs and events, and the ConfigMaps and Secrets it depends on.
+func (t Trigger) Resources() []string {
+	resources := []string{t.Namespace + ":" + t.Kind + ":" + t.Name}
+	for _, name := range t.ConfigMaps {
+		resources = append(resources, t.Namespace+":configmap:"+name)
+	}
+	for _, name := range t.Secrets {
+		resources = append(resources, t.Namespace+":secret:"+name)
+	}
+	return resources
+}
+
+// triggerWatcher turns pod, deployment and job updates into Triggers, firing
+// each workload at most once per cooldown.
+type triggerWatcher struct {
+	clientset kubernetes.Interface
+	cooldown  time.Duration
+	fire      func(Trigger)
+	onError   func(error)
+
+	mu    sync.Mutex
+	fired map[string]time.Time
+}
+
+// WatchTriggers watches pods, deployments and jobs in namespaces ("*" for
+// all) until ctx is done, calling fire when a pod enters CrashLoopBackOff
+// (with the workload owning it), a deployment stops being Available or a job
+// with ttlSecondsAfterFinished finishes, before it and its pods' logs are
+// deleted. A workload fires at most once per cooldown, since a crash-looping
+// pod re-enters CrashLoopBackOff after every restart. Objects already in
+// trouble when watching starts don't fire. Errors that don't stop the watch,
+// such as failing to follow a pod up to its Deployment, are passed to
+// onError, which may be nil.
+func WatchTriggers(ctx context.Context, clientset kubernetes.Interface, namespaces []string, cooldown time.Duration, fire func(Trigger), onError func(error)) error {
+	w := &triggerWatcher{clientset: clientset, cooldown: cooldown, fire: fire, onError: onError, fired: map[string]time.Time{}}
+	for _, namespace := range namespaces {
+		if namespace == "*" {
+			namespace = metav1.NamespaceAll
+		}
+		factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(namespace))
+		_, err := factory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldPod, _ := oldObj.(*corev1.Pod)
+				newPod, _ := newObj.(*corev1.Pod)
+				if oldPod != nil && newPod != nil {
+					w.podUpdated(ctx, oldPod, newPod)
+				}
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("Error watching pods in %s: %v", namespace, err)
+		}
+		_, err = factory.Apps().V1().Deployments().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldDeployment, _ := oldObj.(*appsv1.Deployment)
+				newDeployment, _ := newObj.(*appsv1.Deployment)
+				if oldDeployment != nil && newDeployment != nil {
+					w.deploymentUpdated(oldDeployment, newDeployment)
+				}
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("Error watching deployments in %s: %v", namespace, err)
+		}
+		_, err = factory.Batch().V1().Jobs().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldJob, _ := oldObj.(*batchv1.Job)
+				newJob, _ := newObj.(*batchv1.Job)
+				if oldJob != nil && newJob != nil {
+					if t, ok := jobExpiring(oldJob, newJob); ok {
+						w.trigger(t)
+					}
+				}
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("Error watching jobs in %s: %v", namespace, err)
+		}
+		factory.Start(ctx.Done())
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (w *triggerWatcher) podUpdated(ctx context.Context, oldPod, newPod *corev1.Pod) {
+	if crashLooping(oldPod) != "" {
+		return
+	}
+	container := crashLooping(newPod)
+	if container == "" {
+		return
+	}
+
+	t := Trigger{Kind: "pod", Namespace: newPod.Namespace, Name: newPod.Name,
+		Reason: fmt.Sprintf("pod %s container %s is in CrashLoopBackOff", newPod.Name, container)}
+	if owner := metav1.GetControllerOf(newPod); owner != nil {
+		t.Kind, t.Name = w.podWorkload(ctx, newPod.Namespace, owner)
+	}
+	t.ConfigMaps, t.Secrets = configReferences(newPod.Spec)
+	w.trigger(t)
+}
+
+func (w *triggerWatcher) deploymentUpdated(oldDeployment, newDeployment *appsv1.Deployment) {
+	if !deploymentAvailable(oldDeployment) || deploymentAvailable(newDeployment) {
+		return
+	}
+	t := Trigger{Kind: "deployment", Namespace: newDeployment.Namespace, Name: newDeployment.Name,
+		Reason: fmt.Sprintf("deployment %s became unavailable", newDeployment.Name)}
+	t.ConfigMaps, t.Secrets = configReferences(newDeployment.Spec.Template.Spec)
+	w.trigger(t)
+}
+
+func (w *triggerWatcher) trigger(t Trigger) {
+	key := t.Kind + "/" + t.Namespace + "/" + t.Name
+	w.mu.Lock()
+	last, ok := w.fired[key]
+	if ok && time.Since(last) < w.cooldown {
+		w.mu.Unlock()
+		return
+	}
+	w.fired[key] = time.Now()
+	w.mu.Unlock()
+	w.fire(t)
+}
+
+// podWorkload returns the resource type and name of the workload controlling
+// a pod, following a ReplicaSet up to its Deployment. Controllers the gather
+// has no resource type for are returned as they are.
+func (w *triggerWatcher) podWorkload(ctx context.Context, namespace string, owner *metav1.OwnerReference) (string, string) {
+	kind := strings.ToLower(owner.Kind)
+	if owner.Kind != "ReplicaSet" {
+		return kind, owner.Name
+	}
+	rs, err := w.clientset.AppsV1().ReplicaSets(namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+	if err != nil {
+		if w.onError != nil {
+			w.onError(fmt.Errorf("Error fetching ReplicaSet %s/%s: %w", namespace, owner.Name, err))
+		}
+		return kind, owner.Name
+	}
+	if deployment := metav1.GetControllerOf(rs); deployment != nil && deployment.Kind == "Deployment" {
+		return "deployment", deployment.Name
+	}
+	return kind, owner.Name
+}
+
+// crashLooping returns the first of a pod's containers waiting in
+// CrashLoopBackOff, or "" if none is.
+func crashLooping(pod *corev1.Pod) string {
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, cs := range statuses {
+			if cs.State.Waiting != nil && cs.State.Waiting.Reason == "CrashLoopBackOff" {
+				return cs.Name
+			}
+		}
+	}
+	return ""
+}
+
+// deploymentAvailable reports whether a deployment's Available condition is
+// not False; deployments yet to report it count as available.
+func deploymentAvailable(deployment *appsv1.Deployment) bool {
+	for _, c := range deployment.Status.Conditions {
+		if c.Type == appsv1.DeploymentAvailable {
+			return c.Status != corev1.ConditionFalse
+		}
+	}
+	return true
+}
+
+// configReferences returns the ConfigMaps and Secrets a pod spec refers to.
+func configReferences(spec corev1.PodSpec) ([]string, []string) {
+	specBytes, err := json.Marshal(spec)
+	if err != nil {
+		return nil, nil
+	}
+	refs, err := store.FindConfigReferences(specBytes)
+	if err != nil {
+		return nil, nil
+	}
+	return refs.ConfigMaps, refs.Secrets
+}
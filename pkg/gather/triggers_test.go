@@ -0,0 +1,76 @@
+package gather
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/internal/kubetest"
+)
+
+func TestTriggerWatcherPods(t *testing.T) {
+	var fired []Trigger
+	w := &triggerWatcher{
+		clientset: kubetest.NewClientset(t, "testdata/triggers.yaml"),
+		cooldown:  time.Hour,
+		fire:      func(t Trigger) { fired = append(fired, t) },
+		fired:     map[string]time.Time{},
+	}
+
+	controller := true
+	running := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web-5d9c7-abcde", OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-5d9c7", Controller: &controller},
+		}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:    "web",
+			EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "web-config"}}}},
+		}}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name: "web", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+		}}},
+	}
+	crashing := running.DeepCopy()
+	crashing.Status.ContainerStatuses[0].State = corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}
+
+	ctx := context.Background()
+	w.podUpdated(ctx, running, running)
+	w.podUpdated(ctx, running, crashing)
+	// The pod crashes again after restarting, within the cooldown.
+	w.podUpdated(ctx, crashing, running)
+	w.podUpdated(ctx, running, crashing)
+
+	if len(fired) != 1 {
+		t.Fatalf("fired %d triggers, want 1: %v", len(fired), fired)
+	}
+	want := []string{"prod:deployment:web", "prod:configmap:web-config"}
+	if got := fired[0].Resources(); !reflect.DeepEqual(got, want) {
+		t.Errorf("trigger gathers %v, want %v", got, want)
+	}
+}
+
+func TestTriggerWatcherDeployments(t *testing.T) {
+	var fired []Trigger
+	w := &triggerWatcher{cooldown: time.Hour, fire: func(t Trigger) { fired = append(fired, t) }, fired: map[string]time.Time{}}
+
+	deployment := func(available corev1.ConditionStatus) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "api"},
+			Status: appsv1.DeploymentStatus{Conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentAvailable, Status: available},
+			}},
+		}
+	}
+	w.deploymentUpdated(deployment(corev1.ConditionTrue), deployment(corev1.ConditionTrue))
+	w.deploymentUpdated(deployment(corev1.ConditionFalse), deployment(corev1.ConditionFalse))
+	w.deploymentUpdated(deployment(corev1.ConditionTrue), deployment(corev1.ConditionFalse))
+
+	if len(fired) != 1 || fired[0].Kind != "deployment" || fired[0].Name != "api" {
+		t.Errorf("fired %v, want one trigger for deployment api", fired)
+	}
+}
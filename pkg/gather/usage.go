@@ -0,0 +1,100 @@
+package gather
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"kube-query/pkg/store"
+)
+
+// metricsAPIPath is the metrics.k8s.io API served by metrics-server. Its
+// types are mirrored below rather than pulling in k8s.io/metrics.
+const metricsAPIPath = "/apis/metrics.k8s.io/v1beta1"
+
+type usageMetrics struct {
+	Metadata  metav1.ObjectMeta            `json:"metadata"`
+	Timestamp metav1.Time                  `json:"timestamp"`
+	Window    metav1.Duration              `json:"window"`
+	Usage     map[string]resource.Quantity `json:"usage"`
+	// Containers is only set for pods.
+	Containers []struct {
+		Name  string                       `json:"name"`
+		Usage map[string]resource.Quantity `json:"usage"`
+	} `json:"containers"`
+}
+
+type usageMetricsList struct {
+	Items []usageMetrics `json:"items"`
+}
+
+// getUsageMetrics lists objects from the metrics API, which is served by an
+// aggregated API server and so goes through the generic REST client.
+func getUsageMetrics(ctx context.Context, clientset kubernetes.Interface, path, labelSelector string) (*usageMetricsList, error) {
+	req := clientset.Discovery().RESTClient().Get().AbsPath(metricsAPIPath + path)
+	if labelSelector != "" {
+		req = req.Param("labelSelector", labelSelector)
+	}
+	body, err := req.Do(ctx).Raw()
+	if err != nil {
+		return nil, err
+	}
+	var list usageMetricsList
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// processPodMetrics stores per-container CPU and memory usage for the pods
+// matching labelSelector, as reported by metrics-server at gather time.
+func processPodMetrics(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run, namespace, labelSelector string) {
+	list, err := getUsageMetrics(ctx, clientset, "/namespaces/"+namespace+"/pods", labelSelector)
+	if err != nil {
+		summary.AddResourceError(store.PhaseFetch, "pod", namespace, labelSelector, fmt.Errorf("Error fetching pod metrics: %w", err))
+		return
+	}
+
+	for _, pod := range list.Items {
+		for _, container := range pod.Containers {
+			cpu, memory := container.Usage["cpu"], container.Usage["memory"]
+			_, err := store.ExecWrite(db, `
+				INSERT INTO pod_metrics (run_id, namespace, pod, container, timestamp, window_seconds, cpu_millicores, memory_bytes)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			`, summary.RunID, pod.Metadata.Namespace, pod.Metadata.Name, container.Name,
+				pod.Timestamp.UTC(), pod.Window.Seconds(), cpu.MilliValue(), memory.Value())
+			if err != nil {
+				summary.AddResourceError(store.PhaseStore, "pod", pod.Metadata.Namespace, pod.Metadata.Name, fmt.Errorf("Error inserting pod metrics into database: %w", err))
+				continue
+			}
+		}
+		summary.AddGathered("pod_metrics")
+	}
+}
+
+// processNodeMetrics stores CPU and memory usage for every node.
+func processNodeMetrics(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run) {
+	list, err := getUsageMetrics(ctx, clientset, "/nodes", "")
+	if err != nil {
+		summary.AddResourceError(store.PhaseFetch, "node", "", "", fmt.Errorf("Error fetching node metrics: %w", err))
+		return
+	}
+
+	for _, node := range list.Items {
+		cpu, memory := node.Usage["cpu"], node.Usage["memory"]
+		_, err := store.ExecWrite(db, `
+			INSERT INTO node_metrics (run_id, node, timestamp, window_seconds, cpu_millicores, memory_bytes)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, summary.RunID, node.Metadata.Name, node.Timestamp.UTC(), node.Window.Seconds(), cpu.MilliValue(), memory.Value())
+		if err != nil {
+			summary.AddResourceError(store.PhaseStore, "node", "", node.Metadata.Name, fmt.Errorf("Error inserting node metrics into database: %w", err))
+			continue
+		}
+		summary.AddGathered("node_metrics")
+	}
+}
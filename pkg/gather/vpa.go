@@ -0,0 +1,82 @@
+package gather
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+
+	"kube-query/pkg/store"
+)
+
+// processVPAs stores the VerticalPodAutoscalers targeting workloads the run
+// has gathered, with their recommendations, so that the evictions of pods
+// whose requests the updater changes can be explained. Only the namespaces
+// of gathered workloads are listed.
+func processVPAs(ctx context.Context, dyn dynamic.Interface, db *sql.DB, summary *store.Run, snap *listSnapshot) {
+	namespaces, err := workloadNamespaces(db, summary.RunID)
+	if err != nil {
+		summary.AddError(err)
+		return
+	}
+	k, _ := store.FindObjectKind("verticalpodautoscaler")
+	for _, namespace := range namespaces {
+		list, err := k.Client(dyn, namespace).List(ctx, snap.listOptions(metav1.ListOptions{}))
+		if err != nil {
+			summary.AddResourceError(store.PhaseList, k.Kind, namespace, "", fmt.Errorf("Error listing VerticalPodAutoscalers: %w", err))
+			continue
+		}
+		snap.record(k.Kind, namespace, "", list.GetResourceVersion())
+
+		for i := range list.Items {
+			obj := &list.Items[i]
+			var vpa store.VerticalPodAutoscaler
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &vpa); err != nil {
+				summary.AddResourceError(store.PhaseDecode, k.Kind, namespace, obj.GetName(), fmt.Errorf("Error decoding verticalpodautoscaler: %w", err))
+				continue
+			}
+			ref := vpa.Spec.TargetRef
+			if ref == nil {
+				continue
+			}
+			if deploymentID, objectID := store.WorkloadIDs(db, summary.RunID, ref.Kind, namespace, ref.Name); deploymentID == 0 && objectID == 0 {
+				continue
+			}
+
+			spec, status := store.UnstructuredContent(obj)
+			objectID, err := store.StoreObject(db, summary, k.Kind, &vpa.ObjectMeta, spec, status)
+			if err != nil {
+				summary.AddResourceError(store.PhaseStore, k.Kind, namespace, vpa.Name, fmt.Errorf("Error storing verticalpodautoscaler: %w", err))
+				continue
+			}
+			store.StoreVPARecommendations(db, summary, &vpa, objectID)
+		}
+	}
+}
+
+// workloadNamespaces returns the namespaces of the workloads a run has
+// gathered.
+func workloadNamespaces(db *sql.DB, runID int64) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT namespace FROM deployments WHERE run_id = ?
+		UNION
+		SELECT namespace FROM objects WHERE run_id = ? AND kind IN ('statefulset', 'daemonset', 'job', 'cronjob', 'deploymentconfig')
+		ORDER BY 1
+	`, runID, runID)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing gathered workloads: %v", err)
+	}
+	defer rows.Close()
+	var namespaces []string
+	for rows.Next() {
+		var namespace string
+		if err := rows.Scan(&namespace); err != nil {
+			return nil, fmt.Errorf("Error listing gathered workloads: %v", err)
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces, rows.Err()
+}
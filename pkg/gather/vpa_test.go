@@ -0,0 +1,33 @@
+package gather
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/store"
+)
+
+func TestProcessVPAs(t *testing.T) {
+	dyn := kubetest.NewDynamicClient(t, "testdata/vpa.yaml")
+	s := kubetest.NewStore(t)
+	summary := kubetest.NewRun(t, s)
+	store.StoreDeployment(s.DB, summary, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web"}})
+
+	processVPAs(context.Background(), dyn, s.DB, summary, nil)
+	if err := summary.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got := kubetest.Count(t, s.DB, "objects", "kind = 'verticalpodautoscaler'"); got != 1 {
+		t.Errorf("got %d VPAs, want only the gathered deployment's", got)
+	}
+	if got := kubetest.Count(t, s.DB, "vpa_recommendations", `vpa = 'web' AND update_mode = 'Recreate' AND container = 'web'
+		AND deployment_id = (SELECT id FROM deployments WHERE name = 'web')
+		AND target_cpu_millicores = 250 AND target_memory_bytes = 512 << 20
+		AND lower_bound_cpu_millicores = 100 AND upper_bound_cpu_millicores = 1000 AND upper_bound_memory_bytes = 2 << 30`); got != 1 {
+		t.Errorf("got %d recommendations for web linked to its deployment, want 1", got)
+	}
+}
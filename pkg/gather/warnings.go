@@ -0,0 +1,100 @@
+package gather
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sync"
+
+	"k8s.io/client-go/rest"
+
+	"kube-query/pkg/store"
+)
+
+// warningRecorder is the rest.WarningHandler of a gather's clients. It
+// collects the warnings the API server returns with responses, such as use
+// of a deprecated API or a policy violation, with the resource being
+// gathered when each was raised, and passes them on to be printed once each.
+type warningRecorder struct {
+	mu      sync.Mutex
+	next    rest.WarningHandler
+	current warningSource
+	// counts is how many responses raised each warning for each resource,
+	// and order the order they were first raised.
+	counts map[warningKey]int
+	order  []warningKey
+}
+
+// warningSource is the resource being gathered, or zero outside of one.
+type warningSource struct {
+	kind, namespace, name string
+}
+
+type warningKey struct {
+	warningSource
+	text string
+}
+
+func newWarningRecorder() *warningRecorder {
+	return &warningRecorder{
+		next:   rest.NewWarningWriter(os.Stderr, rest.WarningWriterOptions{Deduplicate: true}),
+		counts: map[warningKey]int{},
+	}
+}
+
+func (w *warningRecorder) HandleWarningHeader(code int, agent string, text string) {
+	// Only 299 warnings are meant for clients; the rest are ignored as
+	// client-go's own handlers do.
+	if code != 299 || text == "" {
+		return
+	}
+	w.mu.Lock()
+	key := warningKey{w.current, text}
+	if w.counts[key] == 0 {
+		w.order = append(w.order, key)
+	}
+	w.counts[key]++
+	w.mu.Unlock()
+	w.next.HandleWarningHeader(code, agent, text)
+}
+
+// track returns hooks that also note which resource is being gathered, so
+// warnings can be recorded against it.
+func (w *warningRecorder) track(hooks Hooks) Hooks {
+	start, done := hooks.OnResourceStart, hooks.OnResourceDone
+	hooks.OnResourceStart = func(kind, namespace, name string) {
+		w.mu.Lock()
+		w.current = warningSource{kind, namespace, name}
+		w.mu.Unlock()
+		if start != nil {
+			start(kind, namespace, name)
+		}
+	}
+	hooks.OnResourceDone = func(kind, namespace, name string) {
+		w.mu.Lock()
+		w.current = warningSource{}
+		w.mu.Unlock()
+		if done != nil {
+			done(kind, namespace, name)
+		}
+	}
+	return hooks
+}
+
+// store writes the warnings collected so far to the warnings table, and
+// counts them in the run.
+func (w *warningRecorder) store(db *sql.DB, summary *store.Run) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, key := range w.order {
+		_, err := store.ExecWrite(db, `
+			INSERT INTO warnings (run_id, kind, namespace, name, text, count) VALUES (?, ?, ?, ?, ?, ?)
+		`, summary.RunID, key.kind, key.namespace, key.name, key.text, w.counts[key])
+		if err != nil {
+			summary.AddError(fmt.Errorf("Error inserting warning into database: %w", err))
+			return
+		}
+		summary.Warnings += w.counts[key]
+	}
+	w.order, w.counts = nil, map[warningKey]int{}
+}
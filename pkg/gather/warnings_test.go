@@ -0,0 +1,39 @@
+package gather
+
+import (
+	"testing"
+
+	"k8s.io/client-go/rest"
+
+	"kube-query/internal/kubetest"
+)
+
+func TestWarningRecorder(t *testing.T) {
+	const deprecated = "policy/v1beta1 PodDisruptionBudget is deprecated in v1.21+, unavailable in v1.25+; use policy/v1 PodDisruptionBudget"
+	s := kubetest.NewStore(t)
+	summary := kubetest.NewRun(t, s)
+	warnings := newWarningRecorder()
+	warnings.next = rest.NoWarnings{}
+	hooks := warnings.track(Hooks{})
+
+	warnings.HandleWarningHeader(299, "-", "raised before any resource")
+	hooks.resourceStart("poddisruptionbudget", "prod", "web")
+	warnings.HandleWarningHeader(299, "-", deprecated)
+	warnings.HandleWarningHeader(299, "-", deprecated)
+	warnings.HandleWarningHeader(199, "-", "not for clients")
+	hooks.resourceDone("poddisruptionbudget", "prod", "web")
+	warnings.store(s.DB, summary)
+
+	if summary.Warnings != 3 {
+		t.Errorf("counted %d warnings, want 3", summary.Warnings)
+	}
+	if got := kubetest.Count(t, s.DB, "warnings", "run_id = ?", summary.RunID); got != 2 {
+		t.Errorf("warnings has %d rows, want 2", got)
+	}
+	if got := kubetest.Count(t, s.DB, "warnings", "run_id = ? AND kind = 'poddisruptionbudget' AND namespace = 'prod' AND name = 'web' AND text = ? AND count = 2", summary.RunID, deprecated); got != 1 {
+		t.Errorf("deprecation warning recorded %d times against the resource, want once", got)
+	}
+	if got := kubetest.Count(t, s.DB, "warnings", "run_id = ? AND kind = '' AND count = 1", summary.RunID); got != 1 {
+		t.Errorf("warning outside a resource recorded %d times, want once", got)
+	}
+}
@@ -0,0 +1,114 @@
+package gather
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// timeWindow limits logs and events to those of a period. A zero since or
+// until leaves that end open.
+type timeWindow struct {
+	since, until time.Time
+}
+
+// newTimeWindow parses --since and --until values, each an RFC 3339 time or
+// a duration such as 2h meaning that long before now.
+func newTimeWindow(since, until string, now time.Time) (timeWindow, error) {
+	var w timeWindow
+	var err error
+	if w.since, err = parseWindowTime(since, now); err != nil {
+		return timeWindow{}, fmt.Errorf("Invalid --since: %v", err)
+	}
+	if w.until, err = parseWindowTime(until, now); err != nil {
+		return timeWindow{}, fmt.Errorf("Invalid --until: %v", err)
+	}
+	if !w.since.IsZero() && !w.until.IsZero() && !w.until.After(w.since) {
+		return timeWindow{}, fmt.Errorf("Invalid time window: --until %s is not after --since %s", w.until.Format(time.RFC3339), w.since.Format(time.RFC3339))
+	}
+	return w, nil
+}
+
+func parseWindowTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("%q is negative; durations count back from now", value)
+		}
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a duration such as 2h nor an RFC 3339 time such as 2024-05-01T09:00:00Z", value)
+	}
+	return t, nil
+}
+
+// overlaps reports whether something happening from first to last falls at
+// least partly within the window.
+func (w timeWindow) overlaps(first, last time.Time) bool {
+	return (w.since.IsZero() || !last.Before(w.since)) && (w.until.IsZero() || !first.After(w.until))
+}
+
+// containsEvent reports whether an event was seen within the window. Events
+// repeating across its start or end are kept whole.
+func (w timeWindow) containsEvent(event *corev1.Event) bool {
+	first, last := event.FirstTimestamp.Time, event.LastTimestamp.Time
+	if first.IsZero() {
+		first = event.EventTime.Time
+	}
+	if event.Series != nil && event.Series.LastObservedTime.Time.After(last) {
+		last = event.Series.LastObservedTime.Time
+	}
+	if first.IsZero() {
+		first = event.CreationTimestamp.Time
+	}
+	if last.IsZero() {
+		last = first
+	}
+	return w.overlaps(first, last)
+}
+
+// keepsLine reports whether a log line was logged by the end of the window.
+// Lines before its start were not asked for, and lines without a timestamp
+// are kept.
+func (w timeWindow) keepsLine(line string) bool {
+	if w.until.IsZero() {
+		return true
+	}
+	ts, ok := lineTimestamp(line)
+	return !ok || !ts.After(w.until)
+}
+
+// logOptions says which of a container's log lines are gathered: at most
+// the last tail of them when tail is positive, logged within the window,
+// kept by lines, at least as severe as minLevel, thinned out by sampling.
+// Lines are stored as the records they make up.
+type logOptions struct {
+	tail     int64
+	window   timeWindow
+	lines    lineFilter
+	minLevel minLevel
+	sampling sampling
+	records  recordStart
+}
+
+// podLogOptions requests a container's timestamped logs, limited to the
+// last tail lines when it is positive, starting from the window's start if
+// it has one.
+func (o logOptions) podLogOptions(container string) *corev1.PodLogOptions {
+	options := &corev1.PodLogOptions{Container: container, Timestamps: true}
+	if o.tail > 0 {
+		tail := o.tail
+		options.TailLines = &tail
+	}
+	if !o.window.since.IsZero() {
+		since := metav1.NewTime(o.window.since)
+		options.SinceTime = &since
+	}
+	return options
+}
@@ -0,0 +1,62 @@
+package gather
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewTimeWindow(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	w, err := newTimeWindow("2h", "2024-05-01T11:30:00Z", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := now.Add(-2 * time.Hour); !w.since.Equal(want) {
+		t.Errorf("got since %s, want %s", w.since, want)
+	}
+	if want := now.Add(-30 * time.Minute); !w.until.Equal(want) {
+		t.Errorf("got until %s, want %s", w.until, want)
+	}
+
+	for _, tt := range []struct{ since, until string }{
+		{"yesterday", ""},
+		{"-2h", ""},
+		{"1h", "2h"},
+	} {
+		if _, err := newTimeWindow(tt.since, tt.until, now); err == nil {
+			t.Errorf("newTimeWindow(%q, %q) succeeded, want an error", tt.since, tt.until)
+		}
+	}
+}
+
+func TestTimeWindowEventsAndLines(t *testing.T) {
+	at := func(hour, minute int) metav1.Time {
+		return metav1.NewTime(time.Date(2024, 5, 1, hour, minute, 0, 0, time.UTC))
+	}
+	w := timeWindow{since: at(10, 0).Time, until: at(11, 0).Time}
+
+	for _, tt := range []struct {
+		name  string
+		event corev1.Event
+		want  bool
+	}{
+		{"before", corev1.Event{FirstTimestamp: at(9, 0), LastTimestamp: at(9, 30)}, false},
+		{"repeating into the window", corev1.Event{FirstTimestamp: at(9, 0), LastTimestamp: at(10, 15)}, true},
+		{"within", corev1.Event{FirstTimestamp: at(10, 30), LastTimestamp: at(10, 30)}, true},
+		{"after", corev1.Event{FirstTimestamp: at(11, 5), LastTimestamp: at(11, 5)}, false},
+		{"events.k8s.io series", corev1.Event{EventTime: metav1.NewMicroTime(at(9, 0).Time), Series: &corev1.EventSeries{LastObservedTime: metav1.NewMicroTime(at(10, 5).Time)}}, true},
+		{"creation time only", corev1.Event{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: at(11, 30)}}, false},
+	} {
+		if got := w.containsEvent(&tt.event); got != tt.want {
+			t.Errorf("%s: containsEvent = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	data := "2024-05-01T10:59:59Z before\nunstamped\n2024-05-01T11:00:01Z after\n"
+	if got, want := keptLines(data, w.keepsLine), "2024-05-01T10:59:59Z before\nunstamped\n"; got != want {
+		t.Errorf("kept %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,137 @@
+package gather
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"kube-query/pkg/store"
+)
+
+// processWorkloadPods stores the pods matching a workload's selector and
+// their logs, linked to the workload's objects row.
+func processWorkloadPods(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run, snap *listSnapshot, namespace, podSelector string, objectID int64, logs logOptions) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, snap.listOptions(metav1.ListOptions{LabelSelector: podSelector}))
+	if err != nil {
+		summary.AddResourceError(store.PhaseList, "pod", namespace, podSelector, fmt.Errorf("Error listing pods: %w", err))
+		return
+	}
+	snap.record("pod", namespace, podSelector, pods.ResourceVersion)
+	snap.cover(gatherScope{kind: "pod", namespace: namespace, selector: podSelector})
+
+	for _, pod := range pods.Items {
+		store.StorePod(db, summary, &pod, 0, objectID)
+		processPodLogs(ctx, clientset, db, summary, &pod, 0, logs)
+	}
+}
+
+// processStandalonePod stores a pod named directly, or found by a namespace
+// dump, with its logs. Pods already stored in this run as part of a workload
+// are skipped.
+func processStandalonePod(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run, namespace, name string, logs logOptions) {
+	if podStored(db, summary, namespace, name) {
+		return
+	}
+
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		summary.AddResourceError(store.PhaseFetch, "pod", namespace, name, fmt.Errorf("Error fetching pod: %w", err))
+		return
+	}
+	store.StorePod(db, summary, pod, 0, 0)
+	processPodLogs(ctx, clientset, db, summary, pod, 0, logs)
+}
+
+// processSelectedPods stores the pods matching a label selector with their
+// logs, whatever controls them, for pods:-l entries. A "*" namespace matches
+// pods in every namespace not denied. Pods already stored in this run are
+// skipped. It returns the namespaces pods were found in.
+func processSelectedPods(ctx context.Context, clientset kubernetes.Interface, db *sql.DB, summary *store.Run, snap *listSnapshot, deny namespaceDenyList, namespace, selector string, logs logOptions) []string {
+	listNamespace := namespace
+	if namespace == "*" {
+		listNamespace = metav1.NamespaceAll
+	}
+	pods, err := clientset.CoreV1().Pods(listNamespace).List(ctx, snap.listOptions(metav1.ListOptions{LabelSelector: selector}))
+	if err != nil {
+		summary.AddResourceError(store.PhaseList, "pod", namespace, selector, fmt.Errorf("Error listing pods: %w", err))
+		return nil
+	}
+	snap.record("pod", namespace, selector, pods.ResourceVersion)
+	scope := gatherScope{kind: "pod", namespace: namespace, selector: selector}
+	if namespace == "*" {
+		scope.deny = deny
+	}
+	snap.cover(scope)
+
+	var namespaces []string
+	found := map[string]bool{}
+	for _, pod := range pods.Items {
+		if ctx.Err() != nil {
+			break
+		}
+		if namespace == "*" && deny.denies(pod.Namespace, "pod") {
+			continue
+		}
+		if !found[pod.Namespace] {
+			found[pod.Namespace] = true
+			namespaces = append(namespaces, pod.Namespace)
+		}
+		if podStored(db, summary, pod.Namespace, pod.Name) {
+			continue
+		}
+		store.StorePod(db, summary, &pod, 0, 0)
+		processPodLogs(ctx, clientset, db, summary, &pod, 0, logs)
+	}
+	return namespaces
+}
+
+// podStored reports whether a pod was already stored in this run. Errors
+// are recorded and reported as stored, so the pod is left alone.
+func podStored(db *sql.DB, summary *store.Run, namespace, name string) bool {
+	var exists int
+	err := db.QueryRow(`SELECT COUNT(*) FROM pods WHERE run_id = ? AND namespace = ? AND name = ?`, summary.RunID, namespace, name).Scan(&exists)
+	if err != nil {
+		summary.AddResourceError(store.PhaseStore, "pod", namespace, name, fmt.Errorf("Error checking for stored pod: %w", err))
+		return true
+	}
+	return exists > 0
+}
+
+// PodReadiness returns the READY column ("ready/total" containers) and the
+// total restart count of a pod.
+func PodReadiness(status *corev1.PodStatus) (string, int32) {
+	ready, restarts := 0, int32(0)
+	for _, cs := range status.ContainerStatuses {
+		if cs.Ready {
+			ready++
+		}
+		restarts += cs.RestartCount
+	}
+	return fmt.Sprintf("%d/%d", ready, len(status.ContainerStatuses)), restarts
+}
+
+// PodStatusReason approximates the STATUS column of `kubectl get pods`.
+func PodStatusReason(meta *metav1.ObjectMeta, status *corev1.PodStatus) string {
+	reason := string(status.Phase)
+	if status.Reason != "" {
+		reason = status.Reason
+	}
+	for _, cs := range status.ContainerStatuses {
+		if cs.State.Waiting != nil && cs.State.Waiting.Reason != "" {
+			reason = cs.State.Waiting.Reason
+		} else if cs.State.Terminated != nil && cs.State.Terminated.Reason != "" {
+			reason = cs.State.Terminated.Reason
+		}
+	}
+	if meta.DeletionTimestamp != nil {
+		reason = "Terminating"
+	}
+	if reason == "" {
+		reason = "Unknown"
+	}
+	return reason
+}
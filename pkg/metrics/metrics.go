@@ -0,0 +1,212 @@
+// Package metrics keeps the process-wide metrics served at /metrics by the
+// long-running modes.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Process-wide metrics for long-running modes, exposed in the Prometheus text
+// format at /metrics.
+var (
+	ObjectsGathered = newMetric("kube_gather_objects_gathered_total", "counter",
+		"Objects gathered, by kind.", "kind")
+	Errors = newMetric("kube_gather_errors_total", "counter",
+		"Errors encountered while gathering.")
+	Runs = newMetric("kube_gather_runs_total", "counter",
+		"Gather runs completed.")
+	APIRequestDuration = newHistogram("kube_gather_api_request_duration_seconds",
+		"Latency of Kubernetes API requests, by verb and status code.", "verb", "code")
+	DBWriteDuration = newHistogram("kube_gather_db_write_duration_seconds",
+		"Latency of database writes.")
+	BundleSize = newMetric("kube_gather_bundle_size_bytes", "gauge",
+		"Size of the gather database file.")
+)
+
+var allMetrics = []metricWriter{
+	ObjectsGathered,
+	Errors,
+	Runs,
+	APIRequestDuration,
+	DBWriteDuration,
+	BundleSize,
+}
+
+type metricWriter interface {
+	write(w io.Writer)
+}
+
+// Metric is a counter or gauge with optional labels.
+type Metric struct {
+	name   string
+	kind   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newMetric(name, kind, help string, labels ...string) *Metric {
+	m := &Metric{name: name, kind: kind, help: help, labels: labels, values: map[string]float64{}}
+	if len(labels) == 0 {
+		m.values[""] = 0
+	}
+	return m
+}
+
+func (m *Metric) Add(delta float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[formatLabels(m.labels, labelValues)] += delta
+}
+
+func (m *Metric) Set(value float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[formatLabels(m.labels, labelValues)] = value
+}
+
+func (m *Metric) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	for _, labels := range sortedMetricKeys(m.values) {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, labels, formatFloat(m.values[labels]))
+	}
+}
+
+var defaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram tracks observations in cumulative buckets, as Prometheus
+// histograms do.
+type Histogram struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+func newHistogram(name, help string, labels ...string) *Histogram {
+	return &Histogram{name: name, help: help, labels: labels, series: map[string]*histogramSeries{}}
+}
+
+func (h *Histogram) Observe(d time.Duration, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := formatLabels(h.labels, labelValues)
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labelValues: labelValues, counts: make([]uint64, len(defaultBuckets))}
+		h.series[key] = s
+	}
+	seconds := d.Seconds()
+	for i, bound := range defaultBuckets {
+		if seconds <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += seconds
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		bucketLabels := append(append([]string{}, h.labels...), "le")
+		for i, bound := range defaultBuckets {
+			labels := formatLabels(bucketLabels, append(append([]string{}, s.labelValues...), formatFloat(bound)))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, s.counts[i])
+		}
+		labels := formatLabels(bucketLabels, append(append([]string{}, s.labelValues...), "+Inf"))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, s.count)
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = fmt.Sprintf("%s=%q", name, value)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedMetricKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Handler serves every registered metric. refresh, if set, is called
+// before each scrape to update gauges that are sampled rather than tracked.
+func Handler(refresh func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if refresh != nil {
+			refresh()
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, m := range allMetrics {
+			m.write(w)
+		}
+	}
+}
+
+// Transport records the latency of every Kubernetes API request.
+type Transport struct {
+	Next http.RoundTripper
+}
+
+func (t Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.Next.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	APIRequestDuration.Observe(time.Since(start), req.Method, code)
+	return resp, err
+}
@@ -0,0 +1,83 @@
+// Package reader reads the runs of kube-gather databases, for tools that
+// consume gathered data without depending on the database schema.
+//
+//	run, err := reader.OpenRun("kube_data.db", 0)
+//	...
+//	defer run.Close()
+//	deployments, err := run.ListResources("deployment")
+package reader
+
+import (
+	"database/sql"
+	"fmt"
+
+	"kube-query/pkg/store"
+)
+
+// Run is a gathered run, opened read-only. Its RunInfo holds when it was
+// gathered and its stored summary, if any.
+type Run struct {
+	store.RunInfo
+	db *sql.DB
+}
+
+// OpenRun opens a run of the database at path, or its latest run if runID
+// is 0. The database is not written to, and may be in use by a gather.
+func OpenRun(path string, runID int64) (*Run, error) {
+	db, err := store.OpenReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	if runID == 0 {
+		if runID, err = store.LatestRunID(db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	info, err := store.GetRun(db, runID)
+	if err != nil {
+		db.Close()
+		if err == store.ErrNotFound {
+			return nil, fmt.Errorf("Error opening run %d: %w", runID, err)
+		}
+		return nil, err
+	}
+	return &Run{RunInfo: *info, db: db}, nil
+}
+
+// Close closes the database.
+func (r *Run) Close() error {
+	return r.db.Close()
+}
+
+// ListResources lists the resources of a kind gathered in the run, or of
+// every kind if kind is "". Their content is left out; GetObject reads it.
+func (r *Run) ListResources(kind string) ([]store.Resource, error) {
+	return store.ListResources(r.db, r.ID, kind)
+}
+
+// GetObject returns a gathered resource with its content: the metadata,
+// spec and status, or data, as gathered. It returns an error wrapping
+// store.ErrNotFound if the resource wasn't gathered in the run.
+func (r *Run) GetObject(kind, namespace, name string) (*store.Resource, error) {
+	resource, err := store.GetResource(r.db, r.ID, kind, namespace, name)
+	if err == store.ErrNotFound {
+		return nil, fmt.Errorf("Error reading %s %s/%s: %w", kind, namespace, name, err)
+	}
+	return resource, err
+}
+
+// StreamLogs passes each log line gathered from a pod to fn in the order it
+// was logged, stopping at the first error fn returns.
+func (r *Run) StreamLogs(namespace, pod string, fn func(store.LogLine) error) error {
+	return store.ForEachLogLine(r.db, r.ID, namespace, pod, fn)
+}
+
+// Diff lists the resources added, removed and changed between this run and
+// another run of the same database.
+func (r *Run) Diff(toRunID int64) (*store.RunDiff, error) {
+	if _, err := store.GetRun(r.db, toRunID); err != nil {
+		return nil, fmt.Errorf("Error reading run %d: %w", toRunID, err)
+	}
+	return store.DiffRuns(r.db, r.ID, toRunID)
+}
@@ -0,0 +1,80 @@
+package reader
+
+import (
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/store"
+)
+
+func TestRun(t *testing.T) {
+	s := kubetest.NewStore(t)
+	first := kubetest.NewRun(t, s)
+	store.StoreConfigMap(s.DB, first, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web-config"},
+		Data:       map[string]string{"LOG_LEVEL": "info"},
+	})
+	second := kubetest.NewRun(t, s)
+	store.StoreConfigMap(s.DB, second, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web-config"},
+		Data:       map[string]string{"LOG_LEVEL": "debug"},
+	})
+	if err := store.StoreLogLines(s.DB, second.RunID, 0, "prod", "web", []byte("starting\nlistening on :8080\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	run, err := OpenRun(s.Path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer run.Close()
+	if run.ID != second.RunID {
+		t.Errorf("opened run %d, want the latest, %d", run.ID, second.RunID)
+	}
+
+	resources, err := run.ListResources("configmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 1 || resources[0].Name != "web-config" {
+		t.Errorf("ListResources = %+v, want web-config", resources)
+	}
+
+	object, err := run.GetObject("configmap", "prod", "web-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(object.Content["data"]); got != `{"LOG_LEVEL":"debug"}` {
+		t.Errorf("GetObject data = %s", got)
+	}
+	if _, err := run.GetObject("configmap", "prod", "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetObject of a missing object returned %v, want ErrNotFound", err)
+	}
+
+	var lines []string
+	err = run.StreamLogs("prod", "web", func(l store.LogLine) error {
+		lines = append(lines, l.Line)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[1] != "listening on :8080" {
+		t.Errorf("StreamLogs = %q", lines)
+	}
+
+	diff, err := run.Diff(first.RunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].Name != "web-config" {
+		t.Errorf("Diff = %+v, want web-config changed", diff)
+	}
+	if _, err := run.Diff(99); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Diff with a missing run returned %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Alert is an alert as Alertmanager's webhook receiver sends it.
+type Alert struct {
+	// Status is firing or resolved.
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// StoreAlert records an alert, with its labels and annotations, against the
+// run gathered because it fired.
+func StoreAlert(db *sql.DB, runID int64, alert Alert) error {
+	labels, err := json.Marshal(alert.Labels)
+	if err != nil {
+		return fmt.Errorf("Error marshaling alert labels: %v", err)
+	}
+	annotations, err := json.Marshal(alert.Annotations)
+	if err != nil {
+		return fmt.Errorf("Error marshaling alert annotations: %v", err)
+	}
+	var startsAt sql.NullTime
+	if !alert.StartsAt.IsZero() {
+		startsAt = sql.NullTime{Time: alert.StartsAt.UTC(), Valid: true}
+	}
+	_, err = ExecWrite(db, `
+		INSERT INTO alerts (run_id, fingerprint, alertname, status, namespace, starts_at, generator_url, labels, annotations)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, runID, alert.Fingerprint, alert.Labels["alertname"], alert.Status, alert.Labels["namespace"], startsAt, alert.GeneratorURL, string(labels), string(annotations))
+	if err != nil {
+		return fmt.Errorf("Error inserting alert into database: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// OpenAttached opens several gather databases read-only as one, for queries
+// comparing them. Each is attached under a name made from its file name
+// (prod and staging for prod.db and staging.db, numbered if two collide),
+// where its tables can be named as prod.pods, and every table is a view of
+// the rows of all of them with a db column naming the database each row came
+// from. Columns a database's table lacks, as older ones may, are NULL in its
+// rows. Full-text indexes are left out of the views. Row IDs, run IDs among
+// them, repeat across databases, so joins must match db as well.
+func OpenAttached(dbFiles []string) (*sql.DB, error) {
+	for _, dbFile := range dbFiles {
+		if _, err := os.Stat(dbFile); err != nil {
+			return nil, fmt.Errorf("Error opening database: %v", err)
+		}
+	}
+	db, err := sql.Open("sqlite3", "file::memory:")
+	if err != nil {
+		return nil, fmt.Errorf("Error opening database: %v", err)
+	}
+	// Attached databases and temporary views belong to a connection.
+	db.SetMaxOpenConns(1)
+
+	used := map[string]bool{"main": true, "temp": true}
+	var names []string
+	for _, dbFile := range dbFiles {
+		name := attachName(dbFile, used)
+		if _, err := db.Exec(`ATTACH DATABASE ? AS `+quoteIdent(name), "file:"+dbFile+"?mode=ro"); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("Error attaching %s: %v", dbFile, err)
+		}
+		names = append(names, name)
+	}
+
+	// The columns of each table, in the order first seen, and which of them
+	// each database has.
+	var tables []string
+	columns := map[string][]string{}
+	has := map[string]map[string]map[string]bool{}
+	for _, name := range names {
+		tableColumns, err := attachedTables(db, name)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		for table, cols := range tableColumns {
+			if _, ok := has[table]; !ok {
+				tables = append(tables, table)
+				has[table] = map[string]map[string]bool{}
+			}
+			has[table][name] = map[string]bool{}
+			for _, col := range cols {
+				if !containsString(columns[table], col) {
+					columns[table] = append(columns[table], col)
+				}
+				has[table][name][col] = true
+			}
+		}
+	}
+
+	for _, table := range tables {
+		var selects []string
+		for _, name := range names {
+			dbColumns, ok := has[table][name]
+			if !ok {
+				continue
+			}
+			fields := []string{"'" + name + "' AS db"}
+			for _, col := range columns[table] {
+				if dbColumns[col] {
+					fields = append(fields, quoteIdent(col))
+				} else {
+					fields = append(fields, "NULL AS "+quoteIdent(col))
+				}
+			}
+			selects = append(selects, "SELECT "+strings.Join(fields, ", ")+" FROM "+quoteIdent(name)+"."+quoteIdent(table))
+		}
+		if _, err := db.Exec(`CREATE TEMP VIEW ` + quoteIdent(table) + ` AS ` + strings.Join(selects, " UNION ALL ")); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("Error creating view of %s: %v", table, err)
+		}
+	}
+	return db, nil
+}
+
+// attachedTables returns the columns of each table of an attached database,
+// leaving out virtual tables, such as the full-text index, and theirs.
+func attachedTables(db *sql.DB, name string) (map[string][]string, error) {
+	rows, err := db.Query(`SELECT name, COALESCE(sql, '') FROM ` + quoteIdent(name) + `.sqlite_master WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing tables of %s: %v", name, err)
+	}
+	var tables, virtual []string
+	for rows.Next() {
+		var table, definition string
+		if err := rows.Scan(&table, &definition); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("Error listing tables of %s: %v", name, err)
+		}
+		if strings.HasPrefix(strings.ToUpper(definition), "CREATE VIRTUAL TABLE") {
+			virtual = append(virtual, table)
+			continue
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Error listing tables of %s: %v", name, err)
+	}
+
+	columns := map[string][]string{}
+	for _, table := range tables {
+		shadow := false
+		for _, v := range virtual {
+			shadow = shadow || strings.HasPrefix(table, v+"_")
+		}
+		if shadow {
+			continue
+		}
+		cols, err := attachedColumns(db, name, table)
+		if err != nil {
+			return nil, err
+		}
+		columns[table] = cols
+	}
+	return columns, nil
+}
+
+// attachedColumns returns the names of the columns of an attached
+// database's table.
+func attachedColumns(db *sql.DB, name, table string) ([]string, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?, ?)`, table, name)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading columns of %s.%s: %v", name, table, err)
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, fmt.Errorf("Error reading columns of %s.%s: %v", name, table, err)
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// attachName makes the name a database is attached as from its file name,
+// unique among those used.
+func attachName(dbFile string, used map[string]bool) string {
+	base := strings.TrimSuffix(filepath.Base(dbFile), filepath.Ext(dbFile))
+	name := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return '_'
+	}, base)
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "db_" + name
+	}
+	unique := name
+	for i := 2; used[strings.ToLower(unique)]; i++ {
+		unique = fmt.Sprintf("%s_%d", name, i)
+	}
+	used[strings.ToLower(unique)] = true
+	return unique
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package store_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/store"
+)
+
+func TestOpenAttached(t *testing.T) {
+	var files []string
+	for _, cluster := range []string{"prod", "staging"} {
+		s, err := store.Open(filepath.Join(t.TempDir(), cluster+".db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		run := kubetest.NewRun(t, s)
+		store.StorePod(s.DB, run, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web-" + cluster}}, 0, 0)
+		if err := store.StoreLogLines(s.DB, run.RunID, 0, "shop", "web-"+cluster, []byte("2024-05-15T10:30:00Z started\n")); err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+		files = append(files, s.Path)
+	}
+	// Databases of the same name are numbered apart.
+	files = append(files, files[0])
+
+	db, err := store.OpenAttached(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT db, name FROM pods ORDER BY db`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var name, pod string
+		if err := rows.Scan(&name, &pod); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, name+"/"+pod)
+	}
+	want := []string{"prod/web-prod", "prod_2/web-prod", "staging/web-staging"}
+	if len(got) != len(want) {
+		t.Fatalf("got pods %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got pods %v, want %v", got, want)
+			break
+		}
+	}
+
+	if n := kubetest.Count(t, db, "staging.log_lines", ""); n != 1 {
+		t.Errorf("got %d log lines in staging, want 1", n)
+	}
+	if n := kubetest.Count(t, db, "log_lines", "line = 'started'"); n != 3 {
+		t.Errorf("got %d log lines across databases, want 3", n)
+	}
+}
+
+func TestOpenAttachedOverlappingIDs(t *testing.T) {
+	var files []string
+	for _, cluster := range []string{"prod", "staging"} {
+		s, err := store.Open(filepath.Join(t.TempDir(), cluster+".db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		run := kubetest.NewRun(t, s)
+		deploymentID := store.StoreDeployment(s.DB, run, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web-" + cluster}})
+		store.StorePod(s.DB, run, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web-" + cluster + "-0"}}, deploymentID, 0)
+		s.Close()
+		files = append(files, s.Path)
+	}
+
+	db, err := store.OpenAttached(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Both databases number their run, deployment and pod 1.
+	var runs, deployments int
+	if err := db.QueryRow(`SELECT COUNT(DISTINCT id), COUNT(*) FROM runs`).Scan(&runs, new(int)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`SELECT COUNT(DISTINCT id) FROM deployments`).Scan(&deployments); err != nil {
+		t.Fatal(err)
+	}
+	if runs != 1 || deployments != 1 {
+		t.Fatalf("got %d run IDs and %d deployment IDs across both databases, want 1 each", runs, deployments)
+	}
+
+	var naive, joined int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pods p JOIN deployments d ON d.id = p.deployment_id`).Scan(&naive); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`
+		SELECT COUNT(*) FROM pods p JOIN deployments d ON d.db = p.db AND d.id = p.deployment_id
+		WHERE d.name || '-0' = p.name
+	`).Scan(&joined); err != nil {
+		t.Fatal(err)
+	}
+	if naive != 4 || joined != 2 {
+		t.Errorf("got %d pods joined on ID alone and %d on db and ID, want 4 mixing the databases and 2", naive, joined)
+	}
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// ErrNoEncryption is returned opening an encrypted database when SQLite was
+// built without SQLCipher, which would otherwise ignore the key and write the
+// database in plaintext.
+var ErrNoEncryption = errors.New("encryption needs kube-gather linked against SQLCipher instead of SQLite")
+
+// OpenEncrypted opens the database at path for writing as Open does, but
+// encrypted by SQLCipher with passphrase. Every page is encrypted, so that no
+// gathered data is written to disk in plaintext.
+func OpenEncrypted(path, passphrase string) (*Store, error) {
+	db, err := openKeyed(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if err := Initialize(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Error initializing database: %v", err)
+	}
+	return &Store{DB: db, Path: path}, nil
+}
+
+// OpenReadOnlyEncrypted opens an existing database encrypted with passphrase
+// without allowing writes.
+func OpenReadOnlyEncrypted(dbFile, passphrase string) (*sql.DB, error) {
+	if _, err := os.Stat(dbFile); err != nil {
+		return nil, fmt.Errorf("Error opening database: %v", err)
+	}
+	db, err := openKeyed("file:"+dbFile+"?mode=ro", passphrase)
+	if err != nil {
+		return nil, err
+	}
+	// A wrong passphrase only shows once a page is read.
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master`).Scan(&n); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Error opening database, is the passphrase right? %v", err)
+	}
+	return db, nil
+}
+
+// openKeyed opens a database whose every connection is keyed with
+// passphrase before it is used, checking that SQLite is SQLCipher.
+func openKeyed(dsn, passphrase string) (*sql.DB, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("Error opening database: empty passphrase")
+	}
+	key := "PRAGMA key = '" + strings.ReplaceAll(passphrase, "'", "''") + "'"
+	db := sql.OpenDB(connector{dsn: dsn, driver: &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			_, err := conn.Exec(key, nil)
+			return err
+		},
+	}})
+
+	// Plain SQLite ignores the key pragma and has no cipher_version.
+	var version string
+	err := db.QueryRow(`PRAGMA cipher_version`).Scan(&version)
+	if err == sql.ErrNoRows {
+		err = ErrNoEncryption
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Error opening database: %w", err)
+	}
+	return db, nil
+}
+
+// connector opens connections to dsn with driver, whose ConnectHook differs
+// from that of the registered sqlite3 driver.
+type connector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+}
+
+func (c connector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c connector) Driver() driver.Driver {
+	return c.driver
+}
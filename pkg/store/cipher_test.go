@@ -0,0 +1,47 @@
+package store_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/store"
+)
+
+func TestOpenEncrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kube_data.db")
+	s, err := store.OpenEncrypted(path, "it's a secret")
+	if errors.Is(err, store.ErrNoEncryption) {
+		t.Skip("SQLite is not SQLCipher")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := kubetest.NewRun(t, s)
+	store.StorePod(s.DB, run, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web"}}, 0, 0)
+	s.Close()
+
+	if db, err := store.OpenReadOnly(path); err == nil {
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM pods`).Scan(&n); err == nil {
+			t.Errorf("read %d pods without the passphrase", n)
+		}
+		db.Close()
+	}
+	if _, err := store.OpenReadOnlyEncrypted(path, "wrong"); err == nil {
+		t.Errorf("opened with the wrong passphrase")
+	}
+
+	db, err := store.OpenReadOnlyEncrypted(path, "it's a secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if n := kubetest.Count(t, db, "pods", "name = 'web'"); n != 1 {
+		t.Errorf("got %d pods named web, want 1", n)
+	}
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// StoreCSRStatus stores a CertificateSigningRequest's requester, signer and
+// state as a row of csr_status, linked to its row in objects. Its state is
+// Denied or Failed if it has that condition, else Issued once approved and
+// signed, Approved once approved, and Pending before: kubelets whose
+// certificates fail to rotate leave Pending or Denied requests behind.
+func StoreCSRStatus(db *sql.DB, summary *Run, csr *certificatesv1.CertificateSigningRequest, objectID int64) {
+	conditions := map[certificatesv1.RequestConditionType]certificatesv1.CertificateSigningRequestCondition{}
+	for _, c := range csr.Status.Conditions {
+		if c.Status == "" || c.Status == corev1.ConditionTrue {
+			conditions[c.Type] = c
+		}
+	}
+	_, approved := conditions[certificatesv1.CertificateApproved]
+	_, denied := conditions[certificatesv1.CertificateDenied]
+	_, failed := conditions[certificatesv1.CertificateFailed]
+
+	state, decided := "Pending", certificatesv1.CertificateSigningRequestCondition{}
+	switch {
+	case denied:
+		state, decided = "Denied", conditions[certificatesv1.CertificateDenied]
+	case failed:
+		state, decided = "Failed", conditions[certificatesv1.CertificateFailed]
+	case approved && len(csr.Status.Certificate) > 0:
+		state, decided = "Issued", conditions[certificatesv1.CertificateApproved]
+	case approved:
+		state, decided = "Approved", conditions[certificatesv1.CertificateApproved]
+	}
+	var decidedAt sql.NullTime
+	if !decided.LastUpdateTime.IsZero() {
+		decidedAt = sql.NullTime{Time: decided.LastUpdateTime.UTC(), Valid: true}
+	}
+	usages := make([]string, 0, len(csr.Spec.Usages))
+	for _, u := range csr.Spec.Usages {
+		usages = append(usages, string(u))
+	}
+	var duration sql.NullInt64
+	if csr.Spec.ExpirationSeconds != nil {
+		duration = sql.NullInt64{Int64: int64(*csr.Spec.ExpirationSeconds), Valid: true}
+	}
+
+	_, err := ExecWrite(db, `
+		INSERT INTO csr_status (run_id, object_id, name, signer_name, username, usages, expiration_seconds, created_at,
+			state, approved, denied, failed, reason, message, decided_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, summary.RunID, nullID(objectID), csr.Name, csr.Spec.SignerName, csr.Spec.Username, strings.Join(usages, ","), duration, csr.CreationTimestamp.UTC(),
+		state, approved, denied, failed, decided.Reason, decided.Message, decidedAt)
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "certificatesigningrequest", "", csr.Name, fmt.Errorf("Error inserting CSR status into database: %w", err))
+	}
+}
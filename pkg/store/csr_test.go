@@ -0,0 +1,69 @@
+package store_test
+
+import (
+	"testing"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/store"
+)
+
+func TestStoreCSRStatus(t *testing.T) {
+	approved := certificatesv1.CertificateSigningRequestCondition{Type: certificatesv1.CertificateApproved, Status: corev1.ConditionTrue, Reason: "AutoApproved"}
+	tests := []struct {
+		name       string
+		status     certificatesv1.CertificateSigningRequestStatus
+		wantState  string
+		wantReason string
+	}{
+		{name: "csr-pending", wantState: "Pending"},
+		{name: "csr-approved", status: certificatesv1.CertificateSigningRequestStatus{Conditions: []certificatesv1.CertificateSigningRequestCondition{approved}}, wantState: "Approved", wantReason: "AutoApproved"},
+		{name: "csr-issued", status: certificatesv1.CertificateSigningRequestStatus{Conditions: []certificatesv1.CertificateSigningRequestCondition{approved}, Certificate: []byte("-----BEGIN CERTIFICATE-----")}, wantState: "Issued", wantReason: "AutoApproved"},
+		{name: "csr-denied", status: certificatesv1.CertificateSigningRequestStatus{Conditions: []certificatesv1.CertificateSigningRequestCondition{
+			{Type: certificatesv1.CertificateDenied, Status: corev1.ConditionTrue, Reason: "NodeNameMismatch", Message: "node name does not match the requesting user"},
+		}}, wantState: "Denied", wantReason: "NodeNameMismatch"},
+		{name: "csr-failed", status: certificatesv1.CertificateSigningRequestStatus{Conditions: []certificatesv1.CertificateSigningRequestCondition{
+			approved, {Type: certificatesv1.CertificateFailed, Status: corev1.ConditionTrue, Reason: "SignerError"},
+		}}, wantState: "Failed", wantReason: "SignerError"},
+	}
+
+	s := kubetest.NewStore(t)
+	run := kubetest.NewRun(t, s)
+	for _, tt := range tests {
+		csr := &certificatesv1.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: tt.name},
+			Spec: certificatesv1.CertificateSigningRequestSpec{
+				SignerName: certificatesv1.KubeletServingSignerName,
+				Username:   "system:node:worker-1",
+				Usages:     []certificatesv1.KeyUsage{certificatesv1.UsageDigitalSignature, certificatesv1.UsageServerAuth},
+			},
+			Status: tt.status,
+		}
+		objectID, err := store.StoreObject(s.DB, run, "certificatesigningrequest", &csr.ObjectMeta, csr.Spec, csr.Status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.StoreCSRStatus(s.DB, run, csr, objectID)
+	}
+	if err := run.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var state, reason, usages string
+			err := s.DB.QueryRow(`
+				SELECT c.state, c.reason, c.usages FROM csr_status c JOIN objects o ON c.object_id = o.id WHERE o.name = ?
+			`, tt.name).Scan(&state, &reason, &usages)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state != tt.wantState || reason != tt.wantReason || usages != "digital signature,server auth" {
+				t.Errorf("got state %s, reason %q, usages %q, want %s, %q", state, reason, usages, tt.wantState, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+)
+
+// The phases of gathering a resource that a GatherError can fail in.
+const (
+	PhaseList   = "list"
+	PhaseFetch  = "fetch"
+	PhaseDecode = "decode"
+	PhaseLogs   = "logs"
+	PhaseExec   = "exec"
+	PhaseStore  = "store"
+	PhasePlugin = "plugin"
+)
+
+// GatherError is an error that failed the gathering of one resource, which
+// is recorded in the gather_errors table. Namespace and Name are "" when the
+// failure was not confined to one object, such as a failed list.
+type GatherError struct {
+	Phase     string
+	Kind      string
+	Namespace string
+	Name      string
+	Err       error
+}
+
+func (e *GatherError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *GatherError) Unwrap() error {
+	return e.Err
+}
+
+// AddResourceError adds an error that failed the gathering of a resource
+// in the given phase.
+func (s *Run) AddResourceError(phase, kind, namespace, name string, err error) {
+	s.AddError(&GatherError{Phase: phase, Kind: kind, Namespace: namespace, Name: name, Err: err})
+}
+
+// storeGatherError records an error of the run in the gather_errors table.
+// Errors not about a resource are recorded with only their text. Failing to
+// record one is logged rather than reported, which could recurse.
+func storeGatherError(db *sql.DB, runID int64, err error) {
+	var gatherErr *GatherError
+	if !errors.As(err, &gatherErr) {
+		gatherErr = &GatherError{}
+	}
+	_, insertErr := ExecWrite(db, `
+		INSERT INTO gather_errors (run_id, namespace, kind, name, phase, error) VALUES (?, ?, ?, ?, ?, ?)
+	`, runID, gatherErr.Namespace, gatherErr.Kind, gatherErr.Name, gatherErr.Phase, err.Error())
+	if insertErr != nil {
+		log.Printf("Error inserting gather error into database: %v\n", insertErr)
+	}
+}
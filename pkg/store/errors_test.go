@@ -0,0 +1,39 @@
+package store_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/store"
+)
+
+func TestAddResourceError(t *testing.T) {
+	s := kubetest.NewStore(t)
+	run := kubetest.NewRun(t, s)
+	run.OnError = func(error) {}
+
+	notFound := errors.New(`deployments.apps "api" not found`)
+	run.AddResourceError(store.PhaseFetch, "deployment", "prod", "api", notFound)
+	run.AddResourceError(store.PhaseLogs, "pod", "prod", "web-5d9c7-abcde", errors.New("container is waiting to start"))
+	run.AddResourceError(store.PhaseLogs, "pod", "prod", "web-5d9c7-fghij", errors.New("container is waiting to start"))
+	run.AddError(errors.New("Error connecting to kubelet"))
+
+	if !errors.Is(run.Err(), notFound) {
+		t.Errorf("run error %v does not wrap the fetch error", run.Err())
+	}
+	if got := kubetest.Count(t, s.DB, "gather_errors", "run_id = ?", run.RunID); got != 4 {
+		t.Errorf("gather_errors has %d rows, want 4", got)
+	}
+	if got := kubetest.Count(t, s.DB, "gather_errors", "run_id = ? AND phase = 'fetch' AND kind = 'deployment' AND namespace = 'prod' AND name = 'api' AND error = ?", run.RunID, notFound.Error()); got != 1 {
+		t.Errorf("fetch error recorded %d times, want once", got)
+	}
+
+	var out bytes.Buffer
+	run.Print(&out)
+	if want := "Errors:     4 (fetch=1 logs=2)"; !strings.Contains(out.String(), want) {
+		t.Errorf("summary has no %q:\n%s", want, out.String())
+	}
+}
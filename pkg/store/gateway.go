@@ -0,0 +1,81 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HTTPRoute is the part of a Gateway API HTTPRoute the route_backends table
+// is extracted from. The Gateway API's own types aren't a dependency, and
+// routes are gathered through the dynamic client like any custom resource.
+type HTTPRoute struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              HTTPRouteSpec `json:"spec,omitempty"`
+}
+
+type HTTPRouteSpec struct {
+	ParentRefs []RouteReference `json:"parentRefs,omitempty"`
+	Hostnames  []string         `json:"hostnames,omitempty"`
+	Rules      []HTTPRouteRule  `json:"rules,omitempty"`
+}
+
+type HTTPRouteRule struct {
+	BackendRefs []BackendRef `json:"backendRefs,omitempty"`
+}
+
+// RouteReference refers to a route's parent Gateway, or one of its
+// listeners by SectionName.
+type RouteReference struct {
+	Group       *string `json:"group,omitempty"`
+	Kind        *string `json:"kind,omitempty"`
+	Namespace   *string `json:"namespace,omitempty"`
+	Name        string  `json:"name"`
+	SectionName *string `json:"sectionName,omitempty"`
+}
+
+// BackendRef is where a rule sends its share of a route's requests: a
+// Service unless Kind says otherwise.
+type BackendRef struct {
+	Group     *string `json:"group,omitempty"`
+	Kind      *string `json:"kind,omitempty"`
+	Namespace *string `json:"namespace,omitempty"`
+	Name      string  `json:"name"`
+	Port      *int32  `json:"port,omitempty"`
+	Weight    *int32  `json:"weight,omitempty"`
+}
+
+// StoreRouteBackends stores each backend of an HTTPRoute's rules as a row of
+// route_backends, linked to the route's row in objects, so routes can be
+// joined to the Services they send requests to. Backends default to
+// Services in the route's namespace, and weights to 1.
+func StoreRouteBackends(db *sql.DB, summary *Run, route *HTTPRoute, objectID int64) {
+	for i, rule := range route.Spec.Rules {
+		for _, backend := range rule.BackendRefs {
+			kind, namespace, weight := "Service", route.Namespace, int32(1)
+			if backend.Kind != nil {
+				kind = *backend.Kind
+			}
+			if backend.Namespace != nil {
+				namespace = *backend.Namespace
+			}
+			if backend.Weight != nil {
+				weight = *backend.Weight
+			}
+			var port sql.NullInt32
+			if backend.Port != nil {
+				port = sql.NullInt32{Int32: *backend.Port, Valid: true}
+			}
+			_, err := ExecWrite(db, `
+				INSERT INTO route_backends (run_id, object_id, namespace, route, rule, backend_kind, backend_namespace, backend_name, port, weight)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, summary.RunID, nullID(objectID), route.Namespace, route.Name, i, kind, namespace, backend.Name, port, weight)
+			if err != nil {
+				summary.AddResourceError(PhaseStore, "httproute", route.Namespace, route.Name, fmt.Errorf("Error inserting route backend into database: %w", err))
+				return
+			}
+		}
+	}
+}
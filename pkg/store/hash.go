@@ -0,0 +1,19 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// ContentHash returns the hash stored with a resource in its content_hash
+// column: the SHA-256 of its content columns other than metadata, as stored
+// and in table order, joined by NUL bytes, with NULL columns as "". Stored
+// JSON is canonical (struct fields in declaration order, map keys sorted), so
+// resources with identical content have identical hashes across runs.
+// Metadata is left out since fields like resourceVersion change on every
+// write without the object meaningfully changing.
+func ContentHash(columns ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(columns, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,55 @@
+package store_test
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/store"
+)
+
+func TestRunContentHashes(t *testing.T) {
+	s := kubetest.NewStore(t)
+	configMap := func(resourceVersion, logLevel string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web-config", ResourceVersion: resourceVersion},
+			Data:       map[string]string{"LOG_LEVEL": logLevel, "PORT": "8080"},
+		}
+	}
+	key := store.ResourceKey{Kind: "configmap", Namespace: "prod", Name: "web-config"}
+
+	hashes := make([]map[store.ResourceKey]string, 3)
+	for i, cm := range []*corev1.ConfigMap{configMap("1", "info"), configMap("2", "info"), configMap("3", "debug")} {
+		run := kubetest.NewRun(t, s)
+		store.StoreConfigMap(s.DB, run, cm)
+		var err error
+		if hashes[i], err = store.RunContentHashes(s.DB, run.RunID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hashes[0][key] == "" {
+		t.Fatalf("no hash for %v in %v", key, hashes[0])
+	}
+	if hashes[0][key] != hashes[1][key] {
+		t.Errorf("metadata-only change altered the hash: %s != %s", hashes[0][key], hashes[1][key])
+	}
+	if hashes[1][key] == hashes[2][key] {
+		t.Errorf("data change kept the hash %s", hashes[1][key])
+	}
+
+	// Rows stored before hashes were get the same hash from their content.
+	run := kubetest.NewRun(t, s)
+	store.StoreConfigMap(s.DB, run, configMap("4", "info"))
+	if _, err := s.DB.Exec(`UPDATE configmaps SET content_hash = NULL WHERE run_id = ?`, run.RunID); err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := store.RunContentHashes(s.DB, run.RunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacy[key] != hashes[0][key] {
+		t.Errorf("hash computed from content is %s, want the stored %s", legacy[key], hashes[0][key])
+	}
+}
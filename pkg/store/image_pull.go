@@ -0,0 +1,78 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// imagePullReasons are the reasons a container waits on its image rather
+// than on anything else.
+var imagePullReasons = map[string]bool{
+	"ErrImagePull":      true,
+	"ImagePullBackOff":  true,
+	"InvalidImageName":  true,
+	"ErrImageNeverPull": true,
+}
+
+// imagePullEvent matches the kubelet's events about a pull failing, naming
+// the image and, for the failure itself, the registry's error.
+var imagePullEvent = regexp.MustCompile(`^(Failed to pull image|Back-off pulling image) "([^"]+)"(?:: (.*))?`)
+
+// registryAuthError matches the errors registries and runtimes give for
+// missing or refused credentials.
+var registryAuthError = regexp.MustCompile(`(?i)unauthori[sz]ed|authentication required|authorization failed|insufficient_scope|access denied|no basic auth credentials|\b40[13]\b`)
+
+// eventContainer matches the container in an event's field path, as in
+// spec.containers{web}.
+var eventContainer = regexp.MustCompile(`^spec\.(?:init|ephemeral)?[cC]ontainers\{([^}]*)\}`)
+
+// imagePullFailure is a container failing to pull its image, as its status
+// or an event about it says.
+type imagePullFailure struct {
+	podID, eventID                   int64
+	namespace, pod, container, image string
+	reason, message                  string
+}
+
+// storeImagePullFailure records a pull failure in image_pull_failures, with
+// the image's registry and whether the registry refused credentials.
+func storeImagePullFailure(db *sql.DB, summary *Run, f imagePullFailure) {
+	_, err := ExecWrite(db, `
+		INSERT INTO image_pull_failures (run_id, pod_id, event_id, namespace, pod, container, image, registry, reason, message, auth_error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, summary.RunID, nullID(f.podID), nullID(f.eventID), f.namespace, f.pod, f.container, f.image, imageRegistry(f.image),
+		f.reason, f.message, registryAuthError.MatchString(f.message))
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "pod", f.namespace, f.pod, fmt.Errorf("Error inserting image pull failure into database: %w", err))
+	}
+}
+
+// eventImagePullFailure returns the pull failure a pod's event reports, if
+// it reports one. Failures are reported as ErrImagePull and back-offs as
+// ImagePullBackOff, as container statuses would.
+func eventImagePullFailure(message, fieldPath string) (imagePullFailure, bool) {
+	m := imagePullEvent.FindStringSubmatch(message)
+	if m == nil {
+		return imagePullFailure{}, false
+	}
+	f := imagePullFailure{image: m[2], reason: "ErrImagePull", message: m[3]}
+	if m[1] == "Back-off pulling image" {
+		f.reason, f.message = "ImagePullBackOff", message
+	}
+	if c := eventContainer.FindStringSubmatch(fieldPath); c != nil {
+		f.container = c[1]
+	}
+	return f, true
+}
+
+// imageRegistry returns the registry an image is pulled from: its first
+// component if that looks like a host, or else Docker Hub.
+func imageRegistry(image string) string {
+	first, _, found := strings.Cut(image, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first
+	}
+	return "docker.io"
+}
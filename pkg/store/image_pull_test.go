@@ -0,0 +1,71 @@
+package store_test
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/store"
+)
+
+func TestImagePullFailures(t *testing.T) {
+	s := kubetest.NewStore(t)
+	run := kubetest.NewRun(t, s)
+	denied := `failed to pull and unpack image "registry.example.com/shop/web:1.3": failed to authorize: 401 Unauthorized`
+	store.StorePod(s.DB, run, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web-5d9c7-abcde"},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "web", Image: "registry.example.com/shop/web:1.3", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ErrImagePull", Message: denied}}},
+			{Name: "proxy", Image: "envoyproxy/envoy:v1.30", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}}},
+		}},
+	}, 0, 0)
+	for _, message := range []string{
+		`Failed to pull image "registry.example.com/shop/web:1.3": ` + denied,
+		`Back-off pulling image "registry.example.com/shop/web:1.3"`,
+		`Failed to pull image "nginx:1.27-typo": rpc error: code = NotFound desc = manifest unknown`,
+		`Started container web`,
+	} {
+		store.StoreEvent(s.DB, run, &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Namespace: "prod", Name: "web-5d9c7-abcde.17c"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "prod", Name: "web-5d9c7-abcde", FieldPath: "spec.containers{web}"},
+			Message:        message,
+		}, 0)
+	}
+	if err := run.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := kubetest.Count(t, s.DB, "image_pull_failures", ""); got != 4 {
+		t.Errorf("got %d image pull failures, want 4", got)
+	}
+	if got := kubetest.Count(t, s.DB, "image_pull_failures", "registry = 'registry.example.com' AND auth_error AND pod_id IS NOT NULL AND container = 'web'"); got != 2 {
+		t.Errorf("got %d refused pulls from registry.example.com, want 2", got)
+	}
+	if got := kubetest.Count(t, s.DB, "image_pull_failures", "reason = 'ImagePullBackOff' AND event_id IS NOT NULL AND NOT auth_error"); got != 1 {
+		t.Errorf("got %d back-off events, want 1", got)
+	}
+	var message string
+	if err := s.DB.QueryRow(`SELECT message FROM image_pull_failures WHERE registry = 'docker.io' AND NOT auth_error`).Scan(&message); err != nil {
+		t.Fatal(err)
+	}
+	if message != "rpc error: code = NotFound desc = manifest unknown" {
+		t.Errorf("got Docker Hub error %q", message)
+	}
+
+	q, err := store.FindNamedQuery("image-pull-failures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var registry, image string
+	var auth bool
+	var pods int
+	var errMsg string
+	if err := s.DB.QueryRow(q.SQL).Scan(&registry, &image, &auth, &pods, &errMsg); err != nil {
+		t.Fatal(err)
+	}
+	if registry != "registry.example.com" || !auth || pods != 1 {
+		t.Errorf("got first failure %s %s auth %v in %d pods, want registry.example.com's refusal", registry, image, auth, pods)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !sqlite_fts5
+
+package store
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// logIndexEnabled reports whether this binary was built with SQLite FTS5
+// support. Without it searches fall back to scanning log_lines with LIKE.
+const logIndexEnabled = false
+
+func initializeLogIndex(db *sql.DB) error {
+	return nil
+}
+
+// LogSearchCondition returns a WHERE clause fragment over log_lines aliased
+// as l that matches lines containing the phrase.
+func LogSearchCondition(phrase string) (string, interface{}) {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(phrase)
+	return `l.line LIKE ? ESCAPE '\'`, "%" + escaped + "%"
+}
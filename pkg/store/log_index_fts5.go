@@ -0,0 +1,46 @@
+//go:build sqlite_fts5
+
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// logIndexEnabled reports whether this binary was built with SQLite FTS5
+// support, in which case log lines are indexed in log_lines_fts.
+const logIndexEnabled = true
+
+// initializeLogIndex creates the full-text index over log_lines, populating
+// it from any lines stored before the index existed.
+func initializeLogIndex(db *sql.DB) error {
+	var exists int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'log_lines_fts'`).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("Error checking for log index: %v", err)
+	}
+	if exists > 0 {
+		return nil
+	}
+
+	_, err = db.Exec(`
+		CREATE VIRTUAL TABLE log_lines_fts USING fts5(line, content='log_lines', content_rowid='id');
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating log_lines_fts table: %v", err)
+	}
+
+	_, err = db.Exec(`INSERT INTO log_lines_fts (log_lines_fts) VALUES ('rebuild')`)
+	if err != nil {
+		return fmt.Errorf("Error building log index: %v", err)
+	}
+	return nil
+}
+
+// LogSearchCondition returns a WHERE clause fragment over log_lines aliased
+// as l that matches lines containing the phrase.
+func LogSearchCondition(phrase string) (string, interface{}) {
+	quoted := `"` + strings.ReplaceAll(phrase, `"`, `""`) + `"`
+	return "l.id IN (SELECT rowid FROM log_lines_fts WHERE log_lines_fts MATCH ?)", quoted
+}
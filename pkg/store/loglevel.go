@@ -0,0 +1,82 @@
+package store
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// LogLevels are the severities DetectLogLevel finds, least severe first.
+var LogLevels = []string{"debug", "info", "warn", "error", "fatal"}
+
+// levelWords maps the severity names loggers write to LogLevels.
+var levelWords = map[string]string{
+	"TRACE": "debug", "DEBUG": "debug", "DBG": "debug",
+	"INFO": "info", "INF": "info", "NOTICE": "info",
+	"WARN": "warn", "WARNING": "warn", "WRN": "warn",
+	"ERROR": "error", "ERR": "error", "SEVERE": "error",
+	"FATAL": "fatal", "CRITICAL": "fatal", "CRIT": "fatal", "PANIC": "fatal",
+}
+
+var (
+	// klogHeader matches the Lmmdd hh:mm:ss header of Kubernetes components'
+	// logs, where L is I, W, E or F.
+	klogHeader  = regexp.MustCompile(`^([IWEF])\d{4} \d{2}:\d{2}:\d{2}`)
+	logfmtLevel = regexp.MustCompile(`(?i)\b(?:level|lvl|severity)=["']?([a-z]+)`)
+	// levelToken matches a severity written as a word in capitals, or in
+	// brackets in any case.
+	levelToken = regexp.MustCompile(`\b(TRACE|DEBUG|INFO|NOTICE|WARN|WARNING|ERROR|SEVERE|FATAL|CRITICAL|PANIC)\b|\[(?i:(trace|debug|info|notice|warn|warning|error|severe|fatal|critical|panic))\]`)
+)
+
+// DetectLogLevel returns the severity of a log line (or the first line of a
+// record) as one of LogLevels, or "" if it has none recognized. It reads the
+// level field of JSON lines (names, or Bunyan and Pino numbers), klog headers,
+// logfmt level= pairs and, failing those, the first severity word.
+func DetectLogLevel(line string) string {
+	line, _, _ = strings.Cut(line, "\n")
+	if strings.HasPrefix(line, "{") {
+		var fields map[string]interface{}
+		if json.Unmarshal([]byte(line), &fields) == nil {
+			for _, key := range []string{"level", "severity", "lvl", "log.level", "loglevel"} {
+				switch v := fields[key].(type) {
+				case string:
+					if level, ok := levelWords[strings.ToUpper(v)]; ok {
+						return level
+					}
+				case float64:
+					return numericLevel(v)
+				}
+			}
+			return ""
+		}
+	}
+	if m := klogHeader.FindStringSubmatch(line); m != nil {
+		return map[string]string{"I": "info", "W": "warn", "E": "error", "F": "fatal"}[m[1]]
+	}
+	if m := logfmtLevel.FindStringSubmatch(line); m != nil {
+		if level, ok := levelWords[strings.ToUpper(m[1])]; ok {
+			return level
+		}
+	}
+	if m := levelToken.FindStringSubmatch(line); m != nil {
+		return levelWords[strings.ToUpper(m[1]+m[2])]
+	}
+	return ""
+}
+
+// numericLevel maps Bunyan and Pino levels: 10 trace, 20 debug, 30 info,
+// 40 warn, 50 error and 60 fatal.
+func numericLevel(n float64) string {
+	switch {
+	case n >= 60:
+		return "fatal"
+	case n >= 50:
+		return "error"
+	case n >= 40:
+		return "warn"
+	case n >= 30:
+		return "info"
+	default:
+		return "debug"
+	}
+}
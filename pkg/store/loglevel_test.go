@@ -0,0 +1,30 @@
+package store_test
+
+import (
+	"testing"
+
+	"kube-query/pkg/store"
+)
+
+func TestDetectLogLevel(t *testing.T) {
+	for _, tt := range []struct{ line, want string }{
+		{`{"level":"warning","msg":"slow"}`, "warn"},
+		{`{"severity":"ERROR","message":"failed"}`, "error"},
+		{`{"level":50,"msg":"pino error"}`, "error"},
+		{`{"msg":"no level"}`, ""},
+		{"E0501 10:00:00.123456       1 reflector.go:123] failed to list", "error"},
+		{"I0501 10:00:00.123456       1 server.go:42] serving", "info"},
+		{`time=2024-05-01T10:00:00Z level=debug msg="cache hit"`, "debug"},
+		{"2024-05-01 10:00:00.123 WARN 1 --- [main] o.s.b.Application : low memory", "warn"},
+		{"[error] upstream timed out", "error"},
+		{"panic: runtime error: index out of range", ""},
+		{"PANIC: out of memory", "fatal"},
+		{"GET /healthz 200", ""},
+		{"an error occurred", ""},
+		{"ERROR failed\n\tat com.example.Main.main", "error"},
+	} {
+		if got := store.DetectLogLevel(tt.line); got != tt.want {
+			t.Errorf("DetectLogLevel(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"kube-query/pkg/metrics"
+)
+
+// StoreLogLines splits a pod's log output into one row per line so it can be
+// searched and filtered by timestamp, for logs read whole, such as those of
+// an imported archive.
+func StoreLogLines(db *sql.DB, runID, deploymentID int64, namespace, pod string, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	return insertLogLines(db, runID, deploymentID, namespace, pod, "", 1, lines)
+}
+
+// AppendLogLines stores lines, or records of several lines, a container
+// logged, numbered from firstLine, for logs stored a batch at a time as they
+// are read. The deployment ID is 0 for pods without one.
+func AppendLogLines(db *sql.DB, runID, deploymentID int64, namespace, pod, container string, firstLine int, lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	return insertLogLines(db, runID, deploymentID, namespace, pod, container, firstLine, lines)
+}
+
+// StoreLogSampling records that a container's log was sampled, how (such as
+// "1 in 10 lines") and how many of its lines were kept, so that counts of
+// the stored lines can be scaled back up.
+func StoreLogSampling(db *sql.DB, summary *Run, namespace, pod, container, method string, lines, kept int) {
+	_, err := ExecWrite(db, `
+		INSERT INTO log_sampling (run_id, namespace, pod, container, method, lines, kept)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, summary.RunID, namespace, pod, container, method, lines, kept)
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "pod", namespace, pod, fmt.Errorf("Error inserting log sampling into database: %w", err))
+	}
+}
+
+// insertLogLines stores raw log lines, each optionally prefixed with its
+// timestamp, numbered from firstLine. The container is NULL if "".
+func insertLogLines(db *sql.DB, runID, deploymentID int64, namespace, pod, container string, firstLine int, lines []string) error {
+	start := time.Now()
+	defer func() { metrics.DBWriteDuration.Observe(time.Since(start)) }()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO log_lines (run_id, deployment_id, namespace, pod, container, line_number, timestamp, line, level)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("Error preparing log line insert: %v", err)
+	}
+	defer stmt.Close()
+
+	var indexStmt *sql.Stmt
+	if logIndexEnabled {
+		indexStmt, err = tx.Prepare(`INSERT INTO log_lines_fts (rowid, line) VALUES (?, ?)`)
+		if err != nil {
+			return fmt.Errorf("Error preparing log index insert: %v", err)
+		}
+		defer indexStmt.Close()
+	}
+
+	containerName := sql.NullString{String: container, Valid: container != ""}
+	for i, raw := range lines {
+		timestamp, line := splitLogTimestamp(raw)
+		level := DetectLogLevel(line)
+		result, err := stmt.Exec(runID, nullID(deploymentID), namespace, pod, containerName, firstLine+i, timestamp, line, sql.NullString{String: level, Valid: level != ""})
+		if err != nil {
+			return fmt.Errorf("Error inserting log line: %v", err)
+		}
+		if indexStmt != nil {
+			lineID, err := result.LastInsertId()
+			if err != nil {
+				return fmt.Errorf("Error getting last insert ID: %v", err)
+			}
+			if _, err := indexStmt.Exec(lineID, line); err != nil {
+				return fmt.Errorf("Error indexing log line: %v", err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// splitLogTimestamp separates the RFC 3339 timestamp the kubelet prefixes to
+// each line when timestamps are requested. Lines without one are returned
+// unchanged with a null timestamp.
+func splitLogTimestamp(raw string) (sql.NullTime, string) {
+	prefix, rest, found := strings.Cut(raw, " ")
+	if !found {
+		return sql.NullTime{}, raw
+	}
+	t, err := time.Parse(time.RFC3339Nano, prefix)
+	if err != nil {
+		return sql.NullTime{}, raw
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}, rest
+}
+
+// LogLine is a gathered log line as read back for export.
+type LogLine struct {
+	ID        int64
+	Timestamp sql.NullTime
+	Line      string
+}
+
+// ForEachPodLogBatch reads a run's log lines pod by pod, in the order they
+// were logged, passing fn at most batchSize lines of one pod at a time.
+func ForEachPodLogBatch(db *sql.DB, runID int64, batchSize int, fn func(namespace, pod string, lines []LogLine) error) error {
+	rows, err := db.Query(`SELECT id, namespace, pod, timestamp, line FROM log_lines WHERE run_id = ? ORDER BY namespace, pod, id`, runID)
+	if err != nil {
+		return fmt.Errorf("Error querying log lines: %v", err)
+	}
+	defer rows.Close()
+
+	var current [2]string
+	var batch []LogLine
+	for rows.Next() {
+		var namespace, pod string
+		var l LogLine
+		if err := rows.Scan(&l.ID, &namespace, &pod, &l.Timestamp, &l.Line); err != nil {
+			return fmt.Errorf("Error scanning log line: %v", err)
+		}
+		if key := [2]string{namespace, pod}; key != current || len(batch) == batchSize {
+			if len(batch) > 0 {
+				if err := fn(current[0], current[1], batch); err != nil {
+					return err
+				}
+			}
+			current, batch = key, batch[:0]
+		}
+		batch = append(batch, l)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("Error reading log lines: %v", err)
+	}
+	if len(batch) > 0 {
+		return fn(current[0], current[1], batch)
+	}
+	return nil
+}
+
+// ForEachLogLine reads the log lines of one pod in a run in the order they
+// were logged, passing each to fn without holding them all in memory.
+func ForEachLogLine(db *sql.DB, runID int64, namespace, pod string, fn func(LogLine) error) error {
+	rows, err := db.Query(`SELECT id, timestamp, line FROM log_lines WHERE run_id = ? AND namespace = ? AND pod = ? ORDER BY id`, runID, namespace, pod)
+	if err != nil {
+		return fmt.Errorf("Error querying log lines: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l LogLine
+		var line sql.NullString
+		if err := rows.Scan(&l.ID, &l.Timestamp, &line); err != nil {
+			return fmt.Errorf("Error scanning log line: %v", err)
+		}
+		l.Line = line.String
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("Error reading log lines: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// NamedQuery is a canned query over the gather schema, runnable with
+// `query --name <name>`.
+type NamedQuery struct {
+	Name        string
+	Description string
+	SQL         string
+}
+
+var namedQueries = []NamedQuery{
+	{
+		Name:        "images",
+		Description: "Container images used by the deployments of the latest run",
+		SQL: `
+			SELECT d.namespace, d.name AS deployment,
+				json_extract(c.value, '$.name') AS container,
+				json_extract(c.value, '$.image') AS image
+			FROM deployments d, json_each(d.spec, '$.template.spec.containers') c
+			WHERE d.run_id = (SELECT MAX(id) FROM runs)
+			UNION ALL
+			SELECT d.namespace, d.name AS deployment,
+				json_extract(c.value, '$.name') AS container,
+				json_extract(c.value, '$.image') AS image
+			FROM deployments d, json_each(d.spec, '$.template.spec.initContainers') c
+			WHERE d.run_id = (SELECT MAX(id) FROM runs)
+			ORDER BY 1, 2, 3
+		`,
+	},
+	{
+		Name:        "replicas",
+		Description: "Desired versus ready replicas per deployment of the latest run",
+		SQL: `
+			SELECT namespace, name,
+				json_extract(spec, '$.replicas') AS desired,
+				COALESCE(json_extract(status, '$.readyReplicas'), 0) AS ready,
+				COALESCE(json_extract(status, '$.updatedReplicas'), 0) AS updated,
+				COALESCE(json_extract(status, '$.availableReplicas'), 0) AS available
+			FROM deployments
+			WHERE run_id = (SELECT MAX(id) FROM runs)
+			ORDER BY namespace, name
+		`,
+	},
+	{
+		Name:        "restarts",
+		Description: "Container restart counts and last termination reasons per pod of the latest run",
+		SQL: `
+			SELECT p.namespace, p.name AS pod,
+				json_extract(c.value, '$.name') AS container,
+				json_extract(c.value, '$.restartCount') AS restarts,
+				json_extract(c.value, '$.lastState.terminated.reason') AS last_reason
+			FROM pods p, json_each(p.status, '$.containerStatuses') c
+			WHERE p.run_id = (SELECT MAX(id) FROM runs) AND json_extract(c.value, '$.restartCount') > 0
+			ORDER BY restarts DESC
+		`,
+	},
+	{
+		Name:        "unready-pods",
+		Description: "Pods that were not Ready when gathered, with their unready containers",
+		SQL: `
+			SELECT p.run_id, p.namespace, p.pod, p.reason,
+				c.container, c.state, c.reason AS container_reason, c.restart_count
+			FROM pod_conditions p
+			LEFT JOIN container_statuses c ON c.pod_id = p.pod_id AND NOT c.ready AND NOT c.init
+			WHERE p.type = 'Ready' AND p.status != 'True'
+			ORDER BY 1, 2, 3, 5
+		`,
+	},
+	{
+		Name:        "config-consumers",
+		Description: "ConfigMaps and secrets referenced by each deployment of the latest run",
+		SQL: `
+			SELECT DISTINCT d.namespace, d.name AS deployment,
+				CASE WHEN t.key LIKE 'configMap%' THEN 'configmap' ELSE 'secret' END AS kind,
+				COALESCE(json_extract(t.value, '$.name'), json_extract(t.value, '$.secretName')) AS resource
+			FROM deployments d, json_tree(d.spec, '$.template.spec') t
+			WHERE d.run_id = (SELECT MAX(id) FROM runs)
+				AND t.key IN ('configMap', 'configMapRef', 'configMapKeyRef', 'secret', 'secretRef', 'secretKeyRef')
+			ORDER BY 1, 2, 3, 4
+		`,
+	},
+	{
+		Name:        "log-volume",
+		Description: "Bytes of logs captured per deployment in the latest run",
+		SQL: `
+			SELECT d.namespace, d.name AS deployment, SUM(LENGTH(l.line)) AS log_bytes
+			FROM log_lines l
+			JOIN deployments d ON d.id = l.deployment_id
+			WHERE d.run_id = (SELECT MAX(id) FROM runs)
+			GROUP BY d.id
+			ORDER BY log_bytes DESC
+		`,
+	},
+	{
+		Name:        "usage",
+		Description: "Container CPU and memory usage (from --metrics) next to requests and limits",
+		SQL: `
+			SELECT m.namespace, m.pod, m.container,
+				m.cpu_millicores,
+				json_extract(c.value, '$.resources.requests.cpu') AS cpu_request,
+				json_extract(c.value, '$.resources.limits.cpu') AS cpu_limit,
+				m.memory_bytes,
+				json_extract(c.value, '$.resources.requests.memory') AS memory_request,
+				json_extract(c.value, '$.resources.limits.memory') AS memory_limit
+			FROM pod_metrics m
+			LEFT JOIN pods p ON p.run_id = m.run_id AND p.namespace = m.namespace AND p.name = m.pod
+			LEFT JOIN json_each(p.spec, '$.containers') c ON json_extract(c.value, '$.name') = m.container
+			ORDER BY 1, 2, 3
+		`,
+	},
+	{
+		Name:        "inventory",
+		Description: "Object counts per kind and namespace (from --inventory)",
+		SQL: `
+			SELECT kind, api_version, namespace, COUNT(*) AS objects
+			FROM inventory
+			WHERE run_id = (SELECT MAX(run_id) FROM inventory)
+			GROUP BY kind, api_version, namespace
+			ORDER BY 1, 3
+		`,
+	},
+	{
+		Name:        "deletions",
+		Description: "Objects found deleted since the run before, by the run that noticed",
+		SQL: `
+			SELECT t.run_id, t.detected_at, t.kind, t.namespace, t.name, t.last_run_id
+			FROM tombstones t
+			ORDER BY 1, 3, 4, 5
+		`,
+	},
+	{
+		Name:        "warnings",
+		Description: "API server warnings, such as deprecated APIs, from the latest run",
+		SQL: `
+			SELECT text, kind, namespace, name, count
+			FROM warnings
+			WHERE run_id = (SELECT MAX(id) FROM runs)
+			ORDER BY 1, 2, 3, 4
+		`,
+	},
+	{
+		Name:        "alerts",
+		Description: "Alertmanager alerts received by the daemon, with the runs gathered for them",
+		SQL: `
+			SELECT a.run_id, r.started_at, a.alertname, a.namespace, a.starts_at,
+				json_extract(a.annotations, '$.summary') AS summary
+			FROM alerts a
+			JOIN runs r ON r.id = a.run_id
+			ORDER BY 1 DESC, 3
+		`,
+	},
+	{
+		Name:        "leases",
+		Description: "Leases of the latest run by holder, with how long before the run each was last renewed",
+		SQL: `
+			SELECT o.namespace, o.name,
+				json_extract(o.spec, '$.holderIdentity') AS holder,
+				COALESCE(json_extract(o.spec, '$.leaseTransitions'), 0) AS transitions,
+				json_extract(o.spec, '$.acquireTime') AS acquired,
+				json_extract(o.spec, '$.renewTime') AS renewed,
+				CAST(ROUND((julianday(r.started_at) - julianday(json_extract(o.spec, '$.renewTime'))) * 86400) AS INTEGER) AS renewed_seconds_ago,
+				json_extract(o.spec, '$.leaseDurationSeconds') AS duration_seconds
+			FROM objects o
+			JOIN runs r ON r.id = o.run_id
+			WHERE o.kind = 'lease' AND o.run_id = (SELECT MAX(id) FROM runs)
+			ORDER BY transitions DESC, 1, 2
+		`,
+	},
+	{
+		Name:        "volume-attachments",
+		Description: "VolumeAttachments of the latest run, those of volumes attached to several nodes or failing first",
+		SQL: `
+			SELECT json_extract(o.spec, '$.source.persistentVolumeName') AS volume,
+				json_extract(o.spec, '$.nodeName') AS node,
+				json_extract(o.spec, '$.attacher') AS attacher,
+				COALESCE(json_extract(o.status, '$.attached'), 0) AS attached,
+				json_extract(o.metadata, '$.deletionTimestamp') IS NOT NULL AS detaching,
+				(SELECT COUNT(*) FROM objects a
+					WHERE a.run_id = o.run_id AND a.kind = 'volumeattachment'
+					AND json_extract(a.spec, '$.source.persistentVolumeName') = json_extract(o.spec, '$.source.persistentVolumeName')) AS nodes,
+				EXISTS (SELECT 1 FROM objects n, json_each(n.spec, '$.drivers') d
+					WHERE n.run_id = o.run_id AND n.kind = 'csinode' AND n.name = json_extract(o.spec, '$.nodeName')
+					AND json_extract(d.value, '$.name') = json_extract(o.spec, '$.attacher')) AS driver_on_node,
+				COALESCE(json_extract(o.status, '$.attachError.message'), json_extract(o.status, '$.detachError.message')) AS error
+			FROM objects o
+			WHERE o.kind = 'volumeattachment' AND o.run_id = (SELECT MAX(id) FROM runs)
+			ORDER BY nodes > 1 DESC, error IS NULL, 1, 2
+		`,
+	},
+	{
+		Name:        "gateway-routes",
+		Description: "HTTPRoute backends of the latest run with their Gateways and Services, missing Services and unresolved routes first",
+		SQL: `
+			SELECT b.namespace, b.route,
+				(SELECT group_concat(COALESCE(json_extract(p.value, '$.namespace'), b.namespace) || '/' || json_extract(p.value, '$.name'), ', ')
+					FROM json_each(o.spec, '$.parentRefs') p) AS gateways,
+				(SELECT group_concat(h.value, ', ') FROM json_each(o.spec, '$.hostnames') h) AS hostnames,
+				b.rule, b.backend_kind, b.backend_namespace || '/' || b.backend_name AS backend, b.port, b.weight,
+				s.id IS NOT NULL AS service_gathered,
+				CASE WHEN s.id IS NOT NULL AND b.port IS NOT NULL THEN
+					EXISTS (SELECT 1 FROM json_each(s.spec, '$.ports') sp WHERE json_extract(sp.value, '$.port') = b.port)
+				END AS port_found,
+				(SELECT group_concat(json_extract(c.value, '$.type') || ': ' || json_extract(c.value, '$.reason'), ', ')
+					FROM json_each(o.status, '$.parents') pa, json_each(pa.value, '$.conditions') c
+					WHERE json_extract(c.value, '$.status') != 'True') AS failing_conditions
+			FROM route_backends b
+			JOIN objects o ON o.id = b.object_id
+			LEFT JOIN objects s ON s.run_id = b.run_id AND s.kind = 'service' AND b.backend_kind = 'Service'
+				AND s.namespace = b.backend_namespace AND s.name = b.backend_name
+			WHERE b.run_id = (SELECT MAX(id) FROM runs)
+			ORDER BY service_gathered, failing_conditions IS NULL, 1, 2, b.rule
+		`,
+	},
+	{
+		Name:        "autoscaling",
+		Description: "HPAs of the latest run with the replicas their targets' /scale reports, those limited or unable to scale first",
+		SQL: `
+			SELECT o.namespace, o.name AS hpa,
+				json_extract(o.spec, '$.scaleTargetRef.kind') || '/' || json_extract(o.spec, '$.scaleTargetRef.name') AS target,
+				COALESCE(json_extract(o.spec, '$.minReplicas'), 1) AS min_replicas,
+				json_extract(o.spec, '$.maxReplicas') AS max_replicas,
+				json_extract(o.status, '$.desiredReplicas') AS desired_replicas,
+				s.spec_replicas AS scale_replicas,
+				s.status_replicas AS running_replicas,
+				(SELECT group_concat(json_extract(c.value, '$.type') || ': ' || json_extract(c.value, '$.reason'), ', ')
+					FROM json_each(o.status, '$.conditions') c
+					WHERE json_extract(c.value, '$.status') != CASE json_extract(c.value, '$.type') WHEN 'ScalingLimited' THEN 'False' ELSE 'True' END) AS conditions
+			FROM objects o
+			LEFT JOIN scales s ON s.run_id = o.run_id AND s.namespace = o.namespace
+				AND s.name = json_extract(o.spec, '$.scaleTargetRef.name')
+				AND (s.kind = lower(json_extract(o.spec, '$.scaleTargetRef.kind')) OR s.kind LIKE lower(json_extract(o.spec, '$.scaleTargetRef.kind')) || '.%')
+			WHERE o.kind = 'horizontalpodautoscaler' AND o.run_id = (SELECT MAX(id) FROM runs)
+			ORDER BY conditions IS NULL, 1, 2
+		`,
+	},
+	{
+		Name:        "image-pull-failures",
+		Description: "Images failing to pull in the latest run, by registry, with the registry's error",
+		SQL: `
+			SELECT registry, image, MAX(auth_error) AS auth_error, COUNT(DISTINCT namespace || '/' || pod) AS pods,
+				MAX(CASE WHEN reason != 'ImagePullBackOff' THEN message END) AS error
+			FROM image_pull_failures
+			WHERE run_id = (SELECT MAX(id) FROM runs)
+			GROUP BY 1, 2
+			ORDER BY 3 DESC, 4 DESC
+		`,
+	},
+}
+
+func FindNamedQuery(name string) (NamedQuery, error) {
+	for _, q := range namedQueries {
+		if q.Name == name {
+			return q, nil
+		}
+	}
+	return NamedQuery{}, fmt.Errorf("unknown named query %q, use --list to see available queries", name)
+}
+
+func ListNamedQueries(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tDESCRIPTION")
+	for _, q := range namedQueries {
+		fmt.Fprintf(tw, "%s\t%s\n", q.Name, q.Description)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,230 @@
+package store_test
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/store"
+)
+
+func TestQueriesOfLatestRun(t *testing.T) {
+	s := kubetest.NewStore(t)
+	for _, image := range []string{"web:1.0", "web:1.1"} {
+		run := kubetest.NewRun(t, s)
+		spec := corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "web", Image: image}},
+			Volumes:    []corev1.Volume{{Name: "config", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "web-config"}}}}},
+		}
+		deploymentID := store.StoreDeployment(s.DB, run, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web"},
+			Spec:       appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: spec}},
+		})
+		store.StorePod(s.DB, run, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web-abcde"},
+			Spec:       spec,
+			Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "web", Image: image, RestartCount: 3}}},
+		}, deploymentID, 0)
+		if err := store.AppendLogLines(s.DB, run.RunID, deploymentID, "prod", "web-abcde", "web", 1, []string{"started " + image}); err != nil {
+			t.Fatal(err)
+		}
+		if run.Errors != 0 {
+			t.Fatal(run.Err())
+		}
+	}
+
+	// Each query reports the deployment and pod once, as the latest run
+	// gathered them.
+	for _, name := range []string{"images", "replicas", "restarts", "config-consumers", "log-volume"} {
+		q, err := store.FindNamedQuery(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := s.DB.Query(q.SQL)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var got []string
+		for rows.Next() {
+			columns, _ := rows.Columns()
+			values := make([]sql.NullString, len(columns))
+			pointers := make([]interface{}, len(columns))
+			for i := range values {
+				pointers[i] = &values[i]
+			}
+			if err := rows.Scan(pointers...); err != nil {
+				t.Fatal(err)
+			}
+			var row []string
+			for _, v := range values {
+				row = append(row, v.String)
+			}
+			got = append(got, strings.Join(row, " "))
+		}
+		rows.Close()
+		if len(got) != 1 {
+			t.Errorf("%s returned %q, want one row of the latest run", name, got)
+		} else if name == "images" && !strings.HasSuffix(got[0], "web:1.1") {
+			t.Errorf("images returned %q, want the latest run's web:1.1", got[0])
+		}
+	}
+}
+
+func TestLeasesQuery(t *testing.T) {
+	s := kubetest.NewStore(t)
+	run := store.NewRun(time.Date(2024, 5, 15, 10, 0, 30, 0, time.FixedZone("CEST", 2*60*60)))
+	if err := run.Begin(s.DB); err != nil {
+		t.Fatal(err)
+	}
+	spec := map[string]interface{}{
+		"holderIdentity":       "controller-2_4f0c",
+		"leaseDurationSeconds": 15,
+		"leaseTransitions":     7,
+		"acquireTime":          "2024-05-15T07:58:00.000000Z",
+		"renewTime":            "2024-05-15T08:00:00.000000Z",
+	}
+	if _, err := store.StoreObject(s.DB, run, "lease", &metav1.ObjectMeta{Namespace: "kube-system", Name: "kube-controller-manager"}, spec, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := store.FindNamedQuery("leases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var namespace, name, holder, acquired, renewed string
+	var transitions, ago, duration int
+	if err := s.DB.QueryRow(q.SQL).Scan(&namespace, &name, &holder, &transitions, &acquired, &renewed, &ago, &duration); err != nil {
+		t.Fatal(err)
+	}
+	if holder != "controller-2_4f0c" || transitions != 7 || ago != 30 || duration != 15 {
+		t.Errorf("got %s/%s held by %s, %d transitions, renewed %ds before the run, want controller-2_4f0c, 7, 30s", namespace, name, holder, transitions, ago)
+	}
+}
+
+func TestVolumeAttachmentsQuery(t *testing.T) {
+	s := kubetest.NewStore(t)
+	run := kubetest.NewRun(t, s)
+	deleted := metav1.NewTime(time.Date(2024, 5, 15, 8, 0, 0, 0, time.UTC))
+	for _, a := range []struct {
+		name, node string
+		deleted    *metav1.Time
+		status     map[string]interface{}
+	}{
+		{"csi-old", "worker-1", &deleted, map[string]interface{}{"attached": true, "detachError": map[string]interface{}{"message": "rpc error: volume is in use"}}},
+		{"csi-new", "worker-2", nil, map[string]interface{}{"attached": false, "attachError": map[string]interface{}{"message": "Multi-Attach error"}}},
+		{"csi-other", "worker-2", nil, map[string]interface{}{"attached": true}},
+	} {
+		volume := "pvc-data"
+		if a.name == "csi-other" {
+			volume = "pvc-logs"
+		}
+		spec := map[string]interface{}{"attacher": "ebs.csi.aws.com", "nodeName": a.node, "source": map[string]interface{}{"persistentVolumeName": volume}}
+		if _, err := store.StoreObject(s.DB, run, "volumeattachment", &metav1.ObjectMeta{Name: a.name, DeletionTimestamp: a.deleted}, spec, a.status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	csiNode := map[string]interface{}{"drivers": []interface{}{map[string]interface{}{"name": "ebs.csi.aws.com", "nodeID": "i-0abc"}}}
+	if _, err := store.StoreObject(s.DB, run, "csinode", &metav1.ObjectMeta{Name: "worker-1"}, csiNode, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := store.FindNamedQuery("volume-attachments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := s.DB.Query(q.SQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var volume, node, attacher string
+		var attached, detaching, driver bool
+		var nodes int
+		var errMsg sql.NullString
+		if err := rows.Scan(&volume, &node, &attacher, &attached, &detaching, &nodes, &driver, &errMsg); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s %s attached=%v detaching=%v nodes=%d driver=%v %s", volume, node, attached, detaching, nodes, driver, errMsg.String))
+	}
+	want := []string{
+		"pvc-data worker-1 attached=true detaching=true nodes=2 driver=true rpc error: volume is in use",
+		"pvc-data worker-2 attached=false detaching=false nodes=2 driver=false Multi-Attach error",
+		"pvc-logs worker-2 attached=true detaching=false nodes=1 driver=false ",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got attachments\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestGatewayRoutesQuery(t *testing.T) {
+	s := kubetest.NewStore(t)
+	run := kubetest.NewRun(t, s)
+	port, weight := int32(8080), int32(90)
+	route := &store.HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web"},
+		Spec: store.HTTPRouteSpec{
+			ParentRefs: []store.RouteReference{{Name: "public"}},
+			Hostnames:  []string{"shop.example.com"},
+			Rules: []store.HTTPRouteRule{
+				{BackendRefs: []store.BackendRef{{Name: "web", Port: &port, Weight: &weight}, {Name: "web-canary", Port: &port}}},
+			},
+		},
+	}
+	status := map[string]interface{}{"parents": []interface{}{map[string]interface{}{
+		"parentRef": map[string]interface{}{"name": "public"},
+		"conditions": []interface{}{
+			map[string]interface{}{"type": "Accepted", "status": "True", "reason": "Accepted"},
+			map[string]interface{}{"type": "ResolvedRefs", "status": "False", "reason": "BackendNotFound"},
+		},
+	}}}
+	objectID, err := store.StoreObject(s.DB, run, "httproute", &route.ObjectMeta, route.Spec, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.StoreRouteBackends(s.DB, run, route, objectID)
+	service := map[string]interface{}{"ports": []interface{}{map[string]interface{}{"port": 80}}}
+	if _, err := store.StoreObject(s.DB, run, "service", &metav1.ObjectMeta{Namespace: "shop", Name: "web"}, service, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := run.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := store.FindNamedQuery("gateway-routes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := s.DB.Query(q.SQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var namespace, name, gateways, hostnames, kind, backend string
+		var rule, backendPort, backendWeight int
+		var gathered bool
+		var portFound sql.NullBool
+		var failing sql.NullString
+		if err := rows.Scan(&namespace, &name, &gateways, &hostnames, &rule, &kind, &backend, &backendPort, &backendWeight, &gathered, &portFound, &failing); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s/%s via %s: %s %s:%d weight %d gathered=%v port=%v %s", namespace, name, gateways, kind, backend, backendPort, backendWeight, gathered, portFound, failing.String))
+	}
+	want := []string{
+		"shop/web via shop/public: Service shop/web-canary:8080 weight 1 gathered=false port={false false} ResolvedRefs: BackendNotFound",
+		"shop/web via shop/public: Service shop/web:8080 weight 90 gathered=true port={false true} ResolvedRefs: BackendNotFound",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got route backends\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// ObjectKind maps a resource type accepted in --resources to its API
+// resource. Kinds without a dedicated process function are fetched through
+// the dynamic client and stored in the objects table.
+type ObjectKind struct {
+	Kind       string
+	Resource   schema.GroupVersionResource
+	Namespaced bool
+	// Workload kinds select pods with spec.selector; their pods and logs are
+	// gathered with them. SelectorMap kinds, such as DeploymentConfigs, use a
+	// plain label map there rather than a LabelSelector.
+	Workload    bool
+	SelectorMap bool
+}
+
+var ObjectKinds = []ObjectKind{
+	{Kind: "deployment", Resource: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, Namespaced: true},
+	{Kind: "daemonset", Resource: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"}, Namespaced: true, Workload: true},
+	{Kind: "statefulset", Resource: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}, Namespaced: true, Workload: true},
+	{Kind: "job", Resource: schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}, Namespaced: true, Workload: true},
+	{Kind: "controllerrevision", Resource: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "controllerrevisions"}, Namespaced: true},
+	{Kind: "cronjob", Resource: schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}, Namespaced: true},
+	{Kind: "pod", Resource: schema.GroupVersionResource{Version: "v1", Resource: "pods"}, Namespaced: true},
+	{Kind: "configmap", Resource: schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, Namespaced: true},
+	{Kind: "secret", Resource: schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, Namespaced: true},
+	{Kind: "service", Resource: schema.GroupVersionResource{Version: "v1", Resource: "services"}, Namespaced: true},
+	{Kind: "endpoints", Resource: schema.GroupVersionResource{Version: "v1", Resource: "endpoints"}, Namespaced: true},
+	{Kind: "endpointslice", Resource: schema.GroupVersionResource{Group: "discovery.k8s.io", Version: "v1", Resource: "endpointslices"}, Namespaced: true},
+	{Kind: "ingress", Resource: schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}, Namespaced: true},
+	{Kind: "ingressclass", Resource: schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingressclasses"}},
+	{Kind: "networkpolicy", Resource: schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "networkpolicies"}, Namespaced: true},
+	{Kind: "persistentvolumeclaim", Resource: schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"}, Namespaced: true},
+	{Kind: "node", Resource: schema.GroupVersionResource{Version: "v1", Resource: "nodes"}},
+	{Kind: "persistentvolume", Resource: schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumes"}},
+	{Kind: "storageclass", Resource: schema.GroupVersionResource{Group: "storage.k8s.io", Version: "v1", Resource: "storageclasses"}},
+	{Kind: "csidriver", Resource: schema.GroupVersionResource{Group: "storage.k8s.io", Version: "v1", Resource: "csidrivers"}},
+	{Kind: "csinode", Resource: schema.GroupVersionResource{Group: "storage.k8s.io", Version: "v1", Resource: "csinodes"}},
+	{Kind: "volumeattachment", Resource: schema.GroupVersionResource{Group: "storage.k8s.io", Version: "v1", Resource: "volumeattachments"}},
+	{Kind: "lease", Resource: schema.GroupVersionResource{Group: "coordination.k8s.io", Version: "v1", Resource: "leases"}, Namespaced: true},
+	{Kind: "resourcequota", Resource: schema.GroupVersionResource{Version: "v1", Resource: "resourcequotas"}, Namespaced: true},
+	{Kind: "limitrange", Resource: schema.GroupVersionResource{Version: "v1", Resource: "limitranges"}, Namespaced: true},
+	{Kind: "horizontalpodautoscaler", Resource: schema.GroupVersionResource{Group: "autoscaling", Version: "v2", Resource: "horizontalpodautoscalers"}, Namespaced: true},
+	{Kind: "verticalpodautoscaler", Resource: schema.GroupVersionResource{Group: "autoscaling.k8s.io", Version: "v1", Resource: "verticalpodautoscalers"}, Namespaced: true},
+	{Kind: "certificatesigningrequest", Resource: schema.GroupVersionResource{Group: "certificates.k8s.io", Version: "v1", Resource: "certificatesigningrequests"}},
+	{Kind: "priorityclass", Resource: schema.GroupVersionResource{Group: "scheduling.k8s.io", Version: "v1", Resource: "priorityclasses"}},
+	{Kind: "poddisruptionbudget", Resource: schema.GroupVersionResource{Group: "policy", Version: "v1", Resource: "poddisruptionbudgets"}, Namespaced: true},
+	{Kind: "customresourcedefinition", Resource: schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}},
+	{Kind: "validatingwebhookconfiguration", Resource: schema.GroupVersionResource{Group: "admissionregistration.k8s.io", Version: "v1", Resource: "validatingwebhookconfigurations"}},
+	{Kind: "mutatingwebhookconfiguration", Resource: schema.GroupVersionResource{Group: "admissionregistration.k8s.io", Version: "v1", Resource: "mutatingwebhookconfigurations"}},
+	{Kind: "role", Resource: schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "roles"}, Namespaced: true},
+	{Kind: "rolebinding", Resource: schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "rolebindings"}, Namespaced: true},
+	{Kind: "clusterrole", Resource: schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"}},
+	{Kind: "clusterrolebinding", Resource: schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterrolebindings"}},
+	// OpenShift kinds, gathered where the cluster serves them.
+	{Kind: "route", Resource: schema.GroupVersionResource{Group: "route.openshift.io", Version: "v1", Resource: "routes"}, Namespaced: true},
+	{Kind: "deploymentconfig", Resource: schema.GroupVersionResource{Group: "apps.openshift.io", Version: "v1", Resource: "deploymentconfigs"}, Namespaced: true, Workload: true, SelectorMap: true},
+	{Kind: "clusteroperator", Resource: schema.GroupVersionResource{Group: "config.openshift.io", Version: "v1", Resource: "clusteroperators"}},
+	{Kind: "clusterversion", Resource: schema.GroupVersionResource{Group: "config.openshift.io", Version: "v1", Resource: "clusterversions"}},
+	// Gateway API kinds, gathered where the cluster serves them.
+	{Kind: "gatewayclass", Resource: schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "gatewayclasses"}},
+	{Kind: "gateway", Resource: schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "gateways"}, Namespaced: true},
+	{Kind: "httproute", Resource: schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "httproutes"}, Namespaced: true},
+	// Custom resources of the operators in operatorCollectors.
+	{Kind: "certificate", Resource: schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}, Namespaced: true},
+	{Kind: "certificaterequest", Resource: schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificaterequests"}, Namespaced: true},
+	{Kind: "issuer", Resource: schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "issuers"}, Namespaced: true},
+	{Kind: "clusterissuer", Resource: schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "clusterissuers"}},
+	{Kind: "application", Resource: schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "applications"}, Namespaced: true},
+	{Kind: "applicationset", Resource: schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "applicationsets"}, Namespaced: true},
+	{Kind: "appproject", Resource: schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "appprojects"}, Namespaced: true},
+}
+
+func FindObjectKind(kind string) (ObjectKind, bool) {
+	for _, k := range ObjectKinds {
+		if k.Kind == kind {
+			return k, true
+		}
+	}
+	return ObjectKind{}, false
+}
+
+func (k ObjectKind) Client(dyn dynamic.Interface, namespace string) dynamic.ResourceInterface {
+	if !k.Namespaced {
+		return dyn.Resource(k.Resource)
+	}
+	return dyn.Resource(k.Resource).Namespace(namespace)
+}
+
+func UnstructuredMeta(obj *unstructured.Unstructured) (*metav1.ObjectMeta, error) {
+	var meta metav1.ObjectMeta
+	metadata, _ := obj.Object["metadata"].(map[string]interface{})
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(metadata, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func UnstructuredContent(obj *unstructured.Unstructured) (spec, status interface{}) {
+	status = obj.Object["status"]
+	if s, ok := obj.Object["spec"]; ok {
+		return s, status
+	}
+	rest := map[string]interface{}{}
+	for key, value := range obj.Object {
+		switch key {
+		case "apiVersion", "kind", "metadata", "status":
+		default:
+			rest[key] = value
+		}
+	}
+	return rest, status
+}
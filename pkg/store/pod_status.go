@@ -0,0 +1,116 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// storePodStatus stores a pod's conditions and the readiness, restarts and
+// state of each of its containers as rows of the pod_conditions and
+// container_statuses tables, so they can be queried without unpacking the
+// status JSON. Containers waiting on their image are recorded in
+// image_pull_failures too.
+func storePodStatus(db *sql.DB, summary *Run, pod *corev1.Pod, podID int64) {
+	for _, c := range pod.Status.Conditions {
+		var transitioned sql.NullTime
+		if !c.LastTransitionTime.IsZero() {
+			transitioned = sql.NullTime{Time: c.LastTransitionTime.UTC(), Valid: true}
+		}
+		_, err := ExecWrite(db, `
+			INSERT INTO pod_conditions (run_id, pod_id, namespace, pod, type, status, reason, message, last_transition_time)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, summary.RunID, podID, pod.Namespace, pod.Name, string(c.Type), string(c.Status), c.Reason, c.Message, transitioned)
+		if err != nil {
+			summary.AddResourceError(PhaseStore, "pod", pod.Namespace, pod.Name, fmt.Errorf("Error inserting pod condition into database: %w", err))
+			return
+		}
+	}
+
+	for _, statuses := range []struct {
+		init     bool
+		statuses []corev1.ContainerStatus
+	}{
+		{true, pod.Status.InitContainerStatuses},
+		{false, pod.Status.ContainerStatuses},
+	} {
+		for _, cs := range statuses.statuses {
+			state, reason := containerState(cs.State)
+			var lastReason sql.NullString
+			if t := cs.LastTerminationState.Terminated; t != nil {
+				lastReason = sql.NullString{String: t.Reason, Valid: true}
+			}
+			_, err := ExecWrite(db, `
+				INSERT INTO container_statuses (run_id, pod_id, namespace, pod, container, init, image, ready, restart_count, state, reason, last_termination_reason)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, summary.RunID, podID, pod.Namespace, pod.Name, cs.Name, statuses.init, cs.Image, cs.Ready, cs.RestartCount, state, reason, lastReason)
+			if err != nil {
+				summary.AddResourceError(PhaseStore, "pod", pod.Namespace, pod.Name, fmt.Errorf("Error inserting container status into database: %w", err))
+				return
+			}
+			if w := cs.State.Waiting; w != nil && imagePullReasons[w.Reason] {
+				storeImagePullFailure(db, summary, imagePullFailure{podID: podID, namespace: pod.Namespace, pod: pod.Name,
+					container: cs.Name, image: cs.Image, reason: w.Reason, message: w.Message})
+			}
+		}
+	}
+}
+
+// containerState returns the state a container is in (waiting, running or
+// terminated, or "" if unknown) and the reason given for it, if any.
+func containerState(state corev1.ContainerState) (string, sql.NullString) {
+	switch {
+	case state.Waiting != nil:
+		return "waiting", sql.NullString{String: state.Waiting.Reason, Valid: state.Waiting.Reason != ""}
+	case state.Terminated != nil:
+		return "terminated", sql.NullString{String: state.Terminated.Reason, Valid: state.Terminated.Reason != ""}
+	case state.Running != nil:
+		return "running", sql.NullString{}
+	}
+	return "", sql.NullString{}
+}
+
+// StoreNodeStatus stores a node's capacity and allocatable resources, its
+// readiness and pressure conditions and whether it is cordoned as a row of
+// the node_status table, linked to the node's row in objects, so capacity can
+// be queried without unpacking the status JSON. Resources a node doesn't
+// report, and conditions it doesn't report as True or False, are NULL.
+func StoreNodeStatus(db *sql.DB, summary *Run, node *corev1.Node, objectID int64) {
+	conditions := map[corev1.NodeConditionType]sql.NullBool{}
+	for _, c := range node.Status.Conditions {
+		if c.Status == corev1.ConditionTrue || c.Status == corev1.ConditionFalse {
+			conditions[c.Type] = sql.NullBool{Bool: c.Status == corev1.ConditionTrue, Valid: true}
+		}
+	}
+	capacity, allocatable := node.Status.Capacity, node.Status.Allocatable
+	_, err := ExecWrite(db, `
+		INSERT INTO node_status (run_id, object_id, node,
+			cpu_capacity_millicores, cpu_allocatable_millicores, memory_capacity_bytes, memory_allocatable_bytes,
+			pods_capacity, pods_allocatable, ephemeral_storage_capacity_bytes, ephemeral_storage_allocatable_bytes,
+			ready, memory_pressure, disk_pressure, pid_pressure, unschedulable)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, summary.RunID, nullID(objectID), node.Name,
+		quantity(capacity, corev1.ResourceCPU, true), quantity(allocatable, corev1.ResourceCPU, true),
+		quantity(capacity, corev1.ResourceMemory, false), quantity(allocatable, corev1.ResourceMemory, false),
+		quantity(capacity, corev1.ResourcePods, false), quantity(allocatable, corev1.ResourcePods, false),
+		quantity(capacity, corev1.ResourceEphemeralStorage, false), quantity(allocatable, corev1.ResourceEphemeralStorage, false),
+		conditions[corev1.NodeReady], conditions[corev1.NodeMemoryPressure], conditions[corev1.NodeDiskPressure], conditions[corev1.NodePIDPressure],
+		node.Spec.Unschedulable)
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "node", "", node.Name, fmt.Errorf("Error inserting node status into database: %w", err))
+	}
+}
+
+// quantity returns a resource of a list in its base unit, or in thousandths
+// of it if milli, as CPU is counted; NULL if the list lacks it.
+func quantity(resources corev1.ResourceList, name corev1.ResourceName, milli bool) sql.NullInt64 {
+	q, ok := resources[name]
+	if !ok {
+		return sql.NullInt64{}
+	}
+	if milli {
+		return sql.NullInt64{Int64: q.MilliValue(), Valid: true}
+	}
+	return sql.NullInt64{Int64: q.Value(), Valid: true}
+}
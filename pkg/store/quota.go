@@ -0,0 +1,48 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// StoreQuotaUsage stores each resource a ResourceQuota limits as a row of
+// the quota_usage table, linked to the quota's row in objects: its hard limit
+// and the namespace's usage, as written and as numbers in the resource's base
+// unit (cores, bytes or a count), so quotas near or at their limit can be
+// found without unpacking the status JSON. Usage the quota controller hasn't
+// reported yet is NULL.
+func StoreQuotaUsage(db *sql.DB, summary *Run, quota *corev1.ResourceQuota, objectID int64) {
+	hard := quota.Status.Hard
+	if hard == nil {
+		hard = quota.Spec.Hard
+	}
+	for _, resource := range SortedMapKeys(resourceNames(hard)) {
+		name := corev1.ResourceName(resource)
+		limit := hard[name]
+		var used sql.NullString
+		var usedValue sql.NullFloat64
+		if q, ok := quota.Status.Used[name]; ok {
+			used = sql.NullString{String: q.String(), Valid: true}
+			usedValue = sql.NullFloat64{Float64: q.AsApproximateFloat64(), Valid: true}
+		}
+		_, err := ExecWrite(db, `
+			INSERT INTO quota_usage (run_id, object_id, namespace, quota, resource, hard, used, hard_value, used_value)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, summary.RunID, nullID(objectID), quota.Namespace, quota.Name, resource, limit.String(), used, limit.AsApproximateFloat64(), usedValue)
+		if err != nil {
+			summary.AddResourceError(PhaseStore, "resourcequota", quota.Namespace, quota.Name, fmt.Errorf("Error inserting quota usage into database: %w", err))
+			return
+		}
+	}
+}
+
+// resourceNames returns a resource list keyed by name, for sorting.
+func resourceNames(resources corev1.ResourceList) map[string]bool {
+	names := make(map[string]bool, len(resources))
+	for name := range resources {
+		names[string(name)] = true
+	}
+	return names
+}
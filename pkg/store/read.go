@@ -0,0 +1,646 @@
+package store
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ErrNotFound is returned by the read helpers when a run or resource does not
+// exist in the database.
+var ErrNotFound = errors.New("not found")
+
+// ResourceTable describes where a gathered kind is stored and which JSON
+// columns hold its content. Kinds in the shared objects table are told apart
+// by their kind column.
+type ResourceTable struct {
+	Kind    string
+	Table   string
+	Columns []string
+}
+
+var resourceTables = []ResourceTable{
+	{Kind: "deployment", Table: "deployments", Columns: []string{"metadata", "spec", "status"}},
+	{Kind: "pod", Table: "pods", Columns: []string{"metadata", "spec", "status"}},
+	{Kind: "configmap", Table: "configmaps", Columns: []string{"metadata", "data", "binary_data"}},
+	{Kind: "secret", Table: "secrets", Columns: []string{"metadata", "data"}},
+}
+
+// Every other gatherable kind is kept in the objects table.
+func init() {
+	for _, k := range ObjectKinds {
+		if _, err := FindResourceTable(k.Kind); err != nil {
+			resourceTables = append(resourceTables, ResourceTable{Kind: k.Kind, Table: "objects", Columns: []string{"metadata", "spec", "status"}})
+		}
+	}
+}
+
+// Where returns the condition selecting the table's rows in a run, to be
+// bound with t.Args(runID).
+func (t ResourceTable) Where() string {
+	if t.Table == "objects" {
+		return "run_id = ? AND kind = ?"
+	}
+	return "run_id = ?"
+}
+
+func (t ResourceTable) Args(runID int64) []interface{} {
+	if t.Table == "objects" {
+		return []interface{}{runID, t.Kind}
+	}
+	return []interface{}{runID}
+}
+
+// FindResourceTable returns where a kind is stored. Kinds found by a
+// namespace dump are not known ahead of time, so any other kind is looked up
+// in the objects table.
+func FindResourceTable(kind string) (ResourceTable, error) {
+	for _, t := range resourceTables {
+		if t.Kind == kind {
+			return t, nil
+		}
+	}
+	if kind == "" || strings.ContainsAny(kind, " /:") {
+		return ResourceTable{}, fmt.Errorf("unsupported resource kind: %s", kind)
+	}
+	return ResourceTable{Kind: kind, Table: "objects", Columns: []string{"metadata", "spec", "status"}}, nil
+}
+
+// RunResourceTables returns the known tables plus any other kinds stored in
+// the run's objects table.
+func RunResourceTables(db *sql.DB, runID int64) ([]ResourceTable, error) {
+	rows, err := db.Query(`SELECT DISTINCT kind FROM objects WHERE run_id = ? ORDER BY kind`, runID)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing object kinds: %v", err)
+	}
+	defer rows.Close()
+
+	tables := append([]ResourceTable{}, resourceTables...)
+	for rows.Next() {
+		var kind string
+		if err := rows.Scan(&kind); err != nil {
+			return nil, fmt.Errorf("Error scanning object kinds: %v", err)
+		}
+		if t, _ := FindResourceTable(kind); !containsTable(tables, t.Kind) {
+			tables = append(tables, t)
+		}
+	}
+	return tables, rows.Err()
+}
+
+func containsTable(tables []ResourceTable, kind string) bool {
+	for _, t := range tables {
+		if t.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// RunInfo is a run as recorded in the runs table.
+type RunInfo struct {
+	ID         int64           `json:"id"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	Summary    json.RawMessage `json:"summary,omitempty"`
+	// Interrupted runs were cancelled before they finished gathering.
+	Interrupted bool `json:"interrupted,omitempty"`
+	// Trigger says what started the run, if it wasn't started by hand.
+	Trigger string `json:"trigger,omitempty"`
+}
+
+// Resource is a gathered object, with its content columns (metadata, spec,
+// status or data, depending on its table) as JSON.
+type Resource struct {
+	ID        int64                      `json:"id"`
+	RunID     int64                      `json:"run_id"`
+	Kind      string                     `json:"kind"`
+	Namespace string                     `json:"namespace"`
+	Name      string                     `json:"name"`
+	Content   map[string]json.RawMessage `json:"content,omitempty"`
+}
+
+func ListRuns(db *sql.DB) ([]RunInfo, error) {
+	rows, err := db.Query(`SELECT id, started_at, finished_at, summary, interrupted, trigger FROM runs ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing runs: %v", err)
+	}
+	defer rows.Close()
+
+	runs := []RunInfo{}
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, *run)
+	}
+	return runs, rows.Err()
+}
+
+func GetRun(db *sql.DB, runID int64) (*RunInfo, error) {
+	run, err := scanRun(db.QueryRow(`SELECT id, started_at, finished_at, summary, interrupted, trigger FROM runs WHERE id = ?`, runID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return run, err
+}
+
+// LatestRunID returns the most recent run, which commands default to when no
+// run is given.
+func LatestRunID(db *sql.DB) (int64, error) {
+	var runID sql.NullInt64
+	if err := db.QueryRow(`SELECT MAX(id) FROM runs`).Scan(&runID); err != nil {
+		return 0, fmt.Errorf("Error finding latest run: %v", err)
+	}
+	if !runID.Valid {
+		return 0, fmt.Errorf("no runs in database: %w", ErrNotFound)
+	}
+	return runID.Int64, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRun(row rowScanner) (*RunInfo, error) {
+	var run RunInfo
+	var startedAt, finishedAt sql.NullTime
+	var summary sql.NullString
+	var interrupted sql.NullBool
+	var trigger sql.NullString
+	if err := row.Scan(&run.ID, &startedAt, &finishedAt, &summary, &interrupted, &trigger); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Error scanning run: %v", err)
+	}
+	if startedAt.Valid {
+		run.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+	run.Interrupted = interrupted.Bool
+	run.Trigger = trigger.String
+	if summary.Valid {
+		run.Summary = json.RawMessage(summary.String)
+	}
+	return &run, nil
+}
+
+// ListResources lists the resources gathered in a run, optionally limited to
+// a single kind. Content columns are not loaded.
+func ListResources(db *sql.DB, runID int64, kind string) ([]Resource, error) {
+	tables, err := RunResourceTables(db, runID)
+	if err != nil {
+		return nil, err
+	}
+	if kind != "" {
+		t, err := FindResourceTable(kind)
+		if err != nil {
+			return nil, err
+		}
+		tables = []ResourceTable{t}
+	}
+
+	resources := []Resource{}
+	for _, t := range tables {
+
+		rows, err := db.Query(fmt.Sprintf(`
+			SELECT id, namespace, name FROM %s WHERE %s ORDER BY namespace, name
+		`, t.Table, t.Where()), t.Args(runID)...)
+		if err != nil {
+			return nil, fmt.Errorf("Error listing %s: %v", t.Table, err)
+		}
+		for rows.Next() {
+			r := Resource{RunID: runID, Kind: t.Kind}
+			if err := rows.Scan(&r.ID, &r.Namespace, &r.Name); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("Error scanning %s: %v", t.Table, err)
+			}
+			resources = append(resources, r)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Error listing %s: %v", t.Table, err)
+		}
+	}
+	return resources, nil
+}
+
+// contentField returns the object field a content column holds, which is
+// the column's name except where SQL naming differs.
+func contentField(column string) string {
+	if column == "binary_data" {
+		return "binaryData"
+	}
+	return column
+}
+
+// GetResource loads a single resource with its content columns, keyed by the
+// object field each holds.
+func GetResource(db *sql.DB, runID int64, kind, namespace, name string) (*Resource, error) {
+	t, err := FindResourceTable(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Resource{RunID: runID, Kind: kind, Namespace: namespace, Name: name}
+	values := make([]sql.NullString, len(t.Columns))
+	dest := []interface{}{&r.ID}
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+
+	err = db.QueryRow(fmt.Sprintf(`
+		SELECT id, %s FROM %s WHERE %s AND namespace = ? AND name = ?
+		ORDER BY id DESC LIMIT 1
+	`, strings.Join(t.Columns, ", "), t.Table, t.Where()), append(t.Args(runID), namespace, name)...).Scan(dest...)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching %s: %v", kind, err)
+	}
+
+	r.Content = map[string]json.RawMessage{}
+	for i, column := range t.Columns {
+		if values[i].Valid {
+			r.Content[contentField(column)] = json.RawMessage(values[i].String)
+		}
+	}
+	return r, nil
+}
+
+// GetDeploymentLogs returns the logs captured for a deployment in a run, its
+// pods' log lines each prefixed with its timestamp, if it has one. Runs
+// gathered before the lines were stored keep their logs in deployment_logs.
+func GetDeploymentLogs(db *sql.DB, runID int64, namespace, name string) ([]byte, error) {
+	var deploymentID int64
+	err := db.QueryRow(`
+		SELECT id FROM deployments WHERE run_id = ? AND namespace = ? AND name = ?
+		ORDER BY id DESC LIMIT 1
+	`, runID, namespace, name).Scan(&deploymentID)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching logs: %v", err)
+	}
+
+	rows, err := db.Query(`SELECT timestamp, line FROM log_lines WHERE deployment_id = ? ORDER BY id`, deploymentID)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching logs: %v", err)
+	}
+	defer rows.Close()
+	var logs bytes.Buffer
+	for rows.Next() {
+		var timestamp sql.NullTime
+		var line sql.NullString
+		if err := rows.Scan(&timestamp, &line); err != nil {
+			return nil, fmt.Errorf("Error scanning log line: %v", err)
+		}
+		if timestamp.Valid {
+			logs.WriteString(timestamp.Time.Format(time.RFC3339Nano) + " ")
+		}
+		logs.WriteString(line.String + "\n")
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading log lines: %v", err)
+	}
+	if logs.Len() > 0 {
+		return logs.Bytes(), nil
+	}
+
+	var blob []byte
+	err = db.QueryRow(`SELECT logs FROM deployment_logs WHERE deployment_id = ? ORDER BY id DESC LIMIT 1`, deploymentID).Scan(&blob)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("Error fetching logs: %v", err)
+	}
+	return blob, nil
+}
+
+// ListDeploymentPods lists the pods gathered alongside a deployment row.
+func ListDeploymentPods(db *sql.DB, deploymentID int64) ([]Resource, error) {
+	return listOwnedPods(db, "deployment_id", deploymentID)
+}
+
+// ListObjectPods lists the pods gathered alongside a workload in the objects
+// table.
+func ListObjectPods(db *sql.DB, objectID int64) ([]Resource, error) {
+	return listOwnedPods(db, "object_id", objectID)
+}
+
+func listOwnedPods(db *sql.DB, ownerColumn string, ownerID int64) ([]Resource, error) {
+	rows, err := db.Query(`
+		SELECT id, run_id, namespace, name FROM pods WHERE `+ownerColumn+` = ? ORDER BY name
+	`, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing pods: %v", err)
+	}
+	defer rows.Close()
+
+	pods := []Resource{}
+	for rows.Next() {
+		pod := Resource{Kind: "pod"}
+		var runID sql.NullInt64
+		if err := rows.Scan(&pod.ID, &runID, &pod.Namespace, &pod.Name); err != nil {
+			return nil, fmt.Errorf("Error scanning pod: %v", err)
+		}
+		pod.RunID = runID.Int64
+		pods = append(pods, pod)
+	}
+	return pods, rows.Err()
+}
+
+// GetPodLogs reassembles the log lines captured for a single pod in a run.
+func GetPodLogs(db *sql.DB, runID int64, namespace, pod string) (string, error) {
+	rows, err := db.Query(`
+		SELECT line FROM log_lines WHERE run_id = ? AND namespace = ? AND pod = ? ORDER BY id
+	`, runID, namespace, pod)
+	if err != nil {
+		return "", fmt.Errorf("Error fetching pod logs: %v", err)
+	}
+	defer rows.Close()
+
+	var b strings.Builder
+	for rows.Next() {
+		var line sql.NullString
+		if err := rows.Scan(&line); err != nil {
+			return "", fmt.Errorf("Error scanning log line: %v", err)
+		}
+		b.WriteString(line.String)
+		b.WriteByte('\n')
+	}
+	return b.String(), rows.Err()
+}
+
+type Event struct {
+	InvolvedKind    string     `json:"involved_kind"`
+	InvolvedName    string     `json:"involved_name"`
+	Reason          string     `json:"reason"`
+	Type            string     `json:"type"`
+	Message         string     `json:"message"`
+	Count           int64      `json:"count"`
+	FirstSeen       *time.Time `json:"first_seen,omitempty"`
+	LastSeen        *time.Time `json:"last_seen,omitempty"`
+	SourceComponent string     `json:"source_component"`
+}
+
+// ListEvents returns the events of a run matching a WHERE clause fragment
+// over the events table, oldest first.
+func ListEvents(db *sql.DB, runID int64, condition string, args ...interface{}) ([]Event, error) {
+	rows, err := db.Query(`
+		SELECT involved_kind, involved_name, reason, type, message, count, first_seen, last_seen, source_component
+		FROM events WHERE run_id = ? AND `+condition+`
+		ORDER BY last_seen, id
+	`, append([]interface{}{runID}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing events: %v", err)
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var e Event
+		var reason, eventType, message, component sql.NullString
+		var count sql.NullInt64
+		var firstSeen, lastSeen sql.NullTime
+		if err := rows.Scan(&e.InvolvedKind, &e.InvolvedName, &reason, &eventType, &message, &count, &firstSeen, &lastSeen, &component); err != nil {
+			return nil, fmt.Errorf("Error scanning event: %v", err)
+		}
+		e.Reason, e.Type, e.Message, e.SourceComponent = reason.String, eventType.String, message.String, component.String
+		e.Count = count.Int64
+		if firstSeen.Valid {
+			e.FirstSeen = &firstSeen.Time
+		}
+		if lastSeen.Valid {
+			e.LastSeen = &lastSeen.Time
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// ConfigReferences holds the names of ConfigMaps and Secrets a pod spec
+// refers to through volumes, envFrom or env valueFrom.
+type ConfigReferences struct {
+	ConfigMaps []string
+	Secrets    []string
+}
+
+// FindConfigReferences walks a JSON-encoded spec collecting ConfigMap and
+// Secret references, mirroring the config-consumers named query.
+func FindConfigReferences(spec json.RawMessage) (ConfigReferences, error) {
+	var tree interface{}
+	if err := json.Unmarshal(spec, &tree); err != nil {
+		return ConfigReferences{}, fmt.Errorf("Error decoding spec: %v", err)
+	}
+
+	configMaps := map[string]bool{}
+	secrets := map[string]bool{}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				ref, _ := child.(map[string]interface{})
+				switch key {
+				case "configMap", "configMapRef", "configMapKeyRef":
+					if name, ok := ref["name"].(string); ok {
+						configMaps[name] = true
+					}
+				case "secret", "secretRef", "secretKeyRef":
+					if name, ok := ref["name"].(string); ok {
+						secrets[name] = true
+					} else if name, ok := ref["secretName"].(string); ok {
+						secrets[name] = true
+					}
+				}
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(tree)
+
+	return ConfigReferences{ConfigMaps: SortedKeys(configMaps), Secrets: SortedKeys(secrets)}, nil
+}
+
+func SortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type ResourceKey struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+type RunDiff struct {
+	From    int64         `json:"from"`
+	To      int64         `json:"to"`
+	Added   []ResourceKey `json:"added"`
+	Removed []ResourceKey `json:"removed"`
+	Changed []ResourceKey `json:"changed"`
+}
+
+// DiffRuns compares the resources of two runs by kind, namespace and name,
+// reporting which were added, removed or changed between them.
+func DiffRuns(db *sql.DB, from, to int64) (*RunDiff, error) {
+	before, err := RunContentHashes(db, from)
+	if err != nil {
+		return nil, err
+	}
+	after, err := RunContentHashes(db, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &RunDiff{From: from, To: to, Added: []ResourceKey{}, Removed: []ResourceKey{}, Changed: []ResourceKey{}}
+	for key, content := range after {
+		previous, ok := before[key]
+		if !ok {
+			diff.Added = append(diff.Added, key)
+		} else if previous != content {
+			diff.Changed = append(diff.Changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+
+	for _, keys := range [][]ResourceKey{diff.Added, diff.Removed, diff.Changed} {
+		sortResourceKeys(keys)
+	}
+	return diff, nil
+}
+
+// RunContentHashes returns the content hash of every resource in a run, as
+// ContentHash computes it. Rows stored before hashes were have theirs
+// computed from their content.
+func RunContentHashes(db *sql.DB, runID int64) (map[ResourceKey]string, error) {
+	tables, err := RunResourceTables(db, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := map[ResourceKey]string{}
+	for _, t := range tables {
+		var columns []string
+		for _, column := range t.Columns {
+			if column != "metadata" {
+				columns = append(columns, "COALESCE("+column+", '')")
+			}
+		}
+		rows, err := db.Query(fmt.Sprintf(`
+			SELECT namespace, name, content_hash, CASE WHEN content_hash IS NULL THEN %s END FROM %s WHERE %s
+		`, strings.Join(columns, " || char(0) || "), t.Table, t.Where()), t.Args(runID)...)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading %s: %v", t.Table, err)
+		}
+		for rows.Next() {
+			key := ResourceKey{Kind: t.Kind}
+			var hash, content sql.NullString
+			if err := rows.Scan(&key.Namespace, &key.Name, &hash, &content); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("Error scanning %s: %v", t.Table, err)
+			}
+			if hash.Valid {
+				hashes[key] = hash.String
+			} else {
+				hashes[key] = ContentHash(content.String)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Error loading %s: %v", t.Table, err)
+		}
+	}
+	return hashes, nil
+}
+
+func sortResourceKeys(keys []ResourceKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Kind != keys[j].Kind {
+			return keys[i].Kind < keys[j].Kind
+		}
+		if keys[i].Namespace != keys[j].Namespace {
+			return keys[i].Namespace < keys[j].Namespace
+		}
+		return keys[i].Name < keys[j].Name
+	})
+}
+
+// LoadObjects decodes every stored resource of a kind into a slice of typed
+// objects, reassembling each from its metadata, spec and status columns.
+func LoadObjects[T any](db *sql.DB, runID int64, kind string, out *[]T) error {
+	resources, err := ListResources(db, runID, kind)
+	if err != nil {
+		return err
+	}
+	for _, r := range resources {
+		resource, err := GetResource(db, runID, r.Kind, r.Namespace, r.Name)
+		if err != nil {
+			return err
+		}
+		raw, err := json.Marshal(resource.Content)
+		if err != nil {
+			return err
+		}
+		var obj T
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return fmt.Errorf("Error decoding %s %s/%s: %v", kind, r.Namespace, r.Name, err)
+		}
+		// Rows gathered before metadata was stored have none in their
+		// content.
+		if m, ok := any(&obj).(metav1.Object); ok && m.GetName() == "" {
+			m.SetNamespace(r.Namespace)
+			m.SetName(r.Name)
+		}
+		*out = append(*out, obj)
+	}
+	return nil
+}
+
+func SortedMapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// OpenReadOnly opens an existing gather database without allowing writes.
+func OpenReadOnly(dbFile string) (*sql.DB, error) {
+	if _, err := os.Stat(dbFile); err != nil {
+		return nil, fmt.Errorf("Error opening database: %v", err)
+	}
+	db, err := sql.Open("sqlite3", "file:"+dbFile+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("Error opening database: %v", err)
+	}
+	return db, nil
+}
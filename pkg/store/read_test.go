@@ -0,0 +1,41 @@
+package store_test
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/store"
+)
+
+func TestGetDeploymentLogs(t *testing.T) {
+	s := kubetest.NewStore(t)
+	run := kubetest.NewRun(t, s)
+	web := store.StoreDeployment(s.DB, run, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web"}})
+	if err := store.AppendLogLines(s.DB, run.RunID, web, "prod", "web-5d9c7-abcde", "", 1, []string{"2024-05-15T10:30:00.5Z started", "unstamped"}); err != nil {
+		t.Fatal(err)
+	}
+	// Runs gathered before the lines were stored have the logs whole.
+	old := store.StoreDeployment(s.DB, run, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "old"}})
+	if _, err := s.DB.Exec(`INSERT INTO deployment_logs (deployment_id, logs) VALUES (?, ?)`, old, "2024-05-15T10:30:00Z started\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"web": "2024-05-15T10:30:00.5Z started\nunstamped\n",
+		"old": "2024-05-15T10:30:00Z started\n",
+	} {
+		logs, err := store.GetDeploymentLogs(s.DB, run.RunID, "prod", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(logs) != want {
+			t.Errorf("got %s logs %q, want %q", name, logs, want)
+		}
+	}
+	if _, err := store.GetDeploymentLogs(s.DB, run.RunID, "prod", "payments"); err != store.ErrNotFound {
+		t.Errorf("got error %v for a deployment not gathered, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PruneRuns deletes all but the newest keep runs, together with every row
+// recorded for them, and returns how many runs it deleted. The space freed
+// is reused by later gathers rather than returned to the filesystem.
+func PruneRuns(db *sql.DB, keep int) (int, error) {
+	if keep <= 0 {
+		return 0, fmt.Errorf("Error pruning runs: must keep at least one run, not %d", keep)
+	}
+
+	// Runs are numbered in the order they started, so every run from the
+	// newest pruned one down goes.
+	var newestPruned int64
+	err := db.QueryRow(`SELECT id FROM runs ORDER BY id DESC LIMIT 1 OFFSET ?`, keep).Scan(&newestPruned)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Error finding runs to prune: %v", err)
+	}
+
+	tables, err := runTables(db)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("Error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Deployments' logs and dependencies hang off them rather than runs.
+	for _, table := range []string{"deployment_logs", "deployment_dependencies"} {
+		_, err := tx.Exec(fmt.Sprintf(`
+			DELETE FROM %s WHERE deployment_id IN (SELECT id FROM deployments WHERE run_id <= ?)
+		`, table), newestPruned)
+		if err != nil {
+			return 0, fmt.Errorf("Error pruning %s: %v", table, err)
+		}
+	}
+	for _, table := range tables {
+		if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE run_id <= ?`, table), newestPruned); err != nil {
+			return 0, fmt.Errorf("Error pruning %s: %v", table, err)
+		}
+	}
+	result, err := tx.Exec(`DELETE FROM runs WHERE id <= ?`, newestPruned)
+	if err != nil {
+		return 0, fmt.Errorf("Error pruning runs: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("Error committing pruned runs: %v", err)
+	}
+
+	// The log index reads lines from log_lines, so it is rebuilt without
+	// the deleted ones.
+	if logIndexEnabled {
+		if _, err := db.Exec(`INSERT INTO log_lines_fts (log_lines_fts) VALUES ('rebuild')`); err != nil {
+			return 0, fmt.Errorf("Error rebuilding log index: %v", err)
+		}
+	}
+
+	pruned, _ := result.RowsAffected()
+	return int(pruned), nil
+}
+
+// runTables returns the tables with a run_id column, whose rows belong to a
+// run.
+func runTables(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT m.name FROM sqlite_master m, pragma_table_info(m.name) c
+		WHERE m.type = 'table' AND c.name = 'run_id'
+		ORDER BY m.name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing run tables: %v", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, fmt.Errorf("Error listing run tables: %v", err)
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// PruneLogLines deletes a run's log lines logged before a time, for runs
+// that keep a rolling window of logs, and returns how many it deleted. Lines
+// without a timestamp are kept.
+func PruneLogLines(db *sql.DB, runID int64, before time.Time) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("Error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// The log index reads lines from log_lines, so it must be told of each
+	// line before it goes.
+	if logIndexEnabled {
+		_, err := tx.Exec(`
+			INSERT INTO log_lines_fts (log_lines_fts, rowid, line)
+			SELECT 'delete', id, line FROM log_lines WHERE run_id = ? AND timestamp < ?
+		`, runID, before.UTC())
+		if err != nil {
+			return 0, fmt.Errorf("Error removing log lines from the log index: %v", err)
+		}
+	}
+	result, err := tx.Exec(`DELETE FROM log_lines WHERE run_id = ? AND timestamp < ?`, runID, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("Error pruning log lines: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("Error committing pruned log lines: %v", err)
+	}
+	pruned, _ := result.RowsAffected()
+	return int(pruned), nil
+}
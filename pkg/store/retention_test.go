@@ -0,0 +1,85 @@
+package store_test
+
+import (
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/store"
+)
+
+func TestPruneRuns(t *testing.T) {
+	s := kubetest.NewStore(t)
+	var runs []*store.Run
+	for i := 0; i < 3; i++ {
+		run := kubetest.NewRun(t, s)
+		deploymentID := store.StoreDeployment(s.DB, run, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web"}})
+		store.StorePod(s.DB, run, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web-5d9c7-abcde"},
+			Status:     corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}},
+		}, deploymentID, 0)
+		if err := store.StoreLogLines(s.DB, run.RunID, deploymentID, "prod", "web-5d9c7-abcde", []byte("2024-05-15T10:30:00Z started\n")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.DB.Exec(`INSERT INTO deployment_logs (deployment_id, logs) VALUES (?, ?)`, deploymentID, "started"); err != nil {
+			t.Fatal(err)
+		}
+		runs = append(runs, run)
+	}
+
+	pruned, err := store.PruneRuns(s.DB, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 1 {
+		t.Errorf("pruned %d runs, want 1", pruned)
+	}
+	for _, table := range []string{"deployments", "pods", "pod_conditions", "log_lines"} {
+		if got := kubetest.Count(t, s.DB, table, "run_id = ?", runs[0].RunID); got != 0 {
+			t.Errorf("%s has %d rows of the pruned run", table, got)
+		}
+		if got := kubetest.Count(t, s.DB, table, "run_id = ?", runs[2].RunID); got == 0 {
+			t.Errorf("%s has no rows of the newest run", table)
+		}
+	}
+	if got := kubetest.Count(t, s.DB, "deployment_logs", ""); got != 2 {
+		t.Errorf("deployment_logs has %d rows, want 2", got)
+	}
+	if got := kubetest.Count(t, s.DB, "runs", ""); got != 2 {
+		t.Errorf("runs has %d rows, want 2", got)
+	}
+
+	if pruned, err := store.PruneRuns(s.DB, 2); err != nil || pruned != 0 {
+		t.Errorf("pruning again = %d, %v; want nothing pruned", pruned, err)
+	}
+}
+
+func TestPruneLogLines(t *testing.T) {
+	s := kubetest.NewStore(t)
+	run := kubetest.NewRun(t, s)
+
+	lines := []string{
+		"2024-05-01T09:00:00.5Z starting",
+		"2024-05-01T10:00:00Z listening on :8080",
+		"no timestamp",
+		"2024-05-01T11:00:00.25Z GET /healthz 200",
+	}
+	if err := store.AppendLogLines(s.DB, run.RunID, 0, "prod", "web-5d9c7-abcde", "web", 1, lines); err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err := store.PruneLogLines(s.DB, run.RunID, time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 2 {
+		t.Errorf("pruned %d log lines, want 2", pruned)
+	}
+	if got := kubetest.Count(t, s.DB, "log_lines", "container = 'web' AND line_number IN (3, 4)"); got != 2 {
+		t.Errorf("kept %d of the untimestamped and newer lines, want 2", got)
+	}
+}
@@ -0,0 +1,1060 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func Initialize(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS deployments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			namespace TEXT,
+			name TEXT,
+			spec TEXT,
+			status TEXT
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating deployments table: %v", err)
+	}
+
+	// deployment_logs holds the logs of runs gathered before each line was
+	// stored in log_lines alone.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS deployment_logs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			deployment_id INTEGER,
+			logs BLOB,
+			FOREIGN KEY(deployment_id) REFERENCES deployments(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating deployment_logs table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS configmaps (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			namespace TEXT,
+			name TEXT,
+			data TEXT
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating configmaps table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS secrets (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			namespace TEXT,
+			name TEXT,
+			data TEXT
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating secrets table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS deployment_dependencies (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			deployment_id INTEGER,
+			resource_type TEXT,
+			resource_id INTEGER,
+			FOREIGN KEY(deployment_id) REFERENCES deployments(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating deployment_dependencies table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			started_at TIMESTAMP,
+			finished_at TIMESTAMP,
+			summary TEXT
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating runs table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS pods (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			deployment_id INTEGER,
+			namespace TEXT,
+			name TEXT,
+			spec TEXT,
+			status TEXT,
+			FOREIGN KEY(deployment_id) REFERENCES deployments(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating pods table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS log_lines (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			deployment_id INTEGER,
+			namespace TEXT,
+			pod TEXT,
+			line_number INTEGER,
+			timestamp TIMESTAMP,
+			line TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id),
+			FOREIGN KEY(deployment_id) REFERENCES deployments(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating log_lines table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			deployment_id INTEGER,
+			namespace TEXT,
+			involved_kind TEXT,
+			involved_name TEXT,
+			reason TEXT,
+			type TEXT,
+			message TEXT,
+			count INTEGER,
+			first_seen TIMESTAMP,
+			last_seen TIMESTAMP,
+			source_component TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id),
+			FOREIGN KEY(deployment_id) REFERENCES deployments(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating events table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS objects (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			kind TEXT,
+			namespace TEXT,
+			name TEXT,
+			metadata TEXT,
+			spec TEXT,
+			status TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating objects table: %v", err)
+	}
+
+	if err := initializeLogIndex(db); err != nil {
+		return err
+	}
+
+	if err := initializeUsageTables(db); err != nil {
+		return err
+	}
+
+	if err := initializeKubeletStatsTables(db); err != nil {
+		return err
+	}
+
+	if err := initializeScrapeTable(db); err != nil {
+		return err
+	}
+
+	if err := initializeExecTable(db); err != nil {
+		return err
+	}
+
+	if err := initializeCopyTable(db); err != nil {
+		return err
+	}
+
+	if err := initializeControlPlaneTable(db); err != nil {
+		return err
+	}
+	if err := initializeInventoryTable(db); err != nil {
+		return err
+	}
+	if err := initializeListVersionsTable(db); err != nil {
+		return err
+	}
+	if err := initializePluginsTable(db); err != nil {
+		return err
+	}
+	if err := initializeCRDSchemaTable(db); err != nil {
+		return err
+	}
+	if err := initializeGatherErrorsTable(db); err != nil {
+		return err
+	}
+	if err := initializeWarningsTable(db); err != nil {
+		return err
+	}
+	if err := initializeTombstonesTable(db); err != nil {
+		return err
+	}
+	if err := initializeOversizedObjectsTable(db); err != nil {
+		return err
+	}
+	if err := initializePodStatusTables(db); err != nil {
+		return err
+	}
+	if err := initializeAlertsTable(db); err != nil {
+		return err
+	}
+	if err := initializeLogSamplingTable(db); err != nil {
+		return err
+	}
+	if err := initializeNodeLogsTable(db); err != nil {
+		return err
+	}
+	if err := initializeNodeStatusTable(db); err != nil {
+		return err
+	}
+	if err := initializeImagePullFailuresTable(db); err != nil {
+		return err
+	}
+	if err := initializeRolloutsTable(db); err != nil {
+		return err
+	}
+	if err := initializeRequestsTable(db); err != nil {
+		return err
+	}
+	if err := initializeQuotaUsageTable(db); err != nil {
+		return err
+	}
+	if err := initializeRouteBackendsTable(db); err != nil {
+		return err
+	}
+	if err := initializeVPARecommendationsTable(db); err != nil {
+		return err
+	}
+	if err := initializeCSRStatusTable(db); err != nil {
+		return err
+	}
+	if err := initializeScalesTable(db); err != nil {
+		return err
+	}
+
+	// Databases written by older versions lack the run_id and metadata columns.
+	for _, table := range []string{"deployments", "configmaps", "secrets", "pods"} {
+		if err := ensureColumn(db, table, "run_id", "INTEGER REFERENCES runs(id)"); err != nil {
+			return err
+		}
+		if err := ensureColumn(db, table, "metadata", "TEXT"); err != nil {
+			return err
+		}
+	}
+
+	// ConfigMaps' binaryData is kept apart from their string data.
+	if err := ensureColumn(db, "configmaps", "binary_data", "TEXT"); err != nil {
+		return err
+	}
+
+	// Resources are stored with a hash of their content, so identical
+	// content is found without comparing it.
+	for _, table := range []string{"deployments", "configmaps", "secrets", "pods", "objects"} {
+		if err := ensureColumn(db, table, "content_hash", "TEXT"); err != nil {
+			return err
+		}
+	}
+
+	// Pods owned by a workload kept in the objects table link to it here
+	// rather than through deployment_id.
+	if err := ensureColumn(db, "pods", "object_id", "INTEGER REFERENCES objects(id)"); err != nil {
+		return err
+	}
+
+	// Runs cancelled part way through are kept, marked as interrupted.
+	if err := ensureColumn(db, "runs", "interrupted", "INTEGER"); err != nil {
+		return err
+	}
+
+	// Runs record the resource version their lists were consistent with.
+	if err := ensureColumn(db, "runs", "resource_version", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "runs", "resource_version_match", "TEXT"); err != nil {
+		return err
+	}
+
+	// Log lines record their container, and their severity.
+	if err := ensureColumn(db, "log_lines", "container", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "log_lines", "level", "TEXT"); err != nil {
+		return err
+	}
+	// Runs started automatically record what started them.
+	if err := ensureColumn(db, "runs", "trigger", "TEXT"); err != nil {
+		return err
+	}
+
+	// Events link to the object they are about.
+	if err := ensureColumn(db, "events", "pod_id", "INTEGER REFERENCES pods(id)"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "events", "object_id", "INTEGER REFERENCES objects(id)"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "events", "involved_namespace", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "events", "involved_uid", "TEXT"); err != nil {
+		return err
+	}
+
+	// Pods record their priority, for reconstructing preemptions.
+	if err := ensureColumn(db, "pods", "priority_class_name", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "pods", "priority", "INTEGER"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "pods", "preemption_policy", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "pods", "nominated_node_name", "TEXT"); err != nil {
+		return err
+	}
+
+	// Rollouts link to the ControllerRevision stored in objects they come from.
+	if err := ensureColumn(db, "rollouts", "object_id", "INTEGER REFERENCES objects(id)"); err != nil {
+		return err
+	}
+
+	// Logs are sampled per container.
+	if err := ensureColumn(db, "log_sampling", "container", "TEXT"); err != nil {
+		return err
+	}
+
+	// Views select the migrated columns, so come last.
+	return initializeViews(db)
+}
+
+// ensureColumn adds a column to an existing table if it is not already present.
+func ensureColumn(db *sql.DB, table, column, decl string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("Error inspecting %s table: %v", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("Error inspecting %s table: %v", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("Error inspecting %s table: %v", table, err)
+	}
+	rows.Close()
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl))
+	if err != nil {
+		return fmt.Errorf("Error adding %s column to %s table: %v", column, table, err)
+	}
+	return nil
+}
+
+func initializeAlertsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS alerts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			fingerprint TEXT,
+			alertname TEXT,
+			status TEXT,
+			namespace TEXT,
+			starts_at TIMESTAMP,
+			generator_url TEXT,
+			labels TEXT,
+			annotations TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating alerts table: %v", err)
+	}
+	return nil
+}
+
+func initializeControlPlaneTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS control_plane (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			component TEXT,
+			name TEXT,
+			status TEXT,
+			detail TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating control_plane table: %v", err)
+	}
+	return nil
+}
+
+func initializeCopyTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS pod_files (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			namespace TEXT,
+			pod TEXT,
+			container TEXT,
+			path TEXT,
+			size INTEGER,
+			mode INTEGER,
+			modified_at TIMESTAMP,
+			content BLOB,
+			truncated BOOLEAN,
+			error TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating pod_files table: %v", err)
+	}
+	return nil
+}
+
+func initializeCSRStatusTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS csr_status (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			object_id INTEGER,
+			name TEXT,
+			signer_name TEXT,
+			username TEXT,
+			usages TEXT,
+			expiration_seconds INTEGER,
+			created_at TIMESTAMP,
+			state TEXT,
+			approved BOOLEAN,
+			denied BOOLEAN,
+			failed BOOLEAN,
+			reason TEXT,
+			message TEXT,
+			decided_at TIMESTAMP,
+			FOREIGN KEY(run_id) REFERENCES runs(id),
+			FOREIGN KEY(object_id) REFERENCES objects(id)
+		);
+		CREATE INDEX IF NOT EXISTS csr_status_run ON csr_status (run_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating csr_status table: %v", err)
+	}
+	return nil
+}
+
+func initializeCRDSchemaTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS crd_schemas (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			crd_id INTEGER,
+			version TEXT,
+			served BOOLEAN,
+			storage BOOLEAN,
+			schema TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id),
+			FOREIGN KEY(crd_id) REFERENCES objects(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating crd_schemas table: %v", err)
+	}
+
+	// Custom resources gathered with their definition link to it here.
+	return ensureColumn(db, "objects", "definition_id", "INTEGER REFERENCES objects(id)")
+}
+
+func initializeExecTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS pod_exec (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			namespace TEXT,
+			pod TEXT,
+			container TEXT,
+			command TEXT,
+			started_at TIMESTAMP,
+			exit_code INTEGER,
+			stdout TEXT,
+			stderr TEXT,
+			truncated BOOLEAN,
+			error TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating pod_exec table: %v", err)
+	}
+
+	// Set when the commands ran in an ephemeral debug container.
+	return ensureColumn(db, "pod_exec", "debug_container", "TEXT")
+}
+
+func initializeGatherErrorsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS gather_errors (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			namespace TEXT,
+			kind TEXT,
+			name TEXT,
+			phase TEXT,
+			error TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating gather_errors table: %v", err)
+	}
+	return nil
+}
+
+func initializeImagePullFailuresTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS image_pull_failures (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			pod_id INTEGER,
+			event_id INTEGER,
+			namespace TEXT,
+			pod TEXT,
+			container TEXT,
+			image TEXT,
+			registry TEXT,
+			reason TEXT,
+			message TEXT,
+			auth_error BOOLEAN,
+			FOREIGN KEY(run_id) REFERENCES runs(id),
+			FOREIGN KEY(pod_id) REFERENCES pods(id),
+			FOREIGN KEY(event_id) REFERENCES events(id)
+		);
+		CREATE INDEX IF NOT EXISTS image_pull_failures_run ON image_pull_failures (run_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating image_pull_failures table: %v", err)
+	}
+	return nil
+}
+
+func initializeInventoryTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS inventory (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			kind TEXT,
+			api_version TEXT,
+			namespace TEXT,
+			name TEXT,
+			labels TEXT,
+			created_at TIMESTAMP,
+			owner_kind TEXT,
+			owner_name TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+		CREATE INDEX IF NOT EXISTS inventory_run_kind ON inventory (run_id, kind);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating inventory table: %v", err)
+	}
+	return nil
+}
+
+func initializeKubeletStatsTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS node_stats (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			node TEXT,
+			fs_available_bytes INTEGER,
+			fs_capacity_bytes INTEGER,
+			fs_inodes_free INTEGER,
+			imagefs_available_bytes INTEGER,
+			imagefs_capacity_bytes INTEGER,
+			memory_available_bytes INTEGER,
+			pleg_metrics TEXT,
+			summary TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating node_stats table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS pod_stats (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			node TEXT,
+			namespace TEXT,
+			pod TEXT,
+			cpu_nanocores INTEGER,
+			memory_working_set_bytes INTEGER,
+			ephemeral_storage_used_bytes INTEGER,
+			volumes TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating pod_stats table: %v", err)
+	}
+	return nil
+}
+
+func initializeListVersionsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS list_versions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			kind TEXT,
+			namespace TEXT,
+			selector TEXT,
+			resource_version TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating list_versions table: %v", err)
+	}
+	return nil
+}
+
+func initializeLogSamplingTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS log_sampling (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			namespace TEXT,
+			pod TEXT,
+			method TEXT,
+			lines INTEGER,
+			kept INTEGER,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating log_sampling table: %v", err)
+	}
+	return nil
+}
+
+func initializeNodeLogsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS node_logs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			node TEXT,
+			unit TEXT,
+			source TEXT,
+			content TEXT,
+			truncated BOOLEAN,
+			error TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating node_logs table: %v", err)
+	}
+	return nil
+}
+
+func initializeNodeStatusTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS node_status (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			object_id INTEGER,
+			node TEXT,
+			cpu_capacity_millicores INTEGER,
+			cpu_allocatable_millicores INTEGER,
+			memory_capacity_bytes INTEGER,
+			memory_allocatable_bytes INTEGER,
+			pods_capacity INTEGER,
+			pods_allocatable INTEGER,
+			ephemeral_storage_capacity_bytes INTEGER,
+			ephemeral_storage_allocatable_bytes INTEGER,
+			ready BOOLEAN,
+			memory_pressure BOOLEAN,
+			disk_pressure BOOLEAN,
+			pid_pressure BOOLEAN,
+			unschedulable BOOLEAN,
+			FOREIGN KEY(run_id) REFERENCES runs(id),
+			FOREIGN KEY(object_id) REFERENCES objects(id)
+		);
+		CREATE INDEX IF NOT EXISTS node_status_run ON node_status (run_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating node_status table: %v", err)
+	}
+	return nil
+}
+
+func initializeOversizedObjectsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS oversized_objects (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			kind TEXT,
+			namespace TEXT,
+			name TEXT,
+			bytes INTEGER,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating oversized_objects table: %v", err)
+	}
+	return nil
+}
+
+func initializePluginsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS plugins (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			name TEXT,
+			path TEXT,
+			exit_code INTEGER,
+			duration_ms INTEGER,
+			objects INTEGER,
+			stderr TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating plugins table: %v", err)
+	}
+	return nil
+}
+
+func initializePodStatusTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS pod_conditions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			pod_id INTEGER,
+			namespace TEXT,
+			pod TEXT,
+			type TEXT,
+			status TEXT,
+			reason TEXT,
+			message TEXT,
+			last_transition_time TIMESTAMP,
+			FOREIGN KEY(run_id) REFERENCES runs(id),
+			FOREIGN KEY(pod_id) REFERENCES pods(id)
+		);
+		CREATE INDEX IF NOT EXISTS pod_conditions_run_type ON pod_conditions (run_id, type);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating pod_conditions table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS container_statuses (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			pod_id INTEGER,
+			namespace TEXT,
+			pod TEXT,
+			container TEXT,
+			init BOOLEAN,
+			image TEXT,
+			ready BOOLEAN,
+			restart_count INTEGER,
+			state TEXT,
+			reason TEXT,
+			last_termination_reason TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id),
+			FOREIGN KEY(pod_id) REFERENCES pods(id)
+		);
+		CREATE INDEX IF NOT EXISTS container_statuses_run ON container_statuses (run_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating container_statuses table: %v", err)
+	}
+	return nil
+}
+
+func initializeQuotaUsageTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS quota_usage (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			object_id INTEGER,
+			namespace TEXT,
+			quota TEXT,
+			resource TEXT,
+			hard TEXT,
+			used TEXT,
+			hard_value REAL,
+			used_value REAL,
+			FOREIGN KEY(run_id) REFERENCES runs(id),
+			FOREIGN KEY(object_id) REFERENCES objects(id)
+		);
+		CREATE INDEX IF NOT EXISTS quota_usage_run ON quota_usage (run_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating quota_usage table: %v", err)
+	}
+	return nil
+}
+
+func initializeRequestsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS requests (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			started_at TIMESTAMP,
+			verb TEXT,
+			method TEXT,
+			path TEXT,
+			api_group TEXT,
+			resource TEXT,
+			subresource TEXT,
+			namespace TEXT,
+			name TEXT,
+			code INTEGER,
+			error TEXT,
+			duration_ms REAL,
+			retries INTEGER,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+		CREATE INDEX IF NOT EXISTS requests_run ON requests (run_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating requests table: %v", err)
+	}
+	return nil
+}
+
+func initializeRolloutsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS rollouts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			kind TEXT,
+			namespace TEXT,
+			workload TEXT,
+			revision INTEGER,
+			source_kind TEXT,
+			source_name TEXT,
+			change_cause TEXT,
+			created_at TIMESTAMP,
+			replicas INTEGER,
+			template TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+		CREATE INDEX IF NOT EXISTS rollouts_run_workload ON rollouts (run_id, namespace, workload);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating rollouts table: %v", err)
+	}
+	return nil
+}
+
+func initializeRouteBackendsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS route_backends (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			object_id INTEGER,
+			namespace TEXT,
+			route TEXT,
+			rule INTEGER,
+			backend_kind TEXT,
+			backend_namespace TEXT,
+			backend_name TEXT,
+			port INTEGER,
+			weight INTEGER,
+			FOREIGN KEY(run_id) REFERENCES runs(id),
+			FOREIGN KEY(object_id) REFERENCES objects(id)
+		);
+		CREATE INDEX IF NOT EXISTS route_backends_run ON route_backends (run_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating route_backends table: %v", err)
+	}
+	return nil
+}
+
+func initializeScalesTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS scales (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			deployment_id INTEGER,
+			object_id INTEGER,
+			kind TEXT,
+			namespace TEXT,
+			name TEXT,
+			spec_replicas INTEGER,
+			status_replicas INTEGER,
+			selector TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id),
+			FOREIGN KEY(deployment_id) REFERENCES deployments(id),
+			FOREIGN KEY(object_id) REFERENCES objects(id)
+		);
+		CREATE INDEX IF NOT EXISTS scales_run ON scales (run_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating scales table: %v", err)
+	}
+	return nil
+}
+
+func initializeScrapeTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS pod_scrapes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			namespace TEXT,
+			pod TEXT,
+			port TEXT,
+			path TEXT,
+			scraped_at TIMESTAMP,
+			body TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating pod_scrapes table: %v", err)
+	}
+	return nil
+}
+
+func initializeTombstonesTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS tombstones (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			kind TEXT,
+			namespace TEXT,
+			name TEXT,
+			last_run_id INTEGER,
+			detected_at TIMESTAMP,
+			FOREIGN KEY(run_id) REFERENCES runs(id),
+			FOREIGN KEY(last_run_id) REFERENCES runs(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating tombstones table: %v", err)
+	}
+	return nil
+}
+
+func initializeUsageTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS pod_metrics (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			namespace TEXT,
+			pod TEXT,
+			container TEXT,
+			timestamp TIMESTAMP,
+			window_seconds REAL,
+			cpu_millicores INTEGER,
+			memory_bytes INTEGER,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating pod_metrics table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS node_metrics (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			node TEXT,
+			timestamp TIMESTAMP,
+			window_seconds REAL,
+			cpu_millicores INTEGER,
+			memory_bytes INTEGER,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating node_metrics table: %v", err)
+	}
+	return nil
+}
+
+func initializeVPARecommendationsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS vpa_recommendations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			object_id INTEGER,
+			namespace TEXT,
+			vpa TEXT,
+			target_kind TEXT,
+			target_name TEXT,
+			deployment_id INTEGER,
+			workload_id INTEGER,
+			update_mode TEXT,
+			container TEXT,
+			target_cpu_millicores INTEGER,
+			target_memory_bytes INTEGER,
+			lower_bound_cpu_millicores INTEGER,
+			lower_bound_memory_bytes INTEGER,
+			upper_bound_cpu_millicores INTEGER,
+			upper_bound_memory_bytes INTEGER,
+			uncapped_target_cpu_millicores INTEGER,
+			uncapped_target_memory_bytes INTEGER,
+			FOREIGN KEY(run_id) REFERENCES runs(id),
+			FOREIGN KEY(object_id) REFERENCES objects(id),
+			FOREIGN KEY(deployment_id) REFERENCES deployments(id),
+			FOREIGN KEY(workload_id) REFERENCES objects(id)
+		);
+		CREATE INDEX IF NOT EXISTS vpa_recommendations_run ON vpa_recommendations (run_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating vpa_recommendations table: %v", err)
+	}
+	return nil
+}
+
+func initializeWarningsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS warnings (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			kind TEXT,
+			namespace TEXT,
+			name TEXT,
+			text TEXT,
+			count INTEGER,
+			FOREIGN KEY(run_id) REFERENCES runs(id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("Error creating warnings table: %v", err)
+	}
+	return nil
+}
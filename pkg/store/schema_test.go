@@ -0,0 +1,202 @@
+package store_test
+
+import (
+	"database/sql"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/store"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden schema files")
+
+// TestSchemaGolden compares the schema of a new database with the golden
+// dump, so schema changes show up in review. Run with -update to accept a
+// change, and add a migration test fixture holding the schema from before
+// it, as created by the last version without the change, so the change's
+// migration is tested.
+func TestSchemaGolden(t *testing.T) {
+	s := kubetest.NewStore(t)
+	got := dumpSchema(t, s.DB)
+
+	// Builds with FTS5 add the log index, so have a golden file of their own.
+	golden := "testdata/schema.golden"
+	if strings.Contains(got, "log_lines_fts") {
+		golden = "testdata/schema_fts5.golden"
+	}
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("schema differs from %s; rerun with -update if the change is intended:\n%s", golden, got)
+	}
+}
+
+// TestMigrations opens a database created by each earlier schema, holding a
+// row in every table, and checks it is upgraded to the current tables and
+// columns without losing rows, and can then be gathered into.
+func TestMigrations(t *testing.T) {
+	fixtures, err := filepath.Glob("testdata/migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no migration fixtures found")
+	}
+	current := tableColumns(t, kubetest.NewStore(t).DB)
+
+	for _, fixture := range fixtures {
+		t.Run(strings.TrimSuffix(filepath.Base(fixture), ".sql"), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "old.db")
+			rows := createOldDatabase(t, path, fixture)
+
+			s, err := store.Open(path)
+			if err != nil {
+				t.Fatalf("upgrading: %v", err)
+			}
+			defer s.Close()
+
+			migrated := tableColumns(t, s.DB)
+			for table, columns := range current {
+				if got := migrated[table]; got != columns {
+					t.Errorf("table %s has columns %s, want %s", table, got, columns)
+				}
+			}
+			for table, want := range rows {
+				if got := kubetest.Count(t, s.DB, table, ""); got != want {
+					t.Errorf("table %s has %d rows after upgrading, want %d", table, got, want)
+				}
+			}
+
+			// Opening an upgraded database again changes nothing.
+			again, err := store.Open(path)
+			if err != nil {
+				t.Fatalf("reopening: %v", err)
+			}
+			again.Close()
+
+			run := store.NewRun(time.Now())
+			if err := run.Begin(s.DB); err != nil {
+				t.Fatal(err)
+			}
+			store.StoreConfigMap(s.DB, run, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web-config"},
+				Data:       map[string]string{"LOG_LEVEL": "info"},
+			})
+			run.Finish(time.Now(), path)
+			if err := run.Complete(s.DB, true); err != nil {
+				t.Fatal(err)
+			}
+			if run.Errors != 0 {
+				t.Fatalf("gathering into upgraded database: %v", run.Err())
+			}
+			if _, err := store.GetResource(s.DB, run.RunID, "configmap", "prod", "web-config"); err != nil {
+				t.Errorf("reading back gathered configmap: %v", err)
+			}
+		})
+	}
+}
+
+// createOldDatabase creates a database from a schema fixture with a row in
+// each table, returning the number of rows in each.
+func createOldDatabase(t *testing.T, path, fixture string) map[string]int {
+	t.Helper()
+	schema, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatalf("creating database from %s: %v", fixture, err)
+	}
+
+	rows := map[string]int{}
+	for table := range tableColumns(t, db) {
+		if _, err := db.Exec(`INSERT INTO ` + table + ` DEFAULT VALUES`); err != nil {
+			t.Fatalf("inserting into %s: %v", table, err)
+		}
+		rows[table] = 1
+	}
+	return rows
+}
+
+// tableColumns returns the sorted column names of each table.
+func tableColumns(t *testing.T, db *sql.DB) map[string]string {
+	t.Helper()
+	tables, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND sql NOT LIKE 'CREATE VIRTUAL%' AND name NOT LIKE '%_fts_%'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for tables.Next() {
+		var name string
+		if err := tables.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	tables.Close()
+
+	columns := map[string]string{}
+	for _, name := range names {
+		info, err := db.Query(`SELECT name FROM pragma_table_info(?)`, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cols []string
+		for info.Next() {
+			var col string
+			if err := info.Scan(&col); err != nil {
+				t.Fatal(err)
+			}
+			cols = append(cols, col)
+		}
+		info.Close()
+		sort.Strings(cols)
+		columns[name] = strings.Join(cols, ",")
+	}
+	return columns
+}
+
+// dumpSchema returns the statements creating every table and index, in name
+// order.
+func dumpSchema(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	rows, err := db.Query(`SELECT sql FROM sqlite_master WHERE sql IS NOT NULL ORDER BY type, name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var b strings.Builder
+	for rows.Next() {
+		var statement string
+		if err := rows.Scan(&statement); err != nil {
+			t.Fatal(err)
+		}
+		b.WriteString(statement)
+		b.WriteString(";\n")
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
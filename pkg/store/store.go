@@ -0,0 +1,29 @@
+// Package store reads and writes kube-gather databases: the SQLite schema,
+// the runs gathered into them, and each run's resources, logs and events.
+package store
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Store is a database gathered into, one run at a time.
+type Store struct {
+	*sql.DB
+	// Path is the database file, whose size is recorded with each run.
+	Path string
+}
+
+// Open opens the database at path for writing, creating the file and its
+// tables if need be.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening database: %v", err)
+	}
+	if err := Initialize(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Error initializing database: %v", err)
+	}
+	return &Store{DB: db, Path: path}, nil
+}
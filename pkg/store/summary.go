@@ -0,0 +1,244 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"kube-query/pkg/metrics"
+)
+
+// Run is a single gather or import into a store. It accumulates counters so
+// they can be reported once at the end instead of being interleaved with
+// progress output, and collects the errors that failed part of the run
+// without stopping it.
+type Run struct {
+	RunID      int64          `json:"run_id"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+	Duration   time.Duration  `json:"duration"`
+	Gathered   map[string]int `json:"gathered"`
+	LogBytes   int64          `json:"log_bytes"`
+	// Warnings counts the warnings the API server returned, such as for
+	// deprecated APIs, which are kept in the warnings table.
+	Warnings int `json:"warnings"`
+	Errors   int `json:"errors"`
+	// ErrorsByPhase counts the errors of each GatherError phase.
+	ErrorsByPhase map[string]int `json:"errors_by_phase,omitempty"`
+	// Failures are the errors counted in Errors, in the order they
+	// happened.
+	Failures ErrorReport `json:"failures,omitempty"`
+	Skipped  int         `json:"skipped"`
+	// Deleted counts the objects the previous run gathered that this one
+	// found gone, which are recorded in the tombstones table.
+	Deleted int `json:"deleted,omitempty"`
+	// Oversized counts the objects stored without their content for
+	// exceeding MaxObjectBytes, which are recorded in oversized_objects.
+	Oversized int   `json:"oversized,omitempty"`
+	DBSize    int64 `json:"db_size"`
+	// Interrupted is set when the gather was cancelled before it finished,
+	// leaving the run partial.
+	Interrupted bool `json:"interrupted,omitempty"`
+	// ResourceVersion is the resource version the run's first list was
+	// served at, which later lists are pinned to according to
+	// ResourceVersionMatch ("" for none, NotOlderThan or Exact).
+	ResourceVersion      string `json:"resource_version,omitempty"`
+	ResourceVersionMatch string `json:"resource_version_match,omitempty"`
+	// Trigger says what started the run, such as a schedule or a
+	// crash-looping pod, or is "" for a run started by hand.
+	Trigger string `json:"trigger,omitempty"`
+
+	// OnError and OnLogBytes, if set, are called as errors and log bytes
+	// are added. Errors are logged instead when OnError is nil.
+	OnError    func(err error)                      `json:"-"`
+	OnLogBytes func(namespace, pod string, n int64) `json:"-"`
+	// KeepManagedFields stores objects' managedFields and last-applied
+	// annotations, which are stripped by default.
+	KeepManagedFields bool `json:"-"`
+	// MaxObjectBytes, if positive, limits the size of the content stored
+	// for each object; larger objects are stored with their metadata alone.
+	MaxObjectBytes int64 `json:"-"`
+
+	// db is where errors are recorded, once Begin has been called.
+	db *sql.DB
+}
+
+func NewRun(start time.Time) *Run {
+	return &Run{
+		StartedAt: start,
+		Gathered:  map[string]int{},
+	}
+}
+
+func (s *Run) AddGathered(kind string) {
+	s.Gathered[kind]++
+	metrics.ObjectsGathered.Add(1, kind)
+}
+
+func (s *Run) AddLogBytes(namespace, pod string, n int64) {
+	s.LogBytes += n
+	if s.OnLogBytes != nil {
+		s.OnLogBytes(namespace, pod, n)
+	}
+}
+
+// AddError reports an error that failed part of the run and adds it to the
+// run's error report.
+func (s *Run) AddError(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	} else {
+		log.Printf("%v\n", err)
+	}
+	s.Errors++
+	s.Failures = append(s.Failures, err)
+	metrics.Errors.Add(1)
+
+	var gatherErr *GatherError
+	if errors.As(err, &gatherErr) {
+		if s.ErrorsByPhase == nil {
+			s.ErrorsByPhase = map[string]int{}
+		}
+		s.ErrorsByPhase[gatherErr.Phase]++
+	}
+	if s.db != nil {
+		storeGatherError(s.db, s.RunID, err)
+	}
+}
+
+// Err returns the run's errors joined into one, or nil if there were none.
+func (s *Run) Err() error {
+	return errors.Join(s.Failures...)
+}
+
+func (s *Run) AddSkipped() {
+	s.Skipped++
+}
+
+// Finish stamps the end time and records the size of the database file.
+func (s *Run) Finish(end time.Time, dbFile string) {
+	s.FinishedAt = end
+	s.Duration = end.Sub(s.StartedAt)
+	if info, err := os.Stat(dbFile); err == nil {
+		s.DBSize = info.Size()
+		metrics.BundleSize.Set(float64(s.DBSize))
+	}
+}
+
+// maxPrintedFailures is how many of a run's errors Print lists under the
+// error count; all of them were logged as they happened.
+const maxPrintedFailures = 10
+
+// Print writes the summary, listing the first errors of the run.
+func (s *Run) Print(w io.Writer) {
+	kinds := make([]string, 0, len(s.Gathered))
+	for kind := range s.Gathered {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	gathered := make([]string, 0, len(kinds))
+	for _, kind := range kinds {
+		gathered = append(gathered, fmt.Sprintf("%s=%d", kind, s.Gathered[kind]))
+	}
+	if len(gathered) == 0 {
+		gathered = append(gathered, "none")
+	}
+
+	if s.Interrupted {
+		fmt.Fprintf(w, "Gather summary (run %d, interrupted)\n", s.RunID)
+	} else {
+		fmt.Fprintf(w, "Gather summary (run %d)\n", s.RunID)
+	}
+	fmt.Fprintf(w, "  Duration:   %s\n", s.Duration.Round(time.Millisecond))
+	fmt.Fprintf(w, "  Resources:  %s\n", strings.Join(gathered, " "))
+	fmt.Fprintf(w, "  Log bytes:  %d\n", s.LogBytes)
+	fmt.Fprintf(w, "  Warnings:   %d\n", s.Warnings)
+	fmt.Fprintf(w, "  Errors:     %d%s\n", s.Errors, phaseCounts(s.ErrorsByPhase))
+	fmt.Fprintf(w, "  Skipped:    %d\n", s.Skipped)
+	fmt.Fprintf(w, "  Deleted:    %d\n", s.Deleted)
+	fmt.Fprintf(w, "  Oversized:  %d\n", s.Oversized)
+	fmt.Fprintf(w, "  DB size:    %d bytes\n", s.DBSize)
+	for i, err := range s.Failures {
+		if i == maxPrintedFailures {
+			fmt.Fprintf(w, "    ... and %d more\n", len(s.Failures)-i)
+			break
+		}
+		fmt.Fprintf(w, "    %v\n", err)
+	}
+}
+
+// phaseCounts formats error counts by phase as " (fetch=2 logs=4)", or ""
+// if there are none.
+func phaseCounts(counts map[string]int) string {
+	if len(counts) == 0 {
+		return ""
+	}
+	phases := make([]string, 0, len(counts))
+	for phase := range counts {
+		phases = append(phases, phase)
+	}
+	sort.Strings(phases)
+	for i, phase := range phases {
+		phases[i] = fmt.Sprintf("%s=%d", phase, counts[phase])
+	}
+	return " (" + strings.Join(phases, " ") + ")"
+}
+
+// Begin records the start of the run, assigning the run ID that gathered
+// rows are stamped with.
+func (s *Run) Begin(db *sql.DB) error {
+	result, err := db.Exec(`
+		INSERT INTO runs (started_at, trigger) VALUES (?, ?)
+	`, s.StartedAt, sql.NullString{String: s.Trigger, Valid: s.Trigger != ""})
+	if err != nil {
+		return fmt.Errorf("Error inserting run into database: %v", err)
+	}
+
+	s.RunID, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("Error getting last insert ID: %v", err)
+	}
+	s.db = db
+	return nil
+}
+
+// Complete marks the run finished, optionally storing the summary with it.
+func (s *Run) Complete(db *sql.DB, storeSummary bool) error {
+	var summary sql.NullString
+	if storeSummary {
+		summaryBytes, err := json.Marshal(s)
+		if err != nil {
+			return fmt.Errorf("Error marshalling run summary: %v", err)
+		}
+		summary = sql.NullString{String: string(summaryBytes), Valid: true}
+	}
+
+	_, err := db.Exec(`
+		UPDATE runs SET finished_at = ?, summary = ?, interrupted = ?, resource_version = ?, resource_version_match = ? WHERE id = ?
+	`, s.FinishedAt, summary, s.Interrupted, s.ResourceVersion, s.ResourceVersionMatch, s.RunID)
+	if err != nil {
+		return fmt.Errorf("Error updating run in database: %v", err)
+	}
+	metrics.Runs.Add(1)
+	return nil
+}
+
+// ErrorReport is the errors of a run, stored in its summary as their
+// messages.
+type ErrorReport []error
+
+func (r ErrorReport) MarshalJSON() ([]byte, error) {
+	messages := make([]string, len(r))
+	for i, err := range r {
+		messages[i] = err.Error()
+	}
+	return json.Marshal(messages)
+}
@@ -0,0 +1,39 @@
+-- Schema created by the version that added the runs table.
+CREATE TABLE deployments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			namespace TEXT,
+			name TEXT,
+			spec TEXT,
+			status TEXT
+		);
+CREATE TABLE deployment_logs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			deployment_id INTEGER,
+			logs BLOB,
+			FOREIGN KEY(deployment_id) REFERENCES deployments(id)
+		);
+CREATE TABLE configmaps (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			namespace TEXT,
+			name TEXT,
+			data TEXT
+		);
+CREATE TABLE secrets (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			namespace TEXT,
+			name TEXT,
+			data TEXT
+		);
+CREATE TABLE deployment_dependencies (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			deployment_id INTEGER,
+			resource_type TEXT,
+			resource_id INTEGER,
+			FOREIGN KEY(deployment_id) REFERENCES deployments(id)
+		);
+CREATE TABLE runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			started_at TIMESTAMP,
+			finished_at TIMESTAMP,
+			summary TEXT
+		);
@@ -0,0 +1,48 @@
+-- Schema created by the version that stamped resources with their run.
+CREATE TABLE deployments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			namespace TEXT,
+			name TEXT,
+			spec TEXT,
+			status TEXT
+		, run_id INTEGER REFERENCES runs(id));
+CREATE TABLE deployment_logs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			deployment_id INTEGER,
+			logs BLOB,
+			FOREIGN KEY(deployment_id) REFERENCES deployments(id)
+		);
+CREATE TABLE configmaps (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			namespace TEXT,
+			name TEXT,
+			data TEXT
+		, run_id INTEGER REFERENCES runs(id));
+CREATE TABLE secrets (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			namespace TEXT,
+			name TEXT,
+			data TEXT
+		, run_id INTEGER REFERENCES runs(id));
+CREATE TABLE deployment_dependencies (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			deployment_id INTEGER,
+			resource_type TEXT,
+			resource_id INTEGER,
+			FOREIGN KEY(deployment_id) REFERENCES deployments(id)
+		);
+CREATE TABLE runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			started_at TIMESTAMP,
+			finished_at TIMESTAMP,
+			summary TEXT
+		);
+CREATE TABLE pods (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			deployment_id INTEGER,
+			namespace TEXT,
+			name TEXT,
+			spec TEXT,
+			status TEXT, run_id INTEGER REFERENCES runs(id),
+			FOREIGN KEY(deployment_id) REFERENCES deployments(id)
+		);
@@ -0,0 +1,77 @@
+-- Schema created by the version that gathered deployment events.
+CREATE TABLE deployments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			namespace TEXT,
+			name TEXT,
+			spec TEXT,
+			status TEXT
+		, run_id INTEGER REFERENCES runs(id), metadata TEXT);
+CREATE TABLE deployment_logs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			deployment_id INTEGER,
+			logs BLOB,
+			FOREIGN KEY(deployment_id) REFERENCES deployments(id)
+		);
+CREATE TABLE configmaps (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			namespace TEXT,
+			name TEXT,
+			data TEXT
+		, run_id INTEGER REFERENCES runs(id), metadata TEXT);
+CREATE TABLE secrets (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			namespace TEXT,
+			name TEXT,
+			data TEXT
+		, run_id INTEGER REFERENCES runs(id), metadata TEXT);
+CREATE TABLE deployment_dependencies (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			deployment_id INTEGER,
+			resource_type TEXT,
+			resource_id INTEGER,
+			FOREIGN KEY(deployment_id) REFERENCES deployments(id)
+		);
+CREATE TABLE runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			started_at TIMESTAMP,
+			finished_at TIMESTAMP,
+			summary TEXT
+		);
+CREATE TABLE pods (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			deployment_id INTEGER,
+			namespace TEXT,
+			name TEXT,
+			spec TEXT,
+			status TEXT, run_id INTEGER REFERENCES runs(id), metadata TEXT,
+			FOREIGN KEY(deployment_id) REFERENCES deployments(id)
+		);
+CREATE TABLE log_lines (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			deployment_id INTEGER,
+			namespace TEXT,
+			pod TEXT,
+			line_number INTEGER,
+			timestamp TIMESTAMP,
+			line TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id),
+			FOREIGN KEY(deployment_id) REFERENCES deployments(id)
+		);
+CREATE TABLE events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER,
+			deployment_id INTEGER,
+			namespace TEXT,
+			involved_kind TEXT,
+			involved_name TEXT,
+			reason TEXT,
+			type TEXT,
+			message TEXT,
+			count INTEGER,
+			first_seen TIMESTAMP,
+			last_seen TIMESTAMP,
+			source_component TEXT,
+			FOREIGN KEY(run_id) REFERENCES runs(id),
+			FOREIGN KEY(deployment_id) REFERENCES deployments(id)
+		);
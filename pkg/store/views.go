@@ -0,0 +1,101 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// views join the normalized tables into the entities most questions are
+// about, created with the schema. They are recreated each time a database is
+// opened, so that a newer version's definitions replace an older one's.
+var views = []struct {
+	name, sql string
+}{
+	{
+		// v_workload_pod_specs is the pod template of every gathered
+		// workload: Deployments, StatefulSets, DaemonSets, Jobs, CronJobs
+		// and DeploymentConfigs.
+		name: "v_workload_pod_specs",
+		sql: `
+			SELECT run_id, 'deployment' AS kind, namespace, name, json_extract(spec, '$.template.spec') AS pod_spec
+			FROM deployments
+			UNION ALL
+			SELECT run_id, kind, namespace, name, json_extract(spec, '$.template.spec')
+			FROM objects
+			WHERE kind IN ('statefulset', 'daemonset', 'job', 'deploymentconfig')
+			UNION ALL
+			SELECT run_id, kind, namespace, name, json_extract(spec, '$.jobTemplate.spec.template.spec')
+			FROM objects
+			WHERE kind = 'cronjob'
+		`,
+	},
+	{
+		// v_workload_images is each container (and init container) of every
+		// gathered workload with its image.
+		name: "v_workload_images",
+		sql: `
+			SELECT w.run_id, w.kind, w.namespace, w.name,
+				json_extract(c.value, '$.name') AS container,
+				0 AS init,
+				json_extract(c.value, '$.image') AS image
+			FROM v_workload_pod_specs w, json_each(w.pod_spec, '$.containers') c
+			UNION ALL
+			SELECT w.run_id, w.kind, w.namespace, w.name,
+				json_extract(c.value, '$.name'),
+				1,
+				json_extract(c.value, '$.image')
+			FROM v_workload_pod_specs w, json_each(w.pod_spec, '$.initContainers') c
+		`,
+	},
+	{
+		// v_pod_restarts is each container that has restarted, with the
+		// workload its pod was gathered with, if any.
+		name: "v_pod_restarts",
+		sql: `
+			SELECT c.run_id, c.namespace, c.pod, c.container, c.init, c.restart_count,
+				c.state, c.reason, c.last_termination_reason,
+				CASE WHEN d.id IS NOT NULL THEN 'deployment' ELSE o.kind END AS workload_kind,
+				COALESCE(d.name, o.name) AS workload
+			FROM container_statuses c
+			LEFT JOIN pods p ON p.id = c.pod_id
+			LEFT JOIN deployments d ON d.id = p.deployment_id
+			LEFT JOIN objects o ON o.id = p.object_id
+			WHERE c.restart_count > 0
+		`,
+	},
+	{
+		// v_config_consumers is each ConfigMap and Secret a gathered
+		// workload's pods refer to, and whether the run gathered it.
+		name: "v_config_consumers",
+		sql: `
+			SELECT r.*,
+				EXISTS (SELECT 1 FROM configmaps m WHERE r.resource_kind = 'configmap'
+					AND m.run_id = r.run_id AND m.namespace = r.namespace AND m.name = r.resource)
+				OR EXISTS (SELECT 1 FROM secrets s WHERE r.resource_kind = 'secret'
+					AND s.run_id = r.run_id AND s.namespace = r.namespace AND s.name = r.resource) AS gathered
+			FROM (
+				SELECT DISTINCT w.run_id, w.kind, w.namespace, w.name,
+					CASE WHEN t.key LIKE 'configMap%' THEN 'configmap' ELSE 'secret' END AS resource_kind,
+					COALESCE(json_extract(t.value, '$.name'), json_extract(t.value, '$.secretName')) AS resource
+				FROM v_workload_pod_specs w, json_tree(w.pod_spec) t
+				WHERE t.key IN ('configMap', 'configMapRef', 'configMapKeyRef', 'secret', 'secretRef', 'secretKeyRef')
+			) r
+		`,
+	},
+}
+
+// initializeViews recreates the views, dependents after what they select
+// from.
+func initializeViews(db *sql.DB) error {
+	for i := len(views) - 1; i >= 0; i-- {
+		if _, err := db.Exec(`DROP VIEW IF EXISTS ` + views[i].name); err != nil {
+			return fmt.Errorf("Error dropping %s view: %v", views[i].name, err)
+		}
+	}
+	for _, v := range views {
+		if _, err := db.Exec(`CREATE VIEW ` + v.name + ` AS ` + v.sql); err != nil {
+			return fmt.Errorf("Error creating %s view: %v", v.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package store_test
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/store"
+)
+
+func TestViews(t *testing.T) {
+	s := kubetest.NewStore(t)
+	run := kubetest.NewRun(t, s)
+	deploymentID := store.StoreDeployment(s.DB, run, &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web"},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "migrate", Image: "web:1.2"}},
+			Containers: []corev1.Container{{
+				Name:    "web",
+				Image:   "web:1.2",
+				EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "web-config"}}}},
+			}},
+			Volumes: []corev1.Volume{{Name: "tls", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "web-tls"}}}},
+		}}},
+	})
+	store.StoreConfigMap(s.DB, run, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web-config"}})
+	store.StorePod(s.DB, run, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web-5d9c7-abcde"},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "web", RestartCount: 3, LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled"}}},
+		}},
+	}, deploymentID, 0)
+
+	if n := kubetest.Count(t, s.DB, "v_workload_images", "name = 'web' AND image = 'web:1.2'"); n != 2 {
+		t.Errorf("got %d images of web, want 2", n)
+	}
+
+	var workload, reason string
+	var restarts int
+	err := s.DB.QueryRow(`SELECT workload, restart_count, last_termination_reason FROM v_pod_restarts WHERE pod = 'web-5d9c7-abcde'`).Scan(&workload, &restarts, &reason)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if workload != "web" || restarts != 3 || reason != "OOMKilled" {
+		t.Errorf("got restarts of %s %d %s, want web 3 OOMKilled", workload, restarts, reason)
+	}
+
+	rows, err := s.DB.Query(`SELECT resource_kind, resource, gathered FROM v_config_consumers WHERE name = 'web' ORDER BY resource`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var kind, name string
+		var gathered bool
+		if err := rows.Scan(&kind, &name, &gathered); err != nil {
+			t.Fatal(err)
+		}
+		if gathered {
+			name += " (gathered)"
+		}
+		got = append(got, kind+" "+name)
+	}
+	if want := "configmap web-config (gathered)|secret web-tls"; len(got) != 2 || got[0]+"|"+got[1] != want {
+		t.Errorf("got consumers %q, want %s", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VerticalPodAutoscaler is the part of a VerticalPodAutoscaler the
+// vpa_recommendations table is extracted from. The autoscaler's own types
+// aren't a dependency, and VPAs are gathered through the dynamic client like
+// any custom resource.
+type VerticalPodAutoscaler struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              VPASpec   `json:"spec,omitempty"`
+	Status            VPAStatus `json:"status,omitempty"`
+}
+
+type VPASpec struct {
+	TargetRef    *autoscalingv1.CrossVersionObjectReference `json:"targetRef,omitempty"`
+	UpdatePolicy *VPAUpdatePolicy                           `json:"updatePolicy,omitempty"`
+}
+
+// VPAUpdatePolicy says whether the updater evicts pods to apply its
+// recommendations: Off, Initial, Recreate, InPlaceOrRecreate or Auto.
+type VPAUpdatePolicy struct {
+	UpdateMode *string `json:"updateMode,omitempty"`
+}
+
+type VPAStatus struct {
+	Recommendation *VPARecommendation `json:"recommendation,omitempty"`
+}
+
+type VPARecommendation struct {
+	ContainerRecommendations []ContainerRecommendation `json:"containerRecommendations,omitempty"`
+}
+
+// ContainerRecommendation is the requests a VPA recommends for a container:
+// Target is applied, and pods with requests outside the bounds are evicted
+// in the Recreate and Auto modes.
+type ContainerRecommendation struct {
+	ContainerName  string              `json:"containerName,omitempty"`
+	Target         corev1.ResourceList `json:"target"`
+	LowerBound     corev1.ResourceList `json:"lowerBound,omitempty"`
+	UpperBound     corev1.ResourceList `json:"upperBound,omitempty"`
+	UncappedTarget corev1.ResourceList `json:"uncappedTarget,omitempty"`
+}
+
+// StoreVPARecommendations stores each container recommendation of a VPA as a
+// row of vpa_recommendations, linked to the VPA's row in objects and to its
+// target workload if the run has gathered it. A VPA without recommendations
+// yet gets a row without a container, so its mode and target are still
+// stored. CPU is in millicores and memory in bytes.
+func StoreVPARecommendations(db *sql.DB, summary *Run, vpa *VerticalPodAutoscaler, objectID int64) {
+	var kind, name string
+	var deploymentID, workloadID int64
+	if ref := vpa.Spec.TargetRef; ref != nil {
+		kind, name = ref.Kind, ref.Name
+		deploymentID, workloadID = WorkloadIDs(db, summary.RunID, kind, vpa.Namespace, name)
+	}
+	mode := "Auto"
+	if p := vpa.Spec.UpdatePolicy; p != nil && p.UpdateMode != nil {
+		mode = *p.UpdateMode
+	}
+	recommendations := []ContainerRecommendation{{}}
+	if r := vpa.Status.Recommendation; r != nil && len(r.ContainerRecommendations) > 0 {
+		recommendations = r.ContainerRecommendations
+	}
+
+	for _, r := range recommendations {
+		_, err := ExecWrite(db, `
+			INSERT INTO vpa_recommendations (run_id, object_id, namespace, vpa, target_kind, target_name, deployment_id, workload_id, update_mode, container,
+				target_cpu_millicores, target_memory_bytes, lower_bound_cpu_millicores, lower_bound_memory_bytes,
+				upper_bound_cpu_millicores, upper_bound_memory_bytes, uncapped_target_cpu_millicores, uncapped_target_memory_bytes)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, summary.RunID, nullID(objectID), vpa.Namespace, vpa.Name, kind, name, nullID(deploymentID), nullID(workloadID), mode,
+			sql.NullString{String: r.ContainerName, Valid: r.ContainerName != ""},
+			quantity(r.Target, corev1.ResourceCPU, true), quantity(r.Target, corev1.ResourceMemory, false),
+			quantity(r.LowerBound, corev1.ResourceCPU, true), quantity(r.LowerBound, corev1.ResourceMemory, false),
+			quantity(r.UpperBound, corev1.ResourceCPU, true), quantity(r.UpperBound, corev1.ResourceMemory, false),
+			quantity(r.UncappedTarget, corev1.ResourceCPU, true), quantity(r.UncappedTarget, corev1.ResourceMemory, false))
+		if err != nil {
+			summary.AddResourceError(PhaseStore, "verticalpodautoscaler", vpa.Namespace, vpa.Name, fmt.Errorf("Error inserting VPA recommendation into database: %w", err))
+			return
+		}
+	}
+}
+
+// WorkloadIDs returns the row ID of a workload the run has gathered: of the
+// deployment in the deployments table if kind is Deployment, or else of the
+// object in the objects table; the other ID, and both if it hasn't, are zero.
+func WorkloadIDs(db *sql.DB, runID int64, kind, namespace, name string) (deploymentID, objectID int64) {
+	if kind == "Deployment" {
+		db.QueryRow(`
+			SELECT id FROM deployments WHERE run_id = ? AND namespace = ? AND name = ? ORDER BY id DESC LIMIT 1
+		`, runID, namespace, name).Scan(&deploymentID)
+		return deploymentID, 0
+	}
+	_, objectID = involvedObject(db, runID, corev1.ObjectReference{Kind: kind, Namespace: namespace, Name: name})
+	return 0, objectID
+}
@@ -0,0 +1,357 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/pkg/metrics"
+)
+
+// ExecWrite runs a database write, recording its latency.
+func ExecWrite(db *sql.DB, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	defer func() { metrics.DBWriteDuration.Observe(time.Since(start)) }()
+	return db.Exec(query, args...)
+}
+
+// StoreDeployment stores a deployment in its table, returning its row ID, or
+// zero if it couldn't be stored.
+func StoreDeployment(db *sql.DB, summary *Run, deployment *appsv1.Deployment) int64 {
+	metadataBytes, err := json.Marshal(storedMeta(summary, &deployment.ObjectMeta))
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "deployment", deployment.Namespace, deployment.Name, fmt.Errorf("Error marshalling deployment metadata: %w", err))
+		return 0
+	}
+
+	specBytes, err := json.Marshal(deployment.Spec)
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "deployment", deployment.Namespace, deployment.Name, fmt.Errorf("Error marshalling deployment spec: %w", err))
+		return 0
+	}
+
+	statusBytes, err := json.Marshal(deployment.Status)
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "deployment", deployment.Namespace, deployment.Name, fmt.Errorf("Error marshalling deployment status: %w", err))
+		return 0
+	}
+
+	result, err := ExecWrite(db, `
+		INSERT INTO deployments (run_id, namespace, name, metadata, spec, status, content_hash) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, append([]interface{}{summary.RunID, deployment.Namespace, deployment.Name, string(metadataBytes)},
+		storedContent(db, summary, "deployment", deployment.Namespace, deployment.Name, string(specBytes), string(statusBytes))...)...)
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "deployment", deployment.Namespace, deployment.Name, fmt.Errorf("Error inserting deployment into database: %w", err))
+		return 0
+	}
+
+	deploymentID, err := result.LastInsertId()
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "deployment", deployment.Namespace, deployment.Name, fmt.Errorf("Error getting last insert ID: %w", err))
+		return 0
+	}
+
+	summary.AddGathered("deployment")
+	return deploymentID
+}
+
+// StoreEvent stores an event, linked to the deployment it concerns unless
+// deploymentID is zero, and to the pod or other object it is about if the run
+// has already gathered it. Pods' image pull failures are recorded in
+// image_pull_failures too.
+func StoreEvent(db *sql.DB, summary *Run, event *corev1.Event, deploymentID int64) {
+	involved := event.InvolvedObject
+	podID, objectID := involvedObject(db, summary.RunID, involved)
+	// Reporters using the events.k8s.io API name themselves as the
+	// reporting controller only.
+	component := event.Source.Component
+	if component == "" {
+		component = event.ReportingController
+	}
+	result, err := ExecWrite(db, `
+		INSERT INTO events (run_id, deployment_id, pod_id, object_id, namespace, involved_kind, involved_namespace, involved_name, involved_uid,
+			reason, type, message, count, first_seen, last_seen, source_component)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, summary.RunID, nullID(deploymentID), nullID(podID), nullID(objectID), event.Namespace, involved.Kind, involved.Namespace, involved.Name, string(involved.UID),
+		event.Reason, event.Type, event.Message,
+		event.Count, eventTime(event.FirstTimestamp, event.EventTime), eventTime(event.LastTimestamp, event.EventTime), component)
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "event", event.Namespace, event.Name, fmt.Errorf("Error inserting event into database: %w", err))
+		return
+	}
+	summary.AddGathered("event")
+
+	if involved.Kind != "Pod" {
+		return
+	}
+	if f, ok := eventImagePullFailure(event.Message, involved.FieldPath); ok {
+		f.podID, f.namespace, f.pod = podID, involved.Namespace, involved.Name
+		f.eventID, _ = result.LastInsertId()
+		storeImagePullFailure(db, summary, f)
+	}
+}
+
+// involvedObject returns the row ID of the pod, or else of the object in the
+// objects table, that an event is about, if the run has gathered it; the other
+// ID, and both if it hasn't, are zero.
+func involvedObject(db *sql.DB, runID int64, involved corev1.ObjectReference) (podID, objectID int64) {
+	if involved.Kind == "Pod" {
+		db.QueryRow(`
+			SELECT id FROM pods WHERE run_id = ? AND namespace = ? AND name = ? ORDER BY id DESC LIMIT 1
+		`, runID, involved.Namespace, involved.Name).Scan(&podID)
+		return podID, 0
+	}
+	db.QueryRow(`
+		SELECT id FROM objects WHERE run_id = ? AND kind = ? AND COALESCE(namespace, '') = ? AND name = ? ORDER BY id DESC LIMIT 1
+	`, runID, strings.ToLower(involved.Kind), involved.Namespace, involved.Name).Scan(&objectID)
+	return 0, objectID
+}
+
+// eventTime prefers the legacy timestamp, falling back to the series event
+// time set by newer reporters.
+func eventTime(timestamp metav1.Time, eventTime metav1.MicroTime) sql.NullTime {
+	switch {
+	case !timestamp.IsZero():
+		return sql.NullTime{Time: timestamp.UTC(), Valid: true}
+	case !eventTime.IsZero():
+		return sql.NullTime{Time: eventTime.UTC(), Valid: true}
+	}
+	return sql.NullTime{}
+}
+
+// StorePod stores a pod owned by either a deployment or a workload in the
+// objects table; the other ID is zero.
+func StorePod(db *sql.DB, summary *Run, pod *corev1.Pod, deploymentID, objectID int64) {
+	metadataBytes, err := json.Marshal(storedMeta(summary, &pod.ObjectMeta))
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "pod", pod.Namespace, pod.Name, fmt.Errorf("Error marshalling pod metadata: %w", err))
+		return
+	}
+
+	specBytes, err := json.Marshal(pod.Spec)
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "pod", pod.Namespace, pod.Name, fmt.Errorf("Error marshalling pod spec: %w", err))
+		return
+	}
+
+	statusBytes, err := json.Marshal(pod.Status)
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "pod", pod.Namespace, pod.Name, fmt.Errorf("Error marshalling pod status: %w", err))
+		return
+	}
+
+	result, err := ExecWrite(db, `
+		INSERT INTO pods (run_id, deployment_id, object_id, namespace, name, metadata, spec, status, content_hash,
+			priority_class_name, priority, preemption_policy, nominated_node_name) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, append(append([]interface{}{summary.RunID, nullID(deploymentID), nullID(objectID), pod.Namespace, pod.Name, string(metadataBytes)},
+		storedContent(db, summary, "pod", pod.Namespace, pod.Name, string(specBytes), string(statusBytes))...),
+		pod.Spec.PriorityClassName, pod.Spec.Priority, pod.Spec.PreemptionPolicy, pod.Status.NominatedNodeName)...)
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "pod", pod.Namespace, pod.Name, fmt.Errorf("Error inserting pod into database: %w", err))
+		return
+	}
+	podID, err := result.LastInsertId()
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "pod", pod.Namespace, pod.Name, fmt.Errorf("Error getting last insert ID: %w", err))
+		return
+	}
+	storePodStatus(db, summary, pod, podID)
+
+	summary.AddGathered("pod")
+}
+
+// storedMeta returns the metadata to store for an object: without its
+// managedFields and kubectl's last-applied-configuration annotation, which
+// bloat every row and change with every apply, unless the run keeps them.
+func storedMeta(summary *Run, meta *metav1.ObjectMeta) *metav1.ObjectMeta {
+	_, lastApplied := meta.Annotations[corev1.LastAppliedConfigAnnotation]
+	if summary.KeepManagedFields || (len(meta.ManagedFields) == 0 && !lastApplied) {
+		return meta
+	}
+	stripped := *meta
+	stripped.ManagedFields = nil
+	if lastApplied {
+		stripped.Annotations = make(map[string]string, len(meta.Annotations)-1)
+		for key, value := range meta.Annotations {
+			if key != corev1.LastAppliedConfigAnnotation {
+				stripped.Annotations[key] = value
+			}
+		}
+		if len(stripped.Annotations) == 0 {
+			stripped.Annotations = nil
+		}
+	}
+	return &stripped
+}
+
+// storedContent returns the values to insert for an object's content
+// columns, "" standing for NULL, followed by its content hash. Objects whose
+// content exceeds the run's MaxObjectBytes are stored with their metadata
+// alone and recorded in the oversized_objects table; their hash is of the
+// content as gathered, so they still compare with other runs.
+func storedContent(db *sql.DB, summary *Run, kind, namespace, name string, columns ...string) []interface{} {
+	size := 0
+	for _, column := range columns {
+		size += len(column)
+	}
+	oversized := summary.MaxObjectBytes > 0 && int64(size) > summary.MaxObjectBytes
+	if oversized {
+		_, err := ExecWrite(db, `
+			INSERT INTO oversized_objects (run_id, kind, namespace, name, bytes) VALUES (?, ?, ?, ?, ?)
+		`, summary.RunID, kind, namespace, name, size)
+		if err != nil {
+			summary.AddResourceError(PhaseStore, kind, namespace, name, fmt.Errorf("Error recording oversized %s: %w", kind, err))
+		}
+		summary.Oversized++
+	}
+
+	values := make([]interface{}, 0, len(columns)+1)
+	for _, column := range columns {
+		values = append(values, sql.NullString{String: column, Valid: column != "" && !oversized})
+	}
+	return append(values, ContentHash(columns...))
+}
+
+// nullID stores an unset (zero) row ID as NULL.
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
+
+// StoreConfigMap stores a configmap in its table, returning its row ID, or
+// zero if it couldn't be stored. Its binaryData is stored base64-encoded in
+// a column of its own.
+func StoreConfigMap(db *sql.DB, summary *Run, configMap *corev1.ConfigMap) int64 {
+	metadataBytes, err := json.Marshal(storedMeta(summary, &configMap.ObjectMeta))
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "configmap", configMap.Namespace, configMap.Name, fmt.Errorf("Error marshalling configmap metadata: %w", err))
+		return 0
+	}
+
+	dataBytes, err := json.Marshal(configMap.Data)
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "configmap", configMap.Namespace, configMap.Name, fmt.Errorf("Error marshalling configmap data: %w", err))
+		return 0
+	}
+
+	// Most configmaps have no binaryData, and leave the column NULL.
+	var binaryData string
+	if len(configMap.BinaryData) > 0 {
+		binaryDataBytes, err := json.Marshal(configMap.BinaryData)
+		if err != nil {
+			summary.AddResourceError(PhaseStore, "configmap", configMap.Namespace, configMap.Name, fmt.Errorf("Error marshalling configmap binary data: %w", err))
+			return 0
+		}
+		binaryData = string(binaryDataBytes)
+	}
+
+	result, err := ExecWrite(db, `
+		INSERT INTO configmaps (run_id, namespace, name, metadata, data, binary_data, content_hash) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, append([]interface{}{summary.RunID, configMap.Namespace, configMap.Name, string(metadataBytes)},
+		storedContent(db, summary, "configmap", configMap.Namespace, configMap.Name, string(dataBytes), binaryData)...)...)
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "configmap", configMap.Namespace, configMap.Name, fmt.Errorf("Error inserting configmap into database: %w", err))
+		return 0
+	}
+
+	configMapID, err := result.LastInsertId()
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "configmap", configMap.Namespace, configMap.Name, fmt.Errorf("Error getting last insert ID: %w", err))
+		return 0
+	}
+
+	summary.AddGathered("configmap")
+	return configMapID
+}
+
+// StoreSecret stores a secret in its table, returning its row ID, or zero if
+// it couldn't be stored. Its values are stored as bytes (base64 in the JSON),
+// with any stringData, as found in imported manifests, merged over them as
+// the API server does.
+func StoreSecret(db *sql.DB, summary *Run, secret *corev1.Secret) int64 {
+	metadataBytes, err := json.Marshal(storedMeta(summary, &secret.ObjectMeta))
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "secret", secret.Namespace, secret.Name, fmt.Errorf("Error marshalling secret metadata: %w", err))
+		return 0
+	}
+
+	data := secret.Data
+	if len(secret.StringData) > 0 {
+		data = make(map[string][]byte, len(secret.Data)+len(secret.StringData))
+		for key, value := range secret.Data {
+			data[key] = value
+		}
+		for key, value := range secret.StringData {
+			data[key] = []byte(value)
+		}
+	}
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "secret", secret.Namespace, secret.Name, fmt.Errorf("Error marshalling secret data: %w", err))
+		return 0
+	}
+
+	result, err := ExecWrite(db, `
+		INSERT INTO secrets (run_id, namespace, name, metadata, data, content_hash) VALUES (?, ?, ?, ?, ?, ?)
+	`, append([]interface{}{summary.RunID, secret.Namespace, secret.Name, string(metadataBytes)},
+		storedContent(db, summary, "secret", secret.Namespace, secret.Name, string(dataBytes))...)...)
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "secret", secret.Namespace, secret.Name, fmt.Errorf("Error inserting secret into database: %w", err))
+		return 0
+	}
+
+	secretID, err := result.LastInsertId()
+	if err != nil {
+		summary.AddResourceError(PhaseStore, "secret", secret.Namespace, secret.Name, fmt.Errorf("Error getting last insert ID: %w", err))
+		return 0
+	}
+
+	summary.AddGathered("secret")
+	return secretID
+}
+
+// func linkDependentResources(db *sql.DB, deploymentID int64, resourceType string, resourceID int64) {
+// 	_, err := db.Exec(`
+// 		INSERT INTO deployment_dependencies (deployment_id, resource_type, resource_id)
+// 		VALUES (?, ?, ?)
+// 	`, deploymentID, resourceType, resourceID)
+// 	if err != nil {
+// 		log.Printf("Error linking dependent resource (type: %s, id: %d) to deployment (id: %d): %v\n",
+// 			resourceType, resourceID, deploymentID, err)
+// 	} else {
+// 		fmt.Printf("Linked %s (ID %d) to Deployment ID %d\n", resourceType, resourceID, deploymentID)
+// 	}
+// }
+
+// StoreObject stores a resource of a kind without a dedicated table in the
+// generic objects table, returning its row ID.
+func StoreObject(db *sql.DB, summary *Run, kind string, meta *metav1.ObjectMeta, spec, status interface{}) (int64, error) {
+	columns := make([]string, 0, 3)
+	for _, v := range []interface{}{storedMeta(summary, meta), spec, status} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return 0, fmt.Errorf("Error marshalling %s: %v", kind, err)
+		}
+		columns = append(columns, string(b))
+	}
+
+	result, err := ExecWrite(db, `
+		INSERT INTO objects (run_id, kind, namespace, name, metadata, spec, status, content_hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, append([]interface{}{summary.RunID, kind, meta.Namespace, meta.Name, columns[0]},
+		storedContent(db, summary, kind, meta.Namespace, meta.Name, columns[1], columns[2])...)...)
+	if err != nil {
+		return 0, fmt.Errorf("Error inserting %s into database: %v", kind, err)
+	}
+	objectID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("Error getting last insert ID: %v", err)
+	}
+
+	summary.AddGathered(kind)
+	return objectID, nil
+}
@@ -0,0 +1,405 @@
+package store_test
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kube-query/internal/kubetest"
+	"kube-query/pkg/store"
+)
+
+func TestStoreConfigMapBinaryData(t *testing.T) {
+	s := kubetest.NewStore(t)
+	run := kubetest.NewRun(t, s)
+	der := []byte{0x30, 0x82, 0x01, 0x0a, 0x00, 0xff}
+
+	for _, configMap := range []*corev1.ConfigMap{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "ca-bundle"},
+			Data:       map[string]string{"ca.crt": "-----BEGIN CERTIFICATE-----"},
+			BinaryData: map[string][]byte{"ca.der": der},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web-config"},
+			Data:       map[string]string{"LOG_LEVEL": "info"},
+		},
+	} {
+		if store.StoreConfigMap(s.DB, run, configMap) == 0 {
+			t.Fatalf("storing %s: %v", configMap.Name, run.Err())
+		}
+	}
+
+	var configMaps []corev1.ConfigMap
+	if err := store.LoadObjects(s.DB, run.RunID, "configmap", &configMaps); err != nil {
+		t.Fatal(err)
+	}
+	if len(configMaps) != 2 {
+		t.Fatalf("loaded %d configmaps, want 2", len(configMaps))
+	}
+	for _, configMap := range configMaps {
+		switch configMap.Name {
+		case "ca-bundle":
+			if !bytes.Equal(configMap.BinaryData["ca.der"], der) || configMap.Data["ca.crt"] == "" {
+				t.Errorf("ca-bundle read back as data %v, binaryData %v", configMap.Data, configMap.BinaryData)
+			}
+		case "web-config":
+			if configMap.BinaryData != nil {
+				t.Errorf("web-config read back with binaryData %v", configMap.BinaryData)
+			}
+		}
+	}
+}
+
+func TestStoreSecretStringData(t *testing.T) {
+	s := kubetest.NewStore(t)
+	run := kubetest.NewRun(t, s)
+
+	store.StoreSecret(s.DB, run, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web-secret"},
+		Data:       map[string][]byte{"tls.key": {0x00, 0x01, 0xfe}, "PASSWORD": []byte("old")},
+		StringData: map[string]string{"PASSWORD": "hunter2"},
+	})
+
+	var secrets []corev1.Secret
+	if err := store.LoadObjects(s.DB, run.RunID, "secret", &secrets); err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 1 {
+		t.Fatalf("loaded %d secrets, want 1", len(secrets))
+	}
+	data := secrets[0].Data
+	if string(data["PASSWORD"]) != "hunter2" || !bytes.Equal(data["tls.key"], []byte{0x00, 0x01, 0xfe}) {
+		t.Errorf("secret read back as %v", data)
+	}
+}
+
+func TestStoreStripsManagedFields(t *testing.T) {
+	meta := metav1.ObjectMeta{
+		Namespace: "prod",
+		Name:      "web-config",
+		Annotations: map[string]string{
+			corev1.LastAppliedConfigAnnotation: `{"apiVersion":"v1","kind":"ConfigMap"}`,
+			"owner":                            "team-web",
+		},
+		ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubectl-client-side-apply", Operation: metav1.ManagedFieldsOperationUpdate}},
+	}
+
+	for _, keep := range []bool{false, true} {
+		s := kubetest.NewStore(t)
+		run := kubetest.NewRun(t, s)
+		run.KeepManagedFields = keep
+		store.StoreConfigMap(s.DB, run, &corev1.ConfigMap{ObjectMeta: meta})
+		if _, err := store.StoreObject(s.DB, run, "service", &meta, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, kind := range []string{"configmap", "service"} {
+			r, err := store.GetResource(s.DB, run.RunID, kind, "prod", "web-config")
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored := string(r.Content["metadata"])
+			if got := strings.Contains(stored, "managedFields") || strings.Contains(stored, "last-applied-configuration"); got != keep {
+				t.Errorf("%s stored with keep=%v: %s", kind, keep, stored)
+			}
+			if !strings.Contains(stored, "team-web") {
+				t.Errorf("%s lost its other annotations: %s", kind, stored)
+			}
+		}
+	}
+	if len(meta.ManagedFields) == 0 || len(meta.Annotations) != 2 {
+		t.Errorf("storing modified the object's metadata: %v", meta)
+	}
+}
+
+func TestStoreMaxObjectBytes(t *testing.T) {
+	s := kubetest.NewStore(t)
+	run := kubetest.NewRun(t, s)
+	run.MaxObjectBytes = 64
+
+	for _, configMap := range []*corev1.ConfigMap{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "dashboards"},
+			Data:       map[string]string{"shop.json": strings.Repeat("x", 100)},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web-config"},
+			Data:       map[string]string{"LOG_LEVEL": "info"},
+		},
+	} {
+		if store.StoreConfigMap(s.DB, run, configMap) == 0 {
+			t.Fatalf("storing %s: %v", configMap.Name, run.Err())
+		}
+	}
+	if run.Oversized != 1 {
+		t.Errorf("Oversized = %d, want 1", run.Oversized)
+	}
+
+	var name string
+	var size int
+	if err := s.DB.QueryRow(`SELECT name, bytes FROM oversized_objects WHERE run_id = ?`, run.RunID).Scan(&name, &size); err != nil {
+		t.Fatal(err)
+	}
+	if name != "dashboards" || size <= 64 {
+		t.Errorf("recorded oversized %s of %d bytes, want dashboards over 64", name, size)
+	}
+
+	rows, err := s.DB.Query(`SELECT name, data IS NULL, content_hash FROM configmaps WHERE run_id = ? ORDER BY name`, run.RunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var dataNull bool
+		var hash string
+		if err := rows.Scan(&name, &dataNull, &hash); err != nil {
+			t.Fatal(err)
+		}
+		if dataNull != (name == "dashboards") {
+			t.Errorf("%s stored with data NULL = %v", name, dataNull)
+		}
+		if hash == "" {
+			t.Errorf("%s stored without a content hash", name)
+		}
+	}
+}
+
+func TestStorePodStatus(t *testing.T) {
+	s := kubetest.NewStore(t)
+	run := kubetest.NewRun(t, s)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web-5d9c7-abcde"},
+		Status: corev1.PodStatus{
+			Conditions: []corev1.PodCondition{
+				{Type: corev1.PodScheduled, Status: corev1.ConditionTrue},
+				{Type: corev1.ContainersReady, Status: corev1.ConditionFalse, Reason: "ContainersNotReady"},
+				{Type: corev1.PodReady, Status: corev1.ConditionFalse, Reason: "ContainersNotReady"},
+			},
+			InitContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "migrate",
+				Ready: true,
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Completed"}},
+			}},
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:                 "web",
+				RestartCount:         4,
+				State:                corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled"}},
+			}},
+		},
+	}
+	store.StorePod(s.DB, run, pod, 0, 0)
+	if err := run.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := kubetest.Count(t, s.DB, "pod_conditions", "run_id = ?", run.RunID); got != 3 {
+		t.Errorf("got %d pod conditions, want 3", got)
+	}
+	if got := kubetest.Count(t, s.DB, "pod_conditions", "type = 'Ready' AND status != 'True'"); got != 1 {
+		t.Errorf("got %d unready pods, want 1", got)
+	}
+
+	var state, reason, lastReason string
+	var restarts int
+	err := s.DB.QueryRow(`
+		SELECT state, reason, last_termination_reason, restart_count
+		FROM container_statuses WHERE NOT init AND NOT ready
+	`).Scan(&state, &reason, &lastReason, &restarts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != "waiting" || reason != "CrashLoopBackOff" || lastReason != "OOMKilled" || restarts != 4 {
+		t.Errorf("web container stored as %s %s (last %s, %d restarts)", state, reason, lastReason, restarts)
+	}
+	if got := kubetest.Count(t, s.DB, "container_statuses", "init AND state = 'terminated'"); got != 1 {
+		t.Errorf("got %d terminated init containers, want 1", got)
+	}
+}
+
+func TestStoreEventLinks(t *testing.T) {
+	s := kubetest.NewStore(t)
+	run := kubetest.NewRun(t, s)
+	store.StorePod(s.DB, run, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web-5d9c7-abcde"}}, 0, 0)
+	if _, err := store.StoreObject(s.DB, run, "node", &metav1.ObjectMeta{Name: "worker-1"}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, involved := range []corev1.ObjectReference{
+		{Kind: "Pod", Namespace: "prod", Name: "web-5d9c7-abcde", UID: "1234"},
+		{Kind: "Node", Name: "worker-1"},
+		{Kind: "Pod", Namespace: "prod", Name: "gone"},
+	} {
+		store.StoreEvent(s.DB, run, &corev1.Event{
+			ObjectMeta:          metav1.ObjectMeta{Namespace: "prod", Name: involved.Name + ".17c"},
+			InvolvedObject:      involved,
+			Reason:              "BackOff",
+			ReportingController: "kubelet",
+		}, 0)
+	}
+	if err := run.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var uid, component string
+	err := s.DB.QueryRow(`
+		SELECT e.involved_uid, e.source_component FROM events e JOIN pods p ON e.pod_id = p.id WHERE p.name = 'web-5d9c7-abcde'
+	`).Scan(&uid, &component)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid != "1234" || component != "kubelet" {
+		t.Errorf("got pod event of uid %q from %q, want 1234 from kubelet", uid, component)
+	}
+	if got := kubetest.Count(t, s.DB, "events e JOIN objects o ON e.object_id = o.id", "o.kind = 'node' AND o.name = 'worker-1'"); got != 1 {
+		t.Errorf("got %d events linked to node worker-1, want 1", got)
+	}
+	if got := kubetest.Count(t, s.DB, "events", "involved_name = 'gone' AND pod_id IS NULL AND object_id IS NULL"); got != 1 {
+		t.Errorf("got %d unlinked events of an ungathered pod, want 1", got)
+	}
+}
+
+func TestStoreNodeStatus(t *testing.T) {
+	s := kubetest.NewStore(t)
+	run := kubetest.NewRun(t, s)
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-1"},
+		Spec:       corev1.NodeSpec{Unschedulable: true},
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("4"),
+				corev1.ResourceMemory: resource.MustParse("16Gi"),
+				corev1.ResourcePods:   resource.MustParse("110"),
+			},
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("3500m"),
+				corev1.ResourceMemory: resource.MustParse("15Gi"),
+			},
+			Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+				{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionTrue},
+				{Type: corev1.NodeDiskPressure, Status: corev1.ConditionFalse},
+				{Type: corev1.NodePIDPressure, Status: corev1.ConditionUnknown},
+			},
+		},
+	}
+	objectID, err := store.StoreObject(s.DB, run, "node", &node.ObjectMeta, node.Spec, node.Status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.StoreNodeStatus(s.DB, run, node, objectID)
+	if err := run.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var cpu, allocatableCPU, memory, pods int64
+	var storage sql.NullInt64
+	var ready, memoryPressure, diskPressure, unschedulable bool
+	var pidPressure sql.NullBool
+	err = s.DB.QueryRow(`
+		SELECT n.cpu_capacity_millicores, n.cpu_allocatable_millicores, n.memory_allocatable_bytes, n.pods_capacity,
+			n.ephemeral_storage_capacity_bytes, n.ready, n.memory_pressure, n.disk_pressure, n.pid_pressure, n.unschedulable
+		FROM node_status n JOIN objects o ON n.object_id = o.id WHERE o.name = 'worker-1'
+	`).Scan(&cpu, &allocatableCPU, &memory, &pods, &storage, &ready, &memoryPressure, &diskPressure, &pidPressure, &unschedulable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpu != 4000 || allocatableCPU != 3500 || memory != 15<<30 || pods != 110 || storage.Valid {
+		t.Errorf("got cpu %d/%d, memory %d, pods %d, storage %v", cpu, allocatableCPU, memory, pods, storage)
+	}
+	if !ready || !memoryPressure || diskPressure || pidPressure.Valid || !unschedulable {
+		t.Errorf("got ready %v, memory pressure %v, disk pressure %v, PID pressure %v, unschedulable %v", ready, memoryPressure, diskPressure, pidPressure, unschedulable)
+	}
+}
+
+func TestStorePodPriority(t *testing.T) {
+	s := kubetest.NewStore(t)
+	run := kubetest.NewRun(t, s)
+	priority := int32(100000)
+	policy := corev1.PreemptLowerPriority
+	store.StorePod(s.DB, run, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "checkout-0"},
+		Spec:       corev1.PodSpec{PriorityClassName: "shop-critical", Priority: &priority, PreemptionPolicy: &policy},
+		Status:     corev1.PodStatus{NominatedNodeName: "worker-2"},
+	}, 0, 0)
+	store.StorePod(s.DB, run, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "batch-0"}}, 0, 0)
+	if err := run.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := kubetest.Count(t, s.DB, "pods", "priority_class_name = 'shop-critical' AND priority = 100000 AND preemption_policy = 'PreemptLowerPriority' AND nominated_node_name = 'worker-2'"); got != 1 {
+		t.Errorf("got %d pods with their priority, want 1", got)
+	}
+	if got := kubetest.Count(t, s.DB, "pods", "name = 'batch-0' AND priority IS NULL AND preemption_policy IS NULL"); got != 1 {
+		t.Errorf("got %d pods without a priority, want 1", got)
+	}
+}
+
+func TestStoreQuotaUsage(t *testing.T) {
+	s := kubetest.NewStore(t)
+	run := kubetest.NewRun(t, s)
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "compute"},
+		Spec: corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{
+			corev1.ResourceRequestsCPU:    resource.MustParse("4"),
+			corev1.ResourceRequestsMemory: resource.MustParse("8Gi"),
+			corev1.ResourcePods:           resource.MustParse("10"),
+		}},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{
+				corev1.ResourceRequestsCPU:    resource.MustParse("4"),
+				corev1.ResourceRequestsMemory: resource.MustParse("8Gi"),
+				corev1.ResourcePods:           resource.MustParse("10"),
+			},
+			Used: corev1.ResourceList{
+				corev1.ResourceRequestsCPU:    resource.MustParse("3500m"),
+				corev1.ResourceRequestsMemory: resource.MustParse("8Gi"),
+			},
+		},
+	}
+	objectID, err := store.StoreObject(s.DB, run, "resourcequota", &quota.ObjectMeta, quota.Spec, quota.Status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.StoreQuotaUsage(s.DB, run, quota, objectID)
+	if err := run.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := s.DB.Query(`
+		SELECT q.resource, q.hard, q.used, q.hard_value, q.used_value
+		FROM quota_usage q JOIN objects o ON q.object_id = o.id
+		WHERE o.namespace = 'shop' AND o.name = 'compute' ORDER BY q.resource
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var name, hard string
+		var used sql.NullString
+		var hardValue float64
+		var usedValue sql.NullFloat64
+		if err := rows.Scan(&name, &hard, &used, &hardValue, &usedValue); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s %s/%s %g/%v", name, used.String, hard, usedValue.Float64, hardValue))
+	}
+	want := []string{
+		"pods /10 0/10",
+		"requests.cpu 3500m/4 3.5/4",
+		"requests.memory 8Gi/8Gi 8.589934592e+09/8.589934592e+09",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got quota usage\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}